@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// Directory used by external certbot --webroot flows
+	acmeWebroot string
+
+	// Set once the relay itself runs ACME, which makes challenge paths local-only
+	acmeEnabled bool
+
+	// HTTP-01 key authorizations keyed by token
+	acmeMu         sync.RWMutex
+	acmeChallenges = make(map[string]string)
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// --- ACME HTTP-01 Logic ---
+
+func setACMEChallenge(token, keyAuth string) {
+	acmeMu.Lock()
+	acmeChallenges[token] = keyAuth
+	acmeMu.Unlock()
+}
+
+func deleteACMEChallenge(token string) bool {
+	acmeMu.Lock()
+	defer acmeMu.Unlock()
+	_, ok := acmeChallenges[token]
+	delete(acmeChallenges, token)
+	return ok
+}
+
+// serveACMEChallenge answers HTTP-01 validation requests from the challenge
+// store or the webroot. Unknown tokens are proxied unless the relay owns the
+// challenge path (webroot configured or built-in ACME enabled).
+func serveACMEChallenge(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.URL.Path, acmeChallengePrefix)
+	if !ok || token == "" || strings.Contains(token, "/") {
+		return false
+	}
+
+	acmeMu.RLock()
+	keyAuth, found := acmeChallenges[token]
+	acmeMu.RUnlock()
+	if found {
+		logRequest(r, "[ACME] Served HTTP-01 challenge %s for %s", token, r.Host)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, keyAuth)
+		return true
+	}
+
+	if acmeWebroot != "" {
+		data, err := os.ReadFile(filepath.Join(acmeWebroot, ".well-known", "acme-challenge", token))
+		if err == nil {
+			logRequest(r, "[ACME] Served HTTP-01 challenge %s for %s from webroot", token, r.Host)
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write(data)
+			return true
+		}
+	}
+
+	if acmeWebroot != "" || acmeEnabled {
+		http.NotFound(w, r)
+		return true
+	}
+	return false
+}
+
+// --- ACME Admin Handlers ---
+
+func handleListACMEChallenges(w http.ResponseWriter, r *http.Request) {
+	acmeMu.RLock()
+	tokens := make([]string, 0, len(acmeChallenges))
+	for token := range acmeChallenges {
+		tokens = append(tokens, token)
+	}
+	acmeMu.RUnlock()
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+func handleSetACMEChallenge(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4096))
+	if err != nil || len(body) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "key authorization body is required")
+		return
+	}
+	setACMEChallenge(token, strings.TrimSpace(string(body)))
+	auditRequest(r, "acme_challenge_set", map[string]string{"token": token})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleDeleteACMEChallenge(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	if !deleteACMEChallenge(token) {
+		writeJSONError(w, http.StatusNotFound, "challenge not found")
+		return
+	}
+	auditRequest(r, "acme_challenge_delete", map[string]string{"token": token})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	// Obtain certificates for routed names from an ACME CA
+	acmeIssue bool
+
+	// ACME directory, contact address and challenge type (http-01 or dns-01)
+	acmeDirectory string
+	acmeEmail     string
+	acmeChallenge string
+
+	// DNS-01 TXT records keyed by the lowercase name they are served under
+	acmeDNSMu      sync.RWMutex
+	acmeDNSRecords = make(map[string][]string)
+
+	// Names whose last order failed, and when, so reloads do not hammer
+	// the CA's rate limits. Only the ACME loop touches it.
+	acmeFailed = make(map[string]time.Time)
+
+	acmeClient *acme.Client
+	acmeKick   = make(chan struct{}, 1)
+)
+
+// Certificates obtained through ACME
+const certACME = "acme"
+
+const (
+	letsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// Renew this long before expiry, and retry failed names after
+	// acmeRetry; the renewal check runs every acmeCheck
+	acmeRenewBefore = 30 * 24 * time.Hour
+	acmeRetry       = time.Hour
+	acmeCheck       = 12 * time.Hour
+
+	// Deadline for one order, challenges included
+	acmeOrderTimeout = 5 * time.Minute
+)
+
+// --- ACME Client Logic ---
+
+// startACME registers the account and keeps every eligible routed name
+// covered by a current certificate in the background. The CA validates
+// http-01 on port 80 and dns-01 against the zone's name servers, so the
+// relay has to be what it reaches there.
+func startACME(listeners []*listenerConfig) {
+	if !acmeIssue {
+		return
+	}
+	switch {
+	case acmeChallenge != "http-01" && acmeChallenge != "dns-01":
+		log.Fatalf("Invalid -acme-challenge %q: http-01 or dns-01", acmeChallenge)
+	case !usesProtocol(listeners, protoHTTPS):
+		log.Fatalf("-acme needs an HTTPS listener (-tls-port)")
+	case acmeChallenge == "http-01" && !usesProtocol(listeners, protoHTTP):
+		log.Fatalf("-acme-challenge http-01 needs an HTTP listener")
+	case acmeChallenge == "dns-01" && !usesProtocol(listeners, protoDNS):
+		log.Fatalf("-acme-challenge dns-01 needs the DNS server (-dns)")
+	}
+	key, err := acmeAccountKey()
+	if err != nil {
+		log.Fatalf("Failed to load ACME account key: %v", err)
+	}
+	acmeEnabled = acmeChallenge == "http-01"
+	acmeClient = &acme.Client{Key: key, DirectoryURL: acmeDirectory, UserAgent: "goRebind/" + version}
+
+	go func() {
+		ctx := context.Background()
+		account := &acme.Account{}
+		if acmeEmail != "" {
+			account.Contact = []string{"mailto:" + acmeEmail}
+		}
+		if _, err := acmeClient.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+			log.Printf("[ACME] Failed to register account with %s: %v", acmeDirectory, err)
+			return
+		}
+		log.Printf("[ACME] Using %s with %s challenges", acmeDirectory, acmeChallenge)
+		ticker := time.NewTicker(acmeCheck)
+		defer ticker.Stop()
+		for {
+			renewACMECerts(ctx)
+			select {
+			case <-ticker.C:
+			case <-acmeKick:
+			}
+		}
+	}()
+}
+
+// kickACME has the ACME loop check the routes again after they changed.
+func kickACME() {
+	if !acmeIssue {
+		return
+	}
+	select {
+	case acmeKick <- struct{}{}:
+	default:
+	}
+}
+
+// acmeAccountKey loads the account key from -cert-store, generating and
+// saving one on first use. Without a store a new account is made per run.
+func acmeAccountKey() (*ecdsa.PrivateKey, error) {
+	path := filepath.Join(certStoreDir, "acme-account.pem")
+	if certStoreDir != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, fmt.Errorf("%s: no PEM data", path)
+			}
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil || certStoreDir == "" {
+		return key, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600)
+}
+
+// acmeNames lists the routed names a public CA can issue for: the hosts
+// and aliases of exact routes, path routes included, and wildcard routes
+// with dns-01, under a public suffix. Routes with their own tls_cert are left alone.
+func acmeNames() []string {
+	var names []string
+	mu.RLock()
+	for _, rt := range routeMap {
+		kind := rt.hostKind()
+		if rt.tlsCert != nil || (kind != "exact" && (kind != "wildcard" || acmeChallenge != "dns-01")) {
+			continue
+		}
+		for _, name := range append([]string{rt.bareHost()}, rt.aliasHosts()...) {
+			if acmeIssuable(name) && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	mu.RUnlock()
+	slices.Sort(names)
+	return names
+}
+
+// acmeIssuable reports whether name is a domain under an ICANN public
+// suffix rather than an address or an internal name like app.local.
+func acmeIssuable(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	if net.ParseIP(name) != nil || !strings.Contains(name, ".") {
+		return false
+	}
+	suffix, icann := publicsuffix.PublicSuffix(name)
+	return icann && suffix != name
+}
+
+// renewACMECerts orders a certificate, one at a time, for every eligible
+// name without an imported certificate or an ACME one far from expiry.
+func renewACMECerts(ctx context.Context) {
+	for _, name := range acmeNames() {
+		leafMu.Lock()
+		entry := leafCerts[name]
+		leafMu.Unlock()
+		if entry != nil && entry.cert != nil && (entry.source == certImported ||
+			entry.source == certACME && time.Until(entry.cert.Leaf.NotAfter) > acmeRenewBefore) {
+			continue
+		}
+		if time.Since(acmeFailed[name]) < acmeRetry {
+			continue
+		}
+		cert, err := orderACMECert(ctx, name)
+		if err != nil {
+			log.Printf("[ACME] Failed to obtain certificate for %s: %v", name, err)
+			acmeFailed[name] = time.Now()
+			continue
+		}
+		delete(acmeFailed, name)
+		setLeaf(name, cert, certACME)
+		saveLeaf(name, cert, certACME)
+		log.Printf("[ACME] Obtained certificate for %s from %s, valid until %s", name, cert.Leaf.Issuer.CommonName, cert.Leaf.NotAfter.Format(time.DateOnly))
+	}
+}
+
+// orderACMECert runs one order for name: it answers each pending
+// authorization with the configured challenge, then finalizes with a new
+// key.
+func orderACMECert(ctx context.Context, name string) (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, acmeOrderTimeout)
+	defer cancel()
+	order, err := acmeClient.AuthorizeOrder(ctx, acme.DomainIDs(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err := authorizeACME(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = acmeClient.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{name}}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := acmeClient.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// authorizeACME completes one authorization unless it is already valid.
+func authorizeACME(ctx context.Context, authzURL string) error {
+	authz, err := acmeClient.GetAuthorization(ctx, authzURL)
+	if err != nil || authz.Status == acme.StatusValid {
+		return err
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == acmeChallenge {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offers no %s challenge for %s", acmeChallenge, authz.Identifier.Value)
+	}
+
+	if acmeChallenge == "http-01" {
+		keyAuth, err := acmeClient.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		setACMEChallenge(chal.Token, keyAuth)
+		defer deleteACMEChallenge(chal.Token)
+	} else {
+		record, err := acmeClient.DNS01ChallengeRecord(chal.Token)
+		if err != nil {
+			return err
+		}
+		// Wildcard identifiers are validated on their base domain
+		owner := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.")
+		setACMEDNSRecord(owner, record)
+		defer deleteACMEDNSRecord(owner, record)
+	}
+	if _, err := acmeClient.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = acmeClient.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+func setACMEDNSRecord(owner, value string) {
+	acmeDNSMu.Lock()
+	acmeDNSRecords[owner] = append(acmeDNSRecords[owner], value)
+	acmeDNSMu.Unlock()
+}
+
+func deleteACMEDNSRecord(owner, value string) {
+	acmeDNSMu.Lock()
+	defer acmeDNSMu.Unlock()
+	acmeDNSRecords[owner] = slices.DeleteFunc(acmeDNSRecords[owner], func(v string) bool { return v == value })
+	if len(acmeDNSRecords[owner]) == 0 {
+		delete(acmeDNSRecords, owner)
+	}
+}
+
+// serveACMEDNS answers TXT queries for pending DNS-01 challenges.
+func serveACMEDNS(m *dns.Msg, q dns.Question, name string) bool {
+	if q.Qtype != dns.TypeTXT || !strings.HasPrefix(name, "_acme-challenge.") {
+		return false
+	}
+	acmeDNSMu.RLock()
+	values := acmeDNSRecords[name]
+	acmeDNSMu.RUnlock()
+	if len(values) == 0 {
+		return false
+	}
+	for _, v := range values {
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{v}})
+	}
+	m.Authoritative = true
+	log.Printf("[ACME] Served DNS-01 challenge for %s", strings.TrimPrefix(name, "_acme-challenge."))
+	return true
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Times routes are active, e.g. "Mon-Fri 09:00-17:30"; outside them the
+	// relay only forwards
+	activeWindows stringList
+	// Time zone the windows are in (default: local time)
+	activeTZ string
+
+	activity      []activityWindow
+	activityZone  = time.Local
+	outsideWindow atomic.Bool
+)
+
+// activityWindow is a daily time span on some days of the week. A span
+// ending before it starts runs past midnight and belongs to its start day.
+type activityWindow struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// --- Activity Window Logic ---
+
+// parseActivityWindow parses "[days ]HH:MM-HH:MM", where days is a comma
+// list of names or ranges such as Mon-Fri,Sun. Without days the window
+// applies every day.
+func parseActivityWindow(s string) (activityWindow, error) {
+	var w activityWindow
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid window %q: want \"Mon-Fri 09:00-17:00\"", s)
+	}
+	if len(fields) == 1 {
+		fields = []string{"sun-sat", fields[0]}
+	}
+	for _, part := range strings.Split(strings.ToLower(fields[0]), ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		from, ok1 := weekdays[first]
+		to, ok2 := weekdays[last]
+		if !ok1 || !ok2 {
+			return w, fmt.Errorf("invalid window %q: bad days %q", s, part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	from, to, ok := strings.Cut(fields[1], "-")
+	var err1, err2 error
+	w.start, err1 = parseClock(from)
+	w.end, err2 = parseClock(to)
+	if !ok || err1 != nil || err2 != nil || w.start == w.end {
+		return w, fmt.Errorf("invalid window %q: bad time span %q", s, fields[1])
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight; 24:00 is the end of
+// the day.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// contains reports whether t falls in the window.
+func (w activityWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[t.Weekday()] && minute >= w.start && minute < w.end
+	}
+	// Past midnight: the evening part, or the morning after a window day
+	return (w.days[t.Weekday()] && minute >= w.start) || (w.days[(t.Weekday()+6)%7] && minute < w.end)
+}
+
+// setupActivityWindows parses -active-window and keeps outsideWindow
+// current, logging each change.
+func setupActivityWindows() {
+	if activeTZ != "" {
+		loc, err := time.LoadLocation(activeTZ)
+		if err != nil {
+			log.Fatalf("Invalid -active-tz: %v", err)
+		}
+		activityZone = loc
+	}
+	if len(activeWindows) == 0 {
+		return
+	}
+	for _, s := range activeWindows {
+		w, err := parseActivityWindow(s)
+		if err != nil {
+			log.Fatalf("Invalid -active-window: %v", err)
+		}
+		activity = append(activity, w)
+	}
+	log.Printf("[SCHEDULE] Routes active during %s (%s)", activeWindows.String(), activityZone)
+	updateActivity(time.Now())
+	go func() {
+		for now := range time.Tick(time.Second) {
+			updateActivity(now)
+		}
+	}()
+}
+
+// updateActivity re-evaluates the windows at now.
+func updateActivity(now time.Time) {
+	now = now.In(activityZone)
+	inside := false
+	for _, w := range activity {
+		inside = inside || w.contains(now)
+	}
+	if outsideWindow.Swap(!inside) == !inside {
+		return
+	}
+	if inside {
+		log.Printf("[SCHEDULE] Activity window opened: routes enabled")
+		audit("system", "", "activity_window_opened", nil)
+	} else {
+		log.Printf("[SCHEDULE] Outside activity windows: all routes disabled, forward-only mode")
+		audit("system", "", "activity_window_closed", nil)
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"goRebind/adminclient"
+	"goRebind/api"
+)
+
+var (
+	// Admin API listener settings
+	adminAddr     string
+	adminToken    string
+	adminTLSCert  string
+	adminTLSKey   string
+	adminClientCA string
+
+	// Named tokens with scopes, from -admin-token and -admin-tokens
+	adminTokensFile string
+	adminTokens     []AdminToken
+)
+
+// Admin token scopes
+const (
+	scopeRead  = "read"
+	scopeAdmin = "admin"
+)
+
+// AdminToken is a named admin API credential. Read-scoped tokens may only
+// query routes and stats; admin-scoped tokens may also change routing.
+type AdminToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Scope string `json:"scope"`
+}
+
+// adminIdentity is the authenticated caller attached to each admin request.
+type adminIdentity struct {
+	Name  string
+	Scope string
+}
+
+type identityKey struct{}
+
+// --- Admin API Logic ---
+
+func startAdminServer() {
+	if adminClientCA != "" && (adminTLSCert == "" || adminTLSKey == "") {
+		log.Fatal("Error: -admin-client-ca requires -admin-tls-cert and -admin-tls-key")
+	}
+	loadAdminTokens()
+	if len(adminTokens) == 0 && adminClientCA == "" {
+		adminToken = generateToken()
+		adminTokens = append(adminTokens, AdminToken{Name: "default", Token: adminToken, Scope: scopeAdmin})
+		log.Printf("[ADMIN] No admin auth configured, generated token: %s", adminToken)
+	}
+	if host, _, err := net.SplitHostPort(adminAddr); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			log.Printf("[ADMIN] Warning: admin API is bound to non-loopback address %s", adminAddr)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/openapi.yaml", requireScope(scopeRead, handleOpenAPI))
+	mux.HandleFunc("GET /api/routes", requireScope(scopeRead, handleListRoutes))
+	mux.HandleFunc("GET /api/stats", requireScope(scopeRead, handleStats))
+	mux.HandleFunc("GET /api/manifest", requireScope(scopeRead, handleManifest))
+	mux.HandleFunc("GET /api/clients", requireScope(scopeRead, handleListClients))
+	mux.HandleFunc("GET /api/top", requireScope(scopeRead, handleTop))
+	mux.HandleFunc("GET /api/sessions", requireScope(scopeRead, handleListSessions))
+	mux.HandleFunc("GET /api/rebind", requireScope(scopeRead, handleListRebindStates))
+	mux.HandleFunc("DELETE /api/rebind", requireScope(scopeAdmin, handleResetRebindStates))
+	mux.HandleFunc("GET /api/version", requireScope(scopeRead, handleVersion))
+	mux.HandleFunc("POST /api/simulate", requireScope(scopeRead, handleSimulate))
+	mux.HandleFunc("GET /metrics", requireScope(scopeRead, handleMetrics))
+	mux.HandleFunc("GET /events", requireScope(scopeRead, handleEvents))
+	mux.HandleFunc("POST /api/routes", requireScope(scopeAdmin, handleAddRoute))
+	mux.HandleFunc("GET /api/routes/{source}", requireScope(scopeRead, handleGetRoute))
+	mux.HandleFunc("PUT /api/routes/{source}", requireScope(scopeAdmin, handlePutRoute))
+	mux.HandleFunc("DELETE /api/routes/{source}", requireScope(scopeAdmin, handleDeleteRoute))
+	mux.HandleFunc("POST /api/routes/{source}/enable", requireScope(scopeAdmin, handleEnableRoute))
+	mux.HandleFunc("POST /api/routes/{source}/disable", requireScope(scopeAdmin, handleDisableRoute))
+	mux.HandleFunc("POST /api/reload", requireScope(scopeAdmin, handleReload))
+	mux.HandleFunc("GET /api/killswitch", requireScope(scopeRead, handleKillSwitchStatus))
+	mux.HandleFunc("POST /api/killswitch", requireScope(scopeAdmin, handleEngageKillSwitch))
+	mux.HandleFunc("DELETE /api/killswitch", requireScope(scopeAdmin, handleReleaseKillSwitch))
+	mux.HandleFunc("GET /api/groups", requireScope(scopeRead, handleListGroups))
+	mux.HandleFunc("POST /api/groups/{name}/enable", requireScope(scopeAdmin, handleEnableGroup))
+	mux.HandleFunc("POST /api/groups/{name}/disable", requireScope(scopeAdmin, handleDisableGroup))
+	mux.HandleFunc("GET /api/acme/challenges", requireScope(scopeRead, handleListACMEChallenges))
+	mux.HandleFunc("PUT /api/acme/challenges/{token}", requireScope(scopeAdmin, handleSetACMEChallenge))
+	mux.HandleFunc("DELETE /api/acme/challenges/{token}", requireScope(scopeAdmin, handleDeleteACMEChallenge))
+	mux.HandleFunc("GET /api/baits", requireScope(scopeRead, handleListBaits))
+	mux.HandleFunc("POST /api/baits", requireScope(scopeAdmin, handleMintBait))
+	mux.HandleFunc("DELETE /api/baits/{host}", requireScope(scopeAdmin, handleDeleteBait))
+	mux.HandleFunc("GET /api/certs", requireScope(scopeRead, handleListCerts))
+	mux.HandleFunc("PUT /api/certs/{name}", requireScope(scopeAdmin, handleImportCert))
+	mux.HandleFunc("DELETE /api/certs/{name}", requireScope(scopeAdmin, handleDeleteCert))
+	mux.HandleFunc("POST /api/certs/{name}/revoke", requireScope(scopeAdmin, handleRevokeCert))
+	mux.HandleFunc("GET /api/ca", requireScope(scopeRead, handleExportCA))
+	mux.HandleFunc("PUT /api/ca", requireScope(scopeAdmin, handleImportCA))
+	mux.HandleFunc("DELETE /api/ca", requireScope(scopeAdmin, handleDeleteCA))
+	registerDiagnostics(mux)
+
+	server := &http.Server{
+		Addr:    adminAddr,
+		Handler: engagementHeaders(adminAuth(auditCalls(mux))),
+	}
+	onShutdown(func(ctx context.Context) { _ = server.Shutdown(ctx) })
+
+	if adminTLSCert == "" {
+		log.Printf("Admin API listening on http://%s", adminAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+		return
+	}
+
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if adminClientCA != "" {
+		pem, err := os.ReadFile(adminClientCA)
+		if err != nil {
+			log.Fatalf("Failed to read admin client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in admin client CA %s", adminClientCA)
+		}
+		server.TLSConfig.ClientCAs = pool
+		// Token holders may still connect without a certificate
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if len(adminTokens) == 0 {
+			server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	log.Printf("Admin API listening on https://%s", adminAddr)
+	if err := server.ListenAndServeTLS(adminTLSCert, adminTLSKey); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Failed to start admin API: %v", err)
+	}
+}
+
+// loadAdminTokens collects the -admin-token and -admin-tokens credentials.
+func loadAdminTokens() {
+	if adminToken != "" {
+		adminTokens = append(adminTokens, AdminToken{Name: "default", Token: adminToken, Scope: scopeAdmin})
+	}
+	if adminTokensFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(adminTokensFile)
+	if err != nil {
+		log.Fatalf("Failed to read admin tokens: %v", err)
+	}
+	var tokens []AdminToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		log.Fatalf("Invalid JSON admin tokens: %v", err)
+	}
+	for _, t := range tokens {
+		if t.Token == "" {
+			log.Fatalf("Admin token %q has an empty token", t.Name)
+		}
+		if t.Scope != scopeRead && t.Scope != scopeAdmin {
+			log.Fatalf("Admin token %q has unknown scope %q (want %s or %s)", t.Name, t.Scope, scopeRead, scopeAdmin)
+		}
+		adminTokens = append(adminTokens, t)
+		log.Printf("[ADMIN] Loaded token %q with %s scope", t.Name, t.Scope)
+	}
+}
+
+// adminAuth accepts either a verified client certificate (full admin scope)
+// or one of the configured bearer tokens.
+func adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			id := adminIdentity{Name: "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName, Scope: scopeAdmin}
+			next.ServeHTTP(w, withIdentity(r, id))
+			return
+		}
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			for _, t := range adminTokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+					next.ServeHTTP(w, withIdentity(r, adminIdentity{Name: "token:" + t.Name, Scope: t.Scope}))
+					return
+				}
+			}
+		}
+		log.Printf("[ADMIN] Rejected unauthenticated request from %s: %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+		auditRequest(r, "auth_failed", map[string]string{"method": r.Method, "path": r.URL.Path})
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// requireScope rejects callers whose token scope does not cover the handler.
+func requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, _ := requestIdentity(r)
+		if scope == scopeAdmin && id.Scope != scopeAdmin {
+			log.Printf("[ADMIN] Rejected %s %s from %s: %s scope required", r.Method, r.URL.Path, id.Name, scope)
+			auditRequest(r, "forbidden", map[string]string{"method": r.Method, "path": r.URL.Path, "scope": id.Scope})
+			writeJSONError(w, http.StatusForbidden, "insufficient scope")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func withIdentity(r *http.Request, id adminIdentity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+func requestIdentity(r *http.Request) (adminIdentity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(adminIdentity)
+	return id, ok
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(api.OpenAPI)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, stats.snapshot())
+}
+
+func handleListRoutes(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	routes := make([]ConfigRoute, 0, len(routeMap))
+	for id, rt := range routeMap {
+		routes = append(routes, rt.config(id))
+	}
+	mu.RUnlock()
+
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	writeJSON(w, http.StatusOK, routes)
+}
+
+func handleGetRoute(w http.ResponseWriter, r *http.Request) {
+	id := canonicalSource(r.PathValue("source"))
+	mu.RLock()
+	rt, exists := routeMap[id]
+	mu.RUnlock()
+
+	if !exists {
+		writeJSONError(w, http.StatusNotFound, "route not found")
+		return
+	}
+	w.Header().Set("ETag", rt.etag())
+	writeJSON(w, http.StatusOK, rt.config(id))
+}
+
+// handlePutRoute creates or replaces the route with the canonical ID in the
+// path. Repeating the same PUT is a no-op, and If-Match / If-None-Match let
+// declarative tooling detect concurrent changes.
+func handlePutRoute(w http.ResponseWriter, r *http.Request) {
+	id := canonicalSource(r.PathValue("source"))
+	var cfg ConfigRoute
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid route: %v", err))
+		return
+	}
+	if cfg.Source == "" {
+		cfg.Source = id
+	}
+	if canonicalSource(cfg.Source) != id {
+		writeJSONError(w, http.StatusBadRequest, "source does not match route ID")
+		return
+	}
+	rt, err := newRoute(cfg)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mu.Lock()
+	previous, exists := routeMap[id]
+	currentETag := ""
+	if exists {
+		currentETag = previous.etag()
+	}
+	if status := checkPreconditions(r, exists, currentETag); status != 0 {
+		mu.Unlock()
+		writeJSONError(w, status, "precondition failed")
+		return
+	}
+	etag := rt.etag()
+	if exists && currentETag == etag {
+		// Unchanged: keep the live route, its index entries and state
+		mu.Unlock()
+		w.Header().Set("ETag", etag)
+		writeJSON(w, http.StatusOK, previous.config(id))
+		return
+	}
+	routeMap[id] = rt
+	mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+
+	log.Printf("[ADMIN] Route set: %s -> %s", id, rt.Target)
+	auditRouteSet(r, id, rt, previous)
+	routesChanged()
+
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, rt.config(id))
+}
+
+func handleAddRoute(w http.ResponseWriter, r *http.Request) {
+	var cfg ConfigRoute
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid route: %v", err))
+		return
+	}
+	if cfg.Source == "" || cfg.Target == "" {
+		writeJSONError(w, http.StatusBadRequest, "source and target are required")
+		return
+	}
+	rt, err := newRoute(cfg)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id := canonicalSource(cfg.Source)
+	mu.Lock()
+	previous := routeMap[id]
+	routeMap[id] = rt
+	mu.Unlock()
+
+	log.Printf("[ADMIN] Route added: %s -> %s", cfg.Source, cfg.Target)
+	auditRouteSet(r, id, rt, previous)
+	routesChanged()
+	writeJSON(w, http.StatusOK, rt.config(id))
+}
+
+func handleDeleteRoute(w http.ResponseWriter, r *http.Request) {
+	id := canonicalSource(r.PathValue("source"))
+
+	mu.Lock()
+	previous, exists := routeMap[id]
+	if !exists {
+		mu.Unlock()
+		writeJSONError(w, http.StatusNotFound, "route not found")
+		return
+	}
+	if status := checkPreconditions(r, true, previous.etag()); status != 0 {
+		mu.Unlock()
+		writeJSONError(w, status, "precondition failed")
+		return
+	}
+	delete(routeMap, id)
+	mu.Unlock()
+
+	log.Printf("[ADMIN] Route deleted: %s", id)
+	auditRequest(r, "route_delete", map[string]any{"source": id, "previous": previous.config(id)})
+	routesChanged()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	doc, err := readConfig(configFiles)
+	if err != nil {
+		auditRequest(r, "reload_failed", map[string]string{"config": strings.Join(configFiles, ", "), "error": err.Error()})
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	before := routeSnapshot()
+	setEngagement(doc.Engagement)
+	setRoutes(doc.Routes)
+	log.Printf("[ADMIN] Reloaded %d routes from %s", len(doc.Routes), strings.Join(configFiles, ", "))
+	auditRequest(r, "reload", map[string]any{"config": strings.Join(configFiles, ", "), "changes": diffRoutes(before, routeSnapshot())})
+	writeJSON(w, http.StatusOK, adminclient.ReloadResult{Routes: len(doc.Routes)})
+}
+
+// --- Helpers ---
+
+func auditRouteSet(r *http.Request, id string, rt, previous *route) {
+	details := map[string]any{"source": id, "route": rt.config(id)}
+	if previous != nil {
+		details["previous"] = previous.config(id)
+	}
+	auditRequest(r, "route_set", details)
+}
+
+// routeSnapshot returns the live route table keyed by route ID.
+func routeSnapshot() map[string]ConfigRoute {
+	mu.RLock()
+	defer mu.RUnlock()
+	snap := make(map[string]ConfigRoute, len(routeMap))
+	for id, rt := range routeMap {
+		snap[id] = rt.config(id)
+	}
+	return snap
+}
+
+// diffRoutes describes how the route table changed between two snapshots.
+func diffRoutes(before, after map[string]ConfigRoute) map[string]any {
+	added := map[string]ConfigRoute{}
+	changed := map[string][2]ConfigRoute{}
+	var removed []string
+	for id, cfg := range after {
+		old, ok := before[id]
+		if !ok {
+			added[id] = cfg
+		} else if !reflect.DeepEqual(old, cfg) {
+			changed[id] = [2]ConfigRoute{old, cfg}
+		}
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+	return map[string]any{"added": added, "removed": removed, "changed": changed}
+}
+
+// checkPreconditions evaluates If-Match / If-None-Match against the current
+// route state and returns a non-zero status when the request must fail.
+func checkPreconditions(r *http.Request, exists bool, etag string) int {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !exists || (ifMatch != "*" && !etagListContains(ifMatch, etag)) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && exists {
+		if ifNoneMatch == "*" || etagListContains(ifNoneMatch, etag) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+func etagListContains(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func generateToken() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,988 @@
+// Package adminclient is a typed client for the goRebind admin API described
+// in api/openapi.yaml. It is shared by the `goRebind ctl` subcommands and any
+// orchestration tooling that manages relays.
+//
+// The client is written by hand, not generated: a change to the API's paths
+// or schemas goes with the matching change here and in the document.
+// /metrics and the /debug endpoints are left to Prometheus and go tool pprof.
+package adminclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route maps a source hostname to a target URL. ID is the canonical
+// (lowercase) source used in /api/routes/{id}.
+type Route struct {
+	ID     string `json:"id,omitempty"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+
+	// Priority decides between routes specificity cannot: regex sources,
+	// port routes of one host and repeated definitions of a source. Higher
+	// wins; ties go to config order (default 0)
+	Priority int `json:"priority,omitempty"`
+
+	// AutoPrefixes makes the route also answer for its apex and these
+	// labels in front of it, e.g. ["www"] serves app.local and
+	// www.app.local alike
+	AutoPrefixes []string `json:"auto_prefixes,omitempty"`
+
+	// ActiveFrom and ActiveUntil (RFC 3339 times) bound when the route is
+	// active, and ActiveWindows ("Mon-Fri 09:00-17:00", in -active-tz)
+	// limit it to those times; an inactive route is skipped as if disabled
+	ActiveFrom    string   `json:"active_from,omitempty"`
+	ActiveUntil   string   `json:"active_until,omitempty"`
+	ActiveWindows []string `json:"active_windows,omitempty"`
+
+	// Tags are free-form labels published in the route manifest, e.g. the
+	// team or phase a route belongs to
+	Tags []string `json:"tags,omitempty"`
+
+	// Group names a set of routes that can be disabled and re-enabled
+	// together at runtime, e.g. "staging"
+	Group string `json:"group,omitempty"`
+
+	// Methods and Query restrict the route to requests with one of these
+	// methods and these query parameters ("" accepts any value); other
+	// requests fall through to less specific routes, or get 404
+	Methods []string          `json:"methods,omitempty"`
+	Query   map[string]string `json:"query,omitempty"`
+
+	// Clients restricts the route to client addresses in these IPs or
+	// CIDRs, for DNS queries and HTTP requests alike; other clients see the
+	// next matching route, as if this one did not exist
+	Clients []string `json:"clients,omitempty"`
+
+	// Mode limits the route to DNS answers ("dns", never proxied) or to
+	// proxying ("http", DNS is left alone); default "both"
+	Mode string `json:"mode,omitempty"`
+
+	// WarmConns keeps this many upstream connections pre-established
+	WarmConns int `json:"warm_conns,omitempty"`
+
+	// Timeout is the overall deadline for proxied requests, e.g. "10s"
+	Timeout string `json:"timeout,omitempty"`
+
+	// SkipSSLVerify overrides -skip-ssl-verify for the route's upstreams
+	SkipSSLVerify *bool `json:"skip_ssl_verify,omitempty"`
+
+	// SNI is the TLS server name presented to https upstreams and checked
+	// against their certificates, e.g. for targets given as IP addresses.
+	// It is also the Host header unless HostHeader is set
+	SNI string `json:"sni,omitempty"`
+
+	// StripPrefix is removed from request paths before the target's own
+	// path is prepended
+	StripPrefix string `json:"strip_prefix,omitempty"`
+
+	// HostHeader replaces the Host header sent upstream
+	HostHeader string `json:"host_header,omitempty"`
+
+	// Headers are set on every request sent upstream; "-" removes a header
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Proxy is an outbound proxy URL overriding -proxy; "-" connects directly
+	Proxy string `json:"proxy,omitempty"`
+
+	// Transform rewrites the method, query and body of upstream requests
+	Transform *RequestTransform `json:"transform,omitempty"`
+
+	// Pace is the minimum interval between upstream requests, e.g. "500ms";
+	// PaceJitter adds up to this much more to each interval
+	Pace       string `json:"pace,omitempty"`
+	PaceJitter string `json:"pace_jitter,omitempty"`
+
+	// ErrorPages maps a status the relay emits on proxy failure ("502",
+	// "504") to a file served as the response body
+	ErrorPages map[string]string `json:"error_pages,omitempty"`
+
+	// StatusMap replaces upstream responses with a given status code
+	StatusMap map[string]StatusRewrite `json:"status_map,omitempty"`
+
+	// Compression controls upstream encoding: "passthrough", "identity"
+	// (request plaintext bodies) or "transcode" (decompress at the relay,
+	// re-compress for the client)
+	Compression string `json:"compression,omitempty"`
+
+	// Trailers, Informational and Upgrade relay chunked trailers, 1xx
+	// responses (100 Continue, 103 Early Hints) and Upgrade handshakes
+	// (WebSockets); nil means enabled
+	Trailers      *bool `json:"trailers,omitempty"`
+	Informational *bool `json:"informational,omitempty"`
+	Upgrade       *bool `json:"upgrade,omitempty"`
+
+	// GraphQL logs the operation names and variables of requests instead
+	// of treating their bodies as opaque
+	GraphQL *bool `json:"graphql,omitempty"`
+
+	// CompareWith is a secondary target sent a copy of every request;
+	// responses that differ from the primary's are logged
+	CompareWith string `json:"compare_with,omitempty"`
+
+	// Backends are extra upstreams sharing the route's traffic with Target
+	Backends []Backend `json:"backends,omitempty"`
+
+	// Weight is Target's share of traffic relative to the backends (default 1)
+	Weight int `json:"weight,omitempty"`
+
+	// Canary steers a percentage of requests at a separate target
+	Canary *Canary `json:"canary,omitempty"`
+
+	// Sticky keeps a client on one backend: "ip" or "cookie"
+	Sticky string `json:"sticky,omitempty"`
+
+	// StickyCookie names the affinity cookie (default "SERVERID")
+	StickyCookie string `json:"sticky_cookie,omitempty"`
+
+	// ViaSSH dials the route's upstreams through a relay-managed SSH
+	// connection instead of directly
+	ViaSSH *SSHTunnel `json:"via_ssh,omitempty"`
+
+	// ViaWireGuard is a wg-quick config file; upstream connections egress
+	// through a userspace WireGuard tunnel
+	ViaWireGuard string `json:"via_wireguard,omitempty"`
+
+	// SpoofHeaders disguises the route's response headers
+	SpoofHeaders *HeaderSpoof `json:"spoof_headers,omitempty"`
+
+	// Redact masks or strips fields of JSON and XML responses
+	Redact *Redaction `json:"redact,omitempty"`
+
+	// MaxResponseBytes caps the response body relayed to the client, and
+	// ContentTypes lists the media types ("text/*" allowed) it may have
+	MaxResponseBytes int64    `json:"max_response_bytes,omitempty"`
+	ContentTypes     []string `json:"content_types,omitempty"`
+
+	// RebindIP is the address a client's A lookups switch to once the
+	// route's strategy fires; without it the relay's address is returned
+	RebindIP string `json:"rebind_ip,omitempty"`
+
+	// StrategyProfile tunes the rebind timing to a client's DNS pinning:
+	// "chrome", "firefox", "safari" or "iot", or "auto" to pick one per
+	// client from its fingerprint
+	StrategyProfile string `json:"strategy_profile,omitempty"`
+
+	// AutoProfiles overrides the profile auto picks for a browser or OS,
+	// e.g. {"Firefox": "chrome", "Android": "default"}
+	AutoProfiles map[string]string `json:"auto_profiles,omitempty"`
+
+	// TLSCert and TLSKey are PEM files of the certificate the HTTPS
+	// listener presents for the route's names instead of a minted one
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+
+	// TLSPassthrough overrides -tls-passthrough: the HTTPS listener
+	// tunnels the route's connections to its https target undecrypted
+	TLSPassthrough *bool `json:"tls_passthrough,omitempty"`
+
+	// HTTP3 overrides -http3: the route's https upstreams are tried over
+	// QUIC first
+	HTTP3 *bool `json:"http3,omitempty"`
+}
+
+// Backend is an additional upstream of a route.
+type Backend struct {
+	Target string `json:"target"`
+	Weight int    `json:"weight,omitempty"` // default 1
+}
+
+// RequestTransform rewrites requests before they are sent upstream. Query
+// values and Body are text/template templates over the client's request.
+type RequestTransform struct {
+	Method      string            `json:"method,omitempty"`
+	Query       map[string]string `json:"query,omitempty"` // "-" removes a parameter
+	Body        string            `json:"body,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+}
+
+// Redaction hides fields of a route's JSON and XML responses. A field is a
+// key or element name, or a dotted path of them such as "user.email".
+type Redaction struct {
+	Fields []string `json:"fields"`
+	Action string   `json:"action,omitempty"` // "mask" (default) or "strip"
+	Mask   string   `json:"mask,omitempty"`   // default "[REDACTED]"
+}
+
+// SSHTunnel is an SSH server used as a jump host for a route.
+type SSHTunnel struct {
+	Host string `json:"host"` // host[:port], port 22 by default
+	User string `json:"user"`
+	Key  string `json:"key"` // private key file
+	// KnownHosts verifies the server key. It is required unless
+	// InsecureHostKey accepts any key
+	KnownHosts      string `json:"known_hosts,omitempty"`
+	InsecureHostKey bool   `json:"insecure_host_key,omitempty"`
+}
+
+// HeaderSpoof rewrites response headers that fingerprint the upstream or
+// the relay.
+type HeaderSpoof struct {
+	// Server and PoweredBy replace those headers; "-" removes them
+	Server    string `json:"server,omitempty"`
+	PoweredBy string `json:"powered_by,omitempty"`
+	// Strip lists further response headers to remove
+	Strip []string `json:"strip,omitempty"`
+	// RequestID keeps the relay's X-Request-Id header
+	RequestID bool `json:"request_id,omitempty"`
+}
+
+// Canary receives a fixed percentage of a route's requests.
+type Canary struct {
+	Target  string  `json:"target"`
+	Percent float64 `json:"percent"`
+}
+
+// StatusRewrite replaces an upstream response with a local file.
+type StatusRewrite struct {
+	// Status sent to the client; zero keeps the upstream status
+	Status int    `json:"status,omitempty"`
+	Body   string `json:"body"`
+}
+
+// ReloadResult is returned after reloading the config file.
+type ReloadResult struct {
+	Routes int `json:"routes"`
+}
+
+// RouteStats holds per-route traffic counters.
+type RouteStats struct {
+	DNSHits      uint64 `json:"dns_hits"`
+	HTTPRequests uint64 `json:"http_requests"`
+}
+
+// Stats is a snapshot of the relay's traffic counters.
+type Stats struct {
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	DNSQueries    uint64                 `json:"dns_queries"`
+	DNSHits       uint64                 `json:"dns_hits"`
+	HTTPRequests  uint64                 `json:"http_requests"`
+	HTTPUnmatched uint64                 `json:"http_unmatched"`
+	ProxyErrors   uint64                 `json:"proxy_errors"`
+	Cloaked       uint64                 `json:"cloaked"`
+	Shed          uint64                 `json:"shed"`
+	Honeypot      uint64                 `json:"honeypot"`
+	Routes        map[string]*RouteStats `json:"routes"`
+
+	// ProxyErrorClasses breaks ProxyErrors down by cause, e.g. dial_timeout
+	ProxyErrorClasses map[string]uint64 `json:"proxy_error_classes"`
+
+	// Clients counts fingerprinted client addresses by inferred browser and
+	// OS, e.g. "Chrome/Windows"; with -fingerprint only
+	Clients map[string]uint64 `json:"clients,omitempty"`
+}
+
+// ClientFingerprint is what the relay inferred about one client address
+// from its DNS queries, HTTP headers and TLS ClientHello. OS and Browser
+// stay empty until known; Source says what decided them: "user-agent",
+// "client-hints" or "tls".
+type ClientFingerprint struct {
+	Client    string `json:"client"`
+	OS        string `json:"os,omitempty"`
+	Browser   string `json:"browser,omitempty"`
+	Source    string `json:"source,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	JA3       string `json:"ja3,omitempty"`
+	JA4       string `json:"ja4,omitempty"`
+
+	// Resolver is set once the address sends 0x20 case-randomized
+	// queries, as recursive resolvers do: it is then a resolver rather
+	// than the victim's device
+	Resolver bool   `json:"resolver,omitempty"`
+	EDNSSize uint16 `json:"edns_size,omitempty"`
+	AAAA     bool   `json:"aaaa,omitempty"` // asked for AAAA records
+
+	DNSQueries    uint64    `json:"dns_queries"`
+	HTTPRequests  uint64    `json:"http_requests"`
+	TLSHandshakes uint64    `json:"tls_handshakes"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+// Top is a relay's live activity: DNS and HTTP rates averaged over the last
+// WindowSeconds, with the busiest clients and routes of that window.
+type Top struct {
+	WindowSeconds int        `json:"window_seconds"`
+	DNSPerSecond  float64    `json:"dns_per_second"`
+	HTTPPerSecond float64    `json:"http_per_second"`
+	Clients       []TopEntry `json:"clients"`
+	Routes        []TopEntry `json:"routes"`
+}
+
+// TopEntry counts one client's or route's activity in a Top window.
+type TopEntry struct {
+	Name         string `json:"name"`
+	DNSQueries   uint64 `json:"dns_queries"`
+	HTTPRequests uint64 `json:"http_requests"`
+}
+
+// Session stitches one client's DNS lookups of a routed name together with
+// the TLS handshakes and HTTP requests that followed, so the whole
+// rebinding chain reads as one record. Client is the device that
+// connected; Resolvers are the addresses its lookups came from, which are
+// the device itself when it resolves directly.
+type Session struct {
+	ID          string        `json:"id"`
+	Host        string        `json:"host"`
+	Route       string        `json:"route,omitempty"`
+	Client      string        `json:"client,omitempty"`
+	Resolvers   []string      `json:"resolvers,omitempty"`
+	Fingerprint string        `json:"fingerprint,omitempty"` // Browser/OS, with -fingerprint
+	Rebound     bool          `json:"rebound"`               // handed the route's rebind_ip
+	Open        bool          `json:"open,omitempty"`
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end"`
+	Chain       string        `json:"chain"` // e.g. "dns x2 -> tls -> http x3"
+	Steps       []SessionStep `json:"steps"`
+
+	DNSQueries    int `json:"dns_queries"`
+	TLSHandshakes int `json:"tls_handshakes"`
+	HTTPRequests  int `json:"http_requests"`
+	StepsDropped  int `json:"steps_dropped,omitempty"`
+}
+
+// SessionStep is one DNS query, TLS handshake or HTTP request of a session.
+type SessionStep struct {
+	Time     time.Time `json:"time"`
+	Protocol string    `json:"protocol"` // dns, tls or http
+	Client   string    `json:"client"`
+	Detail   string    `json:"detail"`
+}
+
+// RebindState is one client's progress through the rebind strategy of a
+// host: Lookups were answered with the relay's address, and Rebound is set
+// once answers switched to the rebind IP. Profile is the strategy profile
+// in effect at the last lookup.
+type RebindState struct {
+	Client      string    `json:"client"`
+	Host        string    `json:"host"`
+	Profile     string    `json:"profile"`
+	Lookups     int       `json:"lookups"`
+	Rebound     bool      `json:"rebound"`
+	FirstLookup time.Time `json:"first_lookup"`
+	LastLookup  time.Time `json:"last_lookup"`
+}
+
+// RebindReset is returned after resetting rebind states.
+type RebindReset struct {
+	Reset int `json:"reset"`
+}
+
+// SimulateRequest describes a hypothetical DNS query (QName) and HTTP
+// request (Host) from a client. Either may be left out; nothing is sent.
+type SimulateRequest struct {
+	Client  string            `json:"client,omitempty"` // client IP; empty for any client
+	QName   string            `json:"qname,omitempty"`
+	QType   string            `json:"qtype,omitempty"`  // default A
+	Host    string            `json:"host,omitempty"`   // Host header, with the listener port if it matters
+	Method  string            `json:"method,omitempty"` // default GET
+	Path    string            `json:"path,omitempty"`   // path and query, default /
+	Headers map[string]string `json:"headers,omitempty"`
+	TLS     bool              `json:"tls,omitempty"` // arrived on an HTTPS listener
+}
+
+// Simulation is what the relay would do with a SimulateRequest.
+type Simulation struct {
+	DNS  *DNSSimulation  `json:"dns,omitempty"`
+	HTTP *HTTPSimulation `json:"http,omitempty"`
+}
+
+// DNSSimulation describes the answer to a simulated query. Result is
+// "answer" (Answer with TTL), "empty" (AAAA blocked by the strategy),
+// "not_served" (the relay has no address to answer with), "system" (left
+// to the system resolver) or "refused".
+type DNSSimulation struct {
+	Route   string       `json:"route,omitempty"`
+	Result  string       `json:"result"`
+	Answer  string       `json:"answer,omitempty"`
+	TTL     *uint32      `json:"ttl,omitempty"`
+	Rebound bool         `json:"rebound,omitempty"` // Answer is the route's rebind_ip
+	Profile string       `json:"profile,omitempty"` // rebind strategy profile for the client
+	State   *RebindState `json:"state,omitempty"`   // the client's live strategy state for the name
+	Reason  string       `json:"reason,omitempty"`
+}
+
+// HTTPSimulation describes where a simulated request would go. Result is
+// "proxy" (to Target, or one of Backends picked per request), "static",
+// "forward" (to the requested host), "not_found" or "unmatched".
+type HTTPSimulation struct {
+	Route    string   `json:"route,omitempty"`
+	Result   string   `json:"result"`
+	Target   string   `json:"target,omitempty"`   // upstream URL
+	Backends []string `json:"backends,omitempty"` // candidates when rotated or split by canary
+	Reason   string   `json:"reason,omitempty"`
+}
+
+// Manifest describes a relay's live route table for deconfliction and
+// peer operators. It leaves out headers, proxies, tunnels and other route
+// settings that may carry credentials.
+type Manifest struct {
+	Relay       string          `json:"relay,omitempty"`
+	Engagement  string          `json:"engagement,omitempty"`
+	Generated   time.Time       `json:"generated"`
+	ForwardOnly bool            `json:"forward_only"`        // safe mode, kill switch engaged or outside activity windows
+	SafeMode    bool            `json:"safe_mode,omitempty"` // forward-only until restarted
+	Routes      []ManifestRoute `json:"routes"`
+}
+
+// ManifestRoute is one route of a Manifest. Kind is "exact", "wildcard",
+// "path", "regex" or "default"; Mode is "dns", "http" or "both".
+type ManifestRoute struct {
+	ID            string          `json:"id"`
+	Kind          string          `json:"kind"`
+	Mode          string          `json:"mode"`
+	Targets       []string        `json:"targets"`
+	Aliases       []string        `json:"aliases,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+	Clients       []string        `json:"clients,omitempty"`
+	Group         string          `json:"group,omitempty"`
+	Enabled       bool            `json:"enabled"`
+	Disabled      bool            `json:"disabled,omitempty"` // switched off on its own
+	ActiveFrom    string          `json:"active_from,omitempty"`
+	ActiveUntil   string          `json:"active_until,omitempty"`
+	ActiveWindows []string        `json:"active_windows,omitempty"`
+	Priority      int             `json:"priority,omitempty"`
+	Strategy      *RebindStrategy `json:"strategy,omitempty"`
+	DNSHits       uint64          `json:"dns_hits"`
+	HTTPRequests  uint64          `json:"http_requests"`
+}
+
+// RebindStrategy is the DNS rebind timing of a route with a rebind IP:
+// lookups are answered with the relay's address until AfterQueries lookups
+// and After have passed, then with RebindIP.
+type RebindStrategy struct {
+	Profile      string `json:"profile"` // "default" without a strategy_profile
+	RebindIP     string `json:"rebind_ip"`
+	TTL          int    `json:"ttl"`
+	AfterQueries int    `json:"after_queries"`
+	After        string `json:"after,omitempty"`
+	BlockAAAA    bool   `json:"block_aaaa,omitempty"`
+}
+
+// BuildInfo identifies the build a relay is running.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// KillSwitchStatus reports whether routing is disabled.
+type KillSwitchStatus struct {
+	Engaged bool       `json:"engaged"`
+	Since   *time.Time `json:"since,omitempty"`
+	Host    string     `json:"host,omitempty"`
+}
+
+// GroupStatus reports whether a route group is enabled and the IDs of its
+// routes.
+type GroupStatus struct {
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Routes  []string `json:"routes"`
+}
+
+// RouteStatus reports whether a route is switched off on its own and
+// whether it is serving, which also needs its group enabled and its
+// schedule active.
+type RouteStatus struct {
+	ID       string `json:"id"`
+	Disabled bool   `json:"disabled"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// Bait is a uniquely named route minted to detect when a seeded document
+// or config is used; Webhook is called on its first DNS or HTTP hit.
+type Bait struct {
+	Host     string     `json:"host,omitempty"`
+	Target   string     `json:"target,omitempty"`
+	Memo     string     `json:"memo,omitempty"`
+	Webhook  string     `json:"webhook,omitempty"`
+	Created  time.Time  `json:"created"`
+	FirstHit *time.Time `json:"first_hit,omitempty"`
+	LastHit  *time.Time `json:"last_hit,omitempty"`
+	Hits     int        `json:"hits"`
+}
+
+// CertInfo describes a certificate served by the relay's HTTPS listener.
+type CertInfo struct {
+	Name        string    `json:"name"`
+	Source      string    `json:"source"` // "minted" or "imported"
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	IPAddresses []string  `json:"ip_addresses,omitempty"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	SHA256      string    `json:"sha256"`
+}
+
+// CertImport is a PEM certificate (chain) and private key to import.
+type CertImport struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Event types published on the /events stream.
+const (
+	EventDNSQuery      = "dns_query"
+	EventRebind        = "rebind"
+	EventHTTPRequest   = "http_request"
+	EventKillSwitch    = "killswitch"
+	EventRoutesChanged = "routes_changed"
+	EventHoneypot      = "honeypot"
+	EventBaitHit       = "bait_hit"
+	EventSession       = "session"
+)
+
+// Event is one entry of the relay's real-time activity stream.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Relay      string    `json:"relay,omitempty"`
+	Engagement string    `json:"engagement,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Client     string    `json:"client,omitempty"`
+	QType      string    `json:"qtype,omitempty"`
+	Hit        bool      `json:"hit,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	DurationMS float64   `json:"duration_ms,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Message    string    `json:"message,omitempty"`
+
+	// Session is the stitched session a session event reports
+	Session *Session `json:"session,omitempty"`
+}
+
+// Error is returned for non-2xx responses.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("admin API: %d %s", e.StatusCode, e.Message)
+}
+
+// Client talks to one relay's admin API.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// New returns a client for baseURL (e.g. "http://127.0.0.1:9090").
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// ListRoutes returns the live route table.
+func (c *Client) ListRoutes(ctx context.Context) ([]Route, error) {
+	var routes []Route
+	err := c.do(ctx, http.MethodGet, "/api/routes", nil, &routes)
+	return routes, err
+}
+
+// SetRoute adds or replaces a route.
+func (c *Client) SetRoute(ctx context.Context, route Route) (Route, error) {
+	var stored Route
+	err := c.do(ctx, http.MethodPost, "/api/routes", route, &stored)
+	return stored, err
+}
+
+// GetRoute returns a single route and its ETag.
+func (c *Client) GetRoute(ctx context.Context, id string) (Route, string, error) {
+	var route Route
+	header, err := c.doHeaders(ctx, http.MethodGet, "/api/routes/"+url.PathEscape(id), nil, nil, &route)
+	return route, header.Get("ETag"), err
+}
+
+// PutRoute idempotently creates or replaces the route with the given ID.
+// A non-empty ifMatch ("*" or an ETag) makes the update conditional; it
+// fails with 412 if the route changed in the meantime.
+func (c *Client) PutRoute(ctx context.Context, id string, route Route, ifMatch string) (Route, string, error) {
+	var stored Route
+	var headers http.Header
+	if ifMatch != "" {
+		headers = http.Header{"If-Match": {ifMatch}}
+	}
+	header, err := c.doHeaders(ctx, http.MethodPut, "/api/routes/"+url.PathEscape(id), headers, route, &stored)
+	return stored, header.Get("ETag"), err
+}
+
+// DeleteRoute removes the route for source.
+func (c *Client) DeleteRoute(ctx context.Context, source string) error {
+	return c.do(ctx, http.MethodDelete, "/api/routes/"+url.PathEscape(source), nil, nil)
+}
+
+// EnableRoute switches a route back on.
+func (c *Client) EnableRoute(ctx context.Context, source string) (RouteStatus, error) {
+	var s RouteStatus
+	err := c.do(ctx, http.MethodPost, "/api/routes/"+url.PathEscape(source)+"/enable", nil, &s)
+	return s, err
+}
+
+// DisableRoute switches a route off, without deleting it, until it is
+// re-enabled.
+func (c *Client) DisableRoute(ctx context.Context, source string) (RouteStatus, error) {
+	var s RouteStatus
+	err := c.do(ctx, http.MethodPost, "/api/routes/"+url.PathEscape(source)+"/disable", nil, &s)
+	return s, err
+}
+
+// Reload re-reads the relay's config file.
+func (c *Client) Reload(ctx context.Context) (ReloadResult, error) {
+	var res ReloadResult
+	err := c.do(ctx, http.MethodPost, "/api/reload", nil, &res)
+	return res, err
+}
+
+// Stats returns the relay's traffic counters.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var s Stats
+	err := c.do(ctx, http.MethodGet, "/api/stats", nil, &s)
+	return s, err
+}
+
+// Version returns the relay's build information.
+func (c *Client) Version(ctx context.Context) (BuildInfo, error) {
+	var b BuildInfo
+	err := c.do(ctx, http.MethodGet, "/api/version", nil, &b)
+	return b, err
+}
+
+// Manifest returns the relay's route manifest.
+func (c *Client) Manifest(ctx context.Context) (Manifest, error) {
+	var m Manifest
+	err := c.do(ctx, http.MethodGet, "/api/manifest", nil, &m)
+	return m, err
+}
+
+// Clients returns the fingerprints of the clients seen, by address.
+func (c *Client) Clients(ctx context.Context) ([]ClientFingerprint, error) {
+	var clients []ClientFingerprint
+	err := c.do(ctx, http.MethodGet, "/api/clients", nil, &clients)
+	return clients, err
+}
+
+// Top returns the live rates with the n busiest clients and routes.
+func (c *Client) Top(ctx context.Context, n int) (Top, error) {
+	var top Top
+	err := c.do(ctx, http.MethodGet, "/api/top?n="+strconv.Itoa(n), nil, &top)
+	return top, err
+}
+
+// Sessions lists stitched sessions, newest first. Empty filters match all.
+func (c *Client) Sessions(ctx context.Context, client, host string) ([]Session, error) {
+	q := url.Values{}
+	if client != "" {
+		q.Set("client", client)
+	}
+	if host != "" {
+		q.Set("host", host)
+	}
+	path := "/api/sessions"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var sessions []Session
+	err := c.do(ctx, http.MethodGet, path, nil, &sessions)
+	return sessions, err
+}
+
+// RebindStates lists clients' progress through rebind strategies, most
+// recent lookup first. Empty filters match all.
+func (c *Client) RebindStates(ctx context.Context, client, host string) ([]RebindState, error) {
+	var states []RebindState
+	err := c.do(ctx, http.MethodGet, "/api/rebind"+rebindQuery(client, host), nil, &states)
+	return states, err
+}
+
+// ResetRebindStates makes the matching clients start their rebind
+// strategies over with the relay's address. Empty filters match all.
+func (c *Client) ResetRebindStates(ctx context.Context, client, host string) (RebindReset, error) {
+	var res RebindReset
+	err := c.do(ctx, http.MethodDelete, "/api/rebind"+rebindQuery(client, host), nil, &res)
+	return res, err
+}
+
+// Simulate reports which route a hypothetical query and request would
+// match and what they would be answered with, without sending anything or
+// advancing rebind strategies.
+func (c *Client) Simulate(ctx context.Context, req SimulateRequest) (Simulation, error) {
+	var sim Simulation
+	err := c.do(ctx, http.MethodPost, "/api/simulate", req, &sim)
+	return sim, err
+}
+
+func rebindQuery(client, host string) string {
+	q := url.Values{}
+	if client != "" {
+		q.Set("client", client)
+	}
+	if host != "" {
+		q.Set("host", host)
+	}
+	if len(q) == 0 {
+		return ""
+	}
+	return "?" + q.Encode()
+}
+
+// KillSwitch returns the kill switch status.
+func (c *Client) KillSwitch(ctx context.Context) (KillSwitchStatus, error) {
+	var s KillSwitchStatus
+	err := c.do(ctx, http.MethodGet, "/api/killswitch", nil, &s)
+	return s, err
+}
+
+// EngageKillSwitch disables all routes.
+func (c *Client) EngageKillSwitch(ctx context.Context) (KillSwitchStatus, error) {
+	var s KillSwitchStatus
+	err := c.do(ctx, http.MethodPost, "/api/killswitch", nil, &s)
+	return s, err
+}
+
+// ReleaseKillSwitch re-enables routes.
+func (c *Client) ReleaseKillSwitch(ctx context.Context) (KillSwitchStatus, error) {
+	var s KillSwitchStatus
+	err := c.do(ctx, http.MethodDelete, "/api/killswitch", nil, &s)
+	return s, err
+}
+
+// Groups lists the route groups and whether each is enabled.
+func (c *Client) Groups(ctx context.Context) ([]GroupStatus, error) {
+	var groups []GroupStatus
+	err := c.do(ctx, http.MethodGet, "/api/groups", nil, &groups)
+	return groups, err
+}
+
+// EnableGroup re-enables the routes of a group.
+func (c *Client) EnableGroup(ctx context.Context, name string) (GroupStatus, error) {
+	var g GroupStatus
+	err := c.do(ctx, http.MethodPost, "/api/groups/"+url.PathEscape(name)+"/enable", nil, &g)
+	return g, err
+}
+
+// DisableGroup disables the routes of a group until it is re-enabled.
+func (c *Client) DisableGroup(ctx context.Context, name string) (GroupStatus, error) {
+	var g GroupStatus
+	err := c.do(ctx, http.MethodPost, "/api/groups/"+url.PathEscape(name)+"/disable", nil, &g)
+	return g, err
+}
+
+// ACMEChallenges lists registered HTTP-01 tokens.
+func (c *Client) ACMEChallenges(ctx context.Context) ([]string, error) {
+	var tokens []string
+	err := c.do(ctx, http.MethodGet, "/api/acme/challenges", nil, &tokens)
+	return tokens, err
+}
+
+// SetACMEChallenge registers an HTTP-01 key authorization.
+func (c *Client) SetACMEChallenge(ctx context.Context, token, keyAuth string) error {
+	return c.doRaw(ctx, http.MethodPut, "/api/acme/challenges/"+url.PathEscape(token), "text/plain", strings.NewReader(keyAuth), nil)
+}
+
+// DeleteACMEChallenge removes an HTTP-01 token.
+func (c *Client) DeleteACMEChallenge(ctx context.Context, token string) error {
+	return c.do(ctx, http.MethodDelete, "/api/acme/challenges/"+url.PathEscape(token), nil, nil)
+}
+
+// MintBait creates a bait route with a fresh hostname. Only Memo, Webhook
+// and Target are read from bait; Target may be empty if the relay has a
+// default bait target.
+func (c *Client) MintBait(ctx context.Context, bait Bait) (Bait, error) {
+	var minted Bait
+	err := c.do(ctx, http.MethodPost, "/api/baits", bait, &minted)
+	return minted, err
+}
+
+// ListBaits returns every minted bait with its hit counters.
+func (c *Client) ListBaits(ctx context.Context) ([]Bait, error) {
+	var baits []Bait
+	err := c.do(ctx, http.MethodGet, "/api/baits", nil, &baits)
+	return baits, err
+}
+
+// DeleteBait removes a bait and its route.
+func (c *Client) DeleteBait(ctx context.Context, host string) error {
+	return c.do(ctx, http.MethodDelete, "/api/baits/"+url.PathEscape(host), nil, nil)
+}
+
+// ListCerts returns the HTTPS listener's minted and imported certificates.
+func (c *Client) ListCerts(ctx context.Context) ([]CertInfo, error) {
+	var certs []CertInfo
+	err := c.do(ctx, http.MethodGet, "/api/certs", nil, &certs)
+	return certs, err
+}
+
+// ImportCert serves the given certificate for a server name (which may be
+// a "*.example.com" wildcard) instead of a minted one.
+func (c *Client) ImportCert(ctx context.Context, name string, cert CertImport) (CertInfo, error) {
+	var info CertInfo
+	err := c.do(ctx, http.MethodPut, "/api/certs/"+url.PathEscape(name), cert, &info)
+	return info, err
+}
+
+// DeleteCert removes a certificate; minted ones are reissued on next use.
+func (c *Client) DeleteCert(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/certs/"+url.PathEscape(name), nil, nil)
+}
+
+// RevokeCert revokes a certificate issued by the internal CA; the relay
+// mints a new one for the name on next use.
+func (c *Client) RevokeCert(ctx context.Context, name string) (CertInfo, error) {
+	var info CertInfo
+	err := c.do(ctx, http.MethodPost, "/api/certs/"+url.PathEscape(name)+"/revoke", nil, &info)
+	return info, err
+}
+
+// CA returns the internal CA certificate as PEM.
+func (c *Client) CA(ctx context.Context) ([]byte, error) {
+	var pem []byte
+	err := c.do(ctx, http.MethodGet, "/api/ca", nil, &pem)
+	return pem, err
+}
+
+// ImportCA replaces the CA that signs minted certificates.
+func (c *Client) ImportCA(ctx context.Context, ca CertImport) (CertInfo, error) {
+	var info CertInfo
+	err := c.do(ctx, http.MethodPut, "/api/ca", ca, &info)
+	return info, err
+}
+
+// DeleteCA removes the internal CA, so certificates are minted self-signed.
+func (c *Client) DeleteCA(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/api/ca", nil, nil)
+}
+
+// Events streams the relay's activity, calling fn for each event until ctx
+// is cancelled, the stream ends or fn returns an error. An empty types
+// list subscribes to every event type.
+func (c *Client) Events(ctx context.Context, types []string, fn func(Event) error) error {
+	path := "/events"
+	if len(types) > 0 {
+		path += "?types=" + url.QueryEscape(strings.Join(types, ","))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	// The stream is long-lived, so the client's overall timeout must not apply
+	hc := *c.HTTPClient
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	_, err := c.doHeaders(ctx, method, path, nil, in, out)
+	return err
+}
+
+func (c *Client) doHeaders(ctx context.Context, method, path string, headers http.Header, in, out any) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	return c.send(ctx, method, path, "application/json", headers, body, out)
+}
+
+func (c *Client) doRaw(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	_, err := c.send(ctx, method, path, contentType, nil, body, out)
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path, contentType string, headers http.Header, body io.Reader, out any) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = http.StatusText(resp.StatusCode)
+		}
+		return resp.Header, &Error{StatusCode: resp.StatusCode, Message: apiErr.Error}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.Header, nil
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw, err = io.ReadAll(resp.Body)
+		return resp.Header, err
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+}
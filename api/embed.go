@@ -0,0 +1,9 @@
+// Package api holds the OpenAPI description of the goRebind admin API.
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 document served at /api/openapi.yaml.
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// Append-only audit trail of control-plane actions
+	auditLogPath string
+	auditFile    *os.File
+	auditMu      sync.Mutex
+)
+
+// AuditEntry is a single line in the audit log.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Remote  string    `json:"remote,omitempty"`
+	Action  string    `json:"action"`
+	Details any       `json:"details,omitempty"`
+
+	Engagement *engagementStamp `json:"engagement,omitempty"`
+}
+
+// --- Audit Logic ---
+
+func openAuditLog() {
+	if auditLogPath == "" {
+		return
+	}
+	f, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	auditFile = f
+	log.Printf("Audit log: %s", auditLogPath)
+}
+
+// audit appends an entry to the audit log. It is a no-op when auditing is disabled.
+func audit(actor, remote, action string, details any) {
+	if auditFile == nil {
+		return
+	}
+	line, err := json.Marshal(AuditEntry{
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Remote:  remote,
+		Action:  action,
+		Details: details,
+
+		Engagement: currentEngagement(),
+	})
+	if err != nil {
+		log.Printf("[AUDIT] Failed to encode entry: %v", err)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		log.Printf("[AUDIT] Failed to write entry: %v", err)
+	}
+}
+
+// auditRequest records an action on behalf of the authenticated admin caller.
+func auditRequest(r *http.Request, action string, details any) {
+	audit(requestActor(r), r.RemoteAddr, action, details)
+}
+
+func requestActor(r *http.Request) string {
+	if id, ok := requestIdentity(r); ok {
+		return id.Name
+	}
+	return "anonymous"
+}
+
+// auditCalls records every admin API call along with its response status.
+func auditCalls(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(lrw, r)
+		auditRequest(r, "api_call", map[string]any{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"status": lrw.statusCode,
+		})
+	})
+}
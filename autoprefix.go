@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A single DNS label, as used in auto_prefixes
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// --- Auto Prefix Logic ---
+
+// parseAutoPrefixes validates and lowercases the route's auto_prefixes.
+// Only exact hosts, with or without a path, have an apex and prefixed names
+// to answer for.
+func (rt *route) parseAutoPrefixes() error {
+	if len(rt.AutoPrefixes) == 0 {
+		return nil
+	}
+	if kind := rt.hostKind(); kind != "exact" {
+		return fmt.Errorf("auto_prefixes needs an exact host source, not a %s one", kind)
+	}
+	prefixes := make([]string, len(rt.AutoPrefixes))
+	for i, p := range rt.AutoPrefixes {
+		prefixes[i] = strings.ToLower(strings.TrimSpace(p))
+		if !dnsLabel.MatchString(prefixes[i]) {
+			return fmt.Errorf("invalid auto prefix %q: want a single label such as www", p)
+		}
+	}
+	rt.AutoPrefixes = prefixes
+	return nil
+}
+
+// bareHost is the host of the route's source, without port or path.
+func (rt *route) bareHost() string {
+	host, _ := splitSource(rt.name())
+	return strings.TrimSuffix(host, ":"+rt.port)
+}
+
+// aliasHosts lists the other names the route answers for: the apex of its
+// host (the host without a leading auto prefix) and each auto prefix in
+// front of the apex.
+func (rt *route) aliasHosts() []string {
+	host := rt.bareHost()
+	apex := host
+	for _, p := range rt.AutoPrefixes {
+		if rest, ok := strings.CutPrefix(host, p+"."); ok {
+			apex = rest
+			break
+		}
+	}
+	var aliases []string
+	add := func(name string) {
+		if name != host && !slices.Contains(aliases, name) {
+			aliases = append(aliases, name)
+		}
+	}
+	add(apex)
+	for _, p := range rt.AutoPrefixes {
+		add(p + "." + apex)
+	}
+	return aliases
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pick each client's strategy profile from its fingerprint on routes with
+// a rebind_ip and no strategy_profile
+var autoStrategy bool
+
+// strategy_profile picking a profile per client
+const autoProfile = "auto"
+
+// Profiles suiting each fingerprint, by lowercase OS and browser. Every
+// iOS browser is WebKit over mDNSResponder, so there the OS decides;
+// command-line tools and HTTP libraries resolve for every request like
+// embedded stacks.
+var (
+	autoSystemProfiles  = map[string]string{"ios": "safari"}
+	autoBrowserProfiles = map[string]string{
+		"chrome": "chrome", "edge": "chrome", "opera": "chrome", "samsung internet": "chrome",
+		"firefox": "firefox",
+		"safari":  "safari",
+		"curl":    "iot", "wget": "iot", "python": "iot", "go": "iot", "okhttp": "iot", "java": "iot",
+	}
+)
+
+// --- Automatic Strategy Logic ---
+
+// setupAutoStrategy turns on the fingerprinting -auto-strategy relies on.
+func setupAutoStrategy() {
+	if autoStrategy {
+		fingerprinting = true
+	}
+}
+
+// parseAutoProfiles validates auto_profiles, keyed by browser or OS names
+// as fingerprints report them, in any case.
+func (rt *route) parseAutoProfiles() error {
+	if len(rt.AutoProfiles) == 0 {
+		return nil
+	}
+	rt.autoProfiles = make(map[string]string, len(rt.AutoProfiles))
+	for key, profile := range rt.AutoProfiles {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid auto_profiles key %q: want a browser or OS such as Firefox or iOS", key)
+		}
+		if _, ok := strategyProfiles[profile]; !ok && profile != "default" {
+			return fmt.Errorf("invalid auto_profiles profile %q for %s (one of: default, %s)", profile, key, strings.Join(sortedKeys(strategyProfiles), ", "))
+		}
+		rt.autoProfiles[strings.ToLower(strings.TrimSpace(key))] = profile
+	}
+	return nil
+}
+
+// picksProfile reports whether the route picks a profile per client.
+func (rt *route) picksProfile() bool {
+	return rt.StrategyProfile == autoProfile || (rt.StrategyProfile == "" && autoStrategy && rt.rebindIP != nil)
+}
+
+// profileFor returns the profile a client's lookups of the route follow:
+// the route's own, or on auto routes the one suiting the client's
+// fingerprint. The route's auto_profiles are consulted first, browser
+// before OS. "" is the default strategy, used until the client is known.
+func (rt *route) profileFor(client string) string {
+	if !rt.picksProfile() {
+		return rt.StrategyProfile
+	}
+	system, browser := clientSystem(client)
+	system, browser = strings.ToLower(system), strings.ToLower(browser)
+	for _, profile := range []string{rt.autoProfiles[browser], rt.autoProfiles[system], autoSystemProfiles[system], autoBrowserProfiles[browser]} {
+		if profile == "default" {
+			return ""
+		}
+		if profile != "" {
+			return profile
+		}
+	}
+	return ""
+}
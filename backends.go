@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"goRebind/adminclient"
+)
+
+// Backend is shared with the admin API client.
+type Backend = adminclient.Backend
+
+// Cookie carrying the backend choice when a route has no sticky_cookie
+const defaultStickyCookie = "SERVERID"
+
+// backend is one parsed upstream of a route.
+type backend struct {
+	url *url.URL
+	// Opaque, stable token identifying the backend in affinity cookies
+	id     string
+	weight int
+
+	// Request URL scheme and host, and the Host header sent upstream
+	scheme, host, hostHeader string
+
+	// Target path and query prepended to request paths and queries
+	path, query string
+}
+
+// --- Backend Selection Logic ---
+
+// parseBackends builds the route's upstream pool: the target first, then
+// any extra backends, then the canary. A CIDR target or backend adds a
+// backend per address.
+func (rt *route) parseBackends() error {
+	switch rt.Sticky {
+	case "", "ip", "cookie":
+	default:
+		return fmt.Errorf("invalid sticky %q (want ip or cookie)", rt.Sticky)
+	}
+	if rt.Weight < 0 {
+		return fmt.Errorf("invalid weight %d", rt.Weight)
+	}
+	if rt.target.Scheme == "unix" && rt.target.Path == "" {
+		return fmt.Errorf("unix target %q has no socket path", rt.Target)
+	}
+	rt.pool = cidrBackends(rt.target, rt.targetCIDR, rt.Weight)
+	for _, b := range rt.Backends {
+		raw, prefix, err := splitTargetCIDR(b.Target)
+		if err != nil {
+			return err
+		}
+		u, err := parseUpstream(raw)
+		if err != nil {
+			return fmt.Errorf("invalid backend URL %q: %w", b.Target, err)
+		}
+		if b.Weight < 0 {
+			return fmt.Errorf("invalid weight %d for backend %s", b.Weight, b.Target)
+		}
+		rt.pool = append(rt.pool, cidrBackends(u, prefix, b.Weight)...)
+	}
+	rt.totalWeight = 0
+	for _, b := range rt.pool {
+		rt.totalWeight += b.weight
+	}
+
+	if rt.Canary != nil {
+		u, err := parseUpstream(rt.Canary.Target)
+		if err != nil {
+			return fmt.Errorf("invalid canary URL %q: %w", rt.Canary.Target, err)
+		}
+		if rt.Canary.Percent <= 0 || rt.Canary.Percent > 100 {
+			return fmt.Errorf("invalid canary percent %v (want above 0, up to 100)", rt.Canary.Percent)
+		}
+		rt.canary = newBackend(u, 0)
+		rt.pool = append(rt.pool, rt.canary)
+	}
+	return nil
+}
+
+func newBackend(u *url.URL, weight int) *backend {
+	sum := sha256.Sum256([]byte(u.String()))
+	b := &backend{url: u, id: hex.EncodeToString(sum[:6]), weight: max(weight, 1)}
+	b.scheme, b.host, b.hostHeader = u.Scheme, u.Host, u.Host
+	b.path, b.query = u.Path, u.RawQuery
+	if u.Scheme == "unix" {
+		b.scheme, b.host, b.hostHeader = "http", unixHost(u.Path), "localhost"
+		b.path, b.query = "", ""
+	}
+	return b
+}
+
+// apply points an outgoing request at the backend.
+func (b *backend) apply(req *http.Request) {
+	req.URL.Scheme = b.scheme
+	req.URL.Host = b.host
+	req.Host = b.hostHeader
+}
+
+// name identifies the backend in logs: its host, or its socket path.
+func (b *backend) name() string {
+	if b.url.Scheme == "unix" {
+		return b.url.Path
+	}
+	return b.url.Host
+}
+
+// upstreams returns the URL of every backend of the route.
+func (rt *route) upstreams() []*url.URL {
+	urls := make([]*url.URL, len(rt.pool))
+	for i, b := range rt.pool {
+		urls[i] = b.url
+	}
+	return urls
+}
+
+func (rt *route) stickyCookie() string {
+	return valueOr(rt.StickyCookie, defaultStickyCookie)
+}
+
+// pickBackend chooses the upstream for a request. Sticky routes keep a
+// client on one backend, by client IP or by an affinity cookie; the
+// returned token is set as that cookie when the client has none yet.
+func (rt *route) pickBackend(req *http.Request) (*backend, string) {
+	if len(rt.pool) == 1 {
+		return rt.pool[0], ""
+	}
+	switch rt.Sticky {
+	case "ip":
+		client, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			client = req.RemoteAddr
+		}
+		h := fnv.New32a()
+		h.Write([]byte(client))
+		sum := h.Sum32()
+		return rt.choose(sum/10000, sum%10000), ""
+	case "cookie":
+		if c, err := req.Cookie(rt.stickyCookie()); err == nil {
+			for _, b := range rt.pool {
+				if b.id == c.Value {
+					stripCookie(req, rt.stickyCookie())
+					return b, ""
+				}
+			}
+		}
+		b := rt.nextBackend()
+		return b, b.id
+	}
+	return rt.nextBackend(), ""
+}
+
+// nextBackend rotates through the pool in proportion to the weights.
+func (rt *route) nextBackend() *backend {
+	return rt.choose(rt.rotation.Add(1)-1, randomN(10000))
+}
+
+// choose maps a slot onto the weighted pool, unless roll (0-9999) falls
+// within the canary's share of traffic.
+func (rt *route) choose(slot, roll uint32) *backend {
+	if rt.canary != nil && float64(roll) < rt.Canary.Percent*100 {
+		return rt.canary
+	}
+	n := int(slot % uint32(rt.totalWeight))
+	for _, b := range rt.pool {
+		if n < b.weight {
+			return b
+		}
+		n -= b.weight
+	}
+	return rt.pool[0]
+}
+
+// stripCookie removes the relay's own cookie before the request goes
+// upstream, so backends never see it.
+func stripCookie(req *http.Request, name string) {
+	var kept []string
+	for _, c := range req.Cookies() {
+		if c.Name != name {
+			kept = append(kept, c.String())
+		}
+	}
+	req.Header.Del("Cookie")
+	if len(kept) > 0 {
+		req.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// setAffinityCookie pins the client to the backend picked for it.
+func setAffinityCookie(resp *http.Response, info *requestInfo) {
+	if info == nil || info.affinity == "" || info.matched == nil {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     info.matched.stickyCookie(),
+		Value:    info.affinity,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	resp.Header.Add("Set-Cookie", cookie.String())
+}
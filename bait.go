@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Bait is shared with the admin API client.
+type Bait = adminclient.Bait
+
+var (
+	// Parent domain under which bait hostnames are minted
+	baitDomain string
+
+	// Target for baits minted without one
+	baitTarget string
+
+	// JSON file persisting minted baits across restarts
+	baitStorePath string
+
+	baitMu sync.RWMutex
+	baits  = make(map[string]*Bait)
+)
+
+var baitEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// --- Bait Minting Logic ---
+
+// loadBaits restores previously minted baits from -bait-store.
+func loadBaits() {
+	if baitStorePath == "" {
+		return
+	}
+	data, err := os.ReadFile(baitStorePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read bait store: %v", err)
+	}
+	var stored []*Bait
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Fatalf("Invalid bait store: %v", err)
+	}
+	for _, b := range stored {
+		baits[b.Host] = b
+	}
+	log.Printf("Loaded %d bait routes from %s", len(stored), baitStorePath)
+}
+
+// saveBaits writes the registry to -bait-store. Callers hold baitMu.
+func saveBaits() {
+	if baitStorePath == "" {
+		return
+	}
+	data, _ := json.MarshalIndent(listBaitsLocked(), "", "  ")
+	tmp := baitStorePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("[BAIT] Failed to save bait store: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, baitStorePath); err != nil {
+		log.Printf("[BAIT] Failed to save bait store: %v", err)
+	}
+}
+
+func listBaitsLocked() []Bait {
+	list := make([]Bait, 0, len(baits))
+	for _, b := range baits {
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// addBaitRoutes adds a route for every bait not shadowed by a configured
+// route, so minted baits survive config reloads.
+func addBaitRoutes(table map[string]*route) {
+	baitMu.RLock()
+	defer baitMu.RUnlock()
+	for host, b := range baits {
+		if _, exists := table[host]; exists {
+			continue
+		}
+		rt, err := newRoute(ConfigRoute{Source: host, Target: b.Target})
+		if err != nil {
+			log.Printf("Warning: Skipping bait %s: %v", host, err)
+			continue
+		}
+		table[host] = rt
+	}
+}
+
+// mintBait creates a bait with a fresh unguessable hostname.
+func mintBait(req Bait) (Bait, error) {
+	if baitDomain == "" {
+		return Bait{}, fmt.Errorf("bait minting is disabled (set -bait-domain)")
+	}
+	target := valueOr(req.Target, baitTarget)
+	if target == "" {
+		return Bait{}, fmt.Errorf("target is required (or set -bait-target)")
+	}
+
+	raw := make([]byte, 10)
+	randomBytes(raw)
+	host := strings.ToLower(baitEncoding.EncodeToString(raw)) + "." + canonicalSource(baitDomain)
+	rt, err := newRoute(ConfigRoute{Source: host, Target: target})
+	if err != nil {
+		return Bait{}, err
+	}
+
+	b := Bait{Host: host, Target: target, Memo: req.Memo, Webhook: req.Webhook, Created: time.Now().UTC()}
+	baitMu.Lock()
+	baits[host] = &b
+	saveBaits()
+	b = *baits[host]
+	baitMu.Unlock()
+
+	mu.Lock()
+	routeMap[host] = rt
+	mu.Unlock()
+	routesChanged()
+	return b, nil
+}
+
+// recordBaitHit notes a DNS or HTTP hit on a bait host and fires its
+// webhook the first time.
+func recordBaitHit(host, kind, client string) {
+	baitMu.RLock()
+	_, ok := baits[host]
+	baitMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	baitMu.Lock()
+	b, ok := baits[host]
+	if !ok {
+		baitMu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	b.Hits++
+	b.LastHit = &now
+	first := b.FirstHit == nil
+	if first {
+		b.FirstHit = &now
+		saveBaits()
+	}
+	hit := *b
+	baitMu.Unlock()
+
+	if !first {
+		return
+	}
+	log.Printf("[BAIT] First %s hit on %s (%s) from %s", kind, host, valueOr(hit.Memo, "no memo"), client)
+	emit(Event{Type: adminclient.EventBaitHit, Host: host, Client: client, Message: hit.Memo})
+	audit("system", client, "bait_hit", map[string]string{"host": host, "memo": hit.Memo, "kind": kind})
+	if hit.Webhook != "" {
+		go sendBaitWebhook(hit, kind, client)
+	}
+}
+
+func sendBaitWebhook(b Bait, kind, client string) {
+	payload, _ := json.Marshal(map[string]any{
+		"host":   b.Host,
+		"memo":   b.Memo,
+		"kind":   kind,
+		"client": client,
+		"time":   b.FirstHit,
+		"relay":  relayName,
+	})
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	for attempt := range 3 {
+		resp, err := httpClient.Post(b.Webhook, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		log.Printf("[BAIT] Webhook for %s failed (attempt %d): %v", b.Host, attempt+1, err)
+		time.Sleep(time.Duration(attempt+1) * 5 * time.Second)
+	}
+}
+
+// --- Bait Admin API Handlers ---
+
+func handleListBaits(w http.ResponseWriter, r *http.Request) {
+	baitMu.RLock()
+	list := listBaitsLocked()
+	baitMu.RUnlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleMintBait(w http.ResponseWriter, r *http.Request) {
+	var req Bait
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid bait: %v", err))
+			return
+		}
+	}
+	b, err := mintBait(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Printf("[ADMIN] Bait minted: %s -> %s", b.Host, b.Target)
+	auditRequest(r, "bait_mint", map[string]string{"host": b.Host, "target": b.Target, "memo": b.Memo})
+	writeJSON(w, http.StatusCreated, b)
+}
+
+func handleDeleteBait(w http.ResponseWriter, r *http.Request) {
+	host := canonicalSource(r.PathValue("host"))
+	baitMu.Lock()
+	b, ok := baits[host]
+	if ok {
+		delete(baits, host)
+		saveBaits()
+	}
+	baitMu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "bait not found")
+		return
+	}
+
+	mu.Lock()
+	if rt, exists := routeMap[host]; exists && rt.Target == b.Target {
+		delete(routeMap, host)
+	}
+	mu.Unlock()
+	routesChanged()
+
+	log.Printf("[ADMIN] Bait deleted: %s", host)
+	auditRequest(r, "bait_delete", map[string]string{"host": host, "memo": b.Memo})
+	w.WriteHeader(http.StatusNoContent)
+}
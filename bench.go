@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// --- Load Generator (bench) Logic ---
+
+const benchUsage = `Usage: goRebind bench [flags]
+
+Hammers a running relay with a mix of HTTP requests and DNS queries for the
+given hosts and reports throughput and latency per protocol.
+
+Flags:
+`
+
+// benchResult collects the outcome of every operation of one kind.
+type benchResult struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	errors    int
+	statuses  map[string]int
+}
+
+func (b *benchResult) record(d time.Duration, status string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.errors++
+		return
+	}
+	b.latencies = append(b.latencies, d)
+	b.statuses[status]++
+}
+
+// benchOptions holds the bench flags.
+type benchOptions struct {
+	httpURL     string
+	dnsAddr     string
+	hostList    string
+	path        string
+	dnsRatio    float64
+	concurrency int
+	duration    time.Duration
+	rate        int
+}
+
+// newBenchFlags registers the bench flags.
+func newBenchFlags() (*flag.FlagSet, *benchOptions) {
+	o := &benchOptions{}
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&o.httpURL, "http", "http://127.0.0.1:80", "Base URL of the relay's HTTP listener (empty disables HTTP load)")
+	fs.StringVar(&o.dnsAddr, "dns", "", "Address of the relay's DNS server, e.g. 127.0.0.1:53 (empty disables DNS load)")
+	fs.StringVar(&o.hostList, "hosts", "", "Comma-separated hostnames to request and query (required)")
+	fs.StringVar(&o.path, "path", "/", "Request path for HTTP load")
+	fs.Float64Var(&o.dnsRatio, "dns-ratio", 0.5, "Fraction of operations that are DNS queries when both are enabled")
+	fs.IntVar(&o.concurrency, "c", 16, "Number of concurrent workers")
+	fs.DurationVar(&o.duration, "d", 10*time.Second, "How long to run")
+	fs.IntVar(&o.rate, "rate", 0, "Maximum total operations per second (0 is unlimited)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), benchUsage)
+		fs.PrintDefaults()
+	}
+	return fs, o
+}
+
+func runBench(args []string) {
+	fs, o := newBenchFlags()
+	_ = fs.Parse(args)
+
+	var hosts []string
+	for _, h := range strings.Split(o.hostList, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == 0 || (o.httpURL == "" && o.dnsAddr == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	switch {
+	case o.dnsAddr == "":
+		o.dnsRatio = 0
+	case o.httpURL == "":
+		o.dnsRatio = 1
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: o.concurrency,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	target := strings.TrimSuffix(o.httpURL, "/") + o.path
+
+	httpRes := &benchResult{statuses: make(map[string]int)}
+	dnsRes := &benchResult{statuses: make(map[string]int)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.duration)
+	defer cancel()
+
+	// A nil channel never blocks, so unlimited runs skip pacing entirely
+	var tokens chan struct{}
+	if o.rate > 0 {
+		tokens = make(chan struct{}, o.concurrency)
+		go func() {
+			ticker := time.NewTicker(time.Second / time.Duration(o.rate))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					select {
+					case tokens <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	log.Printf("[BENCH] %d workers for %s against %d host(s)", o.concurrency, o.duration, len(hosts))
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range o.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dnsClient := &dns.Client{Timeout: 2 * time.Second}
+			for ctx.Err() == nil {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				host := hosts[rand.IntN(len(hosts))]
+				if rand.Float64() < o.dnsRatio {
+					benchDNS(ctx, dnsClient, o.dnsAddr, host, dnsRes)
+				} else {
+					benchHTTP(ctx, client, target, host, httpRes)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("Ran for %s with %d workers\n\n", elapsed.Round(time.Millisecond), o.concurrency)
+	printBenchResult("HTTP", httpRes, elapsed)
+	printBenchResult("DNS", dnsRes, elapsed)
+}
+
+func benchHTTP(ctx context.Context, client *http.Client, target, host string, res *benchResult) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		log.Fatalf("Invalid -http URL: %v", err)
+	}
+	req.Host = host
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		// Requests cut off by the end of the run are not failures
+		if ctx.Err() == nil {
+			res.record(0, "", err)
+		}
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.record(time.Since(start), resp.Status, nil)
+}
+
+func benchDNS(ctx context.Context, client *dns.Client, addr, host string, res *benchResult) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+	start := time.Now()
+	resp, _, err := client.ExchangeContext(ctx, m, addr)
+	if err != nil {
+		if ctx.Err() == nil {
+			res.record(0, "", err)
+		}
+		return
+	}
+	res.record(time.Since(start), dns.RcodeToString[resp.Rcode], nil)
+}
+
+func printBenchResult(name string, res *benchResult, elapsed time.Duration) {
+	n := len(res.latencies)
+	if n == 0 && res.errors == 0 {
+		return
+	}
+	slices.Sort(res.latencies)
+	pct := func(p float64) time.Duration {
+		if n == 0 {
+			return 0
+		}
+		return res.latencies[min(n-1, int(float64(n)*p))]
+	}
+	fmt.Printf("%s\n", name)
+	fmt.Printf("  requests:   %d (%d errors)\n", n+res.errors, res.errors)
+	fmt.Printf("  throughput: %.1f/s\n", float64(n)/elapsed.Seconds())
+	fmt.Printf("  latency:    p50 %s  p90 %s  p99 %s  max %s\n", pct(0.5), pct(0.9), pct(0.99), pct(1))
+	for _, status := range sortedKeys(res.statuses) {
+		fmt.Printf("  %-20s %d\n", status+":", res.statuses[status])
+	}
+	fmt.Println()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// benchRoutes loads a route table of n hosts named host<i>.bench.local.
+func benchRoutes(b *testing.B, n int) {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	noDNSPrefetch = true
+	interfaceIP = net.IPv4(10, 0, 0, 1)
+
+	routes := make([]ConfigRoute, n)
+	for i := range routes {
+		routes[i] = ConfigRoute{Source: fmt.Sprintf("host%d.bench.local", i), Target: "http://127.0.0.1:8080"}
+	}
+	setRoutes(routes)
+}
+
+func BenchmarkLookupRoute(b *testing.B) {
+	benchRoutes(b, 1000)
+	b.Run("hit", func(b *testing.B) {
+		for b.Loop() {
+			lookupRoute("host500.bench.local")
+		}
+	})
+	b.Run("miss", func(b *testing.B) {
+		for b.Loop() {
+			lookupRoute("unknown.bench.local")
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lookupRoute("host500.bench.local")
+			}
+		})
+	})
+}
+
+// discardDNSWriter is a dns.ResponseWriter that drops every answer.
+type discardDNSWriter struct{ dns.ResponseWriter }
+
+func (discardDNSWriter) WriteMsg(*dns.Msg) error { return nil }
+func (discardDNSWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+}
+
+func BenchmarkHandleDNSRequest(b *testing.B) {
+	benchRoutes(b, 1000)
+	req := new(dns.Msg)
+	req.SetQuestion("host500.bench.local.", dns.TypeA)
+	w := discardDNSWriter{}
+	handler := dnsHandler(func(string) bool { return true })
+	b.ReportAllocs()
+	for b.Loop() {
+		handler(w, req)
+	}
+}
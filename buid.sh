@@ -17,7 +17,13 @@ PLATFORMS=(
   "darwin/arm64"
 )
 
-echo "🔨 Building Go binaries..."
+# Build information embedded in every binary (goRebind version)
+VERSION="$(git describe --tags --always --dirty 2>/dev/null || echo dev)"
+COMMIT="$(git rev-parse HEAD 2>/dev/null || true)"
+BUILD_DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+LDFLAGS="-X main.version=$VERSION -X main.commit=$COMMIT -X main.buildDate=$BUILD_DATE"
+
+echo "🔨 Building Go binaries ($VERSION)..."
 
 for PLATFORM in "${PLATFORMS[@]}"; do
     OS="${PLATFORM%%/*}"
@@ -35,7 +41,7 @@ for PLATFORM in "${PLATFORMS[@]}"; do
 
     echo "➡️  Building for $OS/$ARCH ..."
 
-    env GOOS="$OS" GOARCH="$ARCH" go build -o "$FINAL_NAME" .
+    env GOOS="$OS" GOARCH="$ARCH" go build -ldflags "$LDFLAGS" -o "$FINAL_NAME" .
 
 done
 
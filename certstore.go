@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Certificate sources
+const (
+	certMinted   = "minted"
+	certImported = "imported"
+)
+
+var (
+	// Directory persisting the internal CA and leaf certificates
+	certStoreDir string
+
+	// CA signing minted leaves; nil mints self-signed ones. Guarded by leafMu.
+	internalCA *tls.Certificate
+
+	// Generate the internal CA on first run, and its common name
+	internalCAEnabled bool
+	internalCAName    string
+
+	// PEM files of an existing CA to sign with; the key may share the
+	// certificate's file, as in mitmproxy-ca.pem
+	caCertFile string
+	caKeyFile  string
+)
+
+type (
+	CertInfo   = adminclient.CertInfo
+	CertImport = adminclient.CertImport
+)
+
+// --- Certificate Store Logic ---
+
+// loadCertStore restores the CA and leaf certificates kept in -cert-store.
+func loadCertStore() {
+	if certStoreDir == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(certStoreDir, "certs"), 0o700); err != nil {
+		log.Fatalf("Failed to open cert store: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(certStoreDir, "ca.pem")); err == nil {
+		if internalCA, err = parseKeyPair(data, data); err != nil {
+			log.Fatalf("Invalid CA in cert store: %v", err)
+		}
+		log.Printf("Loaded CA %q from %s", internalCA.Leaf.Subject.CommonName, certStoreDir)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(certStoreDir, "certs", "*.pem"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Failed to read cert store: %v", err)
+		}
+		cert, err := parseKeyPair(data, data)
+		if err != nil {
+			log.Printf("[TLS] Skipping %s: %v", file, err)
+			continue
+		}
+		source := certMinted
+		if block, _ := pem.Decode(data); block != nil && (block.Headers["Source"] == certImported || block.Headers["Source"] == certACME) {
+			source = block.Headers["Source"]
+		}
+		setLeaf(strings.TrimSuffix(filepath.Base(file), ".pem"), cert, source)
+	}
+	log.Printf("Loaded %d certificates from %s", len(files), certStoreDir)
+	loadRevocations()
+}
+
+// loadCAFiles makes the CA in -ca-cert and -ca-key the internal CA. A CA
+// other than the one in -cert-store replaces it there, and leaves minted
+// by the old one are dropped.
+func loadCAFiles() {
+	if caCertFile == "" {
+		if caKeyFile != "" {
+			log.Fatalf("-ca-key needs -ca-cert")
+		}
+		return
+	}
+	certPEM, err := os.ReadFile(caCertFile)
+	if err != nil {
+		log.Fatalf("Failed to read -ca-cert: %v", err)
+	}
+	keyPEM := certPEM
+	if caKeyFile != "" {
+		if keyPEM, err = os.ReadFile(caKeyFile); err != nil {
+			log.Fatalf("Failed to read -ca-key: %v", err)
+		}
+	}
+	ca, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		log.Fatalf("Invalid -ca-cert: %v", err)
+	}
+	if !ca.Leaf.IsCA {
+		log.Fatalf("Invalid -ca-cert: %s is not a CA", ca.Leaf.Subject)
+	}
+	if internalCA != nil && bytes.Equal(internalCA.Leaf.Raw, ca.Leaf.Raw) {
+		return
+	}
+	if err := setCA(ca); err != nil {
+		log.Fatalf("Failed to save CA: %v", err)
+	}
+	log.Printf("Loaded CA %q from %s (SHA-256 %s)", ca.Leaf.Subject.CommonName, caCertFile, certInfo("ca", ca, "").SHA256)
+}
+
+// ensureInternalCA generates the internal CA when -internal-ca is set and
+// none was loaded or imported. It is kept in -cert-store when there is one;
+// otherwise a new CA is generated on every start.
+func ensureInternalCA() {
+	if !internalCAEnabled || internalCA != nil {
+		return
+	}
+	ca, err := generateCA(internalCAName)
+	if err != nil {
+		log.Fatalf("Failed to generate internal CA: %v", err)
+	}
+	if err := setCA(ca); err != nil {
+		log.Fatalf("Failed to save internal CA: %v", err)
+	}
+	log.Printf("Generated internal CA %q (SHA-256 %s)", internalCAName, certInfo("ca", ca, "").SHA256)
+	if certStoreDir == "" {
+		log.Printf("[TLS] Warning: no -cert-store, the internal CA will change on restart")
+	}
+}
+
+// generateCA creates a ten-year ECDSA root allowed to sign leaves only.
+func generateCA(name string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// serveCACert answers /ca.crt on hosts without a route (e.g. the relay's
+// own address), so test clients can fetch the CA before trusting it.
+func serveCACert(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != "/ca.crt" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	if _, exists := lookupRoute(strings.ToLower(r.Host)); exists {
+		return false
+	}
+	leafMu.Lock()
+	ca := internalCA
+	leafMu.Unlock()
+	if ca == nil {
+		return false
+	}
+	logRequest(r, "[TLS] Served CA certificate to %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="ca.crt"`)
+	_, _ = w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw}))
+	return true
+}
+
+// parseKeyPair parses PEM certificate and key blocks, filling in Leaf.
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// setLeaf stores a ready certificate under a server name.
+func setLeaf(name string, cert *tls.Certificate, source string) {
+	entry := &leafEntry{cert: cert, source: source}
+	entry.once.Do(func() {})
+	leafMu.Lock()
+	leafCerts[name] = entry
+	leafMu.Unlock()
+}
+
+// saveLeaf persists a certificate and its key to the store.
+func saveLeaf(name string, cert *tls.Certificate, source string) {
+	if certStoreDir == "" {
+		return
+	}
+	data, err := encodeKeyPair(cert, source)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(certStoreDir, "certs", name+".pem"), data, 0o600)
+	}
+	if err != nil {
+		log.Printf("[TLS] Failed to save certificate for %s: %v", name, err)
+	}
+}
+
+// encodeKeyPair writes a certificate chain and its key as PEM. The first
+// block records where the certificate came from.
+func encodeKeyPair(cert *tls.Certificate, source string) ([]byte, error) {
+	var out []byte
+	for i, der := range cert.Certificate {
+		block := &pem.Block{Type: "CERTIFICATE", Bytes: der}
+		if i == 0 && source != "" {
+			block.Headers = map[string]string{"Source": source}
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})...), nil
+}
+
+// validCertName rejects names that cannot be used as store file names.
+func validCertName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid certificate name %q", name)
+	}
+	return nil
+}
+
+func certInfo(name string, cert *tls.Certificate, source string) CertInfo {
+	sum := sha256.Sum256(cert.Leaf.Raw)
+	info := CertInfo{
+		Name: name, Source: source,
+		Subject: cert.Leaf.Subject.String(), Issuer: cert.Leaf.Issuer.String(),
+		DNSNames: cert.Leaf.DNSNames, NotBefore: cert.Leaf.NotBefore, NotAfter: cert.Leaf.NotAfter,
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	for _, ip := range cert.Leaf.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info
+}
+
+// importKeyPair parses an uploaded certificate and key.
+func importKeyPair(r *http.Request) (*tls.Certificate, error) {
+	var req CertImport
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Key == "" {
+		return nil, errors.New("key is required")
+	}
+	return parseKeyPair([]byte(req.Cert), []byte(req.Key))
+}
+
+// --- Certificate Store Admin Handlers ---
+
+func handleListCerts(w http.ResponseWriter, r *http.Request) {
+	leafMu.Lock()
+	list := make([]CertInfo, 0, len(leafCerts))
+	for name, entry := range leafCerts {
+		if entry.cert != nil {
+			list = append(list, certInfo(name, entry.cert, entry.source))
+		}
+	}
+	leafMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleImportCert(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	if err := validCertName(name); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cert, err := importKeyPair(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid certificate: %v", err))
+		return
+	}
+	setLeaf(name, cert, certImported)
+	saveLeaf(name, cert, certImported)
+	log.Printf("[ADMIN] Certificate imported for %s", name)
+	writeJSON(w, http.StatusOK, certInfo(name, cert, certImported))
+}
+
+func handleDeleteCert(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	leafMu.Lock()
+	_, ok := leafCerts[name]
+	delete(leafCerts, name)
+	leafMu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "certificate not found")
+		return
+	}
+	if certStoreDir != "" {
+		_ = os.Remove(filepath.Join(certStoreDir, "certs", name+".pem"))
+	}
+	log.Printf("[ADMIN] Certificate deleted for %s", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleExportCA returns the internal CA certificate as PEM, ready to be
+// installed in a test client's trust store.
+func handleExportCA(w http.ResponseWriter, r *http.Request) {
+	leafMu.Lock()
+	ca := internalCA
+	leafMu.Unlock()
+	if ca == nil {
+		writeJSONError(w, http.StatusNotFound, "no internal CA")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw}))
+}
+
+// handleImportCA replaces the CA minted leaves are signed with. Leaves it
+// minted before are dropped so they are reissued under the new CA.
+func handleImportCA(w http.ResponseWriter, r *http.Request) {
+	ca, err := importKeyPair(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid CA: %v", err))
+		return
+	}
+	if !ca.Leaf.IsCA {
+		writeJSONError(w, http.StatusBadRequest, "certificate is not a CA")
+		return
+	}
+	if err := setCA(ca); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("[ADMIN] CA imported: %s", ca.Leaf.Subject)
+	writeJSON(w, http.StatusOK, certInfo("ca", ca, certImported))
+}
+
+func handleDeleteCA(w http.ResponseWriter, r *http.Request) {
+	leafMu.Lock()
+	ok := internalCA != nil
+	leafMu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no internal CA")
+		return
+	}
+	if err := setCA(nil); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	log.Printf("[ADMIN] CA deleted; minting self-signed certificates")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCA switches the issuing CA (nil for self-signed leaves), persists it
+// and forgets every minted leaf.
+func setCA(ca *tls.Certificate) error {
+	leafMu.Lock()
+	defer leafMu.Unlock()
+	if certStoreDir != "" {
+		path := filepath.Join(certStoreDir, "ca.pem")
+		if ca == nil {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		} else {
+			data, err := encodeKeyPair(ca, "")
+			if err == nil {
+				err = os.WriteFile(path, data, 0o600)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	internalCA = ca
+	for name, entry := range leafCerts {
+		if entry.source == certMinted || entry.cert == nil {
+			delete(leafCerts, name)
+			if certStoreDir != "" {
+				_ = os.Remove(filepath.Join(certStoreDir, "certs", name+".pem"))
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A network in place of a target's host, as in https://10.0.0.0/29 or
+// https://10.0.0.0/29:8443/api
+var targetCIDR = regexp.MustCompile(`^([a-z][a-z0-9+.-]*://)([0-9.]+|\[[0-9a-fA-F:.]+\])/(\d{1,3})(:\d+)?([/?#].*)?$`)
+
+// Most addresses a CIDR target may expand to
+const maxCIDRTargets = 256
+
+// --- CIDR Target Logic ---
+
+// splitTargetCIDR replaces a network in a target URL with its first
+// address, returning the network. Only a network address followed by its
+// prefix length counts, so https://10.0.0.5/30 stays a target with a path.
+func splitTargetCIDR(target string) (string, netip.Prefix, error) {
+	m := targetCIDR.FindStringSubmatch(strings.TrimSpace(target))
+	if m == nil {
+		return target, netip.Prefix{}, nil
+	}
+	prefix, err := netip.ParsePrefix(strings.Trim(m[2], "[]") + "/" + m[3])
+	if err != nil || prefix.Masked() != prefix {
+		return target, netip.Prefix{}, nil
+	}
+	if n := prefix.Addr().BitLen() - prefix.Bits(); n > 8 {
+		return "", netip.Prefix{}, fmt.Errorf("invalid target URL %s: a CIDR target spans at most %d addresses", target, maxCIDRTargets)
+	}
+	return m[1] + m[2] + m[4] + m[5], prefix, nil
+}
+
+// cidrTargets lists a target URL for every host address of prefix, with
+// u's port, path and query. IPv4 networks larger than a /31 leave out the
+// network and broadcast addresses.
+func cidrTargets(u *url.URL, prefix netip.Prefix) []*url.URL {
+	first, last := prefix.Addr(), lastAddr(prefix)
+	if first.Is4() && prefix.Bits() < 31 {
+		first, last = first.Next(), last.Prev()
+	}
+	var urls []*url.URL
+	for addr := first; addr.IsValid() && addr.Compare(last) <= 0; addr = addr.Next() {
+		t := *u
+		t.Host = addr.String()
+		if addr.Is6() {
+			t.Host = "[" + t.Host + "]"
+		}
+		if port := u.Port(); port != "" {
+			t.Host += ":" + port
+		}
+		urls = append(urls, &t)
+	}
+	return urls
+}
+
+// cidrBackends is the backend for u, or with a valid prefix one for each
+// of its addresses, all of the same weight.
+func cidrBackends(u *url.URL, prefix netip.Prefix, weight int) []*backend {
+	if !prefix.IsValid() {
+		return []*backend{newBackend(u, weight)}
+	}
+	var pool []*backend
+	for _, t := range cidrTargets(u, prefix) {
+		pool = append(pool, newBackend(t, weight))
+	}
+	return pool
+}
+
+// lastAddr is the highest address of prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCIDRTargets(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string // pool hosts, or the error's start
+	}{
+		{"https://10.0.0.0/30", []string{"10.0.0.1", "10.0.0.2"}},
+		{"https://10.0.0.8/29:8443/api", []string{"10.0.0.9:8443", "10.0.0.10:8443", "10.0.0.11:8443", "10.0.0.12:8443", "10.0.0.13:8443", "10.0.0.14:8443"}},
+		{"http://10.0.0.4/31", []string{"10.0.0.4", "10.0.0.5"}},
+		{"http://10.0.0.7/32", []string{"10.0.0.7"}},
+		{"https://[2001:db8::]/127:8443", []string{"[2001:db8::]:8443", "[2001:db8::1]:8443"}},
+		{"https://10.0.0.5/30", []string{"10.0.0.5"}}, // not a network address: a path
+		{"https://app.local/24", []string{"app.local"}},
+		{"https://10.0.0.0/16", []string{"invalid target URL"}},
+	}
+	for _, tt := range tests {
+		rt, err := newRoute(ConfigRoute{Source: "app.test", Target: tt.target})
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), tt.want[0]) {
+				t.Errorf("%s: %v, want %v", tt.target, err, tt.want)
+			}
+			continue
+		}
+		var hosts []string
+		for _, u := range rt.upstreams() {
+			hosts = append(hosts, u.Host)
+		}
+		if strings.Join(hosts, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: pool %v, want %v", tt.target, hosts, tt.want)
+		}
+	}
+
+	// Paths and backends carry over to every address
+	rt, err := newRoute(ConfigRoute{Source: "app.test", Target: "https://10.0.0.0/30/v1", Backends: []Backend{{Target: "https://10.0.1.0/31"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, u := range rt.upstreams() {
+		urls = append(urls, u.String())
+	}
+	want := "https://10.0.0.1/v1,https://10.0.0.2/v1,https://10.0.1.0,https://10.0.1.1"
+	if got := strings.Join(urls, ","); got != want {
+		t.Errorf("pool %s, want %s", got, want)
+	}
+	if rt.target.Host != "10.0.0.1" {
+		t.Errorf("target host %s, want the first address", rt.target.Host)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// Auto-cloak settings
+	cloakEnabled   bool
+	cloakCIDRsFile string
+	cloakDecoyAddr string
+	cloakDecoy     *url.URL
+	cloakNets      []*net.IPNet
+
+	// Lowercase User-Agent fragments of common scanners and sandboxes
+	cloakUserAgents = []string{
+		"zgrab", "masscan", "nmap", "censys", "shodan", "expanse", "nuclei",
+		"urlscan", "virustotal", "bingpreview", "paloaltonetworks", "netcraft",
+	}
+
+	// Per-client probe tracking
+	cloakMu      sync.Mutex
+	cloakClients = make(map[string]*cloakClient)
+)
+
+// Number of consecutive HEAD requests that marks a client as a prober
+const cloakHeadProbes = 2
+
+// Bound on tracked clients so a flood cannot grow the table without limit
+const cloakMaxClients = 10000
+
+type cloakClient struct {
+	heads   int
+	other   int
+	flagged string
+}
+
+type cloakKey struct{}
+
+// --- Auto-Cloak Logic ---
+
+func setupCloak() {
+	if !cloakEnabled {
+		return
+	}
+	if cloakCIDRsFile != "" {
+		f, err := os.Open(cloakCIDRsFile)
+		if err != nil {
+			log.Fatalf("Failed to read cloak CIDRs: %v", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			_, ipNet, err := net.ParseCIDR(line)
+			if err != nil {
+				log.Printf("Warning: Skipping invalid cloak CIDR %s: %v", line, err)
+				continue
+			}
+			cloakNets = append(cloakNets, ipNet)
+		}
+	}
+	if cloakDecoyAddr != "" {
+		u, err := url.Parse(cloakDecoyAddr)
+		if err != nil {
+			log.Fatalf("Invalid cloak decoy URL: %v", err)
+		}
+		cloakDecoy = u
+	}
+	log.Printf("Auto-cloak enabled: %d scanner networks, decoy: %s", len(cloakNets), valueOr(cloakDecoyAddr, "forward to origin"))
+}
+
+// cloakedNetwork reports whether ip belongs to a known scanner network.
+func cloakedNetwork(ip net.IP) bool {
+	for _, n := range cloakNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectCanary returns a reason when the request looks like it comes from
+// a sandbox or scanner rather than a victim browser.
+func detectCanary(r *http.Request) string {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); ip != nil && cloakedNetwork(ip) {
+		return "scanner network"
+	}
+
+	ua := strings.ToLower(r.UserAgent())
+	for _, frag := range cloakUserAgents {
+		if strings.Contains(ua, frag) {
+			return "scanner user agent " + frag
+		}
+	}
+
+	cloakMu.Lock()
+	defer cloakMu.Unlock()
+	c, ok := cloakClients[host]
+	if !ok {
+		if len(cloakClients) >= cloakMaxClients {
+			cloakClients = make(map[string]*cloakClient)
+		}
+		c = &cloakClient{}
+		cloakClients[host] = c
+	}
+	if c.flagged != "" {
+		return c.flagged
+	}
+	if r.Method == http.MethodHead {
+		c.heads++
+	} else {
+		c.other++
+	}
+	if c.other == 0 && c.heads >= cloakHeadProbes {
+		c.flagged = "HEAD-only probing"
+	}
+	return c.flagged
+}
+
+// markCloaked checks the request and, if it looks like a canary, flags the
+// event and tags the request so the Director serves the decoy path.
+func markCloaked(r *http.Request) *http.Request {
+	if !cloakEnabled {
+		return r
+	}
+	reason := detectCanary(r)
+	if reason == "" {
+		return r
+	}
+	stats.cloaked.Add(1)
+	logRequest(r, "[CLOAK] %s %s from %s (%s): serving decoy path", r.Method, r.Host, r.RemoteAddr, reason)
+	return r.WithContext(context.WithValue(r.Context(), cloakKey{}, reason))
+}
+
+func isCloaked(req *http.Request) bool {
+	_, ok := req.Context().Value(cloakKey{}).(string)
+	return ok
+}
+
+// cloakRequest points a flagged request at the decoy, or at its real origin
+// when no decoy is configured.
+func cloakRequest(req *http.Request) {
+	if cloakDecoy == nil {
+		forwardToOrigin(req)
+		return
+	}
+	req.URL.Scheme = cloakDecoy.Scheme
+	req.URL.Host = cloakDecoy.Host
+	req.Host = cloakDecoy.Host
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// JSON-lines file receiving every mismatching comparison
+	compareLogPath string
+	compareLog     *os.File
+	compareLogMu   sync.Mutex
+
+	// Upstream transport, shared with the shadow requests
+	compareTransport http.RoundTripper
+)
+
+const (
+	// Request and response bodies compared per side
+	compareMaxBody = 1 << 20
+	// How long the secondary target may take to answer
+	compareTimeout = 30 * time.Second
+)
+
+// Response headers that differ between any two requests
+var compareIgnoredHeaders = map[string]bool{
+	"Date": true, "Age": true, "X-Request-Id": true,
+	"Connection": true, "Keep-Alive": true, "Transfer-Encoding": true,
+}
+
+// CompareDiff is one line of the -compare-log file.
+type CompareDiff struct {
+	Time       time.Time    `json:"time"`
+	RequestID  string       `json:"request_id"`
+	Route      string       `json:"route"`
+	Method     string       `json:"method"`
+	URI        string       `json:"uri"`
+	Primary    CompareSide  `json:"primary"`
+	Secondary  CompareSide  `json:"secondary"`
+	Headers    []HeaderDiff `json:"headers,omitempty"`
+	BodyDiffAt *int         `json:"body_diff_at,omitempty"` // first differing byte
+	Engagement string       `json:"engagement,omitempty"`
+}
+
+// CompareSide summarises one target's response.
+type CompareSide struct {
+	Target     string `json:"target"`
+	Status     int    `json:"status,omitempty"`
+	Error      string `json:"error,omitempty"`
+	BodyBytes  int    `json:"body_bytes"`
+	BodySHA256 string `json:"body_sha256,omitempty"`
+	Truncated  bool   `json:"truncated,omitempty"`
+}
+
+// HeaderDiff is a response header whose values differ.
+type HeaderDiff struct {
+	Name      string   `json:"name"`
+	Primary   []string `json:"primary,omitempty"`
+	Secondary []string `json:"secondary,omitempty"`
+}
+
+type compareResult struct {
+	status    int
+	header    http.Header
+	body      []byte
+	truncated bool
+	err       error
+}
+
+// comparison pairs the primary and secondary responses of one request.
+type comparison struct {
+	diff    CompareDiff
+	primary chan compareResult
+	once    sync.Once
+}
+
+// --- Diff Mode Logic ---
+
+func openCompareLog() {
+	if compareLogPath == "" {
+		return
+	}
+	f, err := os.OpenFile(compareLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Failed to open compare log: %v", err)
+	}
+	compareLog = f
+}
+
+// parseCompareWith validates a route's compare_with target.
+func (rt *route) parseCompareWith() error {
+	if rt.CompareWith == "" {
+		return nil
+	}
+	u, err := parseUpstream(rt.CompareWith)
+	if err != nil {
+		return fmt.Errorf("invalid compare_with URL %q: %w", rt.CompareWith, err)
+	}
+	rt.compareWith = newBackend(u, 0)
+	return nil
+}
+
+// startCompare sends a copy of the outgoing request to the route's
+// compare_with target. Upgrades and oversized bodies are not compared.
+func startCompare(req *http.Request, rt *route, info *requestInfo) {
+	if rt.compareWith == nil || info == nil || req.Header.Get("Upgrade") != "" {
+		return
+	}
+	body, ok := bufferBody(req)
+	if !ok {
+		return
+	}
+
+	c := &comparison{primary: make(chan compareResult, 1)}
+	c.diff = CompareDiff{
+		RequestID: info.id,
+		Route:     info.route,
+		Method:    req.Method,
+		URI:       req.URL.RequestURI(),
+		Primary:   CompareSide{Target: info.backend.name()},
+		Secondary: CompareSide{Target: rt.compareWith.name()},
+	}
+	info.compare = c
+
+	shadow := req.Clone(context.WithoutCancel(req.Context()))
+	rt.compareWith.apply(shadow)
+	shadow.Body = http.NoBody
+	if body != nil {
+		shadow.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	for _, h := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"} {
+		shadow.Header.Del(h)
+	}
+	go c.run(shadow)
+}
+
+// bufferBody reads the request body into memory so it can be sent twice.
+// Bodies over compareMaxBody are left streaming and reported as not ok.
+func bufferBody(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, compareMaxBody+1))
+	if err != nil || len(body) > compareMaxBody {
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+func (c *comparison) run(shadow *http.Request) {
+	ctx, cancel := context.WithTimeout(shadow.Context(), compareTimeout)
+	defer cancel()
+	resp, err := compareTransport.RoundTrip(shadow.WithContext(ctx))
+	secondary := compareResult{err: err}
+	if err == nil {
+		secondary = captureResponse(resp)
+	}
+
+	select {
+	case primary := <-c.primary:
+		c.report(primary, secondary)
+	case <-time.After(compareTimeout):
+		log.Printf("[COMPARE] Primary response for %s%s never completed (req %s)", c.diff.Route, c.diff.URI, c.diff.RequestID)
+	}
+}
+
+// deliverPrimary hands over the primary response; only the first call counts.
+func (c *comparison) deliverPrimary(res compareResult) {
+	c.once.Do(func() { c.primary <- res })
+}
+
+// capturePrimary tees the primary response body as the proxy streams it.
+func capturePrimary(resp *http.Response, info *requestInfo) {
+	if info == nil || info.compare == nil {
+		return
+	}
+	resp.Body = &compareTee{
+		ReadCloser: resp.Body,
+		c:          info.compare,
+		res:        compareResult{status: resp.StatusCode, header: resp.Header.Clone()},
+	}
+}
+
+type compareTee struct {
+	io.ReadCloser
+	c   *comparison
+	res compareResult
+	buf bytes.Buffer
+}
+
+func (t *compareTee) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if room := compareMaxBody - t.buf.Len(); room > 0 {
+		t.buf.Write(p[:min(n, room)])
+		t.res.truncated = n > room
+	} else if n > 0 {
+		t.res.truncated = true
+	}
+	return n, err
+}
+
+func (t *compareTee) Close() error {
+	t.res.body = t.buf.Bytes()
+	t.c.deliverPrimary(t.res)
+	return t.ReadCloser.Close()
+}
+
+func captureResponse(resp *http.Response) compareResult {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, compareMaxBody+1))
+	return compareResult{
+		status:    resp.StatusCode,
+		header:    resp.Header,
+		body:      body[:min(len(body), compareMaxBody)],
+		truncated: len(body) > compareMaxBody,
+		err:       err,
+	}
+}
+
+// report diffs the two responses and logs them if they differ.
+func (c *comparison) report(primary, secondary compareResult) {
+	d := c.diff
+	d.Time = time.Now().UTC()
+	fill := func(side *CompareSide, res compareResult) {
+		side.Status = res.status
+		side.BodyBytes = len(res.body)
+		side.Truncated = res.truncated
+		if res.err != nil {
+			side.Error = res.err.Error()
+		}
+		if res.body != nil {
+			sum := sha256.Sum256(res.body)
+			side.BodySHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+	fill(&d.Primary, primary)
+	fill(&d.Secondary, secondary)
+	// A failed side has nothing to compare beyond the error itself
+	bothAnswered := primary.err == nil && secondary.err == nil
+	if bothAnswered {
+		d.Headers = diffHeaders(primary.header, secondary.header)
+	}
+	if bothAnswered && !bytes.Equal(primary.body, secondary.body) {
+		at := 0
+		for at < min(len(primary.body), len(secondary.body)) && primary.body[at] == secondary.body[at] {
+			at++
+		}
+		d.BodyDiffAt = &at
+	}
+
+	if d.Primary.Status == d.Secondary.Status && d.Primary.Error == d.Secondary.Error && len(d.Headers) == 0 && d.BodyDiffAt == nil {
+		if verboseMode {
+			log.Printf("[COMPARE] %s %s%s matches %s (req %s)", d.Method, d.Route, d.URI, d.Secondary.Target, d.RequestID)
+		}
+		return
+	}
+
+	var parts []string
+	if d.Primary.Status != d.Secondary.Status || d.Primary.Error != d.Secondary.Error {
+		parts = append(parts, fmt.Sprintf("status %s vs %s", compareStatus(d.Primary), compareStatus(d.Secondary)))
+	}
+	if len(d.Headers) > 0 {
+		names := make([]string, len(d.Headers))
+		for i, h := range d.Headers {
+			names[i] = h.Name
+		}
+		parts = append(parts, "headers "+strings.Join(names, ","))
+	}
+	if d.BodyDiffAt != nil {
+		parts = append(parts, fmt.Sprintf("body differs at byte %d (%d vs %d bytes)", *d.BodyDiffAt, d.Primary.BodyBytes, d.Secondary.BodyBytes))
+	}
+	log.Printf("[COMPARE] %s %s%s differs from %s: %s (req %s)", d.Method, d.Route, d.URI, d.Secondary.Target, strings.Join(parts, "; "), d.RequestID)
+	recordCompareDiff(d)
+}
+
+func compareStatus(side CompareSide) string {
+	if side.Error != "" {
+		return "error"
+	}
+	return fmt.Sprint(side.Status)
+}
+
+// diffHeaders lists headers whose values differ, ignoring per-request ones.
+func diffHeaders(a, b http.Header) []HeaderDiff {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var diffs []HeaderDiff
+	for _, name := range names {
+		if compareIgnoredHeaders[name] || slices.Equal(a[name], b[name]) {
+			continue
+		}
+		diffs = append(diffs, HeaderDiff{Name: name, Primary: a[name], Secondary: b[name]})
+	}
+	return diffs
+}
+
+func recordCompareDiff(d CompareDiff) {
+	if compareLog == nil {
+		return
+	}
+	d.Engagement = engagementID()
+	line, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	compareLogMu.Lock()
+	defer compareLogMu.Unlock()
+	if _, err := fmt.Fprintf(compareLog, "%s\n", line); err != nil {
+		log.Printf("[COMPARE] Failed to write log: %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// cliCommand documents a command: its name, arguments and a one-line summary.
+type cliCommand struct {
+	name    string
+	args    string
+	summary string
+}
+
+// cliSubcommand is a subcommand of goRebind with its flags and commands.
+type cliSubcommand struct {
+	cliCommand
+	flags    *flag.FlagSet
+	commands []cliCommand
+}
+
+// Values offered when completing enumerated flags
+var flagChoices = map[string][]string{
+	"preset":        presetNames(),
+	"compression":   {compressionPassthrough, "identity", "transcode"},
+	"config-format": configFormats,
+	"syslog-format": {"cef", "leef"},
+}
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// --- Completion and Man Page Logic ---
+
+// commandTree describes the subcommands. The relay's own flags live on
+// flag.CommandLine.
+func commandTree() []cliSubcommand {
+	ctlFlags, _ := newCtlFlags("ctl")
+	benchFlags, _ := newBenchFlags()
+	versionFlags, _, _ := newVersionFlags()
+	migrateFlags, _, _ := newMigrateFlags()
+	validateFlags, _ := newValidateFlags()
+	replayFlags, _, _, _ := newReplayFlags()
+	spoofFlags, _, _ := newSpoofFlags()
+	shells := make([]cliCommand, len(completionShells))
+	for i, sh := range completionShells {
+		shells[i] = cliCommand{name: sh, summary: sh + " completion script"}
+	}
+	return []cliSubcommand{
+		{cliCommand: cliCommand{"ctl", "[flags] <command> [args]", "Manage a running relay through its admin API"}, flags: ctlFlags, commands: ctlCommands},
+		{cliCommand: cliCommand{"bench", "[flags]", "Load-test a running relay with HTTP requests and DNS queries"}, flags: benchFlags},
+		{cliCommand: cliCommand{"replay", "[flags] <record.jsonl>", "Replay DNS queries recorded with -dns-record to tune rebind timing"}, flags: replayFlags},
+		{cliCommand: cliCommand{"version", "[-json] [-check]", "Print build information and optionally check for a newer release"}, flags: versionFlags},
+		{cliCommand: cliCommand{"migrate", "[-o file | -w] <config>", "Upgrade a config file to the current schema, explaining changed behaviour"}, flags: migrateFlags},
+		{cliCommand: cliCommand{"validate", "[-config file]... [file...]", "Check config files, targets and sources before deploying; exits 1 on problems"}, flags: validateFlags},
+		{cliCommand: cliCommand{"spoof", "-domain <domain> [flags]", "Generate IDN homoglyph look-alikes of a domain and optionally add routes for them"}, flags: spoofFlags},
+		{cliCommand: cliCommand{"completion", "bash|zsh|fish", "Print a shell completion script"}, commands: shells},
+		{cliCommand: cliCommand{"man", "", "Print the man page (troff)"}},
+	}
+}
+
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: goRebind completion bash|zsh|fish")
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, commandTree())
+	case "zsh":
+		writeZshCompletion(os.Stdout, commandTree())
+	case "fish":
+		writeFishCompletion(os.Stdout, commandTree())
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown shell %q (one of: %s)\n", args[0], strings.Join(completionShells, ", "))
+		os.Exit(2)
+	}
+}
+
+func runMan(args []string) {
+	writeManPage(os.Stdout, commandTree())
+}
+
+// flagsOf lists a flag set's flags in name order; nil has none.
+func flagsOf(fs *flag.FlagSet) []*flag.Flag {
+	var flags []*flag.Flag
+	if fs != nil {
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	}
+	return flags
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// firstSentence shortens a flag's usage for completion descriptions.
+func firstSentence(s string) string {
+	if i := strings.Index(s, ". "); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func writeBashCompletion(w io.Writer, tree []cliSubcommand) {
+	words := func(fs *flag.FlagSet, commands []cliCommand) (string, string) {
+		var all, values []string
+		for _, f := range flagsOf(fs) {
+			all = append(all, "-"+f.Name)
+			if !isBoolFlag(f) {
+				values = append(values, "-"+f.Name)
+			}
+		}
+		for _, c := range commands {
+			all = append(all, c.name)
+		}
+		return strings.Join(all, " "), strings.Join(values, " ")
+	}
+
+	fmt.Fprint(w, "# bash completion for goRebind; load with: source <(goRebind completion bash)\n\n")
+	fmt.Fprint(w, "_goRebind() {\n")
+	fmt.Fprint(w, "    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}\n")
+	fmt.Fprint(w, "    local words values\n\n")
+	fmt.Fprint(w, "    case \"$prev\" in\n")
+	for _, name := range sortedKeys(flagChoices) {
+		fmt.Fprintf(w, "    -%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, strings.Join(flagChoices[name], " "))
+	}
+	fmt.Fprint(w, "    esac\n\n")
+	fmt.Fprint(w, "    case \"${COMP_WORDS[1]}\" in\n")
+	for _, sub := range tree {
+		all, values := words(sub.flags, sub.commands)
+		fmt.Fprintf(w, "    %s) words=%q values=%q ;;\n", sub.name, all, values)
+	}
+	all, values := words(flag.CommandLine, nil)
+	var names []string
+	for _, sub := range tree {
+		names = append(names, sub.name)
+	}
+	fmt.Fprintf(w, "    *)\n        words=%q values=%q\n", all, values)
+	fmt.Fprintf(w, "        [ \"$COMP_CWORD\" -eq 1 ] && words=\"%s $words\" ;;\n", strings.Join(names, " "))
+	fmt.Fprint(w, "    esac\n\n")
+	fmt.Fprint(w, "    if [[ \" $values \" == *\" $prev \"* ]]; then\n")
+	fmt.Fprint(w, "        COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprint(w, "    else\n")
+	fmt.Fprint(w, "        COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	fmt.Fprint(w, "    fi\n")
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprint(w, "complete -o filenames -F _goRebind goRebind\n")
+}
+
+func writeZshCompletion(w io.Writer, tree []cliSubcommand) {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	desc := strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace
+	specs := func(fs *flag.FlagSet) []string {
+		var out []string
+		for _, f := range flagsOf(fs) {
+			_, usage := flag.UnquoteUsage(f)
+			spec := "-" + f.Name + "[" + desc(firstSentence(usage)) + "]"
+			switch {
+			case isBoolFlag(f):
+			case flagChoices[f.Name] != nil:
+				spec += ":value:(" + strings.Join(flagChoices[f.Name], " ") + ")"
+			default:
+				spec += ":value:_files"
+			}
+			out = append(out, quote(spec))
+		}
+		return out
+	}
+	values := func(tag string, commands []cliCommand) string {
+		items := []string{quote(tag)}
+		for _, c := range commands {
+			items = append(items, quote(c.name+"["+desc(c.summary)+"]"))
+		}
+		return "_values " + strings.Join(items, " ")
+	}
+
+	var top []cliCommand
+	for _, sub := range tree {
+		top = append(top, sub.cliCommand)
+	}
+	fmt.Fprint(w, "#compdef goRebind\n\n")
+	fmt.Fprint(w, "_goRebind() {\n")
+	fmt.Fprint(w, "    local curcontext=$curcontext state line\n")
+	fmt.Fprint(w, "    _arguments -C \\\n")
+	for _, spec := range specs(flag.CommandLine) {
+		fmt.Fprintf(w, "        %s \\\n", spec)
+	}
+	fmt.Fprint(w, "        '1: :->command' \\\n")
+	fmt.Fprint(w, "        '*:: :->args'\n\n")
+	fmt.Fprint(w, "    case $state in\n")
+	fmt.Fprintf(w, "    command) %s ;;\n", values("command", top))
+	fmt.Fprint(w, "    args)\n")
+	fmt.Fprint(w, "        case $line[1] in\n")
+	for _, sub := range tree {
+		fmt.Fprintf(w, "        %s)\n", sub.name)
+		if sub.flags == nil {
+			if len(sub.commands) > 0 {
+				fmt.Fprintf(w, "            %s ;;\n", values(sub.name, sub.commands))
+			} else {
+				fmt.Fprint(w, "            ;;\n")
+			}
+			continue
+		}
+		fmt.Fprint(w, "            _arguments \\\n")
+		for _, spec := range specs(sub.flags) {
+			fmt.Fprintf(w, "                %s \\\n", spec)
+		}
+		if len(sub.commands) > 0 {
+			fmt.Fprint(w, "                '1: :->command' \\\n")
+			fmt.Fprint(w, "                '*:: :_files'\n")
+			fmt.Fprintf(w, "            [[ $state == command ]] && %s ;;\n", values("command", sub.commands))
+		} else {
+			fmt.Fprint(w, "                '*:: :_files' ;;\n")
+		}
+	}
+	fmt.Fprint(w, "        esac ;;\n")
+	fmt.Fprint(w, "    esac\n")
+	fmt.Fprint(w, "}\n\n")
+	fmt.Fprint(w, "_goRebind \"$@\"\n")
+}
+
+func writeFishCompletion(w io.Writer, tree []cliSubcommand) {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	}
+	flags := func(cond string, fs *flag.FlagSet) {
+		for _, f := range flagsOf(fs) {
+			_, usage := flag.UnquoteUsage(f)
+			arg := ""
+			switch {
+			case isBoolFlag(f):
+			case flagChoices[f.Name] != nil:
+				arg = " -x -a " + quote(strings.Join(flagChoices[f.Name], " "))
+			default:
+				arg = " -r -F"
+			}
+			fmt.Fprintf(w, "complete -c goRebind -n %s -o %s%s -d %s\n", quote(cond), f.Name, arg, quote(firstSentence(usage)))
+		}
+	}
+
+	fmt.Fprint(w, "# fish completion for goRebind; load with: goRebind completion fish | source\n\n")
+	fmt.Fprint(w, "complete -c goRebind -f\n")
+	for _, sub := range tree {
+		fmt.Fprintf(w, "complete -c goRebind -n __fish_use_subcommand -a %s -d %s\n", sub.name, quote(sub.summary))
+	}
+	flags("__fish_use_subcommand", flag.CommandLine)
+	for _, sub := range tree {
+		cond := "__fish_seen_subcommand_from " + sub.name
+		flags(cond, sub.flags)
+		for _, c := range sub.commands {
+			fmt.Fprintf(w, "complete -c goRebind -n %s -a %s -d %s\n", quote(cond), c.name, quote(c.summary))
+		}
+	}
+}
+
+func writeManPage(w io.Writer, tree []cliSubcommand) {
+	esc := func(s string) string {
+		s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+		if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+			s = `\&` + s
+		}
+		return s
+	}
+	options := func(fs *flag.FlagSet) {
+		for _, f := range flagsOf(fs) {
+			typ, usage := flag.UnquoteUsage(f)
+			if typ != "" {
+				fmt.Fprintf(w, ".TP\n.BI \\-%s \" %s\"\n", esc(f.Name), esc(typ))
+			} else {
+				fmt.Fprintf(w, ".TP\n.B \\-%s\n", esc(f.Name))
+			}
+			fmt.Fprint(w, esc(usage))
+			if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" && f.DefValue != "0s" {
+				fmt.Fprintf(w, " (default: %s)", esc(f.DefValue))
+			}
+			fmt.Fprint(w, "\n")
+		}
+	}
+
+	fmt.Fprintf(w, ".TH GOREBIND 1 \"\" \"goRebind %s\" \"User Commands\"\n", esc(version))
+	fmt.Fprint(w, ".SH NAME\ngoRebind \\- dynamic reverse proxy and DNS resolver\n")
+	fmt.Fprint(w, ".SH SYNOPSIS\n.B goRebind\n[\\fIflags\\fR]\n")
+	for _, sub := range tree {
+		fmt.Fprintf(w, ".br\n.B goRebind %s\n", sub.name)
+		if sub.args != "" {
+			fmt.Fprintf(w, "%s\n", esc(sub.args))
+		}
+	}
+	fmt.Fprint(w, ".SH DESCRIPTION\n")
+	fmt.Fprint(w, "goRebind answers DNS queries for routed hostnames with the address of a local\n")
+	fmt.Fprint(w, "interface and reverse proxies HTTP and HTTPS requests for them to their\n")
+	fmt.Fprint(w, "configured targets. Routes are read from a JSON config file and can be changed\n")
+	fmt.Fprint(w, "at runtime through the admin API.\n")
+	fmt.Fprint(w, ".SH OPTIONS\n")
+	options(flag.CommandLine)
+	fmt.Fprint(w, ".SH COMMANDS\n")
+	for _, sub := range tree {
+		fmt.Fprintf(w, ".SS %s\n%s.\n", esc(strings.TrimSpace(sub.name+" "+sub.args)), esc(sub.summary))
+		if len(sub.commands) > 0 {
+			fmt.Fprint(w, ".PP\nCommands:\n")
+			for _, c := range sub.commands {
+				fmt.Fprintf(w, ".TP\n.B %s\n", esc(strings.TrimSpace(c.name+" "+c.args)))
+				fmt.Fprintf(w, "%s\n", esc(c.summary))
+			}
+		}
+		if sub.flags != nil {
+			fmt.Fprint(w, ".PP\nFlags:\n")
+			options(sub.flags)
+		}
+	}
+	fmt.Fprint(w, ".SH ENVIRONMENT\n")
+	fmt.Fprint(w, ".TP\n.B GOREBIND_ADMIN_ADDR\nDefault admin API base URL for \\fBctl\\fR.\n")
+	fmt.Fprint(w, ".TP\n.B GOREBIND_ADMIN_TOKEN\nDefault admin API token for \\fBctl\\fR.\n")
+	fmt.Fprint(w, ".TP\n.B GOMEMLIMIT\nSoft memory limit used when \\fB\\-memory\\-limit\\fR is unset.\n")
+	fmt.Fprint(w, ".SH SEE ALSO\nhttps://github.com/captain-noob/goRebind\n")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Compression modes for the upstream leg of a route
+const (
+	// Forward the client's Accept-Encoding untouched (default)
+	compressionPassthrough = "passthrough"
+	// Ask upstream for uncompressed bodies; the client gets them as-is
+	compressionIdentity = "identity"
+	// Decompress upstream bodies at the relay and re-compress for the client
+	compressionTranscode = "transcode"
+)
+
+// Default compression mode for routes without one
+var compressionMode = compressionPassthrough
+
+// --- Compression Logic ---
+
+func validCompression(mode string) error {
+	switch mode {
+	case "", compressionPassthrough, compressionIdentity, compressionTranscode:
+		return nil
+	}
+	return fmt.Errorf("invalid compression %q (want passthrough, identity or transcode)", mode)
+}
+
+// compression returns the route's effective compression mode.
+func (rt *route) compression() string {
+	return valueOr(rt.Compression, compressionMode)
+}
+
+// negotiateCompression rewrites the upstream Accept-Encoding for the
+// route's mode, remembering what the client asked for.
+func negotiateCompression(req *http.Request, rt *route, info *requestInfo) {
+	switch rt.compression() {
+	case compressionIdentity:
+		// An explicit value also stops the transport adding its own gzip
+		req.Header.Set("Accept-Encoding", "identity")
+	case compressionTranscode:
+		if info != nil {
+			info.acceptEncoding = req.Header.Get("Accept-Encoding")
+		}
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+}
+
+// transcodeResponse decodes a compressed upstream body so the relay sees
+// plaintext, then re-encodes it with gzip if the client accepts it.
+func transcodeResponse(resp *http.Response, info *requestInfo) error {
+	if info == nil || info.matched == nil || info.matched.compression() != compressionTranscode {
+		return nil
+	}
+	if resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		resp.Body = readCloser{zr, resp.Body}
+	case "deflate":
+		zr, err := zlib.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		resp.Body = readCloser{zr, resp.Body}
+	case "", "identity":
+	default:
+		// Unknown codings are passed through untouched
+		return nil
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	if !acceptsGzip(info.acceptEncoding) {
+		return nil
+	}
+	plain := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, plain)
+		if err == nil {
+			err = zw.Close()
+		}
+		plain.Close()
+		pw.CloseWithError(err)
+	}()
+	resp.Body = pr
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+	return nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// readCloser reads from a decoder but closes the underlying body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
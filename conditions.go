@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Subsystems a route applies to, set by its mode
+const (
+	modeDNS  = "dns"
+	modeHTTP = "http"
+	modeBoth = "both"
+)
+
+// --- Route Condition Logic ---
+
+// parseMode validates the route's mode. DNS-only routes take no conditions
+// that only HTTP requests carry.
+func (rt *route) parseMode() error {
+	switch rt.Mode {
+	case "", modeBoth, modeHTTP:
+	case modeDNS:
+		if rt.path != "" || len(rt.Methods) > 0 || len(rt.Query) > 0 {
+			return fmt.Errorf("mode dns routes take no path, methods or query")
+		}
+	default:
+		return fmt.Errorf("invalid mode %q (want dns, http or both)", rt.Mode)
+	}
+	return nil
+}
+
+// serves reports whether the route applies to a subsystem, modeDNS or
+// modeHTTP.
+func (rt *route) serves(mode string) bool {
+	return rt.Mode == "" || rt.Mode == modeBoth || rt.Mode == mode
+}
+
+// parseConditions validates and normalizes the route's methods, and parses
+// its clients.
+func (rt *route) parseConditions() error {
+	methods := make([]string, len(rt.Methods))
+	for i, m := range rt.Methods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(m))
+		if methods[i] == "" || strings.ContainsAny(methods[i], " \t/") {
+			return fmt.Errorf("invalid method %q", m)
+		}
+	}
+	if len(methods) > 0 {
+		rt.Methods = methods
+	}
+	for name := range rt.Query {
+		if name == "" {
+			return fmt.Errorf("query conditions need a parameter name")
+		}
+	}
+	for _, client := range rt.Clients {
+		network, err := parseClientNet(client)
+		if err != nil {
+			return fmt.Errorf("invalid client %q: want an IP address or CIDR", client)
+		}
+		rt.clients = append(rt.clients, network)
+	}
+	return nil
+}
+
+// parseClientNet parses a client IP address or CIDR; an address stands for
+// itself alone.
+func parseClientNet(client string) (*net.IPNet, error) {
+	c := strings.TrimSpace(client)
+	if !strings.Contains(c, "/") {
+		if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+			c += "/32"
+		} else {
+			c += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(c)
+	return network, err
+}
+
+// conditional reports whether the route only serves some requests.
+func (rt *route) conditional() bool {
+	return len(rt.Methods) > 0 || len(rt.Query) > 0 || len(rt.clients) > 0 || !rt.serves(modeDNS) || !rt.serves(modeHTTP)
+}
+
+// matchClient reports whether the route serves a client address. A nil
+// address stands for any client.
+func (rt *route) matchClient(ip net.IP) bool {
+	if len(rt.clients) == 0 || ip == nil {
+		return true
+	}
+	return slices.ContainsFunc(rt.clients, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// remoteIP is the IP of a host:port client address, or nil.
+func remoteIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
+// matchConditions reports whether a request meets the route's methods and
+// query conditions. An empty query value only requires the parameter.
+func (rt *route) matchConditions(r *http.Request) bool {
+	if len(rt.Methods) > 0 && !slices.Contains(rt.Methods, r.Method) {
+		return false
+	}
+	if len(rt.Query) == 0 {
+		return true
+	}
+	query := r.URL.Query()
+	for name, want := range rt.Query {
+		values, ok := query[name]
+		if !ok || (want != "" && !slices.Contains(values, want)) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionsRejected answers 404 for requests that only routes with unmet
+// conditions could serve, instead of forwarding them as unmatched.
+func conditionsRejected(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok, filtered := routeForRequest(r); ok || !filtered {
+		return false
+	}
+	logRequest(r, "[MATCH] %s %s%s from %s: route conditions not met, answering 404", r.Method, r.Host, r.URL.Path, r.RemoteAddr)
+	http.NotFound(w, r)
+	return true
+}
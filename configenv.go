@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ${NAME} or ${NAME:-default}. A bare $ is left alone, so regex sources
+// ending in $ are untouched.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// --- Config Environment Expansion Logic ---
+
+// expandRoutes substitutes environment variables in each route's source and
+// target, as a shell would. An unset variable without a default is an
+// error.
+func expandRoutes(routes []ConfigRoute) error {
+	for i := range routes {
+		source := routes[i].Source
+		for _, field := range []*string{&routes[i].Source, &routes[i].Target} {
+			expanded, err := expandEnv(*field)
+			if err != nil {
+				return fmt.Errorf("route %d (%q): %w", i+1, source, err)
+			}
+			*field = expanded
+		}
+	}
+	return nil
+}
+
+func expandEnv(s string) (string, error) {
+	var missing []string
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		value, ok := os.LookupEnv(m[1])
+		switch {
+		case m[2] != "" && value == "":
+			// As in the shell, :- also replaces an empty value
+			return strings.TrimPrefix(m[2], ":-")
+		case !ok:
+			missing = append(missing, m[1])
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
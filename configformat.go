@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// Config file syntax; empty detects it from the file extension
+	configFormat string
+
+	// Default config files tried in order when -config is not given
+	defaultConfigFiles = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+)
+
+// Config formats accepted by -config-format
+var configFormats = []string{"json", "yaml", "toml"}
+
+// --- Config Format Logic ---
+
+// detectConfigFormat returns -config-format, or the format implied by the
+// file's extension. Unknown extensions are read as JSON.
+func detectConfigFormat(path string) string {
+	if configFormat != "" {
+		return configFormat
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// configJSON converts a YAML or TOML config document to the JSON the rest of
+// the relay reads, so all three formats share one schema. YAML configs are a
+// sequence of routes or a mapping with version, routes, include, engagement
+// and listeners; TOML configs are [[route]] and [[listener]] tables, optional
+// top-level version and include keys and an [engagement] table.
+func configJSON(data []byte, format string) ([]byte, error) {
+	var doc any
+	switch format {
+	case "json":
+		return data, nil
+	case "yaml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid YAML config: %w", err)
+		}
+		if doc == nil {
+			doc = []any{}
+		}
+	case "toml":
+		var tables struct {
+			Version    int              `toml:"version"`
+			Engagement map[string]any   `toml:"engagement"`
+			Include    []string         `toml:"include"`
+			Route      []map[string]any `toml:"route"`
+			Listener   []map[string]any `toml:"listener"`
+		}
+		md, err := toml.Decode(string(data), &tables)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOML config: %w", err)
+		}
+		// Keys nested in the tables decode into their maps but are still
+		// reported as undecoded, so only stray top-level keys are errors
+		for _, key := range md.Undecoded() {
+			if len(key) > 1 && slices.Contains([]string{"route", "listener", "engagement"}, key[0]) {
+				continue
+			}
+			return nil, fmt.Errorf("invalid TOML config: expected [[route]] and [[listener]] tables, version, include and [engagement], found %q", key.String())
+		}
+		routes := make([]any, len(tables.Route))
+		for i, r := range tables.Route {
+			routes[i] = r
+		}
+		m := map[string]any{"include": tables.Include, "routes": routes}
+		if tables.Version != 0 {
+			m["version"] = tables.Version
+		}
+		if tables.Engagement != nil {
+			m["engagement"] = tables.Engagement
+		}
+		if tables.Listener != nil {
+			listeners := make([]any, len(tables.Listener))
+			for i, l := range tables.Listener {
+				listeners[i] = l
+			}
+			m["listeners"] = listeners
+		}
+		doc = m
+	default:
+		return nil, fmt.Errorf("unknown config format %q (one of: %s)", format, strings.Join(configFormats, ", "))
+	}
+	out, err := json.Marshal(jsonValue(doc))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", strings.ToUpper(format), err)
+	}
+	return out, nil
+}
+
+// jsonValue rewrites the mappings YAML decodes with non-string keys (such
+// as error_pages: {502: ...}) so they marshal as JSON objects.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	}
+	return v
+}
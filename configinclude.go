@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// configDocument is a config file in object form: its schema version,
+// routes plus glob patterns of further config files, relative to the file
+// naming them, the engagement and the listeners. A bare array of routes is
+// the same document without includes, in version 1.
+type configDocument struct {
+	Version    int               `json:"version,omitempty"`
+	Engagement *Engagement       `json:"engagement,omitempty"`
+	Listeners  []*listenerConfig `json:"listeners,omitempty"`
+	Include    []string          `json:"include,omitempty"`
+	Routes     configRoutes      `json:"routes"`
+}
+
+// configLoader merges config files and their includes, remembering which
+// file defined each source.
+type configLoader struct {
+	routes  []ConfigRoute
+	files   []string          // file of each route
+	origin  map[string]string // canonical source -> file
+	loaded  map[string]bool
+	loading map[string]bool // files on the current include chain
+
+	engagement     *Engagement
+	engagementFile string
+
+	listeners     []*listenerConfig
+	listenersFile string
+}
+
+// --- Config Include Logic ---
+
+// readConfig parses config files and everything they include into one
+// document without touching the live route table. A source defined in two
+// different files is an error; within one file the definition with the
+// highest priority, or else the last one, wins. Files may repeat the
+// engagement block but not change it.
+func readConfig(paths []string) (*configDocument, error) {
+	l, err := loadConfigFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	return &configDocument{Engagement: l.engagement, Listeners: l.listeners, Routes: l.routes}, nil
+}
+
+func loadConfigFiles(paths []string) (*configLoader, error) {
+	l := &configLoader{origin: make(map[string]string), loaded: make(map[string]bool), loading: make(map[string]bool)}
+	for _, path := range paths {
+		if err := l.load(path); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *configLoader) load(path string) error {
+	abs := path
+	if !isRemoteConfig(path) {
+		var err error
+		if abs, err = filepath.Abs(path); err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+	}
+	if l.loading[abs] {
+		return fmt.Errorf("config include cycle: %s includes itself", path)
+	}
+	if l.loaded[abs] {
+		// Included from two places: its routes are already merged
+		return nil
+	}
+	l.loaded[abs], l.loading[abs] = true, true
+	defer delete(l.loading, abs)
+
+	doc, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if e := doc.Engagement; e != nil {
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if l.engagement != nil && *l.engagement != *e {
+			return fmt.Errorf("config conflict: engagement differs between %s and %s", l.engagementFile, path)
+		}
+		l.engagement, l.engagementFile = e, path
+	}
+	if len(doc.Listeners) > 0 {
+		if l.listeners != nil {
+			return fmt.Errorf("config conflict: listeners are declared in both %s and %s", l.listenersFile, path)
+		}
+		l.listeners, l.listenersFile = doc.Listeners, path
+	}
+	for _, r := range doc.Routes {
+		id := canonicalSource(r.Source)
+		if other, ok := l.origin[id]; ok && other != path {
+			return fmt.Errorf("config conflict: route %s is defined in both %s and %s", id, other, path)
+		}
+		l.origin[id] = path
+		l.routes = append(l.routes, r)
+		l.files = append(l.files, path)
+	}
+	for _, pattern := range doc.Include {
+		if isRemoteConfig(path) || isRemoteConfig(pattern) {
+			// URLs are not globbed; relative ones resolve against the including URL
+			if err := l.load(resolveInclude(path, pattern)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include %q: %w", path, pattern, err)
+		}
+		if len(matches) == 0 {
+			log.Printf("Warning: include %q in %s matches no files", pattern, path)
+		}
+		for _, match := range matches {
+			if err := l.load(match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
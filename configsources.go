@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// configRoutes is the routes array of a config file. A route may list
+// several sources, e.g. "source": ["a.local", "www.a.local"]; it is read
+// as one route per source with the same options.
+type configRoutes []ConfigRoute
+
+// --- Config Source List Logic ---
+
+func (routes *configRoutes) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	list := make(configRoutes, 0, len(raw))
+	for i, obj := range raw {
+		expanded, err := expandSources(obj)
+		if err != nil {
+			return fmt.Errorf("route %d: %w", i+1, err)
+		}
+		list = append(list, expanded...)
+	}
+	*routes = list
+	return nil
+}
+
+// expandSources decodes one route object, repeating it for each entry of
+// a source list.
+func expandSources(obj json.RawMessage) ([]ConfigRoute, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(obj, &fields); err != nil {
+		return nil, err
+	}
+	if src := bytes.TrimSpace(fields["source"]); len(src) == 0 || src[0] != '[' {
+		var r ConfigRoute
+		err := json.Unmarshal(obj, &r)
+		return []ConfigRoute{r}, err
+	}
+	var sources []string
+	if err := json.Unmarshal(fields["source"], &sources); err != nil {
+		return nil, fmt.Errorf("invalid source list: %w", err)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("source list is empty")
+	}
+	routes := make([]ConfigRoute, len(sources))
+	for i, source := range sources {
+		fields["source"], _ = json.Marshal(source)
+		if err := remarshal(fields, &routes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return routes, nil
+}
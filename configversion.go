@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Newest config schema this relay reads. Unversioned documents, a bare
+// route array or an object without "version", are version 1 and keep
+// ignoring fields the relay does not know; version 2 documents are objects
+// with "version": 2 whose unknown fields are errors.
+const configVersion = 2
+
+// Top-level fields of a config document
+var documentFields = map[string]bool{"version": true, "engagement": true, "listeners": true, "include": true, "routes": true}
+
+// --- Config Version Logic ---
+
+// checkConfigVersion rejects versions the relay cannot read and, from
+// version 2 on, fields it does not know.
+func checkConfigVersion(doc *configDocument, data []byte) error {
+	switch {
+	case doc.Version < 0:
+		return fmt.Errorf("invalid config version %d", doc.Version)
+	case doc.Version > configVersion:
+		return fmt.Errorf("config version %d is newer than this relay reads (up to %d)", doc.Version, configVersion)
+	case doc.Version < 2:
+		return nil
+	}
+	return checkConfigFields(data)
+}
+
+// checkConfigFields decodes a config document strictly, naming the first
+// unknown field and where it is.
+func checkConfigFields(data []byte) error {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return err
+	}
+	for _, key := range sortedKeys(top) {
+		if !documentFields[key] {
+			return fmt.Errorf("unknown field %q; a version %d config has version, engagement, listeners, include and routes", key, configVersion)
+		}
+	}
+	if e, ok := top["engagement"]; ok {
+		if err := decodeStrict(e, &Engagement{}); err != nil {
+			return fmt.Errorf("engagement: %w", err)
+		}
+	}
+	if l, ok := top["listeners"]; ok {
+		if err := decodeStrict(l, &[]*listenerConfig{}); err != nil {
+			return fmt.Errorf("listeners: %w", err)
+		}
+	}
+	var routes []map[string]json.RawMessage
+	if err := json.Unmarshal(top["routes"], &routes); err != nil && top["routes"] != nil {
+		return fmt.Errorf("routes: %w", err)
+	}
+	for i, fields := range routes {
+		source := fields["source"]
+		// Source lists are checked by the regular decoder
+		delete(fields, "source")
+		obj, _ := json.Marshal(fields)
+		if err := decodeStrict(obj, &ConfigRoute{}); err != nil {
+			return fmt.Errorf("route %d (%s): %w", i+1, valueOr(string(source), "no source"), err)
+		}
+	}
+	return nil
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
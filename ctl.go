@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// --- Admin CLI (ctl) Logic ---
+
+// ctlCommands lists the ctl commands for usage text, completions and the
+// man page.
+var ctlCommands = []cliCommand{
+	{"routes", "", "List the live route table"},
+	{"set", "<source> <target>", "Add or replace a route"},
+	{"delete", "<source>", "Remove a route"},
+	{"route-enable", "<source>", "Switch a route back on"},
+	{"route-disable", "<source>", "Switch a route off without deleting it"},
+	{"reload", "", "Reload routes from the relay's config file"},
+	{"stats", "", "Show traffic counters"},
+	{"manifest", "", "Show the route manifest (targets, tags, strategies, hits)"},
+	{"clients", "", "Show fingerprinted clients (OS, browser, JA3/JA4)"},
+	{"sessions", "[client] [host]", "Show DNS, TLS and HTTP activity stitched into sessions"},
+	{"rebind", "[client] [host]", "Show clients' progress through rebind strategies"},
+	{"rebind-reset", "[client] [host]", "Start matching clients' rebind strategies over"},
+	{"simulate", "<[https://]host[/path]> [client]", "Show the route, DNS answer and target a lookup and request would get, without sending them"},
+	{"version", "", "Show the relay's build (version, commit, build date)"},
+	{"killswitch", "[on|off]", "Show, engage or release the kill switch"},
+	{"groups", "", "List route groups and whether each is enabled"},
+	{"enable", "<group>", "Re-enable the routes of a group"},
+	{"disable", "<group>", "Disable the routes of a group"},
+	{"baits", "", "List minted bait routes and their hits"},
+	{"mint", "[memo] [webhook]", "Mint a bait route with a unique hostname"},
+	{"unbait", "<host>", "Delete a bait route"},
+	{"certs", "", "List the HTTPS listener's certificates"},
+	{"uncert", "<name>", "Delete a certificate (minted ones are reissued)"},
+	{"revoke", "<name>", "Revoke a certificate issued by the internal CA"},
+	{"ca", "", "Print the internal CA certificate (PEM)"},
+	{"events", "[type...]", "Stream live events as JSON lines (Ctrl-C to stop)"},
+	{"top", "[n]", "Show live DNS and HTTP rates with the n busiest clients and routes (Ctrl-C to stop)"},
+	{"tui", "", "Open the interactive console: live log, route toggling and adding, rebind states"},
+}
+
+func ctlUsage() string {
+	var b strings.Builder
+	b.WriteString("Usage: goRebind ctl [flags] <command> [args]\n\nCommands:\n")
+	for _, c := range ctlCommands {
+		fmt.Fprintf(&b, "  %-24s %s\n", strings.TrimSpace(c.name+" "+c.args), c.summary)
+	}
+	b.WriteString("\nFlags:\n")
+	return b.String()
+}
+
+// newCtlFlags registers the connection flags shared by admin subcommands.
+func newCtlFlags(name string) (*flag.FlagSet, func() *adminclient.Client) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	addr := fs.String("addr", envOr("GOREBIND_ADMIN_ADDR", "http://127.0.0.1:9090"), "Admin API base URL (env GOREBIND_ADMIN_ADDR)")
+	token := fs.String("token", os.Getenv("GOREBIND_ADMIN_TOKEN"), "Admin API token (env GOREBIND_ADMIN_TOKEN)")
+	caFile := fs.String("ca", "", "CA bundle used to verify the admin API certificate")
+	certFile := fs.String("cert", "", "Client certificate for mTLS")
+	keyFile := fs.String("key", "", "Client private key for mTLS")
+	insecure := fs.Bool("insecure", false, "Skip admin API certificate verification")
+
+	return fs, func() *adminclient.Client {
+		client := adminclient.New(*addr, *token)
+		tlsConfig := &tls.Config{InsecureSkipVerify: *insecure}
+		if *caFile != "" {
+			pem, err := os.ReadFile(*caFile)
+			if err != nil {
+				log.Fatalf("Failed to read CA: %v", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			tlsConfig.RootCAs.AppendCertsFromPEM(pem)
+		}
+		if *certFile != "" {
+			cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+			if err != nil {
+				log.Fatalf("Failed to load client certificate: %v", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		client.HTTPClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+		return client
+	}
+}
+
+func runCtl(args []string) {
+	fs, newClient := newCtlFlags("ctl")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), ctlUsage())
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	client := newClient()
+	if fs.Arg(0) == "top" {
+		runCtlTop(client, fs.Args()[1:])
+		return
+	}
+	if fs.Arg(0) == "tui" {
+		runConsole(client, fs.Args()[1:])
+		return
+	}
+	if fs.Arg(0) == "events" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		enc := json.NewEncoder(os.Stdout)
+		err := client.Events(ctx, fs.Args()[1:], func(ev adminclient.Event) error { return enc.Encode(ev) })
+		if err != nil && ctx.Err() == nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var (
+		out any
+		err error
+	)
+	cmdArgs := fs.Args()[1:]
+	switch fs.Arg(0) {
+	case "routes":
+		out, err = client.ListRoutes(ctx)
+	case "set":
+		if len(cmdArgs) != 2 {
+			log.Fatal("Usage: goRebind ctl set <source> <target>")
+		}
+		out, err = client.SetRoute(ctx, adminclient.Route{Source: cmdArgs[0], Target: cmdArgs[1]})
+	case "delete":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl delete <source>")
+		}
+		err = client.DeleteRoute(ctx, cmdArgs[0])
+	case "route-enable":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl route-enable <source>")
+		}
+		out, err = client.EnableRoute(ctx, cmdArgs[0])
+	case "route-disable":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl route-disable <source>")
+		}
+		out, err = client.DisableRoute(ctx, cmdArgs[0])
+	case "reload":
+		out, err = client.Reload(ctx)
+	case "stats":
+		out, err = client.Stats(ctx)
+	case "manifest":
+		out, err = client.Manifest(ctx)
+	case "clients":
+		out, err = client.Clients(ctx)
+	case "sessions":
+		if len(cmdArgs) > 2 {
+			log.Fatal("Usage: goRebind ctl sessions [client] [host]")
+		}
+		cmdArgs = append(cmdArgs, "", "")
+		out, err = client.Sessions(ctx, cmdArgs[0], cmdArgs[1])
+	case "rebind", "rebind-reset":
+		if len(cmdArgs) > 2 {
+			log.Fatalf("Usage: goRebind ctl %s [client] [host]", fs.Arg(0))
+		}
+		cmdArgs = append(cmdArgs, "", "")
+		if fs.Arg(0) == "rebind" {
+			out, err = client.RebindStates(ctx, cmdArgs[0], cmdArgs[1])
+		} else {
+			out, err = client.ResetRebindStates(ctx, cmdArgs[0], cmdArgs[1])
+		}
+	case "simulate":
+		if len(cmdArgs) < 1 || len(cmdArgs) > 2 {
+			log.Fatal("Usage: goRebind ctl simulate <[https://]host[/path]> [client]")
+		}
+		rest, secure := strings.CutPrefix(cmdArgs[0], "https://")
+		if !secure {
+			rest = strings.TrimPrefix(rest, "http://")
+		}
+		host, path, _ := strings.Cut(rest, "/")
+		req := adminclient.SimulateRequest{QName: host, Host: host, Path: "/" + path, TLS: secure}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			req.QName = h
+		}
+		if len(cmdArgs) == 2 {
+			req.Client = cmdArgs[1]
+		}
+		out, err = client.Simulate(ctx, req)
+	case "version":
+		out, err = client.Version(ctx)
+	case "groups":
+		out, err = client.Groups(ctx)
+	case "enable":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl enable <group>")
+		}
+		out, err = client.EnableGroup(ctx, cmdArgs[0])
+	case "disable":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl disable <group>")
+		}
+		out, err = client.DisableGroup(ctx, cmdArgs[0])
+	case "baits":
+		out, err = client.ListBaits(ctx)
+	case "mint":
+		var bait adminclient.Bait
+		if len(cmdArgs) > 0 {
+			bait.Memo = cmdArgs[0]
+		}
+		if len(cmdArgs) > 1 {
+			bait.Webhook = cmdArgs[1]
+		}
+		out, err = client.MintBait(ctx, bait)
+	case "unbait":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl unbait <host>")
+		}
+		err = client.DeleteBait(ctx, cmdArgs[0])
+	case "certs":
+		out, err = client.ListCerts(ctx)
+	case "uncert":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl uncert <name>")
+		}
+		err = client.DeleteCert(ctx, cmdArgs[0])
+	case "revoke":
+		if len(cmdArgs) != 1 {
+			log.Fatal("Usage: goRebind ctl revoke <name>")
+		}
+		out, err = client.RevokeCert(ctx, cmdArgs[0])
+	case "ca":
+		var pem []byte
+		if pem, err = client.CA(ctx); err == nil {
+			_, _ = os.Stdout.Write(pem)
+		}
+	case "killswitch":
+		switch {
+		case len(cmdArgs) == 0:
+			out, err = client.KillSwitch(ctx)
+		case cmdArgs[0] == "on":
+			out, err = client.EngageKillSwitch(ctx)
+		case cmdArgs[0] == "off":
+			out, err = client.ReleaseKillSwitch(ctx)
+		default:
+			log.Fatal("Usage: goRebind ctl killswitch [on|off]")
+		}
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+	if out != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(out)
+	}
+}
+
+// runCtlTop redraws the relay's live rates every second until Ctrl-C.
+func runCtlTop(client *adminclient.Client, args []string) {
+	n := 10
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 || len(args) > 1 {
+			log.Fatal("Usage: goRebind ctl top [n]")
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		top, err := client.Top(reqCtx, n)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		// Clear the screen and move to the top left
+		fmt.Print("\033[H\033[2J")
+		if err != nil {
+			fmt.Printf("goRebind top - %s\n\n%v\n", time.Now().Format(time.TimeOnly), err)
+		} else {
+			fmt.Print(formatTop(top))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatTop renders a snapshot as the ctl top screen.
+func formatTop(top Top) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "goRebind top - %s, last %ds\n\n", time.Now().Format(time.TimeOnly), top.WindowSeconds)
+	fmt.Fprintf(&b, "DNS  %8.1f q/s\nHTTP %8.1f req/s\n", top.DNSPerSecond, top.HTTPPerSecond)
+	for _, table := range []struct {
+		title   string
+		entries []TopEntry
+	}{{"CLIENT", top.Clients}, {"ROUTE", top.Routes}} {
+		fmt.Fprintf(&b, "\n%-40s %8s %8s\n", table.title, "DNS/s", "HTTP/s")
+		for _, e := range table.entries {
+			w := float64(top.WindowSeconds)
+			fmt.Fprintf(&b, "%-40s %8.1f %8.1f\n", e.Name, float64(e.DNSQueries)/w, float64(e.HTTPRequests)/w)
+		}
+	}
+	return b.String()
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Longest argument kept in command logs
+	commandLogArg = 64
+	// Largest Redis command buffered for inspection before it is forwarded
+	maxRESPCommand = 8 << 20
+)
+
+// respParser assembles RESP commands (arrays of bulk strings) from lines.
+type respParser struct {
+	raw      []byte
+	args     []string
+	left     int // array elements still expected
+	bulkLeft int // bytes of the current bulk string (with CRLF) still expected
+	bulk     []byte
+}
+
+// --- Datastore Guard Logic (Redis, Memcached) ---
+
+// allows applies the relay's command allow and deny lists. Entries match
+// a command ("FLUSHALL") or a command and subcommand ("CONFIG SET").
+func (cfg *tcpRelayConfig) allows(args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	names := []string{strings.ToUpper(args[0])}
+	if len(args) > 1 {
+		names = append(names, names[0]+" "+strings.ToUpper(args[1]))
+	}
+	listed := func(list []string) bool {
+		for _, entry := range list {
+			for _, name := range names {
+				if strings.EqualFold(entry, name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if len(cfg.Allow) > 0 && !listed(cfg.Allow) {
+		return false
+	}
+	return !listed(cfg.Deny)
+}
+
+// guardCommand logs a datastore command and decides whether it may pass.
+func (s *tcpSession) guardCommand(args []string) bool {
+	allowed := s.cfg.allows(args)
+	client := s.client.RemoteAddr().String()
+	if s.cfg.LogCommands || !allowed {
+		shown := make([]string, len(args))
+		for i, a := range args {
+			if len(a) > commandLogArg {
+				a = a[:commandLogArg] + "..."
+			}
+			shown[i] = strconv.Quote(a)
+		}
+		verdict := "passed"
+		if !allowed {
+			verdict = "blocked"
+		}
+		log.Printf("[TCP] %s %s -> %s: %s (%s)", s.cfg.Protocol, client, s.cfg.Target, strings.Join(shown, " "), verdict)
+	}
+	if !allowed {
+		audit("system", client, "datastore_command_blocked", map[string]any{
+			"protocol": s.cfg.Protocol, "target": s.cfg.Target, "command": args[0],
+		})
+	}
+	return allowed
+}
+
+// redisProtocol filters RESP and inline commands.
+type redisProtocol struct{}
+
+func (redisProtocol) fromClient(s *tcpSession, line []byte) ([]byte, []byte) {
+	p := &s.resp
+	p.raw = append(p.raw, line...)
+	if len(p.raw) > maxRESPCommand {
+		return s.refuse("-ERR Protocol error: command too large\r\n")
+	}
+
+	switch {
+	case p.bulkLeft > 0:
+		p.bulk = append(p.bulk, line...)
+		if len(p.bulk) < p.bulkLeft {
+			return nil, nil
+		}
+		p.args = append(p.args, string(bytes.TrimSuffix(p.bulk, []byte("\r\n"))))
+		p.bulk, p.bulkLeft = nil, 0
+		p.left--
+	case !bytes.HasSuffix(line, []byte("\n")):
+		// Only bulk strings may be longer than a line piece
+		return s.refuse("-ERR Protocol error: too big inline request\r\n")
+	case p.left > 0:
+		if line[0] != '$' {
+			return s.refuse("-ERR Protocol error: expected '$'\r\n")
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(line[1:])))
+		if err != nil || n < 0 || n > maxRESPCommand {
+			return s.refuse("-ERR Protocol error: invalid bulk length\r\n")
+		}
+		p.bulkLeft = n + 2
+		return nil, nil
+	case line[0] == '*':
+		n, err := strconv.Atoi(strings.TrimSpace(string(line[1:])))
+		if err != nil || n <= 0 {
+			return s.refuse("-ERR Protocol error: invalid multibulk length\r\n")
+		}
+		p.left = n
+		return nil, nil
+	default:
+		// Inline command, as sent by gopher:// and telnet
+		p.args = strings.Fields(string(line))
+		if len(p.args) == 0 {
+			raw := p.raw
+			*p = respParser{}
+			return raw, nil
+		}
+	}
+	if p.left > 0 {
+		return nil, nil
+	}
+
+	raw, args := p.raw, p.args
+	*p = respParser{}
+	if !s.guardCommand(args) {
+		return s.refuse("-ERR command '" + strings.ToLower(args[0]) + "' blocked by relay policy\r\n")
+	}
+	return raw, nil
+}
+
+func (redisProtocol) fromServer(_ *tcpSession, line []byte) []byte {
+	return line
+}
+
+// memcachedProtocol filters text protocol commands, passing or swallowing
+// the data blocks of storage commands along with them.
+type memcachedProtocol struct{}
+
+var memcachedStorage = map[string]bool{"SET": true, "ADD": true, "REPLACE": true, "APPEND": true, "PREPEND": true, "CAS": true}
+
+func (memcachedProtocol) fromClient(s *tcpSession, line []byte) ([]byte, []byte) {
+	if s.dataLeft > 0 {
+		s.dataLeft -= len(line)
+		return line, nil
+	}
+	if line[0] == 0x80 {
+		// Binary protocol: commands cannot be inspected, so none pass
+		log.Printf("[TCP] memcached %s -> %s: binary protocol refused", s.client.RemoteAddr(), s.cfg.Target)
+		return nil, nil
+	}
+
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		return s.refuse("CLIENT_ERROR line too long\r\n")
+	}
+	args := strings.Fields(string(line))
+	if len(args) == 0 {
+		return line, nil
+	}
+	if !s.guardCommand(args) {
+		// The data block of a storage command is never read
+		return s.refuse("CLIENT_ERROR command blocked by relay policy\r\n")
+	}
+	if memcachedStorage[strings.ToUpper(args[0])] && len(args) >= 5 {
+		if n, err := strconv.Atoi(args[4]); err == nil && n >= 0 {
+			s.dataLeft = n + 2
+		}
+	}
+	return line, nil
+}
+
+func (memcachedProtocol) fromServer(_ *tcpSession, line []byte) []byte {
+	return line
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Default overall deadline for proxied requests (0 disables)
+var upstreamTimeout time.Duration
+
+// --- Request Deadline Logic ---
+
+// parseTimeout validates a route's timeout option.
+func (rt *route) parseTimeout() error {
+	if rt.Timeout == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(rt.Timeout)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid timeout %q", rt.Timeout)
+	}
+	rt.timeout = d
+	return nil
+}
+
+// withDeadline bounds a proxied request by its route's timeout, falling
+// back to -upstream-timeout, so dials to blackholed addresses fail fast
+// instead of waiting for the OS TCP timeout.
+func withDeadline(r *http.Request) (*http.Request, context.CancelFunc) {
+	timeout := upstreamTimeout
+	if rt, exists := requestRoute(r); exists && rt.timeout > 0 {
+		timeout = rt.timeout
+	}
+	// Upgraded connections (WebSockets) outlive any request deadline
+	if timeout <= 0 || isCloaked(r) || r.Header.Get("Upgrade") != "" {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return r.WithContext(ctx), cancel
+}
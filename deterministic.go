@@ -0,0 +1,56 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"log"
+	"math/rand/v2"
+	"sync"
+)
+
+var (
+	// Reproducible IDs, names and traffic splits for CI regression tests
+	deterministic bool
+	seed          uint64
+
+	// Seeded generator used instead of crypto/rand in deterministic mode
+	seededMu   sync.Mutex
+	seededRand *rand.ChaCha8
+)
+
+// --- Deterministic Mode Logic ---
+
+// setupDeterministic seeds the generator behind request and trace IDs,
+// bait hostnames and canary rolls. Keys, certificate serials and admin
+// tokens stay random.
+func setupDeterministic() {
+	if !deterministic {
+		return
+	}
+	var s [32]byte
+	binary.LittleEndian.PutUint64(s[:], seed)
+	seededRand = rand.NewChaCha8(s)
+	log.Printf("[DETERMINISTIC] Seed %d: request IDs, trace IDs, bait names and canary rolls are reproducible", seed)
+}
+
+// randomBytes fills b from crypto/rand, or from the seeded generator in
+// deterministic mode.
+func randomBytes(b []byte) {
+	if seededRand == nil {
+		_, _ = crand.Read(b)
+		return
+	}
+	seededMu.Lock()
+	defer seededMu.Unlock()
+	_, _ = seededRand.Read(b)
+}
+
+// randomN returns a number in [0, n).
+func randomN(n uint32) uint32 {
+	if seededRand == nil {
+		return rand.Uint32N(n)
+	}
+	seededMu.Lock()
+	defer seededMu.Unlock()
+	return uint32(seededRand.Uint64() % uint64(n))
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// Serve net/http/pprof and expvar on the admin API
+var adminPprof bool
+
+// --- Runtime Diagnostics Logic ---
+
+// registerDiagnostics mounts the profiling endpoints on the admin mux. They
+// require admin scope since heap profiles can contain request data.
+func registerDiagnostics(mux *http.ServeMux) {
+	if !adminPprof {
+		return
+	}
+	expvar.Publish("gorebind", expvar.Func(func() any { return stats.snapshot() }))
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+
+	mux.HandleFunc("GET /debug/pprof/", requireScope(scopeAdmin, pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", requireScope(scopeAdmin, pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", requireScope(scopeAdmin, pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", requireScope(scopeAdmin, pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", requireScope(scopeAdmin, pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", requireScope(scopeAdmin, pprof.Trace))
+	mux.HandleFunc("GET /debug/vars", requireScope(scopeAdmin, expvar.Handler().ServeHTTP))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net"
+	"slices"
+)
+
+var (
+	// Client addresses and networks the DNS server answers; empty answers
+	// anyone
+	dnsAllow     stringList
+	dnsAllowNets []*net.IPNet
+)
+
+// --- DNS ACL Logic ---
+
+// setupDNSAllow parses -dns-allow.
+func setupDNSAllow() {
+	for _, client := range dnsAllow {
+		network, err := parseClientNet(client)
+		if err != nil {
+			log.Fatalf("Invalid -dns-allow %q: want an IP address or CIDR", client)
+		}
+		dnsAllowNets = append(dnsAllowNets, network)
+	}
+	if len(dnsAllowNets) > 0 {
+		log.Printf("[DNS] Answering only %s", dnsAllow.String())
+	}
+}
+
+// dnsAllowed reports whether the DNS server answers a client; others are
+// refused. ACME DNS-01 challenges are answered for anyone, as the CA's
+// validators query from addresses of their own.
+func dnsAllowed(ip net.IP) bool {
+	if len(dnsAllowNets) == 0 {
+		return true
+	}
+	return ip != nil && slices.ContainsFunc(dnsAllowNets, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
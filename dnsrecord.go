@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// JSON-lines file recording every DNS query as the client sent it
+	dnsRecordPath string
+	dnsRecord     *os.File
+	dnsRecordMu   sync.Mutex
+)
+
+// dnsQueryRecord is one recorded query. Name keeps the client's case, so
+// 0x20 randomization shows up.
+type dnsQueryRecord struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Port      int       `json:"port"`
+	Transport string    `json:"transport"`
+	ID        uint16    `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	RD        bool      `json:"rd"`
+	EDNSSize  uint16    `json:"edns_size,omitempty"`
+	DO        bool      `json:"do,omitempty"`
+	Rebound   bool      `json:"rebound"`
+	TTL       uint      `json:"ttl,omitempty"`
+
+	Engagement string `json:"engagement,omitempty"`
+}
+
+const replayUsage = `Usage: goRebind replay [flags] <record.jsonl>
+
+Replays DNS queries recorded with -dns-record, per client and name: timing,
+retransmissions, query types and how often the resolver really re-resolves.
+With -rebind-after it shows which query a rebind at that delay would reach.
+
+Flags:
+`
+
+// --- DNS Record and Replay Logic ---
+
+func openDNSRecord() {
+	if dnsRecordPath == "" {
+		return
+	}
+	f, err := os.OpenFile(dnsRecordPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Failed to open DNS record: %v", err)
+	}
+	dnsRecord = f
+}
+
+// recordDNSQuery appends a query and whether it was answered with the
+// relay's address.
+func recordDNSQuery(w dns.ResponseWriter, r *dns.Msg, q dns.Question, rebound bool) {
+	if dnsRecord == nil {
+		return
+	}
+	rec := dnsQueryRecord{
+		Time:      time.Now().UTC(),
+		Transport: "udp",
+		ID:        r.Id,
+		Name:      q.Name,
+		Type:      dns.TypeToString[q.Qtype],
+		RD:        r.RecursionDesired,
+		Rebound:   rebound,
+	}
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		rec.Client, rec.Port = addr.IP.String(), addr.Port
+	case *net.TCPAddr:
+		rec.Client, rec.Port, rec.Transport = addr.IP.String(), addr.Port, "tcp"
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		rec.EDNSSize, rec.DO = opt.UDPSize(), opt.Do()
+	}
+	if rebound {
+		rec.TTL = dnsTTL
+	}
+	rec.Engagement = engagementID()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	dnsRecordMu.Lock()
+	defer dnsRecordMu.Unlock()
+	if _, err := fmt.Fprintf(dnsRecord, "%s\n", line); err != nil {
+		log.Printf("[DNS] Failed to write record: %v", err)
+	}
+}
+
+func newReplayFlags() (*flag.FlagSet, *string, *string, *time.Duration) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	client := fs.String("client", "", "Only replay queries from this client IP")
+	name := fs.String("name", "", "Only replay queries for this name")
+	rebindAfter := fs.Duration("rebind-after", 0, "Simulate rebinding this long after a client's first query for a name")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	return fs, client, name, rebindAfter
+}
+
+func runReplay(args []string) {
+	fs, client, name, rebindAfter := newReplayFlags()
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open record: %v", err)
+	}
+	defer f.Close()
+
+	// Queries grouped by client and lowercase name, in recorded order
+	sessions := make(map[string][]dnsQueryRecord)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var rec dnsQueryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.Fatalf("%s:%d: %v", fs.Arg(0), line, err)
+		}
+		host := strings.TrimSuffix(strings.ToLower(rec.Name), ".")
+		if (*client != "" && rec.Client != *client) || (*name != "" && host != canonicalSource(*name)) {
+			continue
+		}
+		key := rec.Client + " " + host
+		sessions[key] = append(sessions[key], rec)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read record: %v", err)
+	}
+	for _, key := range sortedKeys(sessions) {
+		replaySession(key, sessions[key], *rebindAfter)
+	}
+}
+
+// replaySession prints one client's queries for one name.
+func replaySession(key string, queries []dnsQueryRecord, rebindAfter time.Duration) {
+	start := queries[0].Time
+	types := make(map[string]int)
+	seen := make(map[string]bool)           // id/type pairs, to spot retransmissions
+	lastFresh := make(map[string]time.Time) // last new query per type
+	var intervals []time.Duration
+	retries, mixedCase := 0, false
+	rebindAt := -1
+
+	fmt.Printf("%s\n", key)
+	for i, q := range queries {
+		offset := q.Time.Sub(start)
+		types[q.Type]++
+		if q.Name != strings.ToLower(q.Name) {
+			mixedCase = true
+		}
+		note := ""
+		id := fmt.Sprintf("%d/%s", q.ID, q.Type)
+		if seen[id] {
+			retries++
+			note = "retransmission"
+		} else {
+			seen[id] = true
+			if prev, ok := lastFresh[q.Type]; ok {
+				gap := q.Time.Sub(prev)
+				intervals = append(intervals, gap)
+				note = fmt.Sprintf("re-resolve after %s", gap.Round(time.Millisecond))
+			}
+			lastFresh[q.Type] = q.Time
+		}
+		if rebindAfter > 0 && rebindAt < 0 && offset >= rebindAfter {
+			rebindAt = i
+			note = strings.TrimPrefix(note+", rebinds here", ", ")
+		}
+		fmt.Printf("  +%9.3fs  %-5s id=%-5d %-4s %s\n", offset.Seconds(), q.Type, q.ID, q.Transport, note)
+	}
+
+	span := queries[len(queries)-1].Time.Sub(start)
+	var summary []string
+	for _, t := range sortedKeys(types) {
+		summary = append(summary, fmt.Sprintf("%s %d", t, types[t]))
+	}
+	fmt.Printf("  %d queries over %s (%s), %d retransmissions", len(queries), span.Round(time.Millisecond), strings.Join(summary, ", "), retries)
+	if mixedCase {
+		fmt.Print(", 0x20 case randomization")
+	}
+	fmt.Println()
+	if len(intervals) > 0 {
+		slices.Sort(intervals)
+		shortest, median := intervals[0], intervals[len(intervals)/2]
+		fmt.Printf("  re-resolution interval: min %s, median %s", shortest.Round(time.Millisecond), median.Round(time.Millisecond))
+		if i := slices.IndexFunc(queries, func(q dnsQueryRecord) bool { return q.Rebound }); i >= 0 {
+			ttl := queries[i].TTL
+			if time.Duration(ttl)*time.Second > shortest {
+				fmt.Printf(" (re-resolved before the %ds TTL expired)", ttl)
+			} else {
+				fmt.Printf(" (TTL was %ds)", ttl)
+			}
+		}
+		fmt.Println()
+	}
+	switch {
+	case rebindAfter == 0:
+	case rebindAt < 0:
+		fmt.Printf("  rebinding after %s: no query would see the new address\n", rebindAfter)
+	default:
+		fmt.Printf("  rebinding after %s: first seen by query %d at +%.3fs\n", rebindAfter, rebindAt+1, queries[rebindAt].Time.Sub(start).Seconds())
+	}
+	fmt.Println()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	// Flag queries that look like DNS tunneling or exfiltration
+	tunnelDetect bool
+
+	// Labels at least this long are flagged
+	tunnelLabelLen int
+
+	// Subdomains with at least this many bits of entropy per character
+	// are flagged
+	tunnelEntropy float64
+
+	// JSON-lines file of flagged queries and their decoded payloads
+	tunnelCapturePath string
+	tunnelCapture     *os.File
+	tunnelCaptureMu   sync.Mutex
+
+	// Clients already reported per domain, so the log gets one line per
+	// tunnel rather than one per query
+	tunnelSeen   = make(map[string]int)
+	tunnelSeenMu sync.Mutex
+)
+
+// Shortest subdomain whose entropy is meaningful
+const tunnelMinPayload = 24
+
+// tunnelRecord is one flagged query in the capture file. Payload is the
+// subdomain without dots in the client's case; Decoded is set when it
+// decodes as Encoding, as text or else as hex in DecodedHex.
+type tunnelRecord struct {
+	Time         time.Time `json:"time"`
+	Client       string    `json:"client"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Domain       string    `json:"domain"`
+	Reasons      []string  `json:"reasons"`
+	Entropy      float64   `json:"entropy"`
+	LongestLabel int       `json:"longest_label"`
+	Payload      string    `json:"payload"`
+	Encoding     string    `json:"encoding,omitempty"`
+	Decoded      string    `json:"decoded,omitempty"`
+	DecodedHex   string    `json:"decoded_hex,omitempty"`
+
+	Engagement string `json:"engagement,omitempty"`
+}
+
+// --- DNS Tunnel Detection Logic ---
+
+func openTunnelCapture() {
+	if tunnelLabelLen < 1 || tunnelLabelLen > 63 {
+		log.Fatalf("Invalid -dns-tunnel-label %d: labels are 1 to 63 characters", tunnelLabelLen)
+	}
+	if tunnelEntropy <= 0 {
+		log.Fatalf("Invalid -dns-tunnel-entropy %g: must be positive", tunnelEntropy)
+	}
+	if tunnelCapturePath == "" {
+		return
+	}
+	tunnelDetect = true
+	f, err := os.OpenFile(tunnelCapturePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatalf("Failed to open DNS tunnel capture: %v", err)
+	}
+	tunnelCapture = f
+}
+
+// inspectDNSTunnel flags a query whose subdomain has a long label or looks
+// random, logs the first one per client and domain, and captures each.
+func inspectDNSTunnel(client string, q dns.Question) {
+	if !tunnelDetect {
+		return
+	}
+	rec, ok := classifyTunnelQuery(q.Name)
+	if !ok {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	rec.Client = client
+	rec.Type = dns.TypeToString[q.Qtype]
+	rec.Engagement = engagementID()
+
+	tunnelSeenMu.Lock()
+	key := client + " " + rec.Domain
+	tunnelSeen[key]++
+	first := tunnelSeen[key] == 1
+	tunnelSeenMu.Unlock()
+	if first {
+		log.Printf("[TUNNEL] Suspected DNS tunnel from %s via %s (%s, %.2f bits/char, %s)", client, rec.Domain, strings.Join(rec.Reasons, ", "), rec.Entropy, valueOr(rec.Encoding, "unknown encoding"))
+	}
+	writeTunnelRecord(rec)
+}
+
+// classifyTunnelQuery splits a name into payload and registered domain and
+// reports whether the payload looks like tunneled data.
+func classifyTunnelQuery(name string) (tunnelRecord, bool) {
+	fqdn := strings.TrimSuffix(name, ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(fqdn))
+	if err != nil || len(fqdn) <= len(domain) {
+		return tunnelRecord{}, false
+	}
+	sub := fqdn[:len(fqdn)-len(domain)-1]
+	rec := tunnelRecord{Name: name, Domain: domain, Payload: strings.ReplaceAll(sub, ".", "")}
+	labels := strings.Split(sub, ".")
+	for _, label := range labels {
+		rec.LongestLabel = max(rec.LongestLabel, len(label))
+	}
+	rec.Entropy = math.Round(shannonEntropy(strings.ToLower(rec.Payload))*100) / 100
+	if rec.LongestLabel >= tunnelLabelLen {
+		rec.Reasons = append(rec.Reasons, fmt.Sprintf("%d-character label", rec.LongestLabel))
+	}
+	if len(rec.Payload) >= tunnelMinPayload && rec.Entropy >= tunnelEntropy {
+		rec.Reasons = append(rec.Reasons, "high entropy")
+	}
+	if len(rec.Reasons) == 0 {
+		return tunnelRecord{}, false
+	}
+	// The labels nearest the domain may name the tunnel's zone rather
+	// than carry data, so shorter prefixes are tried for text too
+	for n := len(labels); n > 0; n-- {
+		encoding, text, hexed := decodeTunnelPayload(strings.Join(labels[:n], ""))
+		if text != "" {
+			rec.Encoding, rec.Decoded, rec.DecodedHex = encoding, text, ""
+			break
+		}
+		if rec.Encoding == "" {
+			rec.Encoding, rec.DecodedHex = encoding, hexed
+		}
+	}
+	return rec, true
+}
+
+// shannonEntropy is the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(utf8.RuneCountInString(s))
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// decodeTunnelPayload tries the encodings common tools use, in order of
+// how narrow their alphabet is: hex (dnscat2), base32 (iodine, dns2tcp)
+// and base64url. It returns the first that decodes to text, or else the
+// first hex or base32 decoding as hex; almost anything decodes as
+// base64url, so binary results of it are ignored.
+func decodeTunnelPayload(payload string) (encoding, text, hexed string) {
+	lower := strings.ToLower(payload)
+	upper := strings.ToUpper(strings.TrimRight(payload, "="))
+	decoders := []struct {
+		name   string
+		decode func() ([]byte, error)
+	}{
+		{"hex", func() ([]byte, error) { return hex.DecodeString(lower) }},
+		{"base32", func() ([]byte, error) {
+			// Unpadded base32 ends on whole bytes only at these lengths;
+			// the decoder itself drops a stray trailing character
+			if !slices.Contains([]int{0, 2, 4, 5, 7}, len(upper)%8) {
+				return nil, base32.CorruptInputError(len(upper))
+			}
+			return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(upper)
+		}},
+		{"base64url", func() ([]byte, error) {
+			return base64.RawURLEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}},
+	}
+	for _, d := range decoders {
+		data, err := d.decode()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if printable(data) {
+			return d.name, string(data), ""
+		}
+		if encoding == "" && d.name != "base64url" {
+			encoding, hexed = d.name, hex.EncodeToString(data)
+		}
+	}
+	return encoding, "", hexed
+}
+
+// printable reports whether data is UTF-8 text without control characters
+// other than whitespace.
+func printable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func writeTunnelRecord(rec tunnelRecord) {
+	if tunnelCapture == nil {
+		return
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	tunnelCaptureMu.Lock()
+	defer tunnelCaptureMu.Unlock()
+	if _, err := fmt.Fprintf(tunnelCapture, "%s\n", line); err != nil {
+		log.Printf("[TUNNEL] Failed to write capture: %v", err)
+	}
+}
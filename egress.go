@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+type dialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Transport for routes without their own egress
+var baseTransport *http.Transport
+
+// --- Per-Route Egress Logic ---
+
+// parseEgress sets up the route's own upstream dialer, if it has one.
+func (rt *route) parseEgress() error {
+	var err error
+	switch {
+	case rt.ViaSSH != nil && rt.ViaWireGuard != "":
+		return fmt.Errorf("via_ssh and via_wireguard are mutually exclusive")
+	case rt.ViaSSH != nil:
+		rt.egress, err = sshDialer(*rt.ViaSSH)
+	case rt.ViaWireGuard != "":
+		rt.egress, err = wireguardDialer(rt.ViaWireGuard)
+	}
+	return err
+}
+
+// routeTransport paces upstream requests for routes with a pace, and hands
+// requests for routes with their own egress (SSH or WireGuard tunnels),
+// outbound proxy, TLS verification or server name to a dedicated transport, so their
+// connections are never pooled with the shared ones. gRPC calls go through
+// an HTTP/2-only variant of the transport.
+type routeTransport struct{}
+
+func (routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	info := getRequestInfo(req)
+	if info == nil || info.matched == nil {
+		if isGRPC(req) {
+			return grpcTransport(nil).RoundTrip(req)
+		}
+		return baseTransport.RoundTrip(req)
+	}
+	if err := info.matched.awaitPace(req.Context()); err != nil {
+		return nil, err
+	}
+	if isGRPC(req) {
+		return grpcTransport(info.matched).RoundTrip(req)
+	}
+	if info.matched.speaksHTTP3() && req.URL.Scheme == "https" {
+		if resp, ok, err := roundTripHTTP3(info.matched, req); ok {
+			return resp, err
+		}
+	}
+	if info.matched.ownTransport() {
+		return info.matched.egressTransport().RoundTrip(req)
+	}
+	return baseTransport.RoundTrip(req)
+}
+
+// ownTransport reports whether the route's upstream connections differ from
+// the shared transport's.
+func (rt *route) ownTransport() bool {
+	return rt.egress != nil || rt.Proxy != "" || rt.SkipSSLVerify != nil || rt.SNI != ""
+}
+
+func (rt *route) egressTransport() *http.Transport {
+	rt.egressOnce.Do(func() {
+		t := baseTransport.Clone()
+		tlsConfig := warmTLSConfig()
+		if rt.SkipSSLVerify != nil {
+			tlsConfig.InsecureSkipVerify = *rt.SkipSSLVerify
+		}
+		tlsConfig.ServerName = rt.SNI
+		t.TLSClientConfig = tlsConfig
+		dial := warmDial
+		if rt.egress != nil {
+			dial = guardedDial(dialUnix(rt.egress))
+			// The tunnel is the way out; the outbound proxy does not apply
+			t.Proxy = nil
+		} else if rt.Proxy == "-" {
+			t.Proxy = nil
+		} else if rt.proxyURL != nil {
+			t.Proxy = bypassUnix(http.ProxyURL(rt.proxyURL))
+		}
+		t.DialContext = dial
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialTLSVia(ctx, dial, tlsConfig, network, addr)
+		}
+		if t.IdleConnTimeout == 0 {
+			t.IdleConnTimeout = 90 * time.Second
+		}
+		rt.transport = t
+	})
+	return rt.transport
+}
+
+// dialTLSVia dials with the given dialer and completes a TLS handshake
+// using the given TLS settings.
+func dialTLSVia(ctx context.Context, dial dialFunc, tlsConfig *tls.Config, network, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := tlsConfig.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, cfg)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
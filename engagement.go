@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Engagement is the config block identifying the engagement a relay runs
+// for. It is stamped into logs, audit entries, events and recordings.
+type Engagement struct {
+	ID            string `json:"id"`
+	Operator      string `json:"operator,omitempty"`
+	Authorization string `json:"authorization,omitempty"` // e.g. the signed rules-of-engagement reference
+	// AdminHeaders adds X-Engagement-* headers to admin API responses
+	AdminHeaders bool `json:"admin_headers,omitempty"`
+}
+
+// engagementStamp is the part of the engagement recorded with entries.
+type engagementStamp struct {
+	ID            string `json:"id"`
+	Operator      string `json:"operator,omitempty"`
+	Authorization string `json:"authorization,omitempty"`
+}
+
+// Engagement of the loaded config, if any
+var engagement atomic.Pointer[Engagement]
+
+// --- Engagement Metadata Logic ---
+
+func (e *Engagement) validate() error {
+	if e.ID == "" {
+		return fmt.Errorf("engagement needs an id")
+	}
+	for _, v := range []string{e.ID, e.Operator, e.Authorization} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("engagement fields must be single lines")
+		}
+	}
+	return nil
+}
+
+// setEngagement applies the engagement of a (re)loaded config, prefixing
+// every log line with its ID.
+func setEngagement(e *Engagement) {
+	prev := engagement.Swap(e)
+	if e == nil {
+		log.SetPrefix("")
+		if prev != nil {
+			log.Printf("[ENGAGEMENT] Cleared (was %s)", prev.ID)
+		}
+		return
+	}
+	log.SetFlags(log.Flags() | log.Lmsgprefix)
+	log.SetPrefix("[" + e.ID + "] ")
+	if prev == nil || *prev != *e {
+		log.Printf("[ENGAGEMENT] %s, operator %s, authorization %s", e.ID, valueOr(e.Operator, "-"), valueOr(e.Authorization, "-"))
+	}
+}
+
+// currentEngagement returns the stamp for a new entry, or nil.
+func currentEngagement() *engagementStamp {
+	e := engagement.Load()
+	if e == nil {
+		return nil
+	}
+	return &engagementStamp{ID: e.ID, Operator: e.Operator, Authorization: e.Authorization}
+}
+
+// engagementID is the current engagement's ID, or empty.
+func engagementID() string {
+	if e := engagement.Load(); e != nil {
+		return e.ID
+	}
+	return ""
+}
+
+// engagementHeaders adds the engagement to admin API responses when the
+// config asks for it.
+func engagementHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e := engagement.Load(); e != nil && e.AdminHeaders {
+			w.Header().Set("X-Engagement-Id", e.ID)
+			if e.Operator != "" {
+				w.Header().Set("X-Engagement-Operator", e.Operator)
+			}
+			if e.Authorization != "" {
+				w.Header().Set("X-Engagement-Authorization", e.Authorization)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
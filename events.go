@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Event is shared with the admin API client.
+type Event = adminclient.Event
+
+// Name identifying this relay in events (defaults to the hostname)
+var relayName string
+
+// Events buffered per subscriber before new ones are dropped
+const eventBuffer = 256
+
+// Upper bound on tracked (route, resolver) pairs for rebind events
+const maxRebindPairs = 10000
+
+type eventSubscriber struct {
+	ch    chan Event
+	types map[string]bool // nil means all types
+}
+
+var (
+	eventMu        sync.Mutex
+	eventSubs      = make(map[*eventSubscriber]struct{})
+	eventSubCount  atomic.Int32
+	eventsDropped  atomic.Uint64
+	rebindMu       sync.Mutex
+	rebindSeen     = make(map[string]bool)
+	eventsShutdown sync.Once
+)
+
+// --- Event Stream Logic ---
+
+// emit publishes an event to every subscriber without ever blocking the
+// DNS or HTTP path; slow subscribers lose events instead.
+func emit(ev Event) {
+	if eventSubCount.Load() == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	ev.Relay = relayName
+	ev.Engagement = engagementID()
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	for sub := range eventSubs {
+		if sub.types != nil && !sub.types[ev.Type] {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			eventsDropped.Add(1)
+		}
+	}
+}
+
+// subscribe registers a listener for the given event types (all if empty).
+// The channel is closed by cancel or when the relay shuts down.
+func subscribe(types []string) (<-chan Event, func()) {
+	eventsShutdown.Do(func() {
+		onShutdown(func(context.Context) {
+			// Open sessions are published before subscribers go away
+			closeSessions()
+			eventMu.Lock()
+			defer eventMu.Unlock()
+			for sub := range eventSubs {
+				close(sub.ch)
+				delete(eventSubs, sub)
+			}
+			eventSubCount.Store(0)
+		})
+	})
+
+	sub := &eventSubscriber{ch: make(chan Event, eventBuffer)}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	eventMu.Lock()
+	eventSubs[sub] = struct{}{}
+	eventSubCount.Add(1)
+	eventMu.Unlock()
+
+	return sub.ch, func() {
+		eventMu.Lock()
+		defer eventMu.Unlock()
+		if _, ok := eventSubs[sub]; ok {
+			close(sub.ch)
+			delete(eventSubs, sub)
+			eventSubCount.Add(-1)
+		}
+	}
+}
+
+// emitDNS publishes a DNS query event, plus a rebind event the first time
+// a resolver is handed the relay address for a route.
+func emitDNS(name, client, qtype string, hit bool) {
+	emit(Event{Type: adminclient.EventDNSQuery, Host: name, Client: client, QType: qtype, Hit: hit})
+	if !hit {
+		return
+	}
+	key := name + "|" + client
+	rebindMu.Lock()
+	first := !rebindSeen[key]
+	if first {
+		if len(rebindSeen) >= maxRebindPairs {
+			rebindSeen = make(map[string]bool)
+		}
+		rebindSeen[key] = true
+	}
+	rebindMu.Unlock()
+	if first {
+		emit(Event{Type: adminclient.EventRebind, Host: name, Route: name, Client: client, Message: "resolver now points at the relay"})
+	}
+}
+
+// handleEvents streams events as Server-Sent Events. ?types= filters by a
+// comma-separated list of event types.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	if t := r.URL.Query().Get("types"); t != "" {
+		types = strings.Split(t, ",")
+	}
+	events, cancel := subscribe(types)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	_ = rc.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
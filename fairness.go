@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+var (
+	// Proxied requests a single client IP may have in flight (0 disables)
+	clientConcurrency int
+	// Requests a client may have waiting for a slot before it gets 429
+	clientQueue int
+
+	clientSlotsMu sync.Mutex
+	clientSlots   = make(map[string]*clientSlot)
+)
+
+// clientSlot limits one client's in-flight requests. refs counts requests
+// holding or waiting for a slot, so idle clients are dropped from the map.
+type clientSlot struct {
+	sem     chan struct{}
+	waiting int
+	refs    int
+}
+
+// --- Client Fairness Logic ---
+
+// acquireClientSlot waits for one of the client's -client-concurrency
+// slots. Each client queues only behind its own requests, so a flood from
+// one address cannot starve the others. ok is false when the client's
+// queue is full, after answering 429, or when the request ends while
+// waiting.
+func acquireClientSlot(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if clientConcurrency <= 0 {
+		return func() {}, true
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	clientSlotsMu.Lock()
+	slot := clientSlots[client]
+	if slot == nil {
+		slot = &clientSlot{sem: make(chan struct{}, clientConcurrency)}
+		clientSlots[client] = slot
+	}
+	slot.refs++
+	clientSlotsMu.Unlock()
+
+	done := func() {
+		clientSlotsMu.Lock()
+		if slot.refs--; slot.refs == 0 {
+			delete(clientSlots, client)
+		}
+		clientSlotsMu.Unlock()
+	}
+
+	select {
+	case slot.sem <- struct{}{}:
+		return func() { <-slot.sem; done() }, true
+	default:
+	}
+
+	clientSlotsMu.Lock()
+	full := slot.waiting >= clientQueue
+	if !full {
+		slot.waiting++
+	}
+	clientSlotsMu.Unlock()
+	if full {
+		done()
+		rejectClient(w, r, client)
+		return nil, false
+	}
+
+	defer func() {
+		clientSlotsMu.Lock()
+		slot.waiting--
+		clientSlotsMu.Unlock()
+	}()
+	select {
+	case slot.sem <- struct{}{}:
+		return func() { <-slot.sem; done() }, true
+	case <-r.Context().Done():
+		done()
+		return nil, false
+	}
+}
+
+func rejectClient(w http.ResponseWriter, r *http.Request, client string) {
+	stats.shed.Add(1)
+	if verboseMode {
+		logRequest(r, "[FAIR] Rejected %s %s from %s: %d requests in flight, %d queued", r.Method, r.Host, client, clientConcurrency, clientQueue)
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
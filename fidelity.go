@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// --- Protocol Fidelity Logic ---
+
+// Trailers, 1xx responses and Upgrade handshakes are relayed by default;
+// routes can switch each one off for targets or clients that choke on them.
+
+func enabled(opt *bool) bool {
+	return opt == nil || *opt
+}
+
+// trimRequest drops request trailers and upgrade attempts the route does
+// not relay. Without an Upgrade header the proxy sends a plain request.
+func trimRequest(req *http.Request, rt *route) {
+	if !enabled(rt.Trailers) {
+		req.Trailer = nil
+		req.Header.Del("Te")
+	}
+	if !enabled(rt.Upgrade) {
+		req.Header.Del("Upgrade")
+	}
+}
+
+// trimResponse drops response trailers for routes that disable them.
+func trimResponse(resp *http.Response, info *requestInfo) {
+	if info == nil || info.matched == nil || enabled(info.matched.Trailers) {
+		return
+	}
+	resp.Trailer = nil
+	resp.Body = &trailerStripper{ReadCloser: resp.Body, resp: resp}
+}
+
+// trailerStripper discards trailers the transport merges into the
+// response once the body has been read, before the proxy copies them.
+type trailerStripper struct {
+	io.ReadCloser
+	resp *http.Response
+}
+
+func (t *trailerStripper) Close() error {
+	err := t.ReadCloser.Close()
+	t.resp.Trailer = nil
+	return err
+}
+
+// relayInformational reports whether a 1xx response (other than 101)
+// should reach the client.
+func relayInformational(info *requestInfo) bool {
+	return info == nil || info.matched == nil || enabled(info.matched.Informational)
+}
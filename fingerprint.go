@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"goRebind/adminclient"
+)
+
+var (
+	// Infer each client's OS and browser from DNS, HTTP and TLS
+	fingerprinting bool
+
+	fingerprintMu sync.Mutex
+	fingerprints  = make(map[string]*ClientFingerprint)
+)
+
+// ClientFingerprint is returned by the admin API.
+type ClientFingerprint = adminclient.ClientFingerprint
+
+// Client addresses tracked before the table starts over
+const maxFingerprints = 10000
+
+// User-Agent fragments, most specific first: iOS and Android user agents
+// also name Mac OS X and Linux, and every Chromium browser names Chrome
+// and Safari.
+var (
+	uaSystems = []struct{ fragment, os string }{
+		{"iphone", "iOS"}, {"ipad", "iOS"}, {"ipod", "iOS"},
+		{"android", "Android"},
+		{"cros", "ChromeOS"},
+		{"windows", "Windows"},
+		{"macintosh", "macOS"}, {"mac os x", "macOS"},
+		{"linux", "Linux"},
+	}
+	uaBrowsers = []struct{ fragment, browser string }{
+		{"edg/", "Edge"}, {"edga/", "Edge"}, {"edgios/", "Edge"},
+		{"opr/", "Opera"},
+		{"samsungbrowser/", "Samsung Internet"},
+		{"firefox/", "Firefox"}, {"fxios/", "Firefox"},
+		{"crios/", "Chrome"}, {"chrome/", "Chrome"}, {"chromium/", "Chrome"},
+		{"version/", "Safari"},
+		{"curl/", "curl"},
+		{"wget/", "Wget"},
+		{"python-requests/", "Python"}, {"python-urllib/", "Python"}, {"aiohttp/", "Python"},
+		{"go-http-client/", "Go"},
+		{"okhttp/", "OkHttp"},
+		{"java/", "Java"},
+	}
+	// Sec-CH-UA-Platform values
+	hintSystems = map[string]string{
+		"windows": "Windows", "macos": "macOS", "ios": "iOS", "android": "Android",
+		"linux": "Linux", "chrome os": "ChromeOS", "chromium os": "ChromeOS",
+	}
+	// The cipher part of well-known JA4 fingerprints, which stays put
+	// across releases while the extension part moves
+	ja4Browsers = map[string]string{
+		"8daaf6152771": "Chrome",
+		"5b57614c22b0": "Firefox",
+	}
+)
+
+// --- Client Fingerprint Logic ---
+
+// fingerprint returns the client's entry, creating it. Callers hold
+// fingerprintMu.
+func fingerprint(client string) *ClientFingerprint {
+	fp, ok := fingerprints[client]
+	if !ok {
+		if len(fingerprints) >= maxFingerprints {
+			fingerprints = make(map[string]*ClientFingerprint)
+		}
+		fp = &ClientFingerprint{Client: client, FirstSeen: time.Now().UTC()}
+		fingerprints[client] = fp
+	}
+	fp.LastSeen = time.Now().UTC()
+	return fp
+}
+
+// observeDNSClient records the traits of a query: EDNS buffer size, AAAA
+// lookups and 0x20 case randomization, which marks a resolver.
+func observeDNSClient(client string, r *dns.Msg, q dns.Question) {
+	if !fingerprinting || client == "" {
+		return
+	}
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	fp := fingerprint(client)
+	fp.DNSQueries++
+	if opt := r.IsEdns0(); opt != nil {
+		fp.EDNSSize = opt.UDPSize()
+	}
+	if q.Qtype == dns.TypeAAAA {
+		fp.AAAA = true
+	}
+	if name := q.Name; strings.ToLower(name) != name && strings.ToUpper(name) != name {
+		fp.Resolver = true
+	}
+}
+
+// observeHTTPClient infers the OS and browser from the User-Agent and the
+// Sec-CH-UA-Platform client hint. A new User-Agent from the address, say
+// another program or a device behind the same NAT, replaces both.
+func observeHTTPClient(r *http.Request) {
+	if !fingerprinting {
+		return
+	}
+	client := remoteIP(r.RemoteAddr)
+	if client == nil {
+		return
+	}
+	ua := r.UserAgent()
+	system, browser := parseUserAgent(ua)
+	source := "user-agent"
+	if hint := hintSystems[strings.ToLower(strings.Trim(r.Header.Get("Sec-CH-UA-Platform"), `"`))]; hint != "" {
+		system, source = hint, "client-hints"
+	}
+	fingerprintMu.Lock()
+	fp := fingerprint(client.String())
+	fp.HTTPRequests++
+	if ua == "" || ua == fp.UserAgent {
+		fingerprintMu.Unlock()
+		return
+	}
+	fp.UserAgent = ua
+	changed := setFingerprint(fp, system, browser, source)
+	fingerprintMu.Unlock()
+	logFingerprint(changed)
+}
+
+// observeClientHello records the JA3 and JA4 fingerprints of a TLS
+// handshake, and takes the browser from a known JA4 when no User-Agent
+// has named it. It is a GetConfigForClient hook that changes nothing.
+func observeClientHello(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if !fingerprinting || hello.Conn == nil {
+		return nil, nil
+	}
+	client := remoteIP(hello.Conn.RemoteAddr().String())
+	if client == nil {
+		return nil, nil
+	}
+	ja3, ja4 := ja3Fingerprint(hello), ja4Fingerprint(hello)
+	fingerprintMu.Lock()
+	fp := fingerprint(client.String())
+	fp.TLSHandshakes++
+	fp.JA3, fp.JA4 = ja3, ja4
+	var changed *ClientFingerprint
+	if browser := ja4Browsers[strings.Split(ja4, "_")[1]]; browser != "" && fp.UserAgent == "" {
+		changed = setFingerprint(fp, fp.OS, browser, "tls")
+	}
+	fingerprintMu.Unlock()
+	logFingerprint(changed)
+	return nil, nil
+}
+
+// setFingerprint replaces the inferred OS and browser, returning a copy of
+// the entry if either changed. Callers hold fingerprintMu.
+func setFingerprint(fp *ClientFingerprint, system, browser, source string) *ClientFingerprint {
+	if system == fp.OS && browser == fp.Browser {
+		return nil
+	}
+	fp.OS, fp.Browser, fp.Source = system, browser, source
+	copied := *fp
+	return &copied
+}
+
+func logFingerprint(fp *ClientFingerprint) {
+	if fp != nil {
+		log.Printf("[FINGERPRINT] %s: %s (%s)", fp.Client, fingerprintText(fp), fp.Source)
+	}
+}
+
+// parseUserAgent returns the OS and browser a User-Agent names, empty
+// when it names none.
+func parseUserAgent(ua string) (system, browser string) {
+	lower := strings.ToLower(ua)
+	for _, s := range uaSystems {
+		if strings.Contains(lower, s.fragment) {
+			system = s.os
+			break
+		}
+	}
+	for _, b := range uaBrowsers {
+		if strings.Contains(lower, b.fragment) {
+			browser = b.browser
+			break
+		}
+	}
+	return system, browser
+}
+
+// fingerprintText reads "Chrome on Windows", or whichever half is known.
+func fingerprintText(fp *ClientFingerprint) string {
+	switch {
+	case fp.Browser != "" && fp.OS != "":
+		return fp.Browser + " on " + fp.OS
+	case fp.Browser != "":
+		return fp.Browser
+	}
+	return valueOr(fp.OS, "unknown")
+}
+
+// fingerprintTag is " [Browser/OS]" for log lines about the client, or ""
+// when nothing is known about it.
+func fingerprintTag(client string) string {
+	if !fingerprinting {
+		return ""
+	}
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	if fp, ok := fingerprints[client]; ok && (fp.Browser != "" || fp.OS != "") {
+		return " [" + fingerprintLabel(fp) + "]"
+	}
+	return ""
+}
+
+// fingerprintLabel is "Browser/OS", each half "unknown" until known.
+func fingerprintLabel(fp *ClientFingerprint) string {
+	return valueOr(fp.Browser, "unknown") + "/" + valueOr(fp.OS, "unknown")
+}
+
+// clientSystem returns a client's inferred OS and browser.
+func clientSystem(client string) (system, browser string) {
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	if fp, ok := fingerprints[client]; ok {
+		return fp.OS, fp.Browser
+	}
+	return "", ""
+}
+
+// fingerprintCounts counts the fingerprinted clients by label.
+func fingerprintCounts() map[string]uint64 {
+	if !fingerprinting {
+		return nil
+	}
+	fingerprintMu.Lock()
+	defer fingerprintMu.Unlock()
+	counts := make(map[string]uint64)
+	for _, fp := range fingerprints {
+		if fp.Browser != "" || fp.OS != "" {
+			counts[fingerprintLabel(fp)]++
+		}
+	}
+	return counts
+}
+
+// grease reports whether a TLS value is one of the reserved GREASE values
+// clients send to keep servers tolerant; fingerprints leave them out.
+func grease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGrease[T ~uint16](values []T) []uint16 {
+	out := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !grease(uint16(v)) {
+			out = append(out, uint16(v))
+		}
+	}
+	return out
+}
+
+func joinValues(values []uint16, format, sep string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf(format, v)
+	}
+	return strings.Join(parts, sep)
+}
+
+// ja3Fingerprint is the MD5 of version, ciphers, extensions, curves and
+// point formats as sent. The hello's legacy version is not exposed, so
+// clients offering TLS 1.2 or later count as 771 (TLS 1.2), which they
+// all send there.
+func ja3Fingerprint(hello *tls.ClientHelloInfo) string {
+	versions := withoutGrease(hello.SupportedVersions)
+	version := uint16(0)
+	if len(versions) > 0 {
+		version = min(slices.Max(versions), tls.VersionTLS12)
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+	s := fmt.Sprintf("%d,%s,%s,%s,%s", version,
+		joinValues(withoutGrease(hello.CipherSuites), "%d", "-"),
+		joinValues(withoutGrease(hello.Extensions), "%d", "-"),
+		joinValues(withoutGrease(hello.SupportedCurves), "%d", "-"),
+		joinValues(points, "%d", "-"))
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4Fingerprint is the JA4 of the hello: protocol, version, SNI, cipher
+// and extension counts and ALPN, then truncated hashes of the sorted
+// ciphers and of the sorted extensions with the signature algorithms.
+func ja4Fingerprint(hello *tls.ClientHelloInfo) string {
+	versions := withoutGrease(hello.SupportedVersions)
+	version := "00"
+	if len(versions) > 0 {
+		switch v := slices.Max(versions); v {
+		case tls.VersionTLS13:
+			version = "13"
+		case tls.VersionTLS12:
+			version = "12"
+		case tls.VersionTLS11:
+			version = "11"
+		case tls.VersionTLS10:
+			version = "10"
+		default:
+			version = "s3"
+		}
+	}
+	sni := "i"
+	if hello.ServerName != "" {
+		sni = "d"
+	}
+	alpn := "00"
+	if len(hello.SupportedProtos) > 0 && hello.SupportedProtos[0] != "" {
+		p := hello.SupportedProtos[0]
+		alpn = p[:1] + p[len(p)-1:]
+	}
+	ciphers := withoutGrease(hello.CipherSuites)
+	extensions := withoutGrease(hello.Extensions)
+	a := fmt.Sprintf("t%s%s%02d%02d%s", version, sni, min(len(ciphers), 99), min(len(extensions), 99), alpn)
+
+	slices.Sort(ciphers)
+	// SNI and ALPN are already counted in the first part
+	extensions = slices.DeleteFunc(extensions, func(e uint16) bool { return e == 0x0000 || e == 0x0010 })
+	slices.Sort(extensions)
+	c := joinValues(extensions, "%04x", ",")
+	if schemes := withoutGrease(hello.SignatureSchemes); len(schemes) > 0 {
+		c += "_" + joinValues(schemes, "%04x", ",")
+	}
+	return a + "_" + truncatedHash(joinValues(ciphers, "%04x", ",")) + "_" + truncatedHash(c)
+}
+
+func truncatedHash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// clientFingerprints lists the clients seen, by address.
+func clientFingerprints() []ClientFingerprint {
+	fingerprintMu.Lock()
+	list := make([]ClientFingerprint, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		list = append(list, *fp)
+	}
+	fingerprintMu.Unlock()
+	slices.SortFunc(list, func(a, b ClientFingerprint) int {
+		return compareAddrs(a.Client, b.Client)
+	})
+	return list
+}
+
+// compareAddrs orders IP addresses numerically.
+func compareAddrs(a, b string) int {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	return bytes.Compare(ipA.To16(), ipB.To16())
+}
+
+func handleListClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, clientFingerprints())
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Bytes of GraphQL variables logged per operation before truncating
+var graphqlLogMax int
+
+// Largest GraphQL request body parsed for logging; bigger ones are relayed
+// without a [GRAPHQL] line
+const maxGraphQLBody = 1 << 20
+
+// graphqlRequest is one GraphQL operation as POSTed by clients.
+type graphqlRequest struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName"`
+	Variables     json.RawMessage `json:"variables"`
+}
+
+// Operation definitions in a GraphQL document: type and optional name
+var graphqlOperation = regexp.MustCompile(`(?:^|[\s}])(query|mutation|subscription)\b\s*([_A-Za-z][_0-9A-Za-z]*)?`)
+
+// --- GraphQL Logging Logic ---
+
+// logGraphQL logs the operations of a request to a graphql route, leaving
+// the body intact for the upstream.
+func logGraphQL(req *http.Request, rt *route) {
+	if rt.GraphQL == nil || !*rt.GraphQL {
+		return
+	}
+	ops, err := graphqlOperations(req)
+	if err != nil {
+		logRequest(req, "[GRAPHQL] %s: %v", rt.name(), err)
+		return
+	}
+	for _, op := range ops {
+		kind, name := op.operation()
+		logRequest(req, "[GRAPHQL] %s %s %s variables=%s", rt.name(), kind, name, truncateVariables(op.Variables))
+	}
+}
+
+// graphqlOperations reads the operations from a GET query string or a JSON
+// POST body, single or batched.
+func graphqlOperations(req *http.Request) ([]graphqlRequest, error) {
+	if req.Method == http.MethodGet {
+		q := req.URL.Query()
+		if q.Get("query") == "" {
+			return nil, nil
+		}
+		return []graphqlRequest{{Query: q.Get("query"), OperationName: q.Get("operationName"), Variables: json.RawMessage(q.Get("variables"))}}, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/graphql" {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxGraphQLBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(body) > maxGraphQLBody {
+		// Relay the part already read followed by the rest
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, nil
+	}
+	req.Body.Close()
+	setRequestBody(req, body)
+
+	if mediaType == "application/graphql" {
+		return []graphqlRequest{{Query: string(body)}}, nil
+	}
+	var ops []graphqlRequest
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(body, &ops)
+	} else {
+		ops = make([]graphqlRequest, 1)
+		err = json.Unmarshal(body, &ops[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("body is not a GraphQL request: %w", err)
+	}
+	return ops, nil
+}
+
+// operation returns the type and name of the operation that runs: the one
+// named by operationName, else the first in the document. Shorthand
+// documents ({ ... }) are anonymous queries.
+func (op graphqlRequest) operation() (kind, name string) {
+	kind, name = "query", op.OperationName
+	for _, m := range graphqlOperation.FindAllStringSubmatch(op.Query, -1) {
+		if op.OperationName == "" || m[2] == op.OperationName {
+			kind = m[1]
+			if name == "" {
+				name = m[2]
+			}
+			break
+		}
+	}
+	if name == "" {
+		name = "(anonymous)"
+	}
+	return kind, name
+}
+
+// truncateVariables renders variables as compact JSON capped at
+// -graphql-log-max bytes.
+func truncateVariables(vars json.RawMessage) string {
+	var buf bytes.Buffer
+	if len(vars) == 0 || json.Compact(&buf, vars) != nil || buf.String() == "null" {
+		return "{}"
+	}
+	if graphqlLogMax > 0 && buf.Len() > graphqlLogMax {
+		return fmt.Sprintf("%s... (%d bytes)", strings.ToValidUTF8(string(buf.Bytes()[:graphqlLogMax]), ""), buf.Len())
+	}
+	return buf.String()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"goRebind/adminclient"
+)
+
+var (
+	// Route groups disabled at startup
+	disableGroups stringList
+
+	// Disabled route groups; guarded by mu and kept across reloads
+	disabledGroups = make(map[string]bool)
+
+	// Routes switched off on their own, by ID; guarded by mu and kept
+	// across reloads like disabled groups
+	disabledRoutes = make(map[string]bool)
+
+	groupName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// GroupStatus and RouteStatus are returned by the admin API.
+type (
+	GroupStatus = adminclient.GroupStatus
+	RouteStatus = adminclient.RouteStatus
+)
+
+// --- Route Group Logic ---
+
+// parseGroup validates the route's group name.
+func (rt *route) parseGroup() error {
+	if rt.Group != "" && !groupName.MatchString(rt.Group) {
+		return fmt.Errorf("invalid group %q: use letters, digits, '.', '_' and '-'", rt.Group)
+	}
+	return nil
+}
+
+// enabled reports whether the route is switched on, its group is enabled
+// and its schedule has it active. Routes without a group or schedule
+// always are unless switched off. Callers hold mu.
+func (rt *route) enabled() bool {
+	return !disabledRoutes[rt.name()] && (rt.Group == "" || !disabledGroups[rt.Group]) && !rt.scheduledOff.Load()
+}
+
+// setupGroups applies -disable-group.
+func setupGroups() {
+	for _, name := range disableGroups {
+		if !groupName.MatchString(name) {
+			log.Fatalf("Invalid -disable-group %q", name)
+		}
+		disabledGroups[name] = true
+	}
+	if len(disableGroups) > 0 {
+		log.Printf("[GROUPS] Disabled at startup: %s", disableGroups.String())
+	}
+}
+
+// setGroupEnabled enables or disables a group, reporting whether that
+// changed anything. Groups need not have routes yet, so a group can be
+// disabled before routes are added to it.
+func setGroupEnabled(name string, enabled bool) bool {
+	mu.Lock()
+	changed := disabledGroups[name] == enabled
+	if enabled {
+		delete(disabledGroups, name)
+	} else {
+		disabledGroups[name] = true
+	}
+	mu.Unlock()
+	if changed {
+		routesChanged()
+	}
+	return changed
+}
+
+// groupStatuses lists the groups of the route table and every disabled
+// group, by name.
+func groupStatuses() []GroupStatus {
+	mu.RLock()
+	defer mu.RUnlock()
+	groups := make(map[string]*GroupStatus)
+	get := func(name string) *GroupStatus {
+		if g, ok := groups[name]; ok {
+			return g
+		}
+		g := &GroupStatus{Name: name, Enabled: !disabledGroups[name], Routes: []string{}}
+		groups[name] = g
+		return g
+	}
+	for id, rt := range routeMap {
+		if rt.Group != "" {
+			g := get(rt.Group)
+			g.Routes = append(g.Routes, id)
+		}
+	}
+	for name := range disabledGroups {
+		get(name)
+	}
+	list := make([]GroupStatus, 0, len(groups))
+	for _, name := range sortedKeys(groups) {
+		slices.Sort(groups[name].Routes)
+		list = append(list, *groups[name])
+	}
+	return list
+}
+
+func groupStatus(name string) GroupStatus {
+	for _, g := range groupStatuses() {
+		if g.Name == name {
+			return g
+		}
+	}
+	return GroupStatus{Name: name, Enabled: true, Routes: []string{}}
+}
+
+func handleListGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, groupStatuses())
+}
+
+func handleEnableGroup(w http.ResponseWriter, r *http.Request) {
+	toggleGroup(w, r, true)
+}
+
+func handleDisableGroup(w http.ResponseWriter, r *http.Request) {
+	toggleGroup(w, r, false)
+}
+
+func toggleGroup(w http.ResponseWriter, r *http.Request, enabled bool) {
+	name := r.PathValue("name")
+	if !groupName.MatchString(name) {
+		writeJSONError(w, http.StatusBadRequest, "invalid group name")
+		return
+	}
+	if setGroupEnabled(name, enabled) {
+		action := "disabled"
+		if enabled {
+			action = "enabled"
+		}
+		log.Printf("[GROUPS] Group %s %s by %s", name, action, requestActor(r))
+		auditRequest(r, "group_"+action, map[string]string{"group": name})
+	}
+	writeJSON(w, http.StatusOK, groupStatus(name))
+}
+
+// setRouteEnabled switches one route on or off, reporting whether the
+// route exists and whether that changed anything.
+func setRouteEnabled(id string, enabled bool) (status RouteStatus, found, changed bool) {
+	mu.Lock()
+	rt, found := routeMap[id]
+	if found {
+		changed = disabledRoutes[id] == enabled
+		if enabled {
+			delete(disabledRoutes, id)
+		} else {
+			disabledRoutes[id] = true
+		}
+		status = RouteStatus{ID: id, Disabled: !enabled, Enabled: rt.enabled()}
+	}
+	mu.Unlock()
+	if changed {
+		routesChanged()
+	}
+	return status, found, changed
+}
+
+func handleEnableRoute(w http.ResponseWriter, r *http.Request) {
+	toggleRoute(w, r, true)
+}
+
+func handleDisableRoute(w http.ResponseWriter, r *http.Request) {
+	toggleRoute(w, r, false)
+}
+
+func toggleRoute(w http.ResponseWriter, r *http.Request, enabled bool) {
+	id := canonicalSource(r.PathValue("source"))
+	status, found, changed := setRouteEnabled(id, enabled)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "route not found")
+		return
+	}
+	if changed {
+		action := "disabled"
+		if enabled {
+			action = "enabled"
+		}
+		log.Printf("[ADMIN] Route %s %s by %s", id, action, requestActor(r))
+		auditRequest(r, "route_"+action, map[string]string{"source": id})
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Resource limits
+	maxGoroutines    int
+	maxUpstreamConns int
+	memoryLimitFlag  string
+
+	// Effective soft memory limit in bytes (math.MaxInt64 when unset)
+	memoryLimit int64
+
+	// Set by the sampler while the relay is above a limit
+	overloaded     atomic.Bool
+	overloadReason atomic.Value
+
+	upstreamConns atomic.Int64
+)
+
+// Fraction of a limit at which a warning is logged
+const guardrailWarnRatio = 0.8
+
+// Fraction of the memory limit at which requests start being shed
+const memoryShedRatio = 0.9
+
+var errUpstreamLimit = errors.New("upstream connection limit reached")
+
+// --- Guardrails Logic ---
+
+func setupGuardrails() {
+	if memoryLimitFlag != "" {
+		limit, err := parseByteSize(memoryLimitFlag)
+		if err != nil {
+			log.Fatalf("Invalid -memory-limit: %v", err)
+		}
+		debug.SetMemoryLimit(limit)
+	}
+	// A negative input only reads the limit, which also honours GOMEMLIMIT
+	memoryLimit = debug.SetMemoryLimit(-1)
+
+	if maxGoroutines <= 0 && maxUpstreamConns <= 0 && memoryLimit == math.MaxInt64 {
+		return
+	}
+	if memoryLimit != math.MaxInt64 {
+		log.Printf("Memory limit: %d MiB", memoryLimit>>20)
+	}
+	if maxGoroutines > 0 {
+		log.Printf("Max goroutines: %d", maxGoroutines)
+	}
+	if maxUpstreamConns > 0 {
+		log.Printf("Max upstream connections: %d", maxUpstreamConns)
+	}
+	go sampleResources()
+}
+
+// sampleResources periodically checks goroutine and heap usage and flips
+// the overloaded flag, so the request path only reads an atomic.
+func sampleResources() {
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	var lastWarn time.Time
+	for range time.Tick(time.Second) {
+		goroutines := runtime.NumGoroutine()
+		metrics.Read(samples)
+		heap := int64(samples[0].Value.Uint64())
+
+		reason := ""
+		switch {
+		case maxGoroutines > 0 && goroutines >= maxGoroutines:
+			reason = fmt.Sprintf("%d goroutines (limit %d)", goroutines, maxGoroutines)
+		case memoryLimit != math.MaxInt64 && float64(heap) >= float64(memoryLimit)*memoryShedRatio:
+			reason = fmt.Sprintf("heap %d MiB (limit %d MiB)", heap>>20, memoryLimit>>20)
+		}
+		if reason != "" && !overloaded.Load() {
+			log.Printf("[GUARDRAIL] Shedding load: %s", reason)
+		} else if reason == "" && overloaded.Load() {
+			log.Println("[GUARDRAIL] Load back under limits, accepting requests")
+		}
+		overloadReason.Store(reason)
+		overloaded.Store(reason != "")
+
+		if reason == "" && time.Since(lastWarn) > time.Minute {
+			warn := ""
+			switch {
+			case maxGoroutines > 0 && float64(goroutines) >= float64(maxGoroutines)*guardrailWarnRatio:
+				warn = fmt.Sprintf("%d of %d goroutines in use", goroutines, maxGoroutines)
+			case memoryLimit != math.MaxInt64 && float64(heap) >= float64(memoryLimit)*guardrailWarnRatio:
+				warn = fmt.Sprintf("heap at %d of %d MiB", heap>>20, memoryLimit>>20)
+			case maxUpstreamConns > 0 && float64(upstreamConns.Load()) >= float64(maxUpstreamConns)*guardrailWarnRatio:
+				warn = fmt.Sprintf("%d of %d upstream connections open", upstreamConns.Load(), maxUpstreamConns)
+			}
+			if warn != "" {
+				log.Printf("[GUARDRAIL] Warning: %s", warn)
+				lastWarn = time.Now()
+			}
+		}
+	}
+}
+
+// shedLoad rejects the request with 503 while the relay is overloaded.
+func shedLoad(w http.ResponseWriter, r *http.Request) bool {
+	if !overloaded.Load() {
+		return false
+	}
+	stats.shed.Add(1)
+	if verboseMode {
+		logRequest(r, "[GUARDRAIL] Shed %s %s from %s: %v", r.Method, r.Host, r.RemoteAddr, overloadReason.Load())
+	}
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	return true
+}
+
+// guardedDial wraps a dialer so the number of open upstream connections
+// never exceeds -max-upstream-conns.
+func guardedDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if maxUpstreamConns <= 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if upstreamConns.Add(1) > int64(maxUpstreamConns) {
+			upstreamConns.Add(-1)
+			return nil, errUpstreamLimit
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			upstreamConns.Add(-1)
+			return nil, err
+		}
+		return &countedConn{Conn: conn}, nil
+	}
+}
+
+// countedConn releases its upstream slot when closed.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { upstreamConns.Add(-1) })
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the inner connection, so TCP relays can pass a
+// client's EOF on through a counted upstream.
+func (c *countedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.ErrUnsupported
+}
+
+// parseByteSize parses sizes in GOMEMLIMIT syntax, e.g. 512MiB or 2GiB.
+func parseByteSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"goRebind/adminclient"
+	"golang.org/x/net/idna"
+)
+
+const spoofUsage = `Usage: goRebind spoof -domain example.com [flags]
+
+Generates look-alike names for a domain by swapping letters for IDN
+homoglyphs (Cyrillic, Greek and other characters that render alike), and
+prints each in Unicode and punycode (xn--) form. With -target, every
+candidate is also added as a route on a running relay through its admin API,
+using the same connection flags as ctl.
+
+Flags:
+`
+
+// homoglyphs maps Latin letters to characters valid in IDNs that render
+// like them.
+var homoglyphs = map[rune][]string{
+	'a': {"\u0430", "\u0251"},
+	'c': {"\u0441", "\u03f2"},
+	'd': {"\u0501"},
+	'e': {"\u0435"},
+	'g': {"\u0261"},
+	'h': {"\u04bb"},
+	'i': {"\u0456", "\u0131"},
+	'j': {"\u0458"},
+	'k': {"\u03ba"},
+	'l': {"\u04cf"},
+	'n': {"\u0578"},
+	'o': {"\u043e", "\u03bf", "\u0585"},
+	'p': {"\u0440", "\u03c1"},
+	'q': {"\u051b"},
+	'r': {"\u0433"},
+	's': {"\u0455"},
+	'u': {"\u03c5", "\u057d"},
+	'v': {"\u03bd", "\u0475"},
+	'w': {"\u051d"},
+	'x': {"\u0445"},
+	'y': {"\u0443"},
+	'z': {"\u1d22"},
+}
+
+// cyrillicTwins are the Latin letters with a Cyrillic twin, used for names
+// spelled entirely in Cyrillic, which browsers show in Unicode.
+var cyrillicTwins = map[rune]string{
+	'a': "\u0430", 'c': "\u0441", 'd': "\u0501", 'e': "\u0435", 'h': "\u04bb", 'i': "\u0456",
+	'j': "\u0458", 'o': "\u043e", 'p': "\u0440", 's': "\u0455", 'x': "\u0445", 'y': "\u0443",
+}
+
+// spoofCandidate is a look-alike name in both forms.
+type spoofCandidate struct {
+	Unicode string `json:"unicode"`
+	ASCII   string `json:"ascii"`
+}
+
+// --- Homoglyph Spoofing Logic ---
+
+// homoglyphCandidates lists look-alikes of domain: all-Cyrillic spellings
+// of its labels first, then every single-letter substitution, in order.
+// The last label (the TLD) is kept. Names IDNA rejects are skipped.
+func homoglyphCandidates(domain string, limit int) []spoofCandidate {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(domain), "."), ".")
+	seen := make(map[string]bool)
+	var out []spoofCandidate
+	add := func(i int, label string) bool {
+		variant := append(append(append([]string{}, labels[:i]...), label), labels[i+1:]...)
+		name := strings.Join(variant, ".")
+		ascii, err := idna.Lookup.ToASCII(name)
+		if err != nil || seen[ascii] || ascii == strings.Join(labels, ".") {
+			return limit <= 0 || len(out) < limit
+		}
+		seen[ascii] = true
+		out = append(out, spoofCandidate{Unicode: name, ASCII: ascii})
+		return limit <= 0 || len(out) < limit
+	}
+	for i := range labels[:len(labels)-1] {
+		var b strings.Builder
+		for _, r := range labels[i] {
+			twin, ok := cyrillicTwins[r]
+			if !ok {
+				b.Reset()
+				break
+			}
+			b.WriteString(twin)
+		}
+		if b.Len() > 0 && !add(i, b.String()) {
+			return out
+		}
+	}
+	for i, label := range labels[:len(labels)-1] {
+		for pos, r := range label {
+			for _, glyph := range homoglyphs[r] {
+				if !add(i, label[:pos]+glyph+label[pos+len(string(r)):]) {
+					return out
+				}
+			}
+		}
+	}
+	return out
+}
+
+// spoofOptions are the spoof subcommand's own flags.
+type spoofOptions struct {
+	domain, target, group string
+	limit                 int
+	json                  bool
+}
+
+func newSpoofFlags() (*flag.FlagSet, *spoofOptions, func() *adminclient.Client) {
+	fs, newClient := newCtlFlags("spoof")
+	var o spoofOptions
+	fs.StringVar(&o.domain, "domain", "", "Domain to generate look-alikes for, e.g. example.com")
+	fs.IntVar(&o.limit, "limit", 100, "Maximum number of candidates (0 for all)")
+	fs.StringVar(&o.target, "target", "", "Add a route to this target URL for every candidate on the relay at -addr")
+	fs.StringVar(&o.group, "group", "", "Route group for the added routes, so they can be disabled together")
+	fs.BoolVar(&o.json, "json", false, "Print candidates as JSON lines")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), spoofUsage)
+		fs.PrintDefaults()
+	}
+	return fs, &o, newClient
+}
+
+func runSpoof(args []string) {
+	fs, o, newClient := newSpoofFlags()
+	_ = fs.Parse(args)
+	if o.domain == "" || !strings.Contains(o.domain, ".") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	candidates := homoglyphCandidates(o.domain, o.limit)
+	enc := json.NewEncoder(os.Stdout)
+	for _, c := range candidates {
+		if o.json {
+			_ = enc.Encode(c)
+		} else {
+			fmt.Printf("%-40s %s\n", c.ASCII, c.Unicode)
+		}
+	}
+	if o.target == "" {
+		return
+	}
+
+	client := newClient()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	for _, c := range candidates {
+		route := adminclient.Route{Source: c.ASCII, Target: o.target, Group: o.group, Tags: []string{"homoglyph", o.domain}}
+		if _, err := client.SetRoute(ctx, route); err != nil {
+			log.Fatalf("Failed to add route %s: %v", c.ASCII, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Added %d routes for %s\n", len(candidates), o.domain)
+}
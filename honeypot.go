@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+var (
+	// Honeypot persona file; enables honeypot mode for unmatched hosts
+	honeypotFile string
+
+	// JSON-lines file receiving full details of every honeypot hit
+	honeypotLogPath string
+	honeypotLog     *os.File
+	honeypotLogMu   sync.Mutex
+
+	honeypot *honeypotConfig
+)
+
+// Request bodies captured per honeypot hit
+const honeypotMaxBody = 64 << 10
+
+// honeypotResponse is one canned answer. Host is a glob ("*.corp.local")
+// and Path a prefix; empty fields match everything.
+type honeypotResponse struct {
+	Host    string            `json:"host,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"` // file, relative to the persona file
+
+	page errorPage
+}
+
+// honeypotConfig is the persona served to unmatched hosts.
+type honeypotConfig struct {
+	// Server header sent on every response, e.g. "Apache/2.4.41 (Ubuntu)"
+	Server    string              `json:"server,omitempty"`
+	Responses []*honeypotResponse `json:"responses"`
+	Default   *honeypotResponse   `json:"default,omitempty"`
+}
+
+// HoneypotHit is one line of the -honeypot-log file.
+type HoneypotHit struct {
+	Time      time.Time   `json:"time"`
+	RequestID string      `json:"request_id"`
+	Remote    string      `json:"remote"`
+	Method    string      `json:"method"`
+	Host      string      `json:"host"`
+	URI       string      `json:"uri"`
+	Proto     string      `json:"proto"`
+	Headers   http.Header `json:"headers"`
+	Body      string      `json:"body,omitempty"`
+	Truncated bool        `json:"truncated,omitempty"`
+	Status    int         `json:"status"`
+	TLS       bool        `json:"tls,omitempty"`
+
+	Engagement string `json:"engagement,omitempty"`
+}
+
+// --- Honeypot Logic ---
+
+func loadHoneypot() {
+	if honeypotFile == "" {
+		return
+	}
+	data, err := os.ReadFile(honeypotFile)
+	if err != nil {
+		log.Fatalf("Failed to read honeypot persona: %v", err)
+	}
+	cfg := &honeypotConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		log.Fatalf("Invalid honeypot persona: %v", err)
+	}
+	if cfg.Default == nil {
+		cfg.Default = &honeypotResponse{Status: http.StatusNotFound}
+	}
+	dir := filepath.Dir(honeypotFile)
+	for _, resp := range append(cfg.Responses, cfg.Default) {
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		if resp.Body == "" {
+			continue
+		}
+		file := resp.Body
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if resp.page, err = readErrorPage(file); err != nil {
+			log.Fatalf("Invalid honeypot response %s%s: %v", resp.Host, resp.Path, err)
+		}
+	}
+	honeypot = cfg
+
+	if honeypotLogPath != "" {
+		f, err := os.OpenFile(honeypotLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("Failed to open honeypot log: %v", err)
+		}
+		honeypotLog = f
+	}
+	log.Printf("Honeypot mode: %d responses for unmatched hosts", len(cfg.Responses))
+}
+
+// match returns the response for a request: the longest matching path
+// prefix wins, host-specific entries before generic ones.
+func (cfg *honeypotConfig) match(host, urlPath string) *honeypotResponse {
+	var best *honeypotResponse
+	for _, resp := range cfg.Responses {
+		if resp.Host != "" {
+			if ok, _ := path.Match(resp.Host, host); !ok {
+				continue
+			}
+		}
+		if !strings.HasPrefix(urlPath, resp.Path) {
+			continue
+		}
+		if best == nil || len(resp.Path) > len(best.Path) || (len(resp.Path) == len(best.Path) && best.Host == "" && resp.Host != "") {
+			best = resp
+		}
+	}
+	if best == nil {
+		return cfg.Default
+	}
+	return best
+}
+
+// serveHoneypot answers requests for unmatched hosts with the fake
+// application persona and records everything about them.
+func serveHoneypot(w http.ResponseWriter, r *http.Request) bool {
+	if honeypot == nil || forwardOnly() {
+		return false
+	}
+	host := strings.ToLower(r.Host)
+	if _, exists := lookupClientRoute(host, remoteIP(r.RemoteAddr), modeHTTP); exists {
+		return false
+	}
+	stats.recordHTTP(host, false)
+	stats.honeypot.Add(1)
+
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, honeypotMaxBody+1))
+	resp := honeypot.match(name, r.URL.Path)
+
+	if honeypot.Server != "" {
+		w.Header().Set("Server", honeypot.Server)
+	}
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	if resp.page.body != nil {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", resp.page.contentType)
+		}
+		w.WriteHeader(resp.Status)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(resp.page.body)
+		}
+	} else {
+		w.WriteHeader(resp.Status)
+	}
+
+	logRequest(r, "[HONEYPOT] %s %s %s from %s -> %d (%q)", r.Method, r.Host, r.URL.RequestURI(), r.RemoteAddr, resp.Status, r.UserAgent())
+	emit(Event{
+		Type: adminclient.EventHoneypot, Host: r.Host, Client: r.RemoteAddr, Method: r.Method,
+		Path: r.URL.Path, Status: resp.Status, RequestID: requestID(r), Message: r.UserAgent(),
+	})
+	recordHoneypotHit(HoneypotHit{
+		Time:      time.Now().UTC(),
+		RequestID: requestID(r),
+		Remote:    r.RemoteAddr,
+		Method:    r.Method,
+		Host:      r.Host,
+		URI:       r.URL.RequestURI(),
+		Proto:     r.Proto,
+		Headers:   r.Header,
+		Body:      string(body[:min(len(body), honeypotMaxBody)]),
+		Truncated: len(body) > honeypotMaxBody,
+		Status:    resp.Status,
+		TLS:       r.TLS != nil,
+	})
+	return true
+}
+
+func recordHoneypotHit(hit HoneypotHit) {
+	if honeypotLog == nil {
+		return
+	}
+	hit.Engagement = engagementID()
+	line, err := json.Marshal(hit)
+	if err != nil {
+		return
+	}
+	honeypotLogMu.Lock()
+	defer honeypotLogMu.Unlock()
+	if _, err := fmt.Fprintf(honeypotLog, "%s\n", line); err != nil {
+		log.Printf("[HONEYPOT] Failed to write log: %v", err)
+	}
+}
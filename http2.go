@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	// Serve HTTP/2 with prior knowledge (h2c) on plain HTTP listeners
+	h2cEnabled bool
+
+	// Shared HTTP/2-only transport for gRPC requests of routes without
+	// their own egress
+	grpcOnce          sync.Once
+	grpcBaseTransport *http.Transport
+)
+
+// --- HTTP/2 Logic ---
+
+// serverProtocols returns the protocols a listener speaks: HTTP/1.1 and,
+// negotiated through ALPN, HTTP/2 over TLS; HTTP/1.1 and with -h2c HTTP/2
+// with prior knowledge in cleartext.
+func serverProtocols(protocol string) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	if protocol == protoHTTP {
+		p.SetUnencryptedHTTP2(h2cEnabled)
+	} else {
+		p.SetHTTP2(true)
+	}
+	return p
+}
+
+// isGRPC reports whether a request is a gRPC call, which needs HTTP/2 all
+// the way to the upstream. gRPC-Web works over HTTP/1.1 and is left alone.
+func isGRPC(req *http.Request) bool {
+	ct := req.Header.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;")
+}
+
+// grpcTransport is the HTTP/2-only transport gRPC requests of a route (nil
+// for unmatched requests) go upstream through, whatever -http2 says.
+func grpcTransport(rt *route) *http.Transport {
+	if rt == nil || !rt.ownTransport() {
+		grpcOnce.Do(func() { grpcBaseTransport = http2Transport(baseTransport) })
+		return grpcBaseTransport
+	}
+	rt.grpcOnce.Do(func() { rt.grpc = http2Transport(rt.egressTransport()) })
+	return rt.grpc
+}
+
+// http2Transport clones t to speak only HTTP/2: over TLS through ALPN, and
+// to http:// targets with prior knowledge (h2c), as gRPC servers expect.
+// It dials like t but skips the warm TLS pools, whose connections have
+// negotiated HTTP/1.1.
+func http2Transport(t *http.Transport) *http.Transport {
+	h2 := t.Clone()
+	tlsConfig := t.TLSClientConfig.Clone()
+	tlsConfig.NextProtos = []string{"h2"}
+	h2.TLSClientConfig = tlsConfig
+	h2.TLSNextProto = nil
+	h2.Protocols = new(http.Protocols)
+	h2.Protocols.SetHTTP2(true)
+	h2.Protocols.SetUnencryptedHTTP2(true)
+	dial := t.DialContext
+	h2.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialTLSVia(ctx, dial, tlsConfig, network, addr)
+	}
+	return h2
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+var (
+	// Speak HTTP/3 to https targets, for routes without http3
+	http3Upstream bool
+
+	// UDP port of the HTTP/3 listener (0 disables)
+	h3Port int
+
+	// Shared QUIC transport for routes without their own TLS settings
+	h3Once          sync.Once
+	h3BaseTransport *http3.Transport
+
+	// Upstream hosts whose QUIC handshake failed, reached over TCP until
+	// the time recorded
+	h3FallbackMu sync.Mutex
+	h3Fallback   = make(map[string]time.Time)
+
+	// Alt-Svc header HTTPS listeners send when an h3 listener is up
+	altSvc string
+)
+
+const (
+	// Time an upstream that failed the QUIC handshake is reached over TCP
+	h3FallbackFor = 5 * time.Minute
+	// Time allowed for an upstream QUIC handshake before falling back
+	h3HandshakeTimeout = 5 * time.Second
+)
+
+// quicDialError marks a failed QUIC handshake, after which nothing has
+// been sent and the request can safely go over TCP instead.
+type quicDialError struct {
+	err error
+}
+
+func (e *quicDialError) Error() string { return e.err.Error() }
+func (e *quicDialError) Unwrap() error { return e.err }
+
+// --- HTTP/3 Logic ---
+
+// parseHTTP3 checks that a route speaking HTTP/3 has an https upstream and
+// no tunnel or proxy, which only carry TCP.
+func (rt *route) parseHTTP3() error {
+	if rt.HTTP3 == nil || !*rt.HTTP3 {
+		return nil
+	}
+	switch {
+	case !slices.ContainsFunc(rt.upstreams(), func(u *url.URL) bool { return u.Scheme == "https" }):
+		return fmt.Errorf("http3 needs an https target or backend")
+	case rt.egress != nil:
+		return fmt.Errorf("http3 cannot go through via_ssh or via_wireguard")
+	case rt.proxyURL != nil:
+		return fmt.Errorf("http3 cannot go through a proxy")
+	}
+	return nil
+}
+
+// speaksHTTP3 reports whether the route's https upstreams are tried over
+// QUIC first.
+func (rt *route) speaksHTTP3() bool {
+	if rt.egress != nil || rt.proxyURL != nil {
+		return false
+	}
+	if rt.HTTP3 != nil {
+		return *rt.HTTP3
+	}
+	return http3Upstream
+}
+
+// roundTripHTTP3 sends the request upstream over HTTP/3. ok is false when
+// the upstream is known not to speak QUIC or its handshake fails now, and
+// the request should go over TCP.
+func roundTripHTTP3(rt *route, req *http.Request) (resp *http.Response, ok bool, err error) {
+	host := req.URL.Host
+	h3FallbackMu.Lock()
+	until, down := h3Fallback[host]
+	h3FallbackMu.Unlock()
+	if down && time.Now().Before(until) {
+		return nil, false, nil
+	}
+	resp, err = h3Transport(rt).RoundTrip(req)
+	var dialErr *quicDialError
+	if errors.As(err, &dialErr) {
+		log.Printf("[HTTP3] %s: QUIC handshake failed (%v), using TCP for %s", host, dialErr.err, h3FallbackFor)
+		h3FallbackMu.Lock()
+		h3Fallback[host] = time.Now().Add(h3FallbackFor)
+		h3FallbackMu.Unlock()
+		return nil, false, nil
+	}
+	return resp, true, err
+}
+
+// h3Transport returns the QUIC transport for the route's upstreams.
+func h3Transport(rt *route) *http3.Transport {
+	if rt.SkipSSLVerify == nil && rt.SNI == "" {
+		h3Once.Do(func() { h3BaseTransport = newHTTP3Transport(warmTLSConfig()) })
+		return h3BaseTransport
+	}
+	rt.h3Once.Do(func() {
+		tlsConfig := warmTLSConfig()
+		if rt.SkipSSLVerify != nil {
+			tlsConfig.InsecureSkipVerify = *rt.SkipSSLVerify
+		}
+		tlsConfig.ServerName = rt.SNI
+		rt.h3 = newHTTP3Transport(tlsConfig)
+	})
+	return rt.h3
+}
+
+// newHTTP3Transport builds an HTTP/3 transport verifying upstreams like
+// tlsConfig. Sessions are cached apart from the TCP ones.
+func newHTTP3Transport(tlsConfig *tls.Config) *http3.Transport {
+	tlsConfig.NextProtos = nil
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	return &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		QUICConfig:      &quic.Config{HandshakeIdleTimeout: h3HandshakeTimeout},
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			conn, err := quic.DialAddr(ctx, addr, tlsCfg, cfg)
+			if err != nil {
+				return nil, &quicDialError{err: err}
+			}
+			return conn, nil
+		},
+	}
+}
+
+// serveHTTP3 serves handler over QUIC, with the TLS settings and minted
+// certificates of an HTTPS listener.
+func (l *listenerConfig) serveHTTP3(handler http.Handler) {
+	server := &http3.Server{Addr: l.addr(), Handler: handler, TLSConfig: http3.ConfigureTLSConfig(l.tlsConfig())}
+	onShutdown(func(ctx context.Context) { _ = server.Shutdown(ctx) })
+	log.Printf("H3 listening on %s (QUIC over UDP)", l.addr())
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// advertiseHTTP3 points clients of HTTPS listeners at the first h3
+// listener through Alt-Svc, so browsers switch to QUIC.
+func advertiseHTTP3(listeners []*listenerConfig) {
+	for _, l := range listeners {
+		if l.Protocol == protoH3 {
+			altSvc = `h3=":` + strconv.Itoa(l.Port) + `"; ma=86400`
+			return
+		}
+	}
+}
+
+// withAltSvc adds the Alt-Svc header to the responses of handler.
+func withAltSvc(handler http.Handler) http.Handler {
+	if altSvc == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// Spread of routed answers' TTLs, in seconds either side of the configured one
+	jitterTTL uint
+	// Upper bound of the random delay before DNS answers and proxied requests
+	jitterDelay time.Duration
+	// Shuffle the order of multi-record DNS answers
+	shuffleAnswers bool
+)
+
+// --- Jitter Logic ---
+
+// setupJitter reports the jitter in effect. All draws go through randomN,
+// so -deterministic makes them reproducible.
+func setupJitter() {
+	if jitterTTL == 0 && jitterDelay <= 0 && !shuffleAnswers {
+		return
+	}
+	log.Printf("[JITTER] TTL ±%ds, delay up to %s, shuffled answers: %v", jitterTTL, jitterDelay, shuffleAnswers)
+}
+
+// jitteredTTL spreads ttl uniformly over ttl±jitterTTL, never below zero.
+func jitteredTTL(ttl uint32) uint32 {
+	if jitterTTL == 0 {
+		return ttl
+	}
+	spread := int64(randomN(uint32(2*jitterTTL+1))) - int64(jitterTTL)
+	return uint32(max(int64(ttl)+spread, 0))
+}
+
+// jitterSleep waits a random time up to jitterDelay, in whole milliseconds.
+func jitterSleep() {
+	if jitterDelay < time.Millisecond {
+		return
+	}
+	time.Sleep(time.Duration(randomN(uint32(jitterDelay/time.Millisecond)+1)) * time.Millisecond)
+}
+
+// shuffleRRs reorders answers in place when -shuffle-answers is set.
+func shuffleRRs(rrs []dns.RR) {
+	if !shuffleAnswers {
+		return
+	}
+	for i := len(rrs) - 1; i > 0; i-- {
+		j := randomN(uint32(i + 1))
+		rrs[i], rrs[j] = rrs[j], rrs[i]
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+var (
+	// Emergency stop hostname and state
+	killSwitchHost string
+	killSwitch     atomic.Bool
+	killSwitchAt   atomic.Int64
+)
+
+// KillSwitchStatus is returned by the admin API.
+type KillSwitchStatus = adminclient.KillSwitchStatus
+
+// --- Kill Switch Logic ---
+
+func isKillSwitchHost(host string) bool {
+	if killSwitchHost == "" {
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	return host == strings.ToLower(killSwitchHost)
+}
+
+// forwardOnly reports whether all routes are disabled and traffic is only
+// forwarded to its real destination: the relay runs in -safe-mode, the kill
+// switch is engaged, or the time is outside -active-window.
+func forwardOnly() bool {
+	return safeMode || killSwitch.Load() || outsideWindow.Load()
+}
+
+// engageKillSwitch disables every route. It stays engaged until re-armed
+// through the admin API.
+func engageKillSwitch(reason string) {
+	if !killSwitch.CompareAndSwap(false, true) {
+		return
+	}
+	killSwitchAt.Store(time.Now().UnixNano())
+	log.Printf("[KILL-SWITCH] Engaged (%s): all routes disabled, forward-only mode", reason)
+	audit("system", "", "kill_switch_engaged", map[string]string{"reason": reason})
+	emit(Event{Type: adminclient.EventKillSwitch, Message: "engaged: " + reason})
+}
+
+func releaseKillSwitch() bool {
+	if !killSwitch.CompareAndSwap(true, false) {
+		return false
+	}
+	log.Println("[KILL-SWITCH] Released: routes re-enabled")
+	emit(Event{Type: adminclient.EventKillSwitch, Message: "released"})
+	return true
+}
+
+func killSwitchStatus() KillSwitchStatus {
+	status := KillSwitchStatus{Engaged: killSwitch.Load(), Host: killSwitchHost}
+	if status.Engaged {
+		since := time.Unix(0, killSwitchAt.Load()).UTC()
+		status.Since = &since
+	}
+	return status
+}
+
+// forwardToOrigin sends the request to the host the client actually asked
+// for, resolved by the system resolver.
+func forwardToOrigin(req *http.Request) {
+	req.URL.Scheme = "http"
+	if req.TLS != nil {
+		req.URL.Scheme = "https"
+	}
+	req.URL.Host = req.Host
+}
+
+// --- Kill Switch Admin Handlers ---
+
+func handleKillSwitchStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, killSwitchStatus())
+}
+
+func handleEngageKillSwitch(w http.ResponseWriter, r *http.Request) {
+	engageKillSwitch("admin API call by " + requestActor(r))
+	writeJSON(w, http.StatusOK, killSwitchStatus())
+}
+
+func handleReleaseKillSwitch(w http.ResponseWriter, r *http.Request) {
+	if releaseKillSwitch() {
+		auditRequest(r, "kill_switch_released", nil)
+	}
+	writeJSON(w, http.StatusOK, killSwitchStatus())
+}
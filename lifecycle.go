@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// Automatic teardown settings
+	maxRuntime  time.Duration
+	idleTimeout time.Duration
+
+	// Unix nanos of the last relayed HTTP request or DNS query
+	lastActivity atomic.Int64
+
+	shutdownMu    sync.Mutex
+	shutdownHooks []func(ctx context.Context)
+	shutdownOnce  sync.Once
+	shutdownDone  = make(chan struct{})
+)
+
+// --- Lifecycle Logic ---
+
+// touchActivity marks the relay as busy for the idle shutdown timer.
+func touchActivity() {
+	lastActivity.Store(time.Now().UnixNano())
+}
+
+// onShutdown registers a hook that is run (with a deadline) before exit.
+func onShutdown(hook func(ctx context.Context)) {
+	shutdownMu.Lock()
+	shutdownHooks = append(shutdownHooks, hook)
+	shutdownMu.Unlock()
+}
+
+// startLifecycle arms the runtime/idle timers and signal handling.
+func startLifecycle() {
+	touchActivity()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		shutdown("received " + sig.String())
+	}()
+
+	if maxRuntime > 0 {
+		log.Printf("Relay will shut down after max runtime of %s", maxRuntime)
+		time.AfterFunc(maxRuntime, func() {
+			shutdown("max runtime of " + maxRuntime.String() + " reached")
+		})
+	}
+
+	if idleTimeout > 0 {
+		log.Printf("Relay will shut down after %s without traffic", idleTimeout)
+		go func() {
+			ticker := time.NewTicker(max(min(idleTimeout/4, time.Minute), time.Second))
+			defer ticker.Stop()
+			for range ticker.C {
+				idle := time.Since(time.Unix(0, lastActivity.Load()))
+				if idle >= idleTimeout {
+					shutdown("idle for " + idle.Round(time.Second).String())
+					return
+				}
+			}
+		}()
+	}
+}
+
+// shutdown stops all registered servers and exits the process.
+func shutdown(reason string) {
+	shutdownOnce.Do(func() {
+		log.Printf("[SHUTDOWN] %s, tearing down relay", reason)
+		audit("system", "", "shutdown", map[string]string{"reason": reason})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		shutdownMu.Lock()
+		hooks := shutdownHooks
+		shutdownMu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, hook := range hooks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hook(ctx)
+			}()
+		}
+		wg.Wait()
+		close(shutdownDone)
+	})
+}
+
+// waitForShutdown blocks until shutdown has finished running its hooks.
+func waitForShutdown() {
+	<-shutdownDone
+}
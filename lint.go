@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Refuse to start when the startup lint finds a risky setup
+var strictLint bool
+
+// Labels that mark a name as a test system rather than production
+var nonProductionLabels = []string{"dev", "test", "testing", "stage", "staging", "qa", "uat", "lab", "sandbox", "demo"}
+
+// --- Config Lint Logic ---
+
+// lintStartup warns about setups that expose more than intended, and with
+// -strict refuses to start on any. skipSSL is -skip-ssl-verify.
+func lintStartup(listeners []*listenerConfig, skipSSL bool) {
+	warnings := lintSetup(listeners, skipSSL)
+	for _, w := range warnings {
+		log.Printf("[LINT] %s", w)
+	}
+	if strictLint && len(warnings) > 0 {
+		log.Fatalf("Refusing to start with %d lint warning(s) (-strict)", len(warnings))
+	}
+}
+
+// lintSetup lists the risky parts of the listeners and route table.
+func lintSetup(listeners []*listenerConfig, skipSSL bool) []string {
+	var warnings []string
+	var webAddr, dnsAddr string
+	for _, l := range listeners {
+		public := exposedAddress(l.Address)
+		if public == "" {
+			continue
+		}
+		switch l.Protocol {
+		case protoHTTP, protoHTTPS, protoH3:
+			if webAddr == "" {
+				webAddr = fmt.Sprintf("%s listener on %s is reachable at public address %s", strings.ToUpper(l.Protocol), l.addr(), public)
+			}
+		case protoDNS, protoDoT, protoDoH:
+			if dnsAddr == "" && len(dnsAllowNets) == 0 {
+				dnsAddr = fmt.Sprintf("%s listener on %s is reachable at public address %s", strings.ToUpper(l.Protocol), l.addr(), public)
+			}
+		}
+	}
+	if dnsAddr != "" {
+		warnings = append(warnings, dnsAddr+" without -dns-allow: anyone can resolve routed names, and other names are resolved recursively for them")
+	}
+	if interfaceIP != nil && usesProtocol(listeners, protoHTTP, protoHTTPS) && !webBinds(listeners, interfaceIP) {
+		warnings = append(warnings, fmt.Sprintf("DNS answers routed names with %s, but no HTTP or HTTPS listener is bound to it (see -bind)", interfaceIP))
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, id := range sortedKeys(routeMap) {
+		rt := routeMap[id]
+		if !rt.serves(modeHTTP) {
+			continue
+		}
+		skip := skipSSL
+		if rt.SkipSSLVerify != nil {
+			skip = *rt.SkipSSLVerify
+		}
+		for _, b := range rt.pool {
+			host := b.url.Hostname()
+			if ip := net.ParseIP(host); ip != nil && ip.IsPrivate() && webAddr != "" {
+				warnings = append(warnings, fmt.Sprintf("Route %s targets private address %s while the %s", id, host, webAddr))
+			}
+			if skip && b.url.Scheme == "https" && productionLooking(host) {
+				warnings = append(warnings, fmt.Sprintf("Route %s skips certificate verification for %s, which looks like a production site (set skip_ssl_verify false or -skip-ssl-verify=false)", id, host))
+			}
+		}
+	}
+	return warnings
+}
+
+// webBinds reports whether an HTTP or HTTPS listener accepts connections
+// to ip, binding it or every interface.
+func webBinds(listeners []*listenerConfig, ip net.IP) bool {
+	for _, l := range listeners {
+		if l.Protocol != protoHTTP && l.Protocol != protoHTTPS {
+			continue
+		}
+		addr := net.ParseIP(l.Address)
+		if l.Address == "" || addr == nil || addr.IsUnspecified() || addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// exposedAddress returns a public address a listener bound to addr is
+// reachable at, or "" when it only listens on private networks. An empty
+// or unspecified address binds every interface of the host.
+func exposedAddress(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil && !ip.IsUnspecified() {
+		if publicIP(ip) {
+			return ip.String()
+		}
+		return ""
+	}
+	if addr != "" && net.ParseIP(addr) == nil {
+		// A host name resolves to whatever it resolves to; assume the worst
+		return addr
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && publicIP(ipNet.IP) {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
+
+// publicIP reports whether ip is a globally routable unicast address
+// outside RFC 1918 and unique local ranges.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// productionLooking reports whether a target host is a public domain with
+// no label marking it as a test system.
+func productionLooking(host string) bool {
+	if !acmeIssuable(host) {
+		return false
+	}
+	for _, label := range strings.FieldsFunc(strings.ToLower(host), func(r rune) bool { return r == '.' || r == '-' }) {
+		if slices.Contains(nonProductionLabels, label) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// JSON file declaring every listener; replaces -port, -tls-port, -dns
+	// and -admin-addr
+	listenersFile string
+
+	// Listeners declared in the config's listeners section, used unless
+	// -listeners is set. Read at startup only.
+	configListeners []*listenerConfig
+)
+
+// Listener protocols
+const (
+	protoHTTP  = "http"
+	protoHTTPS = "https"
+	protoDNS   = "dns"
+	protoDoT   = "dot"
+	protoDoH   = "doh"
+	protoAdmin = "admin"
+	protoTCP   = "tcp"
+	protoH3    = "h3"
+)
+
+// Default port per protocol; tcp listeners need one of their own
+var listenerPorts = map[string]int{
+	protoHTTP: 80, protoHTTPS: 443, protoDNS: 53, protoDoT: 853, protoDoH: 443, protoAdmin: 9090, protoTCP: 0, protoH3: 443,
+}
+
+// listenerConfig is one socket the relay serves.
+type listenerConfig struct {
+	Address  string      `json:"address,omitempty"` // default all interfaces (loopback for admin)
+	Port     int         `json:"port,omitempty"`    // default per protocol
+	Protocol string      `json:"protocol"`          // http, https, h3, dns, dot, doh, admin, tcp
+	TLS      *TLSProfile `json:"tls,omitempty"`
+
+	// TCP is the target and options of a tcp listener, as in -tcp-relays
+	// without listen
+	TCP *tcpRelayConfig `json:"tcp,omitempty"`
+
+	// Namespaces limits the listener to these hosts and their subdomains;
+	// other hosts get a 404 (HTTP) or the system's answer (DNS)
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// TLSProfile configures a TLS listener.
+type TLSProfile struct {
+	// Cert and Key are served to every client; without them certificates
+	// are minted per server name (see -internal-ca)
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// MinVersion is "1.0", "1.1", "1.2" (default) or "1.3"
+	MinVersion string `json:"min_version,omitempty"`
+}
+
+// --- Listener Logic ---
+
+// loadListeners reads -listeners, else the config's listeners section, or
+// returns the listeners the legacy flags describe.
+func loadListeners(legacy []*listenerConfig) []*listenerConfig {
+	listeners := configListeners
+	switch {
+	case listenersFile != "":
+		data, err := os.ReadFile(listenersFile)
+		if err != nil {
+			log.Fatalf("Failed to read listeners: %v", err)
+		}
+		if err := json.Unmarshal(data, &listeners); err != nil {
+			log.Fatalf("Invalid listeners: %v", err)
+		}
+	case listeners == nil:
+		return legacy
+	}
+	if err := validateListeners(listeners); err != nil {
+		log.Fatalf("Invalid listeners: %v", err)
+	}
+	return listeners
+}
+
+// validateListeners checks each listener and that at most one serves the
+// admin API.
+func validateListeners(listeners []*listenerConfig) error {
+	admins := 0
+	for _, l := range listeners {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("%s: %w", l.addr(), err)
+		}
+		if l.Protocol == protoAdmin {
+			admins++
+		}
+	}
+	if admins > 1 {
+		return fmt.Errorf("only one admin listener is supported")
+	}
+	return nil
+}
+
+func (l *listenerConfig) validate() error {
+	defaultPort, ok := listenerPorts[l.Protocol]
+	if !ok {
+		return fmt.Errorf("unknown protocol %q", l.Protocol)
+	}
+	if l.Port == 0 {
+		l.Port = defaultPort
+	}
+	if l.Port <= 0 || l.Port > 65535 {
+		return fmt.Errorf("a port is required")
+	}
+	if (l.TCP != nil) != (l.Protocol == protoTCP) {
+		return fmt.Errorf("tcp goes with protocol tcp, and protocol tcp needs a tcp target")
+	}
+	if l.TCP != nil {
+		if l.TLS != nil || len(l.Namespaces) > 0 {
+			return fmt.Errorf("tls and namespaces do not apply to tcp")
+		}
+		l.TCP.Listen = l.addr()
+		if err := l.TCP.validate(); err != nil {
+			return err
+		}
+	}
+	if l.Address == "" && l.Protocol == protoAdmin {
+		l.Address = "127.0.0.1"
+	}
+	if l.TLS != nil {
+		switch l.Protocol {
+		case protoHTTP, protoDNS:
+			return fmt.Errorf("tls does not apply to %s", l.Protocol)
+		case protoAdmin:
+			if l.TLS.Cert == "" || l.TLS.Key == "" {
+				return fmt.Errorf("admin tls needs cert and key")
+			}
+		}
+		if _, err := tlsVersion(l.TLS.MinVersion); err != nil {
+			return err
+		}
+	}
+	for i, ns := range l.Namespaces {
+		l.Namespaces[i] = strings.TrimSuffix(strings.ToLower(ns), ".")
+	}
+	return nil
+}
+
+func (l *listenerConfig) addr() string {
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+// usesProtocol reports whether any listener speaks one of the protocols.
+func usesProtocol(listeners []*listenerConfig, protocols ...string) bool {
+	for _, l := range listeners {
+		for _, p := range protocols {
+			if l.Protocol == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serves reports whether host falls under the listener's namespaces.
+func (l *listenerConfig) serves(host string) bool {
+	if len(l.Namespaces) == 0 {
+		return true
+	}
+	for _, ns := range l.Namespaces {
+		if host == ns || strings.HasSuffix(host, "."+ns) {
+			return true
+		}
+	}
+	return false
+}
+
+func tlsVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", v)
+}
+
+// observeHandshake feeds a ClientHello to fingerprinting and session
+// stitching.
+func observeHandshake(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	observeSessionTLS(hello)
+	return observeClientHello(hello)
+}
+
+// tlsConfig builds the listener's TLS settings from its profile.
+func (l *listenerConfig) tlsConfig() *tls.Config {
+	profile := l.TLS
+	if profile == nil {
+		profile = &TLSProfile{}
+	}
+	minVersion, _ := tlsVersion(profile.MinVersion)
+	cfg := &tls.Config{MinVersion: minVersion, GetCertificate: leafCertificate, GetConfigForClient: observeHandshake}
+	if profile.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(profile.Cert, profile.Key)
+		if err != nil {
+			log.Fatalf("Failed to load certificate for listener %s: %v", l.addr(), err)
+		}
+		cfg.GetCertificate = nil
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
+
+// flagListeners describes the listeners set up by -port, -tls-port,
+// -h3-port, -dns and -admin/-admin-addr. The HTTP, HTTPS and HTTP/3 ones
+// bind address.
+func flagListeners(ports, address string, enableDNS, enableAdmin bool) []*listenerConfig {
+	listeners, err := portListeners(ports)
+	if err != nil {
+		log.Fatalf("Invalid -port %q: %v", ports, err)
+	}
+	if tlsPort != 0 {
+		listeners = append(listeners, &listenerConfig{Port: tlsPort, Protocol: protoHTTPS})
+	}
+	if h3Port != 0 {
+		listeners = append(listeners, &listenerConfig{Port: h3Port, Protocol: protoH3})
+	}
+	for _, l := range listeners {
+		l.Address = address
+	}
+	if enableDNS {
+		listeners = append(listeners, &listenerConfig{Port: 53, Protocol: protoDNS})
+	}
+	if enableAdmin {
+		host, portStr, err := net.SplitHostPort(adminAddr)
+		adminPort, perr := strconv.Atoi(portStr)
+		if err != nil || perr != nil {
+			log.Fatalf("Invalid -admin-addr %q", adminAddr)
+		}
+		listeners = append(listeners, &listenerConfig{Address: host, Port: adminPort, Protocol: protoAdmin})
+	}
+	return listeners
+}
+
+// bindAddress resolves -bind: an IP address or host name, or "interface"
+// for the IPv4 address of iface (-interface), the one DNS answers with.
+func bindAddress(bind, iface string) string {
+	switch {
+	case bind == "":
+		return ""
+	case bind == "interface":
+		if iface == "" {
+			log.Fatal("Error: -bind interface needs -interface or -I")
+		}
+		ip, err := getInterfaceIP(iface)
+		if err != nil {
+			log.Fatalf("Error getting IP for interface %s: %v", iface, err)
+		}
+		return ip.String()
+	}
+	if _, _, err := net.SplitHostPort(bind); err == nil {
+		log.Fatalf("Invalid -bind %q: expected an address without a port (ports come from -port and -tls-port)", bind)
+	}
+	return strings.Trim(bind, "[]")
+}
+
+// portListeners parses -port: a comma-separated list of ports, each served
+// over HTTP unless suffixed with /https, e.g. "80,8080,443/https".
+func portListeners(ports string) ([]*listenerConfig, error) {
+	var listeners []*listenerConfig
+	for _, spec := range strings.Split(ports, ",") {
+		portStr, protocol, _ := strings.Cut(strings.TrimSpace(spec), "/")
+		protocol = valueOr(strings.ToLower(protocol), protoHTTP)
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", portStr)
+		}
+		if protocol != protoHTTP && protocol != protoHTTPS {
+			return nil, fmt.Errorf("port %d: protocol %q is not http or https", port, protocol)
+		}
+		listeners = append(listeners, &listenerConfig{Port: port, Protocol: protocol})
+	}
+	return listeners, nil
+}
+
+// startListeners starts every listener; handler serves the HTTP ones.
+func startListeners(listeners []*listenerConfig, handler http.Handler) {
+	advertiseHTTP3(listeners)
+	for _, l := range listeners {
+		switch l.Protocol {
+		case protoHTTP:
+			go l.serveHTTP(l.restrict(handler))
+		case protoHTTPS:
+			go l.serveHTTP(withAltSvc(l.restrict(handler)))
+		case protoH3:
+			go l.serveHTTP3(l.restrict(handler))
+		case protoDoH:
+			mux := http.NewServeMux()
+			mux.Handle("/dns-query", dohHandler(dnsHandler(l.serves)))
+			go l.serveHTTP(mux)
+		case protoDNS, protoDoT:
+			go l.serveDNS()
+		case protoTCP:
+			startTCPRelay(l.TCP)
+		case protoAdmin:
+			adminAddr = l.addr()
+			if l.TLS != nil {
+				adminTLSCert, adminTLSKey = l.TLS.Cert, l.TLS.Key
+			}
+			go startAdminServer()
+		}
+	}
+}
+
+func (l *listenerConfig) serveHTTP(handler http.Handler) {
+	server := &http.Server{Addr: l.addr(), Handler: handler, Protocols: serverProtocols(l.Protocol)}
+	onShutdown(func(ctx context.Context) { _ = server.Shutdown(ctx) })
+	var err error
+	if l.Protocol == protoHTTP {
+		if h2cEnabled {
+			log.Printf("HTTP Redirector listening on %s (HTTP/1.1 and h2c)", l.addr())
+		} else {
+			log.Printf("HTTP Redirector listening on %s", l.addr())
+		}
+		err = server.ListenAndServe()
+	} else {
+		server.TLSConfig = l.tlsConfig()
+		log.Printf("%s listening on %s (TLS, clone certificates: %v)", strings.ToUpper(l.Protocol), l.addr(), tlsClone)
+		var ln net.Listener
+		if ln, err = net.Listen("tcp", l.addr()); err == nil {
+			err = server.ServeTLS(newPassthroughListener(ln, l), "", "")
+		}
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// restrict answers hosts outside the listener's namespaces with a 404.
+func (l *listenerConfig) restrict(handler http.Handler) http.Handler {
+	if len(l.Namespaces) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !l.serves(host) {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (l *listenerConfig) serveDNS() {
+	server := &dns.Server{Addr: l.addr(), Net: "udp", Handler: dnsHandler(l.serves)}
+	if l.Protocol == protoDoT {
+		server.Net, server.TLSConfig = "tcp-tls", l.tlsConfig()
+	}
+	onShutdown(func(ctx context.Context) { _ = server.ShutdownContext(ctx) })
+	log.Printf("%s Server listening on %s %s...", strings.ToUpper(l.Protocol), server.Net, l.addr())
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("Failed to start %s server: %v", l.Protocol, err)
+	}
+}
+
+// dohHandler serves RFC 8484 DNS-over-HTTPS queries (GET ?dns= and POST).
+func dohHandler(h dns.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			raw, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			raw, err = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		msg := new(dns.Msg)
+		if err != nil || msg.Unpack(raw) != nil {
+			http.Error(w, "malformed DNS message", http.StatusBadRequest)
+			return
+		}
+		remote, _ := netip.ParseAddrPort(r.RemoteAddr)
+		dw := &dohWriter{remote: net.TCPAddrFromAddrPort(remote)}
+		h.ServeDNS(dw, msg)
+		if dw.reply == nil {
+			http.Error(w, "no answer", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(dw.reply)
+	})
+}
+
+// dohWriter captures the answer a dns.Handler writes.
+type dohWriter struct {
+	remote net.Addr
+	reply  []byte
+}
+
+func (d *dohWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (d *dohWriter) RemoteAddr() net.Addr { return d.remote }
+func (d *dohWriter) WriteMsg(m *dns.Msg) (err error) {
+	d.reply, err = m.Pack()
+	return err
+}
+func (d *dohWriter) Write(b []byte) (int, error) {
+	d.reply = append([]byte(nil), b...)
+	return len(b), nil
+}
+func (d *dohWriter) Close() error        { return nil }
+func (d *dohWriter) TsigStatus() error   { return nil }
+func (d *dohWriter) TsigTimersOnly(bool) {}
+func (d *dohWriter) Hijack()             {}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -9,23 +13,105 @@ import (
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go/http3"
+
+	"goRebind/adminclient"
 )
 
-// ConfigRoute represents a single mapping rule
-type ConfigRoute struct {
-	Source string `json:"source"`
-	Target string `json:"target"`
+// ConfigRoute represents a single mapping rule. It is shared with the admin
+// API client so config files and API calls use the same schema.
+type ConfigRoute = adminclient.Route
+
+// route is a parsed ConfigRoute in the live route table
+type route struct {
+	ConfigRoute
+	target *url.URL
+
+	// Compiled regex source, and creation order among regex routes
+	pattern *regexp.Regexp
+	seq     uint64
+
+	// Port and path parts of the source, e.g. 8443 and /v2/*, the source
+	// port range, and the first port of a target port range
+	port           string
+	path           string
+	portLo, portHi int
+	targetPortLo   int
+
+	// Network of a CIDR target, whose addresses share the route's traffic
+	targetCIDR netip.Prefix
+
+	// Parsed rebind_ip
+	rebindIP net.IP
+
+	// auto_profiles by lowercase browser or OS
+	autoProfiles map[string]string
+
+	// Loaded tls_cert and tls_key
+	tlsCert *tls.Certificate
+
+	// Parsed clients condition
+	clients []*net.IPNet
+
+	// Parsed schedule, and whether it currently has the route inactive
+	activeFrom, activeUntil time.Time
+	windows                 []activityWindow
+	scheduledOff            atomic.Bool
+
+	// Compiled transform templates
+	transform *requestTemplate
+
+	// Parsed redact fields, each a path of names
+	redactFields [][]string
+
+	timeout    time.Duration
+	errorPages map[int]errorPage
+	statusMap  map[int]statusRewrite
+
+	compareWith *backend
+
+	// Upstream pacing: interval, jitter and the next free slot
+	pace       time.Duration
+	paceJitter time.Duration
+	paceMu     sync.Mutex
+	paceNext   time.Time
+
+	// Upstream pool (target first, canary last) and round-robin position
+	pool        []*backend
+	canary      *backend
+	totalWeight int
+	rotation    atomic.Uint32
+
+	// Dialer and lazily built transport for routes with their own egress,
+	// outbound proxy, TLS verification or server name
+	egress     dialFunc
+	proxyURL   *url.URL
+	egressOnce sync.Once
+	transport  *http.Transport
+
+	// HTTP/2-only variant of transport for gRPC requests
+	grpcOnce sync.Once
+	grpc     *http.Transport
+
+	// QUIC transport for routes with their own TLS verification or server name
+	h3Once sync.Once
+	h3     *http3.Transport
 }
 
 var (
 	// Global map for O(1) lookups during high traffic
-	routeMap = make(map[string]*url.URL)
+	routeMap = make(map[string]*route)
 	mu       sync.RWMutex
 
 	// Interface IP for DNS responses
@@ -33,21 +119,142 @@ var (
 
 	// Global verbose flag
 	verboseMode bool
+
+	// Active config file, kept so routes can be reloaded at runtime
+	configFiles []string
+
+	// Build version, set with -ldflags "-X main.version=..."
+	version = "dev"
 )
 
+// subcommands run instead of the relay when named as the first argument
+var subcommands = map[string]func(args []string){
+	"ctl":        runCtl,
+	"bench":      runBench,
+	"version":    runVersion,
+	"migrate":    runMigrate,
+	"validate":   runValidate,
+	"spoof":      runSpoof,
+	"replay":     runReplay,
+	"completion": runCompletion,
+	"man":        runMan,
+}
+
 func main() {
 	// 1. Parse Flags
-	configPath := flag.String("config", "", "Path to config file")
+	var configPaths stringList
+	flag.Var(&configPaths, "config", "Path to config file; repeat to merge several")
+	flag.DurationVar(&configRefresh, "config-refresh", time.Minute, "How often http(s) -config URLs are fetched again (0 disables)")
+	flag.Var(&configHeaders, "config-header", "Header sent when fetching http(s) configs, as \"Name: value\"; repeatable")
+	flag.StringVar(&configFormat, "config-format", "", "Config file syntax: json, yaml or toml (default: from the file extension)")
 	skipSSL := flag.Bool("skip-ssl-verify", true, "Skip TLS verification")
-	port := flag.Int("port", 80, "Port for HTTP server")
+	port := flag.String("port", "80", "Port for HTTP server; a comma-separated list serves several, and a /https suffix serves that port over TLS (e.g. 80,8080,8443/https)")
+	bind := flag.String("bind", "", "Address the -port and -tls-port listeners bind, e.g. 127.0.0.1, or \"interface\" for the -interface address (default: all interfaces)")
+	bindShort := flag.String("listen-addr", "", "Alias for -bind")
 	proxyURL := flag.String("proxy", "", "Optional outbound HTTP proxy URL")
 	enableDNS := flag.Bool("dns", false, "Enable DNS server functionality")
+	flag.Var(&dnsAllow, "dns-allow", "Client IP or CIDR the DNS server answers; others are refused. Repeatable (default: anyone)")
 	ifaceName := flag.String("interface", "", "Network interface name (required for DNS)")
 	ifaceNameShort := flag.String("I", "", "Alias for -interface")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging for DNS misses")
+	flag.BoolVar(&strictLint, "strict", false, "Refuse to start when the startup lint warns about a risky setup")
 	forceH2 := flag.Bool("http2", false, "Force enable HTTP/2 (may cause 'tls: user canceled' errors on some proxies)")
+	flag.BoolVar(&http3Upstream, "http3", false, "Speak HTTP/3 (QUIC) to https targets, falling back to TCP for those that do not answer over QUIC")
+	flag.IntVar(&h3Port, "h3-port", 0, "UDP port for an HTTP/3 listener next to the HTTPS one, advertised through Alt-Svc (0 disables)")
+	flag.BoolVar(&h2cEnabled, "h2c", false, "Accept HTTP/2 with prior knowledge (h2c) on plain HTTP listeners, e.g. for gRPC clients")
 	disableKeepAlive := flag.Bool("no-keep-alive", false, "Disable HTTP connection reuse (fixes 'unsolicited response' in some proxies)")
+	enableAdmin := flag.Bool("admin", false, "Enable the admin API")
+	flag.StringVar(&adminAddr, "admin-addr", "127.0.0.1:9090", "Listen address for the admin API (localhost-only by default)")
+	flag.StringVar(&adminToken, "admin-token", "", "Bearer token with full admin scope (generated if no auth is configured)")
+	flag.StringVar(&adminTokensFile, "admin-tokens", "", "JSON file of named admin tokens with scopes (read or admin)")
+	flag.StringVar(&adminTLSCert, "admin-tls-cert", "", "TLS certificate file for the admin API listener")
+	flag.StringVar(&adminTLSKey, "admin-tls-key", "", "TLS private key file for the admin API listener")
+	flag.StringVar(&adminClientCA, "admin-client-ca", "", "CA bundle used to verify admin API client certificates (mTLS)")
+	flag.BoolVar(&adminPprof, "admin-pprof", false, "Expose net/http/pprof and expvar on the admin API (admin scope)")
+	flag.StringVar(&baitDomain, "bait-domain", "", "Parent domain for bait hostnames minted through the admin API")
+	flag.StringVar(&baitTarget, "bait-target", "", "Default target for minted baits (e.g. a file:// decoy)")
+	flag.StringVar(&baitStorePath, "bait-store", "", "JSON file persisting minted baits across restarts")
+	flag.StringVar(&auditLogPath, "audit-log", "", "Append-only audit log file for control-plane actions")
+	flag.StringVar(&relayName, "relay-name", "", "Name identifying this relay in published events (default: hostname)")
+	flag.StringVar(&eventsNATS, "events-nats", "", "NATS server URL to publish the event stream to")
+	flag.StringVar(&eventsNATSSubject, "events-nats-subject", "gorebind.events", "NATS subject prefix; events go to <prefix>.<type>")
+	flag.StringVar(&eventsKafka, "events-kafka", "", "Comma-separated Kafka brokers to publish the event stream to")
+	flag.StringVar(&eventsKafkaTopic, "events-kafka-topic", "gorebind-events", "Kafka topic for the event stream")
+	flag.StringVar(&syslogAddr, "syslog", "", "Send security events to a syslog collector (udp://host:514 or tcp://host:514)")
+	flag.StringVar(&syslogFormat, "syslog-format", "cef", "Syslog security event format: cef or leef")
+	flag.BoolVar(&safeMode, "safe-mode", false, "Never rebind or proxy: resolve DNS with the system and forward HTTP to the requested host, logging the route each would have hit")
+	flag.StringVar(&killSwitchHost, "kill-switch", "", "Hostname that, when queried or requested, disables all routes (forward-only mode)")
+	flag.Var(&activeWindows, "active-window", "Times routes are active, as \"Mon-Fri 09:00-17:00\"; outside every window the relay is forward-only. Repeatable")
+	flag.StringVar(&activeTZ, "active-tz", "", "Time zone of -active-window, e.g. Europe/Berlin (default: local time)")
+	flag.Var(&disableGroups, "disable-group", "Start with the routes of this group disabled; toggle groups at runtime through the admin API. Repeatable")
+	flag.BoolVar(&cloakEnabled, "cloak", false, "Serve the decoy/forward path to clients that look like sandboxes or scanners")
+	flag.StringVar(&cloakCIDRsFile, "cloak-cidrs", "", "File of scanner/vendor CIDRs (one per line) to cloak")
+	flag.StringVar(&cloakDecoyAddr, "cloak-decoy", "", "Decoy target URL for cloaked clients (default: forward to the real host)")
+	flag.StringVar(&robotsTxtFile, "robots-txt", "", "File served as /robots.txt on routed hosts (default: disallow all, 'proxy' to pass upstream)")
+	flag.StringVar(&securityTxtFile, "security-txt", "", "File served as /.well-known/security.txt on routed hosts (default: 404, 'proxy' to pass upstream)")
+	flag.BoolVar(&acmeIssue, "acme", false, "Obtain certificates for routed public names from an ACME CA (Let's Encrypt by default)")
+	flag.StringVar(&acmeDirectory, "acme-directory", letsEncryptDirectory, "ACME directory URL")
+	flag.StringVar(&acmeEmail, "acme-email", "", "Contact address for the ACME account")
+	flag.StringVar(&acmeChallenge, "acme-challenge", "http-01", "ACME challenge type: http-01 or dns-01 (answered by the relay's DNS server)")
+	flag.StringVar(&acmeWebroot, "acme-webroot", "", "Serve /.well-known/acme-challenge/ locally from this certbot webroot directory")
+	flag.BoolVar(&forwardRequestID, "forward-request-id", false, "Also send the generated X-Request-Id header to upstream targets")
+	flag.DurationVar(&upstreamTimeout, "upstream-timeout", 0, "Overall deadline for proxied requests, overridable per route (0 disables)")
+	flag.StringVar(&compressionMode, "compression", compressionPassthrough, "Upstream compression for routes without one: passthrough, identity or transcode")
+	flag.StringVar(&errorPagesDir, "error-pages", "", "Directory of custom proxy error pages named after the error class (e.g. dial_timeout.html)")
+	flag.StringVar(&compareLogPath, "compare-log", "", "JSON-lines file recording responses that differ from a route's compare_with target")
+	flag.IntVar(&graphqlLogMax, "graphql-log-max", 1024, "Bytes of variables logged per GraphQL operation on graphql routes (0 logs them whole)")
+	flag.IntVar(&tlsPort, "tls-port", 0, "Port for the HTTPS listener, presenting certificates minted per server name (0 disables)")
+	flag.BoolVar(&tlsPassthrough, "tls-passthrough", false, "Tunnel HTTPS connections for routes with https targets to the target by SNI, without terminating TLS")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "Certificate the HTTPS listener presents for names without a route certificate or imported one, instead of minting")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "Private key for -tls-cert")
+	flag.BoolVar(&tlsClone, "tls-clone", false, "Copy subject, SANs and issuer of the routed target's certificate into minted certificates")
+	flag.StringVar(&certStoreDir, "cert-store", "", "Directory persisting the internal CA and the HTTPS listener's certificates")
+	flag.StringVar(&caCertFile, "ca-cert", "", "PEM file of an existing CA (e.g. mitmproxy-ca.pem) signing the HTTPS listener's certificates")
+	flag.StringVar(&caKeyFile, "ca-key", "", "PEM private key of -ca-cert, if not in the same file")
+	flag.BoolVar(&internalCAEnabled, "internal-ca", false, "Generate an internal CA on first run and sign the HTTPS listener's certificates with it")
+	flag.StringVar(&internalCAName, "internal-ca-name", "goRebind Internal CA", "Common name of the generated internal CA")
+	flag.StringVar(&caURL, "ca-url", "", "Base URL of this relay (e.g. http://10.0.0.2) written into internal CA certificates as their OCSP and CRL location")
+	flag.StringVar(&listenersFile, "listeners", "", "JSON file declaring every listener (http, https, h3, dns, dot, doh, tcp, admin); replaces -port, -bind, -tls-port, -h3-port, -dns and -admin-addr")
+	flag.StringVar(&presetName, "preset", "", "Flag defaults for a common scenario: browser-rebind, iot-rebind, sinkhole or forward-proxy")
+	flag.UintVar(&dnsTTL, "dns-ttl", 3600, "TTL in seconds of DNS answers pointing routed hosts at the relay")
+	flag.UintVar(&jitterTTL, "jitter-ttl", 0, "Spread routed DNS answers' TTLs randomly by up to this many seconds either way")
+	flag.DurationVar(&jitterDelay, "jitter-delay", 0, "Delay DNS answers and proxied requests by a random time up to this long")
+	flag.BoolVar(&shuffleAnswers, "shuffle-answers", false, "Shuffle the order of multi-record DNS answers")
+	flag.BoolVar(&forwardUnmatched, "forward-unmatched", false, "Forward requests for hosts without a route to their real destination")
+	flag.BoolVar(&deterministic, "deterministic", false, "Reproducible runs for CI: seeded request/trace IDs, bait names and canary rolls, sorted DNS answers")
+	flag.Uint64Var(&seed, "seed", 1, "Seed for -deterministic")
+	flag.StringVar(&dnsRecordPath, "dns-record", "", "JSON-lines file recording every DNS query (timing, ID, type, EDNS) for goRebind replay")
+	flag.BoolVar(&fingerprinting, "fingerprint", false, "Infer each client's OS and browser from DNS queries, HTTP headers and TLS ClientHello (JA3/JA4)")
+	flag.BoolVar(&sessionsEnabled, "sessions", false, "Stitch each client's DNS lookups, TLS handshakes and HTTP requests of routed names into sessions")
+	flag.DurationVar(&sessionIdle, "session-idle", 2*time.Minute, "End a session after this long without activity")
+	flag.BoolVar(&autoStrategy, "auto-strategy", false, "Pick each client's rebind strategy profile from its fingerprint on routes with rebind_ip and no strategy_profile (implies -fingerprint)")
+	flag.BoolVar(&tunnelDetect, "dns-tunnel-detect", false, "Flag DNS queries with long labels or high-entropy subdomains as suspected tunneling")
+	flag.StringVar(&tunnelCapturePath, "dns-tunnel-capture", "", "JSON-lines file of suspected tunnel queries with their decoded payloads (implies -dns-tunnel-detect)")
+	flag.IntVar(&tunnelLabelLen, "dns-tunnel-label", 40, "Label length at which -dns-tunnel-detect flags a query")
+	flag.Float64Var(&tunnelEntropy, "dns-tunnel-entropy", 4.3, "Subdomain entropy in bits per character at which -dns-tunnel-detect flags a query")
+	flag.StringVar(&tcpRelaysFile, "tcp-relays", "", "JSON file of raw TCP relays (listen, target, protocol: raw, ftp, smtp, imap, redis or memcached)")
+	flag.StringVar(&honeypotFile, "honeypot", "", "JSON persona of fake application responses served to unmatched hosts (honeypot mode)")
+	flag.StringVar(&honeypotLogPath, "honeypot-log", "", "JSON-lines file recording headers and bodies of every honeypot request")
+	flag.IntVar(&maxGoroutines, "max-goroutines", 0, "Shed HTTP requests with 503 above this many goroutines (0 disables)")
+	flag.IntVar(&maxUpstreamConns, "max-upstream-conns", 0, "Maximum open upstream connections (0 disables)")
+	flag.IntVar(&clientConcurrency, "client-concurrency", 0, "Maximum proxied requests in flight per client IP; more wait in that client's own queue (0 disables)")
+	flag.IntVar(&clientQueue, "client-queue", 64, "Requests a client may have waiting for -client-concurrency before getting 429")
+	flag.StringVar(&memoryLimitFlag, "memory-limit", "", "Soft memory limit, e.g. 512MiB (defaults to GOMEMLIMIT); requests are shed near the limit")
+	flag.DurationVar(&maxRuntime, "max-runtime", 0, "Shut down automatically after this long (e.g. 8h, 0 disables)")
+	flag.BoolVar(&noDNSPrefetch, "no-dns-prefetch", false, "Resolve upstream target hostnames on demand instead of prefetching them")
+	flag.DurationVar(&idleTimeout, "shutdown-after-idle", 0, "Shut down automatically after this long without HTTP/DNS traffic (0 disables)")
+
+	// Subcommands are dispatched once the relay's flags are registered, so
+	// completion and man can describe them
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
 	flag.Parse()
+	log.Printf("Starting %s", describeBuild(currentBuild()))
+	applyPreset()
 
 	// Set global verbose state
 	verboseMode = *verbose
@@ -58,25 +265,73 @@ func main() {
 		finalIface = *ifaceNameShort
 	}
 
+	// Handle bind alias
+	finalBind := *bind
+	if finalBind == "" {
+		finalBind = *bindShort
+	}
+
 	// 2. Config Loading / Generation
-	targetConfig := *configPath
-	if targetConfig == "" {
-		if _, err := os.Stat("config.json"); err == nil {
-			targetConfig = "config.json"
-			log.Println("No config flag provided, using existing 'config.json'")
-		} else {
-			targetConfig = "config-example.json"
-			createDummyConfig(targetConfig)
-			log.Printf("Created random config file: %s\n", targetConfig)
+	targetConfig := []string(configPaths)
+	if len(targetConfig) == 0 {
+		for _, name := range defaultConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				targetConfig = []string{name}
+				log.Printf("No config flag provided, using existing '%s'", name)
+				break
+			}
+		}
+		if len(targetConfig) == 0 {
+			targetConfig = []string{"config-example.json"}
+			createDummyConfig(targetConfig[0])
+			log.Printf("Created random config file: %s\n", targetConfig[0])
 		}
 	}
 
+	openAuditLog()
+	if relayName == "" {
+		relayName, _ = os.Hostname()
+	}
+	startEventSinks()
+	startSyslogSink()
+	setupDeterministic()
+	setupJitter()
+	setupGuardrails()
+	setupCloak()
+	setupDNSAllow()
+	setupSafeMode()
+	setupActivityWindows()
+	setupGroups()
+	startRouteSchedules()
+	loadWellKnownFiles()
+	loadErrorPages()
+	if configFormat != "" && !slices.Contains(configFormats, configFormat) {
+		log.Fatalf("Invalid -config-format %q (one of: %s)", configFormat, strings.Join(configFormats, ", "))
+	}
+	if err := validCompression(compressionMode); err != nil {
+		log.Fatalf("Invalid -compression: %v", err)
+	}
+	loadHoneypot()
+	openCompareLog()
+	openDNSRecord()
+	openTunnelCapture()
+	setupAutoStrategy()
+	startSessions()
+	loadCertStore()
+	loadCAFiles()
+	loadDefaultCert()
+	ensureInternalCA()
+	loadBaits()
+	configFiles = targetConfig
 	loadConfig(targetConfig)
+	audit("system", "", "config_load", map[string]string{"config": strings.Join(targetConfig, ", ")})
+
+	listeners := loadListeners(flagListeners(*port, bindAddress(finalBind, finalIface), *enableDNS, *enableAdmin))
 
 	// 3. DNS Server Setup (Optional)
-	if *enableDNS {
+	if usesProtocol(listeners, protoDNS, protoDoT, protoDoH) {
 		if finalIface == "" {
-			log.Fatal("Error: -interface or -I is required when -dns is enabled")
+			log.Fatal("Error: -interface or -I is required when DNS is served")
 		}
 
 		var err error
@@ -85,62 +340,338 @@ func main() {
 			log.Fatalf("Error getting IP for interface %s: %v", finalIface, err)
 		}
 		log.Printf("DNS Server enabled. Responding with IP %s for matched hosts.", interfaceIP.String())
-
-		go startDNSServer()
 	}
 
-	// 4. HTTP Redirector Setup
-	startHTTPServer(*port, *skipSSL, *proxyURL, *forceH2, *disableKeepAlive)
+	lintStartup(listeners, *skipSSL)
+	startTCPRelays()
+
+	// 4. HTTP Redirector, DNS and Admin API Listeners
+	startLifecycle()
+	startListeners(listeners, newRedirector(*skipSSL, *proxyURL, *forceH2, *disableKeepAlive))
+	startACME(listeners)
+	waitForShutdown()
 }
 
 // --- Configuration Logic ---
 
 func createDummyConfig(filename string) {
-	dummy := []ConfigRoute{
+	dummy := configDocument{Version: configVersion, Routes: configRoutes{
 		{Source: "example.local", Target: "https://www.google.com"},
 		{Source: "api.local", Target: "http://127.0.0.1:8080"},
-	}
+	}}
 	file, _ := json.MarshalIndent(dummy, "", "  ")
 	_ = os.WriteFile(filename, file, 0644)
 }
 
-func loadConfig(path string) {
-	data, err := os.ReadFile(path)
+func loadConfig(paths []string) {
+	doc, err := readConfig(paths)
 	if err != nil {
-		log.Fatalf("Failed to read config: %v", err)
+		log.Fatal(err)
 	}
+	setEngagement(doc.Engagement)
+	configListeners = doc.Listeners
+	setRoutes(doc.Routes)
+	startConfigRefresh(paths, doc)
+}
 
-	var routes []ConfigRoute
-	if err := json.Unmarshal(data, &routes); err != nil {
-		log.Fatalf("Invalid JSON config: %v", err)
+// readConfigFile parses one config file or URL, either an array of routes
+// or an object with routes and includes.
+func readConfigFile(path string) (*configDocument, error) {
+	var data []byte
+	var format string
+	var err error
+	if isRemoteConfig(path) {
+		data, format, err = fetchRemoteConfig(path)
+	} else {
+		data, err = os.ReadFile(path)
+		format = detectConfigFormat(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
+	if data, err = configJSON(data, format); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc := &configDocument{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &doc.Routes)
+	} else {
+		err = json.Unmarshal(data, doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid %s config: %w", path, strings.ToUpper(format), err)
+	}
+	if err := checkConfigVersion(doc, data); err != nil {
+		return nil, fmt.Errorf("%s: invalid config: %w", path, err)
+	}
+	if err := expandRoutes(doc.Routes); err != nil {
+		return nil, fmt.Errorf("%s: invalid config: %w", path, err)
+	}
+	return doc, nil
+}
+
+// setRoutes replaces the live route table with the given routes.
+func setRoutes(routes []ConfigRoute) {
+	newMap := make(map[string]*route, len(routes))
 	for _, r := range routes {
-		targetURL, err := url.Parse(r.Target)
+		rt, err := newRoute(r)
 		if err != nil {
-			log.Printf("Warning: Skipping invalid target URL %s: %v", r.Target, err)
+			log.Printf("Warning: Skipping route %s: %v", r.Source, err)
 			continue
 		}
-		routeMap[strings.ToLower(r.Source)] = targetURL
+		id := canonicalSource(r.Source)
+		if prev, ok := newMap[id]; ok {
+			if prev.Priority > rt.Priority {
+				log.Printf("Warning: Duplicate route %s: keeping the definition with priority %d", r.Source, prev.Priority)
+				continue
+			}
+			log.Printf("Warning: Duplicate route %s: the later definition replaces the earlier one", r.Source)
+		}
+		newMap[id] = rt
 		log.Printf("Loaded Route: %s -> %s", r.Source, r.Target)
 	}
+
+	addBaitRoutes(newMap)
+
+	mu.Lock()
+	routeMap = newMap
+	mu.Unlock()
+	routesChanged()
+}
+
+// newRoute validates a ConfigRoute and parses its target.
+func newRoute(cfg ConfigRoute) (*route, error) {
+	cfg.ID = ""
+	if cfg.Target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+	target, portLo, portHi, err := splitTargetPorts(cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+	target, cidr, err := splitTargetCIDR(target)
+	if err != nil {
+		return nil, err
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %s: %w", cfg.Target, err)
+	}
+	if cidr.IsValid() {
+		targetURL = cidrTargets(targetURL, cidr)[0]
+	}
+	rt := &route{ConfigRoute: cfg, target: targetURL, targetCIDR: cidr}
+	if err := rt.parsePattern(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseTargetPorts(portLo, portHi); err != nil {
+		return nil, err
+	}
+	if err := rt.parseConditions(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseMode(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseAutoPrefixes(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseSchedule(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseStrategy(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseTimeout(); err != nil {
+		return nil, err
+	}
+	if err := rt.parsePace(); err != nil {
+		return nil, err
+	}
+	if err := rt.loadResponsePages(); err != nil {
+		return nil, err
+	}
+	if err := validCompression(cfg.Compression); err != nil {
+		return nil, err
+	}
+	if err := rt.parseCompareWith(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseBackends(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseEgress(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseUpstreamOptions(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseTransform(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseRedaction(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseResponseLimits(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseStripPrefix(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseGroup(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseTags(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseTLSCert(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseTLSPassthrough(); err != nil {
+		return nil, err
+	}
+	if err := rt.parseHTTP3(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// config returns the route's definition as served by the admin API.
+func (rt *route) config(id string) ConfigRoute {
+	cfg := rt.ConfigRoute
+	cfg.ID = id
+	return cfg
+}
+
+// etag is a strong validator for the route's current definition.
+func (rt *route) etag() string {
+	data, _ := json.Marshal(rt.ConfigRoute)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// routesChanged is called after any change to the live route table.
+func routesChanged() {
+	rebuildRouteIndex()
+	syncPrefetch()
+	syncWarmPools()
+	mu.RLock()
+	n := len(routeMap)
+	mu.RUnlock()
+	emit(Event{Type: adminclient.EventRoutesChanged, Message: fmt.Sprintf("%d routes", n)})
+	kickACME()
+}
+
+// canonicalSource normalizes a source hostname into its route ID. Regex
+// sources and paths are kept as written.
+func canonicalSource(source string) string {
+	source = strings.TrimSpace(source)
+	if isRegexSource(source) {
+		return source
+	}
+	host, path := splitSource(source)
+	return strings.TrimSuffix(strings.ToLower(host), ".") + path
+}
+
+// lookupRoute returns the target for a lowercase host, for HTTP requests
+// from any client. Nothing matches while the kill switch is engaged.
+func lookupRoute(host string) (*route, bool) {
+	return lookupClientRoute(host, nil, modeHTTP)
+}
+
+// lookupClientRoute is lookupRoute for one client's view of the routes of
+// a subsystem, modeDNS or modeHTTP.
+func lookupClientRoute(host string, client net.IP, mode string) (*route, bool) {
+	if forwardOnly() {
+		return nil, false
+	}
+	mu.RLock()
+	rt, exists := matchRoute(host, client, mode)
+	mu.RUnlock()
+	return rt, exists
 }
 
 // --- HTTP Redirector Logic ---
 
+// requestInfo carries per-request state from the handler into the Director.
+type requestInfo struct {
+	id      string
+	route   string
+	matched *route
+	traceID string
+
+	// Client's Accept-Encoding, kept when the route transcodes bodies
+	acceptEncoding string
+
+	// Pending diff against the route's compare_with target
+	compare *comparison
+
+	// Backend picked for the request, and the affinity cookie value to set
+	backend  *backend
+	affinity string
+}
+
+type requestInfoKey struct{}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	info       *requestInfo
 }
 
 func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		if relayInformational(lrw.info) {
+			lrw.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	// The proxy clears the header map after relaying a 1xx response
+	if lrw.info != nil {
+		lrw.Header().Set("X-Request-Id", lrw.info.id)
+	}
+	spoofHeaders(lrw.Header(), lrw.info)
 	lrw.statusCode = code
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-func startHTTPServer(port int, skipSSL bool, proxyAddr string, enableH2 bool, disableKeepAlive bool) {
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// modifyResponse is the ReverseProxy ModifyResponse hook.
+func modifyResponse(resp *http.Response) error {
+	// The relay's own X-Request-Id is already set on the response
+	resp.Header.Del("X-Request-Id")
+	info := getRequestInfo(resp.Request)
+	if err := checkResponseType(resp, info); err != nil {
+		return err
+	}
+	capturePrimary(resp, info)
+	setAffinityCookie(resp, info)
+	trimResponse(resp, info)
+	if err := redactResponse(resp, info); err != nil {
+		return err
+	}
+	if err := transcodeResponse(resp, info); err != nil {
+		return err
+	}
+	if err := limitResponseSize(resp, info); err != nil {
+		return err
+	}
+	return rewriteStatus(resp)
+}
+
+// newRedirector builds the upstream transport and returns the HTTP handler
+// shared by every HTTP and HTTPS listener.
+func newRedirector(skipSSL bool, proxyAddr string, enableH2 bool, disableKeepAlive bool) http.Handler {
 
 	// --- H2 Negotiation Fix ---
 
@@ -162,59 +693,152 @@ func startHTTPServer(port int, skipSSL bool, proxyAddr string, enableH2 bool, di
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: skipSSL,
 			NextProtos:         nextProtos, // Forces http/1.1 if H2 is disabled
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
 		},
 		TLSNextProto:      tlsNextProto, // Explicitly disables H2 if enableH2 is false
 		ForceAttemptHTTP2: enableH2,
-		Proxy:             http.ProxyFromEnvironment,
+		Proxy:             bypassUnix(http.ProxyFromEnvironment),
 		DisableKeepAlives: disableKeepAlive, // New option to fix 'unsolicited response'
+		// Wait for the upstream's 100 Continue before sending Expect bodies
+		ExpectContinueTimeout: time.Second,
+		DialContext:           dialUpstream,
+		DialTLSContext:        dialUpstreamTLSWarm, // Hands out pre-warmed connections (warm_conns)
 	}
 
+	// Upstream dials go through the guardrails, Unix sockets, prefetched DNS and warm connection pools
+	warmDial = guardedDial(dialUnix(prefetchDial((&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext)))
+	warmTLSConfig = func() *tls.Config { return transport.TLSClientConfig.Clone() }
+	baseTransport = transport
+	compareTransport = routeTransport{}
+	syncWarmPools()
+
 	if proxyAddr != "" {
 		pURL, err := url.Parse(proxyAddr)
 		if err != nil {
 			log.Fatalf("Invalid proxy URL: %v", err)
 		}
-		transport.Proxy = http.ProxyURL(pURL)
+		transport.Proxy = bypassUnix(http.ProxyURL(pURL))
 		log.Printf("Using outbound proxy: %s", proxyAddr)
 	}
 
 	proxy := &httputil.ReverseProxy{
-		Transport: transport,
+		Transport: routeTransport{},
 		Director: func(req *http.Request) {
-			mu.RLock()
-			target, exists := routeMap[strings.ToLower(req.Host)]
-			mu.RUnlock()
+			if isCloaked(req) {
+				cloakRequest(req)
+				return
+			}
+
+			host := strings.ToLower(req.Host)
+			rt, exists := requestRoute(req)
 
+			if exists {
+				stats.recordHTTP(rt.name(), true)
+				recordTop(false, req.RemoteAddr, rt.name())
+			} else {
+				stats.recordHTTP(host, false)
+				recordTop(false, req.RemoteAddr, "")
+			}
+			if exists {
+				recordBaitHit(host, "http", req.RemoteAddr)
+			}
 			if !exists {
+				if safeMode {
+					logSafeModeHTTP(req)
+				}
+				if forwardOnly() || forwardUnmatched {
+					forwardToOrigin(req)
+				}
 				return
 			}
-
-			req.URL.Scheme = target.Scheme
-			req.URL.Host = target.Host
-			req.Host = target.Host
-			req.Header["X-Forwarded-For"] = nil
-		},
-		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
-			if err != nil && err.Error() != "context canceled" {
-				log.Printf("[ERROR] Proxy Error for %s: %v", r.Host, err)
+			if info := getRequestInfo(req); info != nil {
+				info.route = rt.name()
+				info.matched = rt
+				if forwardRequestID {
+					req.Header.Set("X-Request-Id", info.id)
+				}
+				if req.Header.Get("Traceparent") == "" {
+					req.Header.Set("Traceparent", newTraceparent(info.traceID))
+				}
+			}
+			negotiateCompression(req, rt, getRequestInfo(req))
+			redactRequest(req, rt)
+			trimRequest(req, rt)
+			logGraphQL(req, rt)
+
+			be, affinity := rt.pickBackend(req)
+			if info := getRequestInfo(req); info != nil {
+				info.backend, info.affinity = be, affinity
 			}
-			w.WriteHeader(http.StatusBadGateway)
+			rewritePath(req, rt, be)
+			clientHost := req.Host
+			be.apply(req)
+			mapTargetPort(req, rt, be, clientHost)
+			applyUpstreamOptions(req, rt)
+			transformRequest(req, rt)
+			req.Header["X-Forwarded-For"] = nil
+			startCompare(req, rt, getRequestInfo(req))
 		},
+		ModifyResponse: modifyResponse,
+		ErrorHandler:   handleProxyError,
 	}
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("[HTTP-IN] %s %s %s", r.Method, r.Host, r.URL.Path)
-		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		touchActivity()
+		info := &requestInfo{id: newRequestID(), traceID: traceIDFromRequest(r)}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		w.Header().Set("X-Request-Id", info.id)
+		if shedLoad(w, r) {
+			return
+		}
+		observeHTTPClient(r)
+		logRequest(r, "[HTTP-IN] %s %s %s%s", r.Method, r.Host, r.URL.Path, fingerprintTag(remoteIP(r.RemoteAddr).String()))
+		if isKillSwitchHost(r.Host) {
+			engageKillSwitch("HTTP request for " + r.Host + " from " + r.RemoteAddr)
+			http.NotFound(w, r)
+			return
+		}
+		if serveACMEChallenge(w, r) || serveWellKnown(w, r) || serveCACert(w, r) || serveRevocation(w, r) {
+			return
+		}
+		if serveHoneypot(w, r) {
+			return
+		}
+		r = markCloaked(r)
+		if !isCloaked(r) && conditionsRejected(w, r) {
+			return
+		}
+		if target := staticTarget(r); target != nil {
+			serveStatic(w, r, target)
+			return
+		}
+		release, ok := acquireClientSlot(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		jitterSleep()
+		r, cancel := withDeadline(r)
+		defer cancel()
+		lrw := &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK, info: info}
+		start := time.Now()
 		proxy.ServeHTTP(lrw, r)
+		elapsed := time.Since(start)
+		emit(Event{
+			Type: adminclient.EventHTTPRequest, Host: r.Host, Route: info.route, Client: r.RemoteAddr,
+			Method: r.Method, Path: r.URL.Path, Status: lrw.statusCode,
+			DurationMS: float64(elapsed.Microseconds()) / 1000, RequestID: info.id,
+		})
+		observeSessionHTTP(r, info.route, lrw.statusCode)
+		if info.route != "" {
+			observeLatency(info.route, elapsed, info.traceID)
+			log.Printf("[HTTP-OUT] %s %s -> %d in %s (req %s, trace %s)", r.Method, info.route, lrw.statusCode, elapsed.Round(time.Millisecond), info.id, info.traceID)
+		}
 	})
 
-	log.Printf("HTTP Redirector listening on port %d...", port)
 	log.Printf("HTTP/2 Enabled: %v", enableH2)
 	log.Printf("Keep-Alives Enabled: %v", !disableKeepAlive)
-
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), handler); err != nil {
-		log.Fatal(err)
-	}
+	return handler
 }
 
 // --- DNS Server Logic ---
@@ -239,16 +863,14 @@ func getInterfaceIP(name string) (net.IP, error) {
 	return nil, fmt.Errorf("no IPv4 address found on interface %s", name)
 }
 
-func startDNSServer() {
-	dns.HandleFunc(".", handleDNSRequest)
-	server := &dns.Server{Addr: ":53", Net: "udp"}
-	log.Println("DNS Server listening on UDP :53...")
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start DNS server: %v", err)
-	}
+// dnsHandler answers queries, rebinding routed names for which serves
+// returns true.
+func dnsHandler(serves func(string) bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) { handleDNSRequest(w, r, serves) }
 }
 
-func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg, serves func(string) bool) {
+	touchActivity()
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Compress = false
@@ -257,16 +879,63 @@ func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 		q := r.Question[0]
 		name := strings.TrimSuffix(strings.ToLower(q.Name), ".")
 
-		mu.RLock()
-		_, exists := routeMap[name]
-		mu.RUnlock()
+		if serveACMEDNS(m, q, name) {
+			w.WriteMsg(m)
+			return
+		}
+		if !dnsAllowed(remoteIP(w.RemoteAddr().String())) {
+			if verboseMode {
+				log.Printf("[DNS] Refused %s from %s (not in -dns-allow)", name, w.RemoteAddr())
+			}
+			m.Rcode = dns.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+		if isKillSwitchHost(name) {
+			engageKillSwitch("DNS query for " + name + " from " + w.RemoteAddr().String())
+		}
+		if safeMode {
+			logSafeModeDNS(name, dns.TypeToString[q.Qtype], w.RemoteAddr().String(), serves)
+		}
+		rt, exists := lookupClientRoute(name, remoteIP(w.RemoteAddr().String()), modeDNS)
+		exists = exists && serves(name)
+		if exists && cloakEnabled {
+			if ip, _, err := net.SplitHostPort(w.RemoteAddr().String()); err == nil && cloakedNetwork(net.ParseIP(ip)) {
+				log.Printf("[CLOAK] DNS query for %s from scanner network %s: answering from system", name, ip)
+				exists = false
+			}
+		}
 
+		if exists {
+			stats.recordDNS(rt.name(), q.Qtype == dns.TypeA)
+		} else {
+			stats.recordDNS(name, false)
+		}
+		recordDNSQuery(w, r, q, exists && q.Qtype == dns.TypeA)
+		client, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+		if exists {
+			recordTop(true, client, rt.name())
+		} else {
+			recordTop(true, client, "")
+		}
+		inspectDNSTunnel(client, q)
+		observeDNSClient(client, r, q)
+		emitDNS(name, client, dns.TypeToString[q.Qtype], exists && q.Qtype == dns.TypeA)
+		if exists {
+			recordBaitHit(name, "dns", client)
+		}
 		if exists && q.Qtype == dns.TypeA {
-			log.Printf("[DNS] Match: %s -> Returning Interface IP", name)
-			rr, err := dns.NewRR(fmt.Sprintf("%s A %s", q.Name, interfaceIP.String()))
+			ip := rt.rebindAnswer(client, name)
+			observeSessionDNS(rt, name, client, ip)
+			if ip.Equal(interfaceIP) {
+				log.Printf("[DNS] Match: %s -> Returning Interface IP", name)
+			}
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d A %s", q.Name, jitteredTTL(rt.answerTTL(client)), ip.String()))
 			if err == nil {
 				m.Answer = append(m.Answer, rr)
 			}
+		} else if exists && q.Qtype == dns.TypeAAAA && rt.rebindIP != nil && rt.clientStrategy(client).BlockAAAA {
+			log.Printf("[DNS] Match: %s AAAA -> empty (profile %s)", name, rt.profileFor(client))
 		} else {
 			if verboseMode {
 				log.Printf("[DNS] No Match/Not A-Record: %s -> System Lookup", name)
@@ -278,6 +947,7 @@ func handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 		}
 	}
 
+	jitterSleep()
 	w.WriteMsg(m)
 }
 
@@ -292,6 +962,9 @@ func systemDNSLookup(q dns.Question) []dns.RR {
 	if err != nil {
 		return nil
 	}
+	if deterministic {
+		slices.SortFunc(ips, func(a, b net.IP) int { return bytes.Compare(a, b) })
+	}
 
 	var answers []dns.RR
 	for _, ip := range ips {
@@ -303,5 +976,6 @@ func systemDNSLookup(q dns.Question) []dns.RR {
 			answers = append(answers, rr)
 		}
 	}
+	shuffleRRs(answers)
 	return answers
 }
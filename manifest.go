@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Manifest is returned by the admin API.
+type Manifest = adminclient.Manifest
+
+// --- Route Manifest Logic ---
+
+// parseTags validates the route's tags.
+func (rt *route) parseTags() error {
+	for _, tag := range rt.Tags {
+		if strings.TrimSpace(tag) == "" || strings.ContainsAny(tag, "\r\n") {
+			return fmt.Errorf("invalid tag %q", tag)
+		}
+	}
+	return nil
+}
+
+// kind classifies the route's source for the manifest.
+func (rt *route) kind() string {
+	if kind := rt.hostKind(); kind == "regex" || kind == "default" || rt.path == "" {
+		return kind
+	}
+	return "path"
+}
+
+// hostKind is the kind of the route's host alone, ignoring any path prefix.
+func (rt *route) hostKind() string {
+	host, _ := splitSource(rt.name())
+	switch {
+	case rt.pattern != nil:
+		return "regex"
+	case host == defaultSource || strings.HasPrefix(host, defaultSource+":"):
+		return "default"
+	case strings.HasPrefix(host, "*."):
+		return "wildcard"
+	}
+	return "exact"
+}
+
+// manifestTargets lists the route's upstreams without credentials, the
+// target first with its port range.
+func (rt *route) manifestTargets() []string {
+	targets := make([]string, len(rt.pool))
+	for i, b := range rt.pool {
+		u := *b.url
+		if i == 0 && rt.targetPortLo != 0 {
+			u.Host = net.JoinHostPort(u.Hostname(), fmt.Sprintf("%d-%d", rt.targetPortLo, rt.targetPortLo+rt.portHi-rt.portLo))
+		}
+		targets[i] = u.Redacted()
+	}
+	return targets
+}
+
+// manifestStrategy describes the route's rebind timing, or nil for routes
+// always answered with the relay's address.
+func (rt *route) manifestStrategy() *adminclient.RebindStrategy {
+	if rt.rebindIP == nil {
+		return nil
+	}
+	s := rt.strategy()
+	profile := valueOr(rt.StrategyProfile, "default")
+	if rt.picksProfile() {
+		profile = autoProfile
+	}
+	strategy := &adminclient.RebindStrategy{
+		Profile:      profile,
+		RebindIP:     rt.rebindIP.String(),
+		TTL:          int(rt.answerTTL("")),
+		AfterQueries: s.AfterQueries,
+		BlockAAAA:    s.BlockAAAA,
+	}
+	if s.After > 0 {
+		strategy.After = s.After.String()
+	}
+	return strategy
+}
+
+// buildManifest describes the live route table with each route's traffic
+// counters.
+func buildManifest() Manifest {
+	counters := stats.snapshot().Routes
+	m := Manifest{
+		Relay:       relayName,
+		Engagement:  engagementID(),
+		Generated:   time.Now().UTC(),
+		ForwardOnly: forwardOnly(),
+		SafeMode:    safeMode,
+	}
+	mu.RLock()
+	m.Routes = make([]adminclient.ManifestRoute, 0, len(routeMap))
+	for id, rt := range routeMap {
+		entry := adminclient.ManifestRoute{
+			ID:       id,
+			Kind:     rt.kind(),
+			Mode:     valueOr(rt.Mode, modeBoth),
+			Targets:  rt.manifestTargets(),
+			Aliases:  rt.aliasHosts(),
+			Tags:     rt.Tags,
+			Clients:  rt.Clients,
+			Group:    rt.Group,
+			Enabled:  rt.enabled(),
+			Disabled: disabledRoutes[id],
+
+			ActiveFrom:    rt.ActiveFrom,
+			ActiveUntil:   rt.ActiveUntil,
+			ActiveWindows: rt.ActiveWindows,
+			Priority:      rt.Priority,
+			Strategy:      rt.manifestStrategy(),
+		}
+		if c := counters[id]; c != nil {
+			entry.DNSHits, entry.HTTPRequests = c.DNSHits, c.HTTPRequests
+		}
+		m.Routes = append(m.Routes, entry)
+	}
+	mu.RUnlock()
+	sort.Slice(m.Routes, func(i, j int) bool { return m.Routes[i].ID < m.Routes[j].ID })
+	return m
+}
+
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildManifest())
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency buckets (seconds) for per-route request histograms
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// exemplar links a histogram bucket to the trace of a request that landed in it.
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+type histogram struct {
+	counts    []uint64 // per bucket, last entry is +Inf
+	exemplars []*exemplar
+	sum       float64
+	count     uint64
+}
+
+var (
+	histMu     sync.Mutex
+	histograms = make(map[string]*histogram)
+)
+
+// --- Metrics Logic ---
+
+// observeLatency records a proxied request duration for a route.
+func observeLatency(route string, d time.Duration, traceID string) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, v)
+
+	histMu.Lock()
+	defer histMu.Unlock()
+	h, ok := histograms[route]
+	if !ok {
+		h = &histogram{
+			counts:    make([]uint64, len(latencyBuckets)+1),
+			exemplars: make([]*exemplar, len(latencyBuckets)+1),
+		}
+		histograms[route] = h
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	if traceID != "" {
+		h.exemplars[i] = &exemplar{traceID: traceID, value: v, at: time.Now()}
+	}
+}
+
+// traceIDFromRequest reuses the W3C trace ID of an incoming traceparent
+// header, or starts a new trace.
+func traceIDFromRequest(r *http.Request) string {
+	if parts := strings.Split(r.Header.Get("Traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+		return parts[1]
+	}
+	b := make([]byte, 16)
+	randomBytes(b)
+	return hex.EncodeToString(b)
+}
+
+// newTraceparent builds a traceparent header continuing traceID upstream.
+func newTraceparent(traceID string) string {
+	span := make([]byte, 8)
+	randomBytes(span)
+	return "00-" + traceID + "-" + hex.EncodeToString(span) + "-01"
+}
+
+// handleMetrics serves Prometheus metrics. Exemplars are only valid in the
+// OpenMetrics format, so they are included when the scraper asks for it.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	writeMetrics(w, openMetrics)
+}
+
+func writeMetrics(w io.Writer, openMetrics bool) {
+	snap := stats.snapshot()
+	// OpenMetrics names counter families without the _total suffix
+	family := func(name string) string {
+		if openMetrics {
+			return name
+		}
+		return name + "_total"
+	}
+	counter := func(name, help string, v uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", family(name), help, family(name))
+		fmt.Fprintf(w, "%s_total %d\n", name, v)
+	}
+	counter("gorebind_dns_queries", "DNS queries received.", snap.DNSQueries)
+	counter("gorebind_dns_hits", "DNS queries answered with the relay address.", snap.DNSHits)
+	counter("gorebind_http_requests", "HTTP requests received.", snap.HTTPRequests)
+	counter("gorebind_http_unmatched", "HTTP requests that matched no route.", snap.HTTPUnmatched)
+	counter("gorebind_proxy_errors", "Upstream proxy errors.", snap.ProxyErrors)
+	counter("gorebind_cloaked", "Requests served the cloak path.", snap.Cloaked)
+	counter("gorebind_shed", "Requests rejected by resource guardrails.", snap.Shed)
+	counter("gorebind_honeypot", "Requests for unmatched hosts answered by the honeypot.", snap.Honeypot)
+	fmt.Fprintf(w, "# HELP %[1]s Upstream proxy errors by cause.\n# TYPE %[1]s counter\n", family("gorebind_proxy_error_class"))
+	for _, class := range sortedKeys(snap.ProxyErrorClasses) {
+		fmt.Fprintf(w, "gorebind_proxy_error_class_total{class=%q} %d\n", class, snap.ProxyErrorClasses[class])
+	}
+	counter("gorebind_events_dropped", "Events dropped because a stream subscriber fell behind.", eventsDropped.Load())
+	if snap.Clients != nil {
+		fmt.Fprintf(w, "# HELP gorebind_clients Fingerprinted client addresses by inferred browser and OS.\n# TYPE gorebind_clients gauge\n")
+		for _, label := range sortedKeys(snap.Clients) {
+			browser, system, _ := strings.Cut(label, "/")
+			fmt.Fprintf(w, "gorebind_clients{browser=%q,os=%q} %d\n", browser, system, snap.Clients[label])
+		}
+	}
+	fmt.Fprintf(w, "# HELP gorebind_upstream_connections Open upstream connections.\n# TYPE gorebind_upstream_connections gauge\ngorebind_upstream_connections %d\n", upstreamConns.Load())
+
+	fmt.Fprintf(w, "# HELP %[1]s Proxied HTTP requests per route.\n# TYPE %[1]s counter\n", family("gorebind_route_requests"))
+	for _, route := range sortedKeys(snap.Routes) {
+		fmt.Fprintf(w, "gorebind_route_requests_total{route=%q} %d\n", route, snap.Routes[route].HTTPRequests)
+	}
+
+	fmt.Fprintf(w, "# HELP gorebind_route_request_duration_seconds Proxied request latency per route.\n# TYPE gorebind_route_request_duration_seconds histogram\n")
+	histMu.Lock()
+	for _, route := range sortedKeys(histograms) {
+		h := histograms[route]
+		var cumulative uint64
+		for i, c := range h.counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = fmt.Sprint(latencyBuckets[i])
+			}
+			fmt.Fprintf(w, "gorebind_route_request_duration_seconds_bucket{route=%q,le=%q} %d", route, le, cumulative)
+			if ex := h.exemplars[i]; openMetrics && ex != nil {
+				fmt.Fprintf(w, " # {trace_id=%q} %g %.3f", ex.traceID, ex.value, float64(ex.at.UnixMilli())/1000)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "gorebind_route_request_duration_seconds_sum{route=%q} %g\n", route, h.sum)
+		fmt.Fprintf(w, "gorebind_route_request_duration_seconds_count{route=%q} %d\n", route, h.count)
+	}
+	histMu.Unlock()
+
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
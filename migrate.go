@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+)
+
+const migrateUsage = `Usage: goRebind migrate [flags] <config>
+
+Rewrites a config file in the current schema and explains every change, and
+every behaviour that differs from the release the file was written for.
+YAML and TOML files, told apart by extension, are written out as JSON.
+
+Flags:
+`
+
+// --- Config Migration Logic ---
+
+func newMigrateFlags() (*flag.FlagSet, *string, *bool) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	out := fs.String("o", "", "Write the migrated config to this file instead of stdout")
+	inPlace := fs.Bool("w", false, "Rewrite the config in place, keeping the original as <config>.bak")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	return fs, out, inPlace
+}
+
+func runMigrate(args []string) {
+	fs, out, inPlace := newMigrateFlags()
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || (*inPlace && *out != "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read config: %v", err)
+	}
+
+	format := detectConfigFormat(path)
+	if *inPlace && format != "json" {
+		log.Fatalf("Cannot migrate %s in place: the result is JSON, so write it out with -o", path)
+	}
+	converted, err := configJSON(data, format)
+	if err != nil {
+		log.Fatalf("Cannot migrate %s: %v", path, err)
+	}
+	doc, warnings, err := migrateConfig(converted)
+	if err != nil {
+		log.Fatalf("Cannot migrate %s: %v", path, err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	migrated, _ := json.MarshalIndent(doc, "", "  ")
+	migrated = append(migrated, '\n')
+
+	switch {
+	case *inPlace:
+		if bytes.Equal(migrated, data) {
+			fmt.Fprintf(os.Stderr, "%s is already current\n", path)
+			return
+		}
+		if err := os.WriteFile(path+".bak", data, 0o644); err != nil {
+			log.Fatalf("Failed to back up config: %v", err)
+		}
+		*out = path
+	case *out == "":
+		_, _ = os.Stdout.Write(migrated)
+		return
+	}
+	if err := os.WriteFile(*out, migrated, 0o644); err != nil {
+		log.Fatalf("Failed to write config: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s (%d routes, %d warnings)\n", *out, len(doc.Routes), len(warnings))
+}
+
+// migrateConfig upgrades a config document, a route array or an object, to
+// a version 2 document. The warnings name every field dropped or rewritten
+// and, for unversioned files, every default whose behaviour changed since
+// routes were only a source and a target.
+func migrateConfig(data []byte) (*configDocument, []string, error) {
+	var in struct {
+		Version    int                          `json:"version"`
+		Engagement *Engagement                  `json:"engagement"`
+		Listeners  []*listenerConfig            `json:"listeners"`
+		Include    []string                     `json:"include"`
+		Routes     []map[string]json.RawMessage `json:"routes"`
+	}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &in.Routes)
+	} else {
+		err = json.Unmarshal(data, &in)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("expected a JSON config document or array of routes: %w", err)
+	}
+	if in.Version > configVersion {
+		return nil, nil, fmt.Errorf("config version %d is newer than this relay reads (up to %d)", in.Version, configVersion)
+	}
+	raw := in.Routes
+
+	known := configFields()
+	var warnings []string
+	routes := []ConfigRoute{}
+	index := make(map[string]int) // canonical source -> position in routes
+	for i, fields := range raw {
+		obj, _ := json.Marshal(fields)
+		expanded, err := expandSources(obj)
+		if err != nil {
+			return nil, nil, fmt.Errorf("route %d: %w", i+1, err)
+		}
+		if len(expanded) > 1 {
+			warnings = append(warnings, fmt.Sprintf("route %d: source list written as %d routes, one per source", i+1, len(expanded)))
+		}
+		for j, r := range expanded {
+			name := fmt.Sprintf("route %d (%q)", i+1, r.Source)
+			if j == 0 {
+				for _, field := range sortedKeys(fields) {
+					if !known[field] {
+						warnings = append(warnings, fmt.Sprintf("%s: unknown field %q dropped; the relay never read it", name, field))
+					}
+				}
+			}
+			if r.ID != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: \"id\" dropped; IDs are assigned by the admin API", name))
+				r.ID = ""
+			}
+			if src := canonicalSource(r.Source); src != r.Source {
+				warnings = append(warnings, fmt.Sprintf("%s: source rewritten to %q; sources match case-insensitively without a trailing dot", name, src))
+				r.Source = src
+			}
+			if prev, ok := index[r.Source]; ok {
+				// Keep the definition setRoutes loads: the later one, unless
+				// the earlier has a higher priority
+				if routes[prev].Priority > r.Priority {
+					warnings = append(warnings, fmt.Sprintf("%s: duplicates an earlier route for %q with priority %d, which the relay kept; this one was dropped", name, r.Source, routes[prev].Priority))
+					continue
+				}
+				warnings = append(warnings, fmt.Sprintf("%s: duplicates an earlier route for %q, which was dropped (the later definition wins at equal or higher priority)", name, r.Source))
+				routes = append(routes[:prev], routes[prev+1:]...)
+				for src, pos := range index {
+					if pos > prev {
+						index[src] = pos - 1
+					}
+				}
+			}
+			index[r.Source] = len(routes)
+			routes = append(routes, r)
+		}
+	}
+
+	if in.Version < 2 {
+		warnings = append(warnings, fmt.Sprintf("written as a version %d document; the relay now rejects fields it does not know instead of ignoring them", configVersion))
+	}
+	if in.Version < 2 && len(routes) > 0 {
+		warnings = append(warnings,
+			"/robots.txt and /.well-known/security.txt on routed hosts are now answered by the relay (disallow-all and 404); start it with -robots-txt proxy -security-txt proxy to pass them upstream as before",
+			"route targets are resolved when the config loads and re-resolved before their TTL expires; start the relay with -no-dns-prefetch to resolve on every dial as before")
+	}
+	doc := &configDocument{Version: configVersion, Engagement: in.Engagement, Listeners: in.Listeners, Include: in.Include, Routes: routes}
+	return doc, warnings, nil
+}
+
+// configFields lists the JSON field names of ConfigRoute.
+func configFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(ConfigRoute{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+func remarshal(in any, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
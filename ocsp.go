@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// How long OCSP responses and CRLs issued by the internal CA stay valid;
+// staples are refreshed halfway through
+const ocspValidity = 24 * time.Hour
+
+var (
+	// Base URL (e.g. http://10.0.0.2) at which clients reach /ocsp and
+	// /ca.crl; written into minted certificates
+	caURL string
+
+	// Revoked serials (decimal) of certificates issued by the internal CA
+	revokedMu sync.RWMutex
+	revoked   = make(map[string]revocation)
+)
+
+type revocation struct {
+	Serial string    `json:"serial"`
+	Name   string    `json:"name"`
+	Time   time.Time `json:"time"`
+}
+
+// --- OCSP and CRL Logic ---
+
+// revocationURLs points a leaf's AIA and CRL distribution point at the
+// relay when -ca-url is set.
+func revocationURLs(template *x509.Certificate) {
+	if caURL == "" {
+		return
+	}
+	base := strings.TrimSuffix(caURL, "/")
+	template.OCSPServer = []string{base + "/ocsp"}
+	template.CRLDistributionPoints = []string{base + "/ca.crl"}
+}
+
+// loadRevocations restores the revocation list from -cert-store.
+func loadRevocations() {
+	data, err := os.ReadFile(filepath.Join(certStoreDir, "revoked.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read revocations: %v", err)
+	}
+	var list []revocation
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Fatalf("Invalid revocations: %v", err)
+	}
+	for _, r := range list {
+		revoked[r.Serial] = r
+	}
+}
+
+// saveRevocations writes the revocation list. Callers hold revokedMu.
+func saveRevocations() {
+	if certStoreDir == "" {
+		return
+	}
+	list := make([]revocation, 0, len(revoked))
+	for _, r := range revoked {
+		list = append(list, r)
+	}
+	data, _ := json.MarshalIndent(list, "", "  ")
+	if err := os.WriteFile(filepath.Join(certStoreDir, "revoked.json"), data, 0o600); err != nil {
+		log.Printf("[TLS] Failed to save revocations: %v", err)
+	}
+}
+
+func revokeSerial(serial *big.Int, name string) {
+	revokedMu.Lock()
+	defer revokedMu.Unlock()
+	revoked[serial.String()] = revocation{Serial: serial.String(), Name: name, Time: time.Now().UTC()}
+	saveRevocations()
+}
+
+// issuedBy reports whether cert was signed by the CA's key.
+func issuedBy(cert *x509.Certificate, ca *tls.Certificate) bool {
+	return ca != nil && bytes.Equal(cert.RawIssuer, ca.Leaf.RawSubject) && cert.CheckSignatureFrom(ca.Leaf) == nil
+}
+
+// ocspResponse signs the status of a serial issued by the CA.
+func ocspResponse(ca *tls.Certificate, serial *big.Int) ([]byte, error) {
+	signer, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key cannot sign")
+	}
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: serial,
+		ThisUpdate:   now.Add(-time.Minute),
+		NextUpdate:   now.Add(ocspValidity),
+	}
+	revokedMu.RLock()
+	r, isRevoked := revoked[serial.String()]
+	revokedMu.RUnlock()
+	if isRevoked {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = r.Time
+	}
+	return ocsp.CreateResponse(ca.Leaf, ca.Leaf, template, signer)
+}
+
+// stapled returns the entry's certificate with a current OCSP staple when
+// the internal CA issued it, refreshing the staple halfway through its
+// validity.
+func stapled(entry *leafEntry) *tls.Certificate {
+	leafMu.Lock()
+	defer leafMu.Unlock()
+	cert, ca := entry.cert, internalCA
+	if cert == nil || entry.source != certMinted || time.Now().Before(entry.stapleRefresh) || !issuedBy(cert.Leaf, ca) {
+		return cert
+	}
+	staple, err := ocspResponse(ca, cert.Leaf.SerialNumber)
+	if err != nil {
+		log.Printf("[TLS] Failed to staple OCSP response for %s: %v", cert.Leaf.Subject.CommonName, err)
+		entry.stapleRefresh = time.Now().Add(time.Minute)
+		return cert
+	}
+	fresh := *cert
+	fresh.OCSPStaple = staple
+	entry.cert = &fresh
+	entry.stapleRefresh = time.Now().Add(ocspValidity / 2)
+	return entry.cert
+}
+
+// serveRevocation answers OCSP requests (POST /ocsp and GET /ocsp/<b64>)
+// and serves the CRL at /ca.crl, on hosts without a route.
+func serveRevocation(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Path != "/ca.crl" && r.URL.Path != "/ocsp" && !strings.HasPrefix(r.URL.Path, "/ocsp/") {
+		return false
+	}
+	if _, exists := lookupRoute(strings.ToLower(r.Host)); exists {
+		return false
+	}
+	leafMu.Lock()
+	ca := internalCA
+	leafMu.Unlock()
+	if ca == nil {
+		return false
+	}
+	if r.URL.Path == "/ca.crl" {
+		serveCRL(w, ca)
+	} else {
+		serveOCSP(w, r, ca)
+	}
+	return true
+}
+
+func serveOCSP(w http.ResponseWriter, r *http.Request, ca *tls.Certificate) {
+	var raw []byte
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		raw, err = io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	case http.MethodGet:
+		var b64 string
+		if b64, err = url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/ocsp/")); err == nil {
+			raw, err = base64.StdEncoding.DecodeString(b64)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	req, perr := ocsp.ParseRequest(raw)
+	if err != nil || perr != nil {
+		_, _ = w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(ca.Leaf.RawSubject)
+	if !bytes.Equal(h.Sum(nil), req.IssuerNameHash) {
+		_, _ = w.Write(ocsp.UnauthorizedErrorResponse)
+		return
+	}
+	resp, err := ocspResponse(ca, req.SerialNumber)
+	if err != nil {
+		log.Printf("[TLS] OCSP response failed: %v", err)
+		_, _ = w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=3600")
+	_, _ = w.Write(resp)
+}
+
+func serveCRL(w http.ResponseWriter, ca *tls.Certificate) {
+	signer, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		http.Error(w, "CA key cannot sign", http.StatusInternalServerError)
+		return
+	}
+	revokedMu.RLock()
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, r := range revoked {
+		serial, ok := new(big.Int).SetString(r.Serial, 10)
+		if ok {
+			entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: r.Time})
+		}
+	}
+	revokedMu.RUnlock()
+
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(now.Unix()),
+		ThisUpdate:                now.Add(-time.Minute),
+		NextUpdate:                now.Add(ocspValidity),
+		RevokedCertificateEntries: entries,
+	}, ca.Leaf, signer)
+	if err != nil {
+		log.Printf("[TLS] CRL generation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	_, _ = w.Write(crl)
+}
+
+// handleRevokeCert revokes a certificate issued by the internal CA. The
+// name gets a freshly minted certificate on its next handshake.
+func handleRevokeCert(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	leafMu.Lock()
+	var cert *tls.Certificate
+	var source string
+	if entry, ok := leafCerts[name]; ok {
+		cert, source = entry.cert, entry.source
+	}
+	ca := internalCA
+	issued := cert != nil && issuedBy(cert.Leaf, ca)
+	if issued {
+		delete(leafCerts, name)
+	}
+	leafMu.Unlock()
+	if cert == nil {
+		writeJSONError(w, http.StatusNotFound, "certificate not found")
+		return
+	}
+	if !issued {
+		writeJSONError(w, http.StatusBadRequest, "certificate was not issued by the internal CA")
+		return
+	}
+	revokeSerial(cert.Leaf.SerialNumber, name)
+	if certStoreDir != "" {
+		_ = os.Remove(filepath.Join(certStoreDir, "certs", name+".pem"))
+	}
+	log.Printf("[ADMIN] Certificate revoked for %s (serial %s)", name, cert.Leaf.SerialNumber)
+	writeJSON(w, http.StatusOK, certInfo(name, cert, source))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// --- Upstream Pacing Logic ---
+
+// parsePace validates a route's pace and pace_jitter options.
+func (rt *route) parsePace() error {
+	for _, opt := range []struct {
+		name, value string
+		d           *time.Duration
+	}{{"pace", rt.Pace, &rt.pace}, {"pace_jitter", rt.PaceJitter, &rt.paceJitter}} {
+		if opt.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(opt.value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q", opt.name, opt.value)
+		}
+		*opt.d = d
+	}
+	if rt.paceJitter > 0 && rt.pace == 0 {
+		return fmt.Errorf("pace_jitter requires pace")
+	}
+	return nil
+}
+
+// awaitPace holds an upstream request until the route's next slot: at
+// least pace, plus up to pace_jitter, after the previous request started.
+// Slots are handed out in arrival order; a request whose context ends
+// while waiting fails with the context's error, and its slot stays used.
+func (rt *route) awaitPace(ctx context.Context) error {
+	if rt.pace <= 0 {
+		return nil
+	}
+	gap := rt.pace
+	if rt.paceJitter >= time.Millisecond {
+		gap += time.Duration(randomN(uint32(rt.paceJitter/time.Millisecond)+1)) * time.Millisecond
+	}
+
+	rt.paceMu.Lock()
+	now := time.Now()
+	slot := rt.paceNext
+	if slot.Before(now) {
+		slot = now
+	}
+	rt.paceNext = slot.Add(gap)
+	rt.paceMu.Unlock()
+
+	wait := slot.Sub(now)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
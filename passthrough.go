@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Tunnel TLS connections for routes with https targets to the target
+// without terminating them, for routes without tls_passthrough
+var tlsPassthrough bool
+
+// Time a client has to send its ClientHello
+const helloTimeout = 10 * time.Second
+
+// errHelloPeeked aborts the handshake once the ClientHello has been read.
+var errHelloPeeked = errors.New("ClientHello peeked")
+
+// --- TLS Passthrough Logic ---
+
+// parseTLSPassthrough checks that a route passed through has a TLS target
+// to pass to.
+func (rt *route) parseTLSPassthrough() error {
+	if rt.TLSPassthrough != nil && *rt.TLSPassthrough && (rt.target == nil || rt.target.Scheme != "https") {
+		return fmt.Errorf("tls_passthrough needs an https target")
+	}
+	return nil
+}
+
+// passesThrough reports whether the HTTPS listener tunnels the route's
+// connections instead of terminating them.
+func (rt *route) passesThrough() bool {
+	if rt.target == nil || rt.target.Scheme != "https" {
+		return false
+	}
+	if rt.TLSPassthrough != nil {
+		return *rt.TLSPassthrough
+	}
+	return tlsPassthrough
+}
+
+// passthroughListener hands the HTTPS server only the connections it
+// terminates. Each accepted connection's ClientHello is read first; when
+// its SNI names a route passed through, the connection is tunneled to the
+// target instead, replaying what was read.
+type passthroughListener struct {
+	net.Listener
+	l     *listenerConfig
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	err   error
+}
+
+func newPassthroughListener(ln net.Listener, l *listenerConfig) *passthroughListener {
+	pl := &passthroughListener{Listener: ln, l: l, conns: make(chan net.Conn), done: make(chan struct{})}
+	go pl.acceptLoop()
+	return pl
+}
+
+func (pl *passthroughListener) acceptLoop() {
+	for {
+		conn, err := pl.Listener.Accept()
+		if err != nil {
+			pl.err = err
+			pl.once.Do(func() { close(pl.done) })
+			return
+		}
+		go pl.dispatch(conn)
+	}
+}
+
+// dispatch tunnels conn or hands it to the server.
+func (pl *passthroughListener) dispatch(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(helloTimeout))
+	hello, peeked := peekClientHello(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	replay := &replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(peeked), conn)}
+	if hello != nil && hello.ServerName != "" && pl.l.serves(hello.ServerName) {
+		name := sessionHost(hello.ServerName)
+		if rt, ok := lookupClientRoute(name, remoteIP(conn.RemoteAddr().String()), modeHTTP); ok && rt.passesThrough() {
+			_, _ = observeHandshake(hello)
+			tunnelTLS(replay, rt, name)
+			return
+		}
+	}
+	select {
+	case pl.conns <- replay:
+	case <-pl.done:
+		conn.Close()
+	}
+}
+
+func (pl *passthroughListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-pl.conns:
+		return conn, nil
+	case <-pl.done:
+		return nil, pl.err
+	}
+}
+
+func (pl *passthroughListener) Close() error {
+	pl.once.Do(func() { close(pl.done) })
+	return pl.Listener.Close()
+}
+
+// peekClientHello reads the client's ClientHello without answering it and
+// returns it with the bytes read. hello is nil if conn does not speak TLS.
+func peekClientHello(conn net.Conn) (hello *tls.ClientHelloInfo, peeked []byte) {
+	var buf bytes.Buffer
+	server := tls.Server(&replayConn{Conn: conn, r: io.TeeReader(conn, &buf), readOnly: true}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloPeeked
+		},
+	})
+	_ = server.Handshake()
+	return hello, buf.Bytes()
+}
+
+// replayConn reads from r instead of the connection, to replay bytes that
+// were already read. A read-only one drops writes.
+type replayConn struct {
+	net.Conn
+	r        io.Reader
+	readOnly bool
+}
+
+func (c *replayConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *replayConn) Write(b []byte) (int, error) {
+	if c.readOnly {
+		return 0, io.ErrClosedPipe
+	}
+	return c.Conn.Write(b)
+}
+
+// tunnelTLS pipes the client's connection to the route's target (through
+// its egress, if any) until either side closes.
+func tunnelTLS(client *replayConn, rt *route, name string) {
+	defer client.Close()
+	addr := rt.target.Host
+	if rt.target.Port() == "" {
+		addr = net.JoinHostPort(rt.target.Hostname(), "443")
+	}
+	dial := warmDial
+	if rt.egress != nil {
+		dial = guardedDial(dialUnix(rt.egress))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	upstream, err := dial(ctx, "tcp", addr)
+	cancel()
+	if err != nil {
+		log.Printf("[PASSTHROUGH] %s for %s: dial %s failed: %v", client.RemoteAddr(), name, addr, err)
+		return
+	}
+	defer upstream.Close()
+	stats.recordHTTP(rt.name(), true)
+	recordTop(false, client.RemoteAddr().String(), rt.name())
+	log.Printf("[PASSTHROUGH] %s -> %s via %s%s", client.RemoteAddr(), name, addr, fingerprintTag(remoteIP(client.RemoteAddr().String()).String()))
+
+	start := time.Now()
+	var up int64
+	done := make(chan struct{})
+	go func() {
+		up, _ = io.Copy(upstream, client)
+		if tc, ok := upstream.(interface{ CloseWrite() error }); ok {
+			_ = tc.CloseWrite()
+		}
+		close(done)
+	}()
+	down, _ := io.Copy(client, upstream)
+	// The client gets a moment to finish its side before it is cut off
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	<-done
+	log.Printf("[PASSTHROUGH] %s -> %s closed after %s (%d bytes up, %d down)", client.RemoteAddr(), name, time.Since(start).Round(time.Millisecond), up, down)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// --- Path Rewriting Logic ---
+
+// parseStripPrefix validates the route's strip_prefix.
+func (rt *route) parseStripPrefix() error {
+	if rt.StripPrefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(rt.StripPrefix, "/") || strings.ContainsAny(rt.StripPrefix, "?#* ") {
+		return fmt.Errorf("invalid strip_prefix %q: want a path such as /app", rt.StripPrefix)
+	}
+	return nil
+}
+
+// rewritePath removes the route's strip_prefix from the request path, then
+// prepends the backend's target path and merges its query.
+func rewritePath(req *http.Request, rt *route, be *backend) {
+	if rt.StripPrefix != "" {
+		req.URL.Path = stripPathPrefix(req.URL.Path, rt.StripPrefix)
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = stripPathPrefix(req.URL.RawPath, rt.StripPrefix)
+		}
+	}
+	if be.path != "" && be.path != "/" {
+		req.URL.Path = joinURLPath(be.path, req.URL.Path)
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = joinURLPath(be.url.EscapedPath(), req.URL.RawPath)
+		}
+	}
+	if be.query != "" {
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = be.query
+		} else {
+			req.URL.RawQuery = be.query + "&" + req.URL.RawQuery
+		}
+	}
+}
+
+// stripPathPrefix removes prefix from p at a segment boundary, so /app
+// strips /app and /app/x but not /application.
+func stripPathPrefix(p, prefix string) string {
+	rest, ok := strings.CutPrefix(p, strings.TrimSuffix(prefix, "/"))
+	if !ok || (rest != "" && rest[0] != '/') {
+		return p
+	}
+	if rest == "" {
+		return "/"
+	}
+	return rest
+}
+
+// joinURLPath joins a target path and a request path with one slash
+// between them, keeping the request path's trailing slash.
+func joinURLPath(base, p string) string {
+	if p == "" || p == "/" {
+		if strings.HasSuffix(base, "/") || p == "" {
+			return base
+		}
+		return base + "/"
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(p, "/")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A port range at the end of a target's authority, as in http://backend:8000-8100
+var targetPortRange = regexp.MustCompile(`^([a-z][a-z0-9+.-]*://[^/?#]*):(\d+)-(\d+)([/?#].*)?$`)
+
+// --- Port Range Logic ---
+
+// parsePortRange parses a source port such as 8443 or 8000-8100.
+func parsePortRange(port string) (lo, hi int, err error) {
+	first, last, isRange := strings.Cut(port, "-")
+	lo, err = strconv.Atoi(first)
+	if err != nil || lo < 1 || lo > 65535 {
+		return 0, 0, fmt.Errorf("bad port %q", port)
+	}
+	if !isRange {
+		return lo, lo, nil
+	}
+	hi, err = strconv.Atoi(last)
+	if err != nil || hi < lo || hi > 65535 {
+		return 0, 0, fmt.Errorf("bad port range %q", port)
+	}
+	return lo, hi, nil
+}
+
+// splitTargetPorts replaces a port range in a target URL with its first
+// port, returning the range.
+func splitTargetPorts(target string) (string, int, int, error) {
+	m := targetPortRange.FindStringSubmatch(strings.TrimSpace(target))
+	if m == nil {
+		return target, 0, 0, nil
+	}
+	lo, hi, err := parsePortRange(m[2] + "-" + m[3])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid target URL %s: %w", target, err)
+	}
+	return m[1] + ":" + m[2] + m[4], lo, hi, nil
+}
+
+// parseTargetPorts checks a target port range against the source's: both
+// must span the same number of ports.
+func (rt *route) parseTargetPorts(lo, hi int) error {
+	if lo == 0 {
+		return nil
+	}
+	if rt.portLo == rt.portHi || hi-lo != rt.portHi-rt.portLo {
+		return fmt.Errorf("target port range %d-%d needs a source port range of the same size", lo, hi)
+	}
+	if rt.target.Scheme == "unix" {
+		return fmt.Errorf("unix targets take no port range")
+	}
+	rt.targetPortLo = lo
+	return nil
+}
+
+// inPortRange reports whether port falls in the route's source port range.
+func (rt *route) inPortRange(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= rt.portLo && n <= rt.portHi
+}
+
+// requestPorts lists the ports a request with the given Host may be routed
+// by: the listener's, then the Host header's when it differs, as behind a
+// port redirect.
+func requestPorts(r *http.Request, host string) []string {
+	ports := make([]string, 0, 2)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, p, err := net.SplitHostPort(addr.String()); err == nil {
+			ports = append(ports, p)
+		}
+	}
+	if _, p, err := net.SplitHostPort(host); err == nil && (len(ports) == 0 || p != ports[0]) {
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// mapTargetPort points a request on a port range route at the target port
+// matching the one it came in on, e.g. 8042 on 8000-8100 -> 9000-9100 goes
+// to 9042. clientHost is the Host header the client sent.
+func mapTargetPort(req *http.Request, rt *route, be *backend, clientHost string) {
+	if rt.targetPortLo == 0 || be != rt.pool[0] {
+		return
+	}
+	for _, p := range requestPorts(req, clientHost) {
+		if !rt.inPortRange(p) {
+			continue
+		}
+		n, _ := strconv.Atoi(p)
+		host := net.JoinHostPort(rt.target.Hostname(), strconv.Itoa(rt.targetPortLo+n-rt.portLo))
+		req.URL.Host = host
+		if req.Host == be.hostHeader {
+			req.Host = host
+		}
+		return
+	}
+}
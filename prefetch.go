@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Disable resolving target hostnames ahead of time
+var noDNSPrefetch bool
+
+// TTL assumed when the system resolver does not expose one
+const prefetchDefaultTTL = 60 * time.Second
+
+// Bounds on how often a prefetched name is refreshed
+const (
+	prefetchMinTTL = 5 * time.Second
+	prefetchMaxTTL = time.Hour
+)
+
+type prefetchEntry struct {
+	ips       []net.IP
+	refreshAt time.Time
+}
+
+var (
+	prefetchMu    sync.RWMutex
+	prefetchCache = make(map[string]*prefetchEntry)
+	prefetchOnce  sync.Once
+
+	// Where target addresses and record TTLs come from
+	prefetchLookupIP = net.DefaultResolver.LookupIP
+	resolvConf       = "/etc/resolv.conf"
+)
+
+// --- Target DNS Prefetch Logic ---
+
+// syncPrefetch makes sure every hostname target in the route table is
+// resolved and cached, and forgets names no longer in use.
+func syncPrefetch() {
+	if noDNSPrefetch {
+		return
+	}
+
+	wanted := make(map[string]bool)
+	mu.RLock()
+	for _, rt := range routeMap {
+		// Tunnelled routes resolve names at the far end
+		if rt.egress != nil {
+			continue
+		}
+		for _, u := range rt.upstreams() {
+			host := u.Hostname()
+			if (u.Scheme == "http" || u.Scheme == "https") && host != "" && net.ParseIP(host) == nil {
+				wanted[host] = true
+			}
+		}
+	}
+	mu.RUnlock()
+
+	prefetchMu.Lock()
+	for host := range prefetchCache {
+		if !wanted[host] {
+			delete(prefetchCache, host)
+		}
+	}
+	var fresh []string
+	for host := range wanted {
+		if _, ok := prefetchCache[host]; !ok {
+			prefetchCache[host] = &prefetchEntry{}
+			fresh = append(fresh, host)
+		}
+	}
+	prefetchMu.Unlock()
+
+	for _, host := range fresh {
+		go refreshPrefetch(host)
+	}
+	prefetchOnce.Do(func() { go prefetchLoop() })
+}
+
+// prefetchLoop refreshes cached names shortly before their TTL expires.
+func prefetchLoop() {
+	for range time.Tick(time.Second) {
+		now := time.Now()
+		var due []string
+		prefetchMu.RLock()
+		for host, e := range prefetchCache {
+			if !e.refreshAt.IsZero() && now.After(e.refreshAt) {
+				due = append(due, host)
+			}
+		}
+		prefetchMu.RUnlock()
+		for _, host := range due {
+			refreshPrefetch(host)
+		}
+	}
+}
+
+func refreshPrefetch(host string) {
+	ips, ttl, err := resolveWithTTL(host)
+
+	prefetchMu.Lock()
+	defer prefetchMu.Unlock()
+	e, ok := prefetchCache[host]
+	if !ok {
+		return
+	}
+	if err != nil {
+		// Keep serving the stale answer and try again soon
+		e.refreshAt = time.Now().Add(10 * time.Second)
+		log.Printf("[PREFETCH] Failed to resolve %s: %v", host, err)
+		return
+	}
+	// Refresh at 80% of the TTL so the cache never runs dry
+	ttl = min(max(ttl*4/5, prefetchMinTTL), prefetchMaxTTL)
+	e.ips = ips
+	e.refreshAt = time.Now().Add(ttl)
+	if verboseMode {
+		log.Printf("[PREFETCH] %s -> %v (refresh in %s)", host, ips, ttl)
+	}
+}
+
+// resolveWithTTL resolves host with the system resolver, so /etc/hosts
+// and nsswitch pick the addresses as they do for any dial, and asks the
+// configured nameserver for the record TTL.
+func resolveWithTTL(host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := prefetchLookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ttl, ok := answerTTL(queryNameserver(host), ips); ok {
+		return ips, ttl, nil
+	}
+	return ips, prefetchDefaultTTL, nil
+}
+
+// queryNameserver asks the first nameserver in resolvConf for host's A
+// and AAAA records.
+func queryNameserver(host string) []dns.RR {
+	conf, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil || len(conf.Servers) == 0 {
+		return nil
+	}
+	client := &dns.Client{Timeout: 3 * time.Second}
+	server := net.JoinHostPort(conf.Servers[0], conf.Port)
+	var answers []dns.RR
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(host), qtype)
+		resp, _, err := client.Exchange(m, server)
+		if err != nil || resp.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		answers = append(answers, resp.Answer...)
+	}
+	return answers
+}
+
+// answerTTL is the lowest TTL of the records answering with ips. The TTL
+// only applies when the records cover every address; otherwise the
+// addresses came from elsewhere, such as /etc/hosts.
+func answerTTL(answers []dns.RR, ips []net.IP) (time.Duration, bool) {
+	if len(ips) == 0 {
+		return 0, false
+	}
+	ttl := prefetchMaxTTL
+	for _, ip := range ips {
+		found := false
+		for _, rr := range answers {
+			var addr net.IP
+			switch rec := rr.(type) {
+			case *dns.A:
+				addr = rec.A
+			case *dns.AAAA:
+				addr = rec.AAAA
+			default:
+				continue
+			}
+			if addr.Equal(ip) {
+				found = true
+				ttl = min(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return ttl, true
+}
+
+// prefetchedIPs returns the cached addresses for host, if any.
+func prefetchedIPs(host string) []net.IP {
+	prefetchMu.RLock()
+	defer prefetchMu.RUnlock()
+	if e, ok := prefetchCache[host]; ok {
+		return e.ips
+	}
+	return nil
+}
+
+// prefetchDial wraps a dialer so prefetched targets are dialed by IP
+// without blocking on name resolution.
+func prefetchDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		ips := prefetchedIPs(host)
+		if len(ips) == 0 {
+			return dial(ctx, network, addr)
+		}
+		var errs []error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
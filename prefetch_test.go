@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolveWithTTLUsesSystemResolver(t *testing.T) {
+	lookup, conf := prefetchLookupIP, resolvConf
+	t.Cleanup(func() { prefetchLookupIP, resolvConf = lookup, conf })
+	// An address pinned in /etc/hosts, with no nameserver to ask
+	prefetchLookupIP = func(_ context.Context, _, host string) ([]net.IP, error) {
+		if host != "target.internal" {
+			t.Errorf("looked up %q", host)
+		}
+		return []net.IP{net.IPv4(10, 9, 9, 9)}, nil
+	}
+	resolvConf = filepath.Join(t.TempDir(), "resolv.conf")
+
+	ips, ttl, err := resolveWithTTL("target.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(10, 9, 9, 9)) || ttl != prefetchDefaultTTL {
+		t.Errorf("got %v with TTL %s, want [10.9.9.9] with %s", ips, ttl, prefetchDefaultTTL)
+	}
+}
+
+func TestAnswerTTL(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	answers := []dns.RR{
+		rr("app.test. 300 IN A 203.0.113.9"),
+		rr("app.test. 120 IN A 203.0.113.10"),
+		rr("app.test. 600 IN AAAA 2001:db8::9"),
+		rr("app.test. 30 IN CNAME edge.test."),
+	}
+	tests := []struct {
+		name    string
+		ips     []string
+		wantTTL time.Duration
+		wantOK  bool
+	}{
+		{"one address", []string{"203.0.113.9"}, 300 * time.Second, true},
+		{"lowest TTL of the addresses", []string{"203.0.113.9", "203.0.113.10", "2001:db8::9"}, 120 * time.Second, true},
+		{"address pinned elsewhere", []string{"10.9.9.9"}, 0, false},
+		{"some addresses pinned elsewhere", []string{"203.0.113.9", "10.9.9.9"}, 0, false},
+		{"no addresses", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, s := range tt.ips {
+				ips = append(ips, net.ParseIP(s))
+			}
+			ttl, ok := answerTTL(answers, ips)
+			if ttl != tt.wantTTL || ok != tt.wantOK {
+				t.Errorf("answerTTL = %s, %v, want %s, %v", ttl, ok, tt.wantTTL, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+)
+
+var (
+	// Named bundle of flag defaults for a common scenario
+	presetName string
+
+	// TTL of DNS answers pointing a routed host at the relay
+	dnsTTL uint
+
+	// Forward requests for hosts without a route to their real destination
+	forwardUnmatched bool
+)
+
+// presets holds the flag values each preset sets. Flags given on the
+// command line always win.
+var presets = map[string]map[string]string{
+	// Browsers cache DNS for about a minute and pin connections, so answers
+	// expire immediately and both HTTP and HTTPS are served
+	"browser-rebind": {
+		"dns":           "true",
+		"dns-ttl":       "1",
+		"tls-port":      "443",
+		"no-keep-alive": "true",
+	},
+	// Embedded resolvers often ignore short TTLs but honour zero; device
+	// HTTP stacks rarely handle compression or slow upstreams
+	"iot-rebind": {
+		"dns":              "true",
+		"dns-ttl":          "0",
+		"compression":      "identity",
+		"upstream-timeout": "30s",
+		"verbose":          "true",
+	},
+	// Answer routed names for long and log every lookup
+	"sinkhole": {
+		"dns":     "true",
+		"dns-ttl": "300",
+		"verbose": "true",
+	},
+	// Relay routed hosts and pass everything else through untouched
+	"forward-proxy": {
+		"port":              "8080",
+		"forward-unmatched": "true",
+		"http2":             "true",
+	},
+}
+
+// --- Preset Logic ---
+
+// applyPreset sets the flags of -preset that were not given explicitly.
+func applyPreset() {
+	if presetName == "" {
+		return
+	}
+	values, ok := presets[presetName]
+	if !ok {
+		log.Fatalf("Unknown -preset %q (one of: %s)", presetName, strings.Join(presetNames(), ", "))
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var applied []string
+	for _, name := range sortedKeys(values) {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, values[name]); err != nil {
+			log.Fatalf("Invalid preset %s: -%s=%s: %v", presetName, name, values[name], err)
+		}
+		applied = append(applied, "-"+name+"="+values[name])
+	}
+	log.Printf("[PRESET] %s: %s", presetName, strings.Join(applied, " "))
+}
+
+func presetNames() []string {
+	return sortedKeys(presets)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errorClass categorises why a proxied request failed.
+type errorClass string
+
+const (
+	errClientAbort     errorClass = "client_abort"     // the client went away first
+	errDialTimeout     errorClass = "dial_timeout"     // upstream did not accept in time
+	errDialFailed      errorClass = "dial_failed"      // refused, unreachable or unresolvable
+	errTLSFailure      errorClass = "tls_failure"      // handshake or certificate error
+	errUpstreamReset   errorClass = "upstream_reset"   // connection reset or closed mid-response
+	errUpstreamTimeout errorClass = "upstream_timeout" // upstream accepted but did not answer in time
+	errUpstreamLimited errorClass = "upstream_limit"   // -max-upstream-conns reached
+	errBlocked         errorClass = "response_blocked" // refused by content_types or max_response_bytes
+	errOther           errorClass = "other"
+)
+
+// Directory of per-class error pages (<class>.html or <class>.json)
+var errorPagesDir string
+
+type errorPage struct {
+	contentType string
+	body        []byte
+}
+
+var errorPages = make(map[errorClass]errorPage)
+
+// --- Proxy Error Logic ---
+
+// classifyProxyError maps a transport error onto an errorClass.
+func classifyProxyError(r *http.Request, err error) errorClass {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		return errClientAbort
+	}
+	if errors.Is(err, errUpstreamLimit) {
+		return errUpstreamLimited
+	}
+	if errors.Is(err, errResponseBlocked) {
+		return errBlocked
+	}
+
+	var (
+		opErr      *net.OpError
+		dnsErr     *net.DNSError
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+		unknownCA  x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+		channelErr *ssh.OpenChannelError
+	)
+	switch {
+	case errors.As(err, &dnsErr), errors.As(err, &channelErr):
+		return errDialFailed
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		if opErr.Timeout() {
+			return errDialTimeout
+		}
+		return errDialFailed
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownCA), errors.As(err, &hostErr), errors.As(err, &invalidErr),
+		strings.HasPrefix(err.Error(), "tls: "):
+		return errTLSFailure
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errUpstreamReset
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return errUpstreamTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errUpstreamTimeout
+	}
+	return errOther
+}
+
+// status returns the response code sent to the client for this class.
+func (c errorClass) status() int {
+	switch c {
+	case errDialTimeout, errUpstreamTimeout:
+		return http.StatusGatewayTimeout
+	case errUpstreamLimited:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// loadErrorPages reads the custom error pages from -error-pages.
+func loadErrorPages() {
+	if errorPagesDir == "" {
+		return
+	}
+	for _, class := range []errorClass{errDialTimeout, errDialFailed, errTLSFailure, errUpstreamReset, errUpstreamTimeout, errUpstreamLimited, errBlocked, errOther} {
+		for ext, contentType := range map[string]string{".html": "text/html; charset=utf-8", ".json": "application/json"} {
+			data, err := os.ReadFile(filepath.Join(errorPagesDir, string(class)+ext))
+			if err != nil {
+				continue
+			}
+			errorPages[class] = errorPage{contentType: contentType, body: data}
+			log.Printf("Loaded error page for %s", class)
+		}
+	}
+}
+
+// handleProxyError is the ReverseProxy ErrorHandler.
+func handleProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	class := classifyProxyError(r, err)
+	if info := getRequestInfo(r); info != nil && info.compare != nil {
+		info.compare.deliverPrimary(compareResult{err: err})
+	}
+	stats.recordProxyError(class)
+	if class == errClientAbort {
+		if verboseMode {
+			logRequest(r, "[ERROR] Client aborted request for %s", r.Host)
+		}
+		return
+	}
+	logRequest(r, "[ERROR] Proxy Error (%s) for %s: %v", class, r.Host, err)
+
+	page, ok := errorPages[class]
+	if info := getRequestInfo(r); info != nil && info.matched != nil {
+		if routePage, found := info.matched.errorPages[class.status()]; found {
+			page, ok = routePage, true
+		}
+	}
+	if ok {
+		w.Header().Set("Content-Type", page.contentType)
+		w.WriteHeader(class.status())
+		_, _ = w.Write(page.body)
+		return
+	}
+	w.WriteHeader(class.status())
+}
+
+// --- Per-route Error Pages and Status Mapping Logic ---
+
+// statusRewrite is a parsed status_map entry.
+type statusRewrite struct {
+	status int
+	page   errorPage
+}
+
+// loadResponsePages reads the files named by a route's error_pages and
+// status_map so bad paths are rejected when the route is loaded.
+func (rt *route) loadResponsePages() error {
+	if len(rt.ErrorPages) > 0 {
+		rt.errorPages = make(map[int]errorPage, len(rt.ErrorPages))
+	}
+	for code, file := range rt.ErrorPages {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return fmt.Errorf("invalid error_pages status %q", code)
+		}
+		page, err := readErrorPage(file)
+		if err != nil {
+			return err
+		}
+		rt.errorPages[status] = page
+	}
+
+	if len(rt.StatusMap) > 0 {
+		rt.statusMap = make(map[int]statusRewrite, len(rt.StatusMap))
+	}
+	for code, rewrite := range rt.StatusMap {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return fmt.Errorf("invalid status_map status %q", code)
+		}
+		if rewrite.Status != 0 && http.StatusText(rewrite.Status) == "" {
+			return fmt.Errorf("invalid status_map replacement status %d", rewrite.Status)
+		}
+		page, err := readErrorPage(rewrite.Body)
+		if err != nil {
+			return err
+		}
+		rt.statusMap[status] = statusRewrite{status: rewrite.Status, page: page}
+	}
+	return nil
+}
+
+func readErrorPage(file string) (errorPage, error) {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return errorPage{}, fmt.Errorf("failed to read page: %w", err)
+	}
+	return errorPage{contentType: assetContentType(file, body), body: body}, nil
+}
+
+// rewriteStatus applies a route's status_map, e.g. swapping an upstream 401 for a decoy login page.
+func rewriteStatus(resp *http.Response) error {
+	info := getRequestInfo(resp.Request)
+	if info == nil || info.matched == nil {
+		return nil
+	}
+	rewrite, ok := info.matched.statusMap[resp.StatusCode]
+	if !ok {
+		return nil
+	}
+	status := resp.StatusCode
+	if rewrite.status != 0 {
+		status = rewrite.status
+	}
+	logRequest(resp.Request, "[STATUS-MAP] %s upstream %d -> %d", info.route, resp.StatusCode, status)
+
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(rewrite.page.body))
+	resp.ContentLength = int64(len(rewrite.page.body))
+	for _, h := range []string{"Content-Encoding", "Content-Range", "ETag", "Last-Modified", "WWW-Authenticate"} {
+		resp.Header.Del(h)
+	}
+	resp.Header.Set("Content-Type", rewrite.page.contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(rewrite.page.body)))
+	resp.StatusCode = status
+	resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	return nil
+}
@@ -0,0 +1,260 @@
+// Package rebindtest runs an in-process DNS and HTTP relay on ephemeral
+// loopback ports, for integration tests of SSRF and DNS rebinding defenses.
+//
+// A test routes hostnames to its own servers and scripts the DNS answers a
+// name returns on successive lookups:
+//
+//	relay := rebindtest.New(t)
+//	relay.Route("victim.test", internal.URL)
+//	relay.Rebind("victim.test", "93.184.216.34", "127.0.0.1")
+//
+//	fetcher := myapp.NewFetcher(relay.Resolver())
+//	_, err := fetcher.Get("http://victim.test/")
+//	// the first lookup passes the allowlist check; the fetcher must not
+//	// connect to the second
+//
+// Client returns an HTTP client that sends every request to the relay, as a
+// browser pointed at it by DNS would.
+//
+// Names without a route or rebind script get NXDOMAIN, so tests never reach
+// real DNS.
+package rebindtest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Relay is a running fixture. DNSAddr and HTTPAddr are host:port pairs on
+// 127.0.0.1; URL is the HTTP proxy's base URL.
+type Relay struct {
+	DNSAddr  string
+	HTTPAddr string
+	URL      string
+
+	// TTL of every DNS answer, in seconds (default 0)
+	TTL uint32
+
+	mu      sync.Mutex
+	routes  map[string]*url.URL
+	answers map[string][]net.IP
+	queries map[string]int
+	lookups map[string]int // per host and query type, positions in the script
+	hits    map[string]int
+
+	dnsServer  *dns.Server
+	httpServer *http.Server
+}
+
+// Start launches a relay. Close stops it.
+func Start() (*Relay, error) {
+	r := &Relay{
+		routes:  make(map[string]*url.URL),
+		answers: make(map[string][]net.IP),
+		queries: make(map[string]int),
+		lookups: make(map[string]int),
+		hits:    make(map[string]int),
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	r.DNSAddr, r.HTTPAddr = pc.LocalAddr().String(), ln.Addr().String()
+	r.URL = "http://" + r.HTTPAddr
+
+	started := make(chan struct{})
+	r.dnsServer = &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(r.serveDNS), NotifyStartedFunc: func() { close(started) }}
+	go func() { _ = r.dnsServer.ActivateAndServe() }()
+	r.httpServer = &http.Server{Handler: &httputil.ReverseProxy{Rewrite: r.rewrite, ErrorHandler: proxyError}}
+	go func() { _ = r.httpServer.Serve(ln) }()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		r.Close()
+		return nil, errors.New("rebindtest: DNS server did not start")
+	}
+	return r, nil
+}
+
+// New starts a relay that is closed when the test ends, failing the test
+// if it cannot start.
+func New(tb testing.TB) *Relay {
+	tb.Helper()
+	r, err := Start()
+	if err != nil {
+		tb.Fatalf("rebindtest: %v", err)
+	}
+	tb.Cleanup(r.Close)
+	return r
+}
+
+// Close stops both servers.
+func (r *Relay) Close() {
+	_ = r.dnsServer.Shutdown()
+	_ = r.httpServer.Close()
+}
+
+// Route proxies HTTP requests for host to target (e.g. an httptest server's
+// URL). Unless Rebind scripts other answers, host resolves to 127.0.0.1.
+func (r *Relay) Route(host, target string) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		panic("rebindtest: invalid target " + target)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[canonical(host)] = u
+}
+
+// Rebind scripts the addresses host resolves to: the nth A or AAAA query
+// gets the nth address, and the last one repeats. An address of the other
+// family gives an empty answer, so a lookup sending both queries sees one
+// address per step.
+func (r *Relay) Rebind(host string, ips ...string) {
+	parsed := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		if parsed[i] = net.ParseIP(ip); parsed[i] == nil {
+			panic("rebindtest: invalid IP " + ip)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	host = canonical(host)
+	r.answers[host] = parsed
+	r.queries[host] = 0
+	delete(r.lookups, host+"/A")
+	delete(r.lookups, host+"/AAAA")
+}
+
+// Queries returns how many A and AAAA queries host has received.
+func (r *Relay) Queries(host string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.queries[canonical(host)]
+}
+
+// Hits returns how many HTTP requests were proxied for host.
+func (r *Relay) Hits(host string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hits[canonical(host)]
+}
+
+// Resolver returns a resolver that asks only the relay.
+func (r *Relay) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", r.DNSAddr)
+		},
+	}
+}
+
+// Client returns an HTTP client that sends every request to the relay,
+// whatever host the URL names, as a victim pointed at it would.
+func (r *Relay) Client() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, r.HTTPAddr)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func (r *Relay) serveDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	for _, q := range req.Question {
+		if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+			continue
+		}
+		ip, known := r.answer(canonical(q.Name), q.Qtype)
+		if !known {
+			m.Rcode = dns.RcodeNameError
+			continue
+		}
+		if ip == nil {
+			continue
+		}
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: r.TTL}
+		if q.Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip})
+		} else {
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	_ = w.WriteMsg(m)
+}
+
+// answer returns the address for the next lookup of host, if the script
+// has one of the query's family. known is false for unrouted names.
+func (r *Relay) answer(host string, qtype uint16) (ip net.IP, known bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	script, scripted := r.answers[host]
+	_, routed := r.routes[host]
+	if !scripted && !routed {
+		return nil, false
+	}
+	key := host + "/" + dns.TypeToString[qtype]
+	n := r.lookups[key]
+	r.lookups[key]++
+	r.queries[host]++
+	if !scripted {
+		script = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	ip = script[min(n, len(script)-1)]
+	if (ip.To4() != nil) != (qtype == dns.TypeA) {
+		return nil, true
+	}
+	return ip, true
+}
+
+func (r *Relay) rewrite(pr *httputil.ProxyRequest) {
+	host := canonical(pr.In.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	r.mu.Lock()
+	target, ok := r.routes[host]
+	if ok {
+		r.hits[host]++
+	}
+	r.mu.Unlock()
+	if !ok {
+		// An empty URL host makes the transport fail; proxyError answers 502
+		return
+	}
+	pr.SetURL(target)
+	pr.Out.Host = pr.In.Host
+}
+
+func proxyError(w http.ResponseWriter, _ *http.Request, err error) {
+	http.Error(w, "rebindtest: "+err.Error(), http.StatusBadGateway)
+}
+
+func canonical(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,171 @@
+package rebindtest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"goRebind/rebindtest"
+)
+
+// internalServer stands in for a service an SSRF must not reach, recording
+// the Host header of each request.
+func internalServer(t *testing.T, hosts *[]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hosts = append(*hosts, r.Host)
+		io.WriteString(w, "internal "+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func lookup4(t *testing.T, relay *rebindtest.Relay, host string) (string, error) {
+	t.Helper()
+	ips, err := relay.Resolver().LookupIP(context.Background(), "ip4", host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) != 1 {
+		t.Fatalf("lookup %s: got %v, want one address", host, ips)
+	}
+	return ips[0].String(), nil
+}
+
+func TestRouteProxiesToTarget(t *testing.T) {
+	var hosts []string
+	srv := internalServer(t, &hosts)
+	relay := rebindtest.New(t)
+	relay.Route("Victim.Test", srv.URL)
+
+	resp, err := relay.Client().Get("http://victim.test/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "internal /admin" {
+		t.Fatalf("got %d %q, want 200 %q", resp.StatusCode, body, "internal /admin")
+	}
+	if len(hosts) != 1 || hosts[0] != "victim.test" {
+		t.Errorf("target saw Host %v, want [victim.test]", hosts)
+	}
+	if n := relay.Hits("victim.test"); n != 1 {
+		t.Errorf("Hits = %d, want 1", n)
+	}
+}
+
+func TestUnroutedHostGets502(t *testing.T) {
+	relay := rebindtest.New(t)
+	resp, err := relay.Client().Get("http://nowhere.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status = %d, want 502", resp.StatusCode)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	relay := rebindtest.New(t)
+	relay.Route("routed.test", "http://127.0.0.1:1")
+	relay.Rebind("victim.test", "93.184.216.34", "127.0.0.1")
+	relay.Rebind("v6.test", "::1")
+
+	tests := []struct {
+		name string
+		host string
+		want []string // successive A answers
+	}{
+		{"rebind script then last answer repeats", "victim.test", []string{"93.184.216.34", "127.0.0.1", "127.0.0.1"}},
+		{"routed host defaults to loopback", "routed.test", []string{"127.0.0.1", "127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				got, err := lookup4(t, relay, tt.host)
+				if err != nil {
+					t.Fatalf("lookup %d: %v", i+1, err)
+				}
+				if got != want {
+					t.Errorf("lookup %d = %s, want %s", i+1, got, want)
+				}
+			}
+			if n := relay.Queries(tt.host); n != len(tt.want) {
+				t.Errorf("Queries = %d, want %d", n, len(tt.want))
+			}
+		})
+	}
+
+	t.Run("unknown name is NXDOMAIN", func(t *testing.T) {
+		_, err := lookup4(t, relay, "nowhere.test")
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("err = %v, want not found", err)
+		}
+	})
+	t.Run("other family gets an empty answer", func(t *testing.T) {
+		if _, err := lookup4(t, relay, "v6.test"); err == nil {
+			t.Error("A lookup of an IPv6-only script succeeded")
+		}
+		ips, err := relay.Resolver().LookupIP(context.Background(), "ip6", "v6.test")
+		if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv6loopback) {
+			t.Errorf("AAAA lookup = %v, %v, want [::1]", ips, err)
+		}
+	})
+	t.Run("Rebind restarts the script", func(t *testing.T) {
+		relay.Rebind("victim.test", "198.51.100.7")
+		if got, err := lookup4(t, relay, "victim.test"); err != nil || got != "198.51.100.7" {
+			t.Errorf("lookup = %s, %v, want 198.51.100.7", got, err)
+		}
+	})
+}
+
+// TestTimeOfCheckTimeOfUse runs the scenario the fixture exists for: a
+// fetcher that validates the first resolution and connects with a second
+// one reaches the internal service through the relay.
+func TestTimeOfCheckTimeOfUse(t *testing.T) {
+	var hosts []string
+	srv := internalServer(t, &hosts)
+	relay := rebindtest.New(t)
+	relay.Route("victim.test", srv.URL)
+	relay.Rebind("victim.test", "93.184.216.34", "127.0.0.1")
+
+	_, port, _ := net.SplitHostPort(relay.HTTPAddr)
+	target := "http://victim.test:" + port + "/secret"
+
+	checked, err := lookup4(t, relay, "victim.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if net.ParseIP(checked).IsLoopback() {
+		t.Fatalf("first answer %s should pass an allowlist check", checked)
+	}
+
+	dialer := &net.Dialer{Resolver: relay.Resolver()}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp4", addr)
+		},
+	}}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "internal /secret" {
+		t.Errorf("body = %q, want the internal service's answer", body)
+	}
+	if n := relay.Hits("victim.test"); n != 1 {
+		t.Errorf("Hits = %d, want 1", n)
+	}
+	if n := relay.Queries("victim.test"); n != 2 {
+		t.Errorf("Queries = %d, want 2", n)
+	}
+}
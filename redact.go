@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"goRebind/adminclient"
+)
+
+type Redaction = adminclient.Redaction
+
+// Redaction actions
+const (
+	// Replace the field's value with the mask (default)
+	redactMask = "mask"
+	// Remove the field altogether
+	redactStrip = "strip"
+)
+
+// Default replacement for masked values
+const defaultRedactMask = "[REDACTED]"
+
+// Largest response body the relay buffers to redact; bigger ones fail
+const maxRedactBody = 32 << 20
+
+// Bytes of an untyped or otherwise typed body looked at for JSON or XML
+const sniffLen = 512
+
+// UTF-8 byte order mark some servers put before a document
+const utf8BOM = "\xef\xbb\xbf"
+
+// --- Response Redaction Logic ---
+
+// parseRedaction validates the route's redact rule and splits its fields
+// into name paths.
+func (rt *route) parseRedaction() error {
+	r := rt.Redact
+	if r == nil {
+		return nil
+	}
+	switch r.Action {
+	case "", redactMask, redactStrip:
+	default:
+		return fmt.Errorf("invalid redact action %q (want mask or strip)", r.Action)
+	}
+	if len(r.Fields) == 0 {
+		return fmt.Errorf("redact needs at least one field")
+	}
+	for _, f := range r.Fields {
+		parts := strings.Split(f, ".")
+		if slices.Contains(parts, "") {
+			return fmt.Errorf("invalid redact field %q", f)
+		}
+		rt.redactFields = append(rt.redactFields, parts)
+	}
+	return nil
+}
+
+// redactRequest asks upstream for whole, uncompressed bodies on routes that
+// redact responses, since only those can be rewritten.
+func redactRequest(req *http.Request, rt *route) {
+	if rt.Redact == nil {
+		return
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Del("Range")
+	req.Header.Del("If-Range")
+}
+
+// redactResponse rewrites JSON and XML response bodies with the route's
+// fields masked or removed. Bodies of other or missing content types are
+// sniffed, so a document sent under the wrong type is redacted all the
+// same. Bodies that cannot be redacted fail the request rather than reach
+// the client unredacted.
+func redactResponse(resp *http.Response, info *requestInfo) error {
+	if info == nil || info.matched == nil || info.matched.Redact == nil {
+		return nil
+	}
+	rt := info.matched
+	switch resp.StatusCode {
+	case http.StatusSwitchingProtocols, http.StatusNoContent, http.StatusNotModified:
+		return nil
+	}
+	if resp.Request.Method == http.MethodHead {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var redact func([]byte, *route) ([]byte, error)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		redact = redactJSON
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		redact = redactXML
+	}
+
+	body := resp.Body
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = readCloser{zr, resp.Body}
+	case "deflate":
+		zr, err := zlib.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = readCloser{zr, resp.Body}
+	case "", "identity":
+	default:
+		return fmt.Errorf("redact: cannot decode %s response body", resp.Header.Get("Content-Encoding"))
+	}
+	if redact == nil {
+		br := bufio.NewReaderSize(body, sniffLen)
+		body = readCloser{br, body}
+		if redact = sniffRedactor(sniffHead(br)); redact == nil {
+			// Not a document: pass it on, decoded if it was encoded
+			resp.Body = body
+			if encoding != "" && encoding != "identity" {
+				resp.ContentLength = -1
+				resp.Header.Del("Content-Length")
+				resp.Header.Del("Content-Encoding")
+			}
+			return nil
+		}
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxRedactBody+1))
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if len(data) > maxRedactBody {
+		return fmt.Errorf("redact: response body larger than %d MiB", maxRedactBody>>20)
+	}
+	out, err := redact(data, rt)
+	if err != nil {
+		return fmt.Errorf("redact: %w", err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(out))
+	resp.ContentLength = int64(len(out))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("ETag")
+	resp.TransferEncoding = nil
+	return nil
+}
+
+// sniffHead peeks at the start of a body without waiting on a stream for
+// more than it takes to tell a document from anything else: the first
+// character past any whitespace, and for a tag enough to tell HTML.
+func sniffHead(br *bufio.Reader) []byte {
+	head, _ := br.Peek(1)
+	for len(head) > 0 && len(head) < sniffLen {
+		if start := trimSniff(head); len(start) > 0 && (start[0] != '<' || len(start) >= 16) {
+			break
+		}
+		more, err := br.Peek(len(head) + 1)
+		if err != nil {
+			break
+		}
+		head = more
+	}
+	head, _ = br.Peek(br.Buffered())
+	return head
+}
+
+// trimSniff drops a byte order mark and leading whitespace.
+func trimSniff(head []byte) []byte {
+	return bytes.TrimLeft(bytes.TrimPrefix(head, []byte(utf8BOM)), " \t\r\n")
+}
+
+// sniffRedactor picks the redactor for a body by its first bytes: JSON
+// starts with an object or array, XML with a tag that is not HTML.
+func sniffRedactor(head []byte) func([]byte, *route) ([]byte, error) {
+	head = trimSniff(head)
+	switch {
+	case len(head) == 0:
+		return nil
+	case head[0] == '{' || head[0] == '[':
+		return redactJSON
+	case head[0] == '<' && !strings.HasPrefix(http.DetectContentType(head), "text/html"):
+		return redactXML
+	}
+	return nil
+}
+
+// redacts reports whether the field at path is one of the route's: a
+// field matches when path ends with its names.
+func (rt *route) redacts(path []string) bool {
+	for _, f := range rt.redactFields {
+		if len(f) <= len(path) && slices.Equal(f, path[len(path)-len(f):]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask is the replacement for masked values.
+func (rt *route) mask() string {
+	return valueOr(rt.Redact.Mask, defaultRedactMask)
+}
+
+// redactJSON rewrites a JSON document token by token, keeping key order.
+// Arrays are transparent to field paths.
+func redactJSON(data []byte, rt *route) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, []byte(utf8BOM))))
+	dec.UseNumber()
+	var out bytes.Buffer
+	for first := true; ; first = false {
+		if !first && dec.More() {
+			// Keep newline-delimited documents apart
+			out.WriteByte('\n')
+		}
+		err := redactJSONValue(dec, &out, nil, rt)
+		if errors.Is(err, io.EOF) {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// redactJSONValue copies one value from dec to out, redacting the fields
+// within it; path names the value's enclosing keys.
+func redactJSONValue(dec *json.Decoder, out *bytes.Buffer, path []string, rt *route) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return writeJSONToken(out, tok)
+	}
+	out.WriteString(delim.String())
+	first := true
+	for dec.More() {
+		if delim == '[' {
+			if !first {
+				out.WriteByte(',')
+			}
+			first = false
+			if err := redactJSONValue(dec, out, path, rt); err != nil {
+				return err
+			}
+			continue
+		}
+		keyTok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := keyTok.(string)
+		field := append(path[:len(path):len(path)], key)
+		if rt.redacts(field) && rt.Redact.Action == redactStrip {
+			if err := skipJSONValue(dec); err != nil {
+				return err
+			}
+			continue
+		}
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		if err := writeJSONToken(out, key); err != nil {
+			return err
+		}
+		out.WriteByte(':')
+		if rt.redacts(field) {
+			if err := skipJSONValue(dec); err != nil {
+				return err
+			}
+			if err := writeJSONToken(out, rt.mask()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := redactJSONValue(dec, out, field, rt); err != nil {
+			return err
+		}
+	}
+	// Closing delimiter
+	end, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	out.WriteString(end.(json.Delim).String())
+	return nil
+}
+
+// skipJSONValue consumes one value, however deeply nested.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// writeJSONToken appends a value as JSON without HTML escaping.
+func writeJSONToken(out *bytes.Buffer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	// Encode ends every value with a newline
+	out.Truncate(out.Len() - 1)
+	return nil
+}
+
+// redactXML rewrites an XML document token by token. Element paths run from
+// the root element; an attribute's path is its element's plus its name.
+// Masked elements keep their attributes and have their content replaced.
+func redactXML(data []byte, rt *route) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+	var path []string
+	for {
+		tok, err := dec.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			if rt.redacts(path) && rt.Redact.Action == redactStrip {
+				if err := skipXMLElement(dec); err != nil {
+					return nil, err
+				}
+				path = path[:len(path)-1]
+				continue
+			}
+			t = rawStart(t)
+			attrs := t.Attr[:0]
+			for _, a := range t.Attr {
+				if a.Name.Local != "xmlns" && !strings.HasPrefix(a.Name.Local, "xmlns:") && rt.redacts(append(path[:len(path):len(path)], localName(a.Name.Local))) {
+					if rt.Redact.Action == redactStrip {
+						continue
+					}
+					a.Value = rt.mask()
+				}
+				attrs = append(attrs, a)
+			}
+			t.Attr = attrs
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, err
+			}
+			if rt.redacts(path) {
+				if err := skipXMLElement(dec); err != nil {
+					return nil, err
+				}
+				path = path[:len(path)-1]
+				if err := enc.EncodeToken(xml.CharData(rt.mask())); err != nil {
+					return nil, err
+				}
+				if err := enc.EncodeToken(t.End()); err != nil {
+					return nil, err
+				}
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+			t.Name = rawName(t.Name)
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, err
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				// The encoder only accepts the declaration first, as written
+				if err := enc.Flush(); err != nil {
+					return nil, err
+				}
+				fmt.Fprintf(&out, "<?xml %s?>", t.Inst)
+				continue
+			}
+			if err := enc.EncodeToken(t); err != nil {
+				return nil, err
+			}
+		default:
+			if err := enc.EncodeToken(tok); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// skipXMLElement consumes the rest of an element read with RawToken.
+func skipXMLElement(dec *xml.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.RawToken()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+// rawStart rewrites a raw start element's prefixed names as plain names so
+// the encoder writes them back verbatim instead of inventing namespaces.
+func rawStart(t xml.StartElement) xml.StartElement {
+	t.Name = rawName(t.Name)
+	for i, a := range t.Attr {
+		t.Attr[i].Name = rawName(a.Name)
+	}
+	return t
+}
+
+func rawName(n xml.Name) xml.Name {
+	if n.Space == "" {
+		return n
+	}
+	return xml.Name{Local: n.Space + ":" + n.Local}
+}
+
+// localName drops a name's namespace prefix.
+func localName(name string) string {
+	_, local, found := strings.Cut(name, ":")
+	if !found {
+		return name
+	}
+	return local
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedactResponseContentTypes(t *testing.T) {
+	rt, err := newRoute(ConfigRoute{Source: "api.test", Target: "http://10.0.0.2", Redact: &Redaction{Fields: []string{"email"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		doc      = `{"id":7,"email":"ann@example.com"}`
+		redacted = `{"id":7,"email":"[REDACTED]"}`
+	)
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        string
+		want        string
+		wantErr     bool
+	}{
+		{"JSON", "application/json", "", doc, redacted, false},
+		{"JSON suffix type", "application/vnd.api+json", "", doc, redacted, false},
+		{"JSON as text/plain", "text/plain", "", doc, redacted, false},
+		{"JSON as text/json", "text/json", "", doc, redacted, false},
+		{"JSON as application/x-json", "application/x-json", "", doc, redacted, false},
+		{"JSON without a Content-Type", "", "", "\ufeff\n  " + doc, redacted, false},
+		{"JSON array as octet-stream", "application/octet-stream", "", "[" + doc + "]", "[" + redacted + "]", false},
+		{"gzipped JSON as text/plain", "text/plain", "gzip", doc, redacted, false},
+		{"XML as text/plain", "text/plain", "", "<user><email>ann@example.com</email></user>", "<user><email>[REDACTED]</email></user>", false},
+		{"XML without a Content-Type", "", "", `<?xml version="1.0"?><email>ann@example.com</email>`, `<?xml version="1.0"?><email>[REDACTED]</email>`, false},
+		{"HTML passes through", "text/html", "", "<!DOCTYPE html><p>ann@example.com</p>", "<!DOCTYPE html><p>ann@example.com</p>", false},
+		{"plain text passes through", "text/plain", "", "email: ann@example.com", "email: ann@example.com", false},
+		{"empty body", "", "", "", "", false},
+		{"gzipped text is passed on decoded", "text/plain", "gzip", "email: ann@example.com", "email: ann@example.com", false},
+		{"JSON-looking body that does not parse", "text/plain", "", `{"email": "ann@`, "", true},
+		{"declared JSON that does not parse", "application/json", "", "email: ann@example.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(tt.body)
+			if tt.encoding == "gzip" {
+				var buf bytes.Buffer
+				zw := gzip.NewWriter(&buf)
+				zw.Write(body)
+				zw.Close()
+				body = buf.Bytes()
+			}
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       io.NopCloser(bytes.NewReader(body)),
+				Request:    httptest.NewRequest("GET", "http://api.test/", nil),
+			}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+			err := redactResponse(resp, &requestInfo{matched: rt})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("redactResponse succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+				t.Errorf("Content-Encoding %q left on a decoded body", enc)
+			}
+		})
+	}
+}
+
+// TestRedactResponseStreams checks that sniffing an untyped stream does not
+// wait for more of it than the first event.
+func TestRedactResponseStreams(t *testing.T) {
+	rt, err := newRoute(ConfigRoute{Source: "api.test", Target: "http://10.0.0.2", Redact: &Redaction{Fields: []string{"email"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("data: ready\n\n"))
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/event-stream"}},
+		Body:       pr,
+		Request:    httptest.NewRequest("GET", "http://api.test/events", nil),
+	}
+	if err := redactResponse(resp, &requestInfo{matched: rt}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, _ := resp.Body.Read(buf)
+	if got := string(buf[:n]); got != "data: ready\n\n" {
+		t.Errorf("first read = %q", got)
+	}
+}
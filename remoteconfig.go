@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// How often remote config files are fetched again (0 disables)
+	configRefresh time.Duration
+	// Extra headers (e.g. Authorization) sent when fetching remote configs
+	configHeaders stringList
+
+	// Last response per remote config URL, for conditional requests
+	remoteConfigMu    sync.Mutex
+	remoteConfigCache = make(map[string]*remoteConfig)
+)
+
+// remoteConfig is the last fetched copy of a config URL.
+type remoteConfig struct {
+	body         []byte
+	format       string
+	etag         string
+	lastModified string
+}
+
+// Largest remote config accepted
+const maxRemoteConfig = 16 << 20
+
+// --- Remote Config Logic ---
+
+func isRemoteConfig(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// resolveInclude resolves an include of a remote config against its URL.
+func resolveInclude(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil || isRemoteConfig(ref) {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// fetchRemoteConfig downloads a config URL, sending the cached ETag and
+// Last-Modified so an unchanged file costs a 304. The format comes from
+// -config-format, the Content-Type, or the URL's extension.
+func fetchRemoteConfig(rawURL string) ([]byte, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid config URL: %w", err)
+	}
+	for _, h := range configHeaders {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, "", fmt.Errorf("invalid -config-header %q: want \"Name: value\"", h)
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	remoteConfigMu.Lock()
+	cached := remoteConfigCache[rawURL]
+	remoteConfigMu.Unlock()
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return cached.body, cached.format, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("failed to fetch config %s: %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfig+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch config: %w", err)
+	}
+	if len(body) > maxRemoteConfig {
+		return nil, "", fmt.Errorf("config %s is larger than %d MiB", rawURL, maxRemoteConfig>>20)
+	}
+
+	format := detectConfigFormat(req.URL.Path)
+	if configFormat == "" {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		switch {
+		case strings.Contains(mediaType, "yaml"):
+			format = "yaml"
+		case strings.Contains(mediaType, "toml"):
+			format = "toml"
+		case strings.HasSuffix(mediaType, "json"):
+			format = "json"
+		}
+	}
+	remoteConfigMu.Lock()
+	remoteConfigCache[rawURL] = &remoteConfig{body: body, format: format, etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}
+	remoteConfigMu.Unlock()
+	return body, format, nil
+}
+
+// startConfigRefresh re-reads the config every -config-refresh when any of
+// its files or includes is remote, and applies it when the routes or
+// engagement it yields change. Admin API edits are kept until the remote copy changes.
+func startConfigRefresh(paths []string, loaded *configDocument) {
+	remoteConfigMu.Lock()
+	remote := len(remoteConfigCache) > 0
+	remoteConfigMu.Unlock()
+	if !remote || configRefresh <= 0 {
+		return
+	}
+	last, _ := json.Marshal(loaded)
+	log.Printf("[CONFIG] Refreshing remote config every %s", configRefresh)
+	go func() {
+		for range time.Tick(configRefresh) {
+			doc, err := readConfig(paths)
+			if err != nil {
+				log.Printf("[CONFIG] Refresh failed, keeping current routes: %v", err)
+				continue
+			}
+			current, _ := json.Marshal(doc)
+			if bytes.Equal(current, last) {
+				continue
+			}
+			last = current
+			before := routeSnapshot()
+			setEngagement(doc.Engagement)
+			setRoutes(doc.Routes)
+			log.Printf("[CONFIG] Remote config changed: loaded %d routes", len(doc.Routes))
+			audit("system", "", "config_refresh", map[string]any{"config": strings.Join(paths, ", "), "changes": diffRoutes(before, routeSnapshot())})
+		}
+	}()
+}
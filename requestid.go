@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+// Send X-Request-Id to upstreams as well as to the client
+var forwardRequestID bool
+
+// --- Request ID Logic ---
+
+// newRequestID returns a short random ID identifying one HTTP transaction.
+func newRequestID() string {
+	b := make([]byte, 8)
+	randomBytes(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID assigned to r by the HTTP handler, if any.
+func requestID(r *http.Request) string {
+	if info := getRequestInfo(r); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// logRequest logs a line about r, tagged with its request ID so one
+// transaction can be followed across the log.
+func logRequest(r *http.Request, format string, args ...any) {
+	if id := requestID(r); id != "" {
+		format += " (req " + id + ")"
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// errResponseBlocked marks upstream responses a route's content_types or
+// max_response_bytes keep from the client.
+var errResponseBlocked = errors.New("response blocked by route limits")
+
+// --- Response Limit Logic ---
+
+// parseResponseLimits validates the route's max_response_bytes and
+// content_types.
+func (rt *route) parseResponseLimits() error {
+	if rt.MaxResponseBytes < 0 {
+		return fmt.Errorf("invalid max_response_bytes %d", rt.MaxResponseBytes)
+	}
+	for i, ct := range rt.ContentTypes {
+		mediaType := strings.ToLower(strings.TrimSpace(ct))
+		if _, _, err := mime.ParseMediaType(strings.TrimSuffix(mediaType, "*") + "x"); err != nil || !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("invalid content type %q", ct)
+		}
+		rt.ContentTypes[i] = mediaType
+	}
+	return nil
+}
+
+// allowsContentType reports whether a Content-Type is on the route's
+// allowlist: an exact media type, or a type/* prefix.
+func (rt *route) allowsContentType(contentType string) bool {
+	if len(rt.ContentTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range rt.ContentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(mediaType, prefix) || mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResponseType refuses responses whose Content-Type the route does not
+// allow. Responses without a body are always relayed.
+func checkResponseType(resp *http.Response, info *requestInfo) error {
+	if info == nil || info.matched == nil || len(info.matched.ContentTypes) == 0 {
+		return nil
+	}
+	if resp.Request.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified || resp.ContentLength == 0 {
+		return nil
+	}
+	if ct := resp.Header.Get("Content-Type"); !info.matched.allowsContentType(ct) {
+		return fmt.Errorf("%w: content type %q not allowed", errResponseBlocked, ct)
+	}
+	return nil
+}
+
+// limitResponseSize refuses responses declaring more than the route's
+// max_response_bytes, and cuts off bodies of unknown length once they
+// exceed it.
+func limitResponseSize(resp *http.Response, info *requestInfo) error {
+	if info == nil || info.matched == nil || info.matched.MaxResponseBytes == 0 {
+		return nil
+	}
+	limit := info.matched.MaxResponseBytes
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%w: %d bytes exceeds max_response_bytes %d", errResponseBlocked, resp.ContentLength, limit)
+	}
+	resp.Body = &cappedBody{ReadCloser: resp.Body, limit: limit, remaining: limit, req: resp.Request, route: info.route}
+	return nil
+}
+
+// cappedBody fails reads past a route's max_response_bytes, so the proxy
+// aborts the response rather than relay the rest.
+type cappedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+	req       *http.Request
+	route     string
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseBlocked
+	}
+	// Read one byte past the limit to tell a body of exactly the limit apart
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = -1
+		logRequest(b.req, "[LIMIT] Response on %s cut off at max_response_bytes %d", b.route, b.limit)
+		return n, errResponseBlocked
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+)
+
+var (
+	// Certificate and key the HTTPS listener presents for names without a
+	// route or imported certificate, instead of minting one
+	tlsCertFile string
+	tlsKeyFile  string
+	defaultCert *tls.Certificate
+)
+
+// --- Route Certificate Logic ---
+
+// loadDefaultCert loads -tls-cert and -tls-key.
+func loadDefaultCert() {
+	if tlsCertFile == "" && tlsKeyFile == "" {
+		return
+	}
+	if tlsCertFile == "" || tlsKeyFile == "" {
+		log.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load -tls-cert: %v", err)
+	}
+	defaultCert = &cert
+	log.Printf("[TLS] Default certificate loaded from %s", tlsCertFile)
+}
+
+// parseTLSCert loads the certificate the HTTPS listener presents for the
+// route's names. The files are read again on every reload, so renewed
+// certificates are picked up without a restart.
+func (rt *route) parseTLSCert() error {
+	if rt.TLSCert == "" && rt.TLSKey == "" {
+		return nil
+	}
+	if rt.TLSCert == "" || rt.TLSKey == "" {
+		return fmt.Errorf("tls_cert and tls_key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(rt.TLSCert, rt.TLSKey)
+	if err != nil {
+		return fmt.Errorf("invalid tls_cert: %w", err)
+	}
+	rt.tlsCert = &cert
+	return nil
+}
+
+// routeCertificate returns the certificate of the route serving name, if
+// it has one.
+func routeCertificate(name string) *tls.Certificate {
+	if rt, ok := lookupRoute(name); ok {
+		return rt.tlsCert
+	}
+	return nil
+}
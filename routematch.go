@@ -0,0 +1,334 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// Regex routes by priority, then in the order they were defined, routes with a path keyed
+	// by their host (and port), longest path first, and the routes with a
+	// port for each bare host, leading route first; rebuilt when routes change
+	regexRoutes []*route
+	pathRoutes  map[string][]*route
+	portRoutes  map[string][]*route
+
+	// Routes with a source port range, keyed by bare host, longest path
+	// then narrowest range first
+	rangeRoutes map[string][]*route
+
+	// Routes answering for a name through auto_prefixes, keyed like
+	// routeMap; names with routes of their own get no aliases
+	aliasRoutes map[string]*route
+
+	// Creation order of routes, so regex sources are tried in config order
+	routeSeq atomic.Uint64
+)
+
+// Source of the default route, serving HTTP requests no other route matches
+const defaultSource = "*"
+
+// --- Route Matching Logic ---
+
+// Sources starting with ^ are regular expressions matched against the
+// whole lowercase host; sources starting with *. match every subdomain.
+func isRegexSource(source string) bool {
+	return strings.HasPrefix(strings.TrimSpace(source), "^")
+}
+
+// splitSource separates a host source from its path, as in api.local/v2/*.
+func splitSource(source string) (host, path string) {
+	if isRegexSource(source) {
+		return source, ""
+	}
+	if i := strings.IndexByte(source, '/'); i >= 0 {
+		return source[:i], source[i:]
+	}
+	return source, ""
+}
+
+// parsePattern validates wildcard sources and compiles regex ones.
+func (rt *route) parsePattern() error {
+	rt.seq = routeSeq.Add(1)
+	source := canonicalSource(rt.Source)
+	if isRegexSource(source) {
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return fmt.Errorf("invalid regex source %q: %w", rt.Source, err)
+		}
+		rt.pattern = re
+		return nil
+	}
+	host, path := splitSource(source)
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		lo, hi, err := parsePortRange(port)
+		if err != nil {
+			return fmt.Errorf("invalid source %q: %w", rt.Source, err)
+		}
+		host, rt.port, rt.portLo, rt.portHi = h, port, lo, hi
+	}
+	if host == "" {
+		return fmt.Errorf("invalid source %q: a host is required", rt.Source)
+	}
+	if host != defaultSource && strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return fmt.Errorf("invalid source %q: a wildcard must be the whole first label, as in *.example.com", rt.Source)
+	}
+	if strings.Contains(strings.TrimSuffix(path, "*"), "*") {
+		return fmt.Errorf("invalid source %q: a path wildcard must be the trailing *, as in api.local/v2/*", rt.Source)
+	}
+	rt.path = path
+	return nil
+}
+
+// matchPath reports whether a request path falls under the route's path:
+// a path ending in * is a prefix (api.local/v2/* also matches /v2 itself),
+// any other path must match exactly.
+func (rt *route) matchPath(path string) bool {
+	prefix, ok := strings.CutSuffix(rt.path, "*")
+	if !ok {
+		return path == rt.path
+	}
+	return strings.HasPrefix(path, prefix) || path == strings.TrimSuffix(prefix, "/")
+}
+
+// name is the route's ID: its canonical source.
+func (rt *route) name() string {
+	return canonicalSource(rt.Source)
+}
+
+// rebuildRouteIndex refreshes the regex, path and alias route lists from
+// routeMap, leaving out routes of disabled groups.
+func rebuildRouteIndex() {
+	mu.Lock()
+	defer mu.Unlock()
+	var list []*route
+	paths := make(map[string][]*route)
+	ports := make(map[string][]*route)
+	ranges := make(map[string][]*route)
+	aliases := make(map[string]*route)
+	// index adds a route to the lists under a bare host
+	index := func(rt *route, bare string) {
+		host := bare
+		if rt.port != "" {
+			host += ":" + rt.port
+		}
+		if rt.path != "" && rt.portLo == rt.portHi {
+			paths[host] = append(paths[host], rt)
+		}
+		if rt.port != "" {
+			ports[bare] = append(ports[bare], rt)
+			if rt.portLo != rt.portHi {
+				ranges[bare] = append(ranges[bare], rt)
+			}
+		}
+	}
+	ownHosts := make(map[string]bool)
+	for _, rt := range routeMap {
+		if rt.pattern == nil {
+			ownHosts[rt.bareHost()] = true
+		}
+	}
+	for _, rt := range routeMap {
+		if !rt.enabled() {
+			continue
+		}
+		if rt.pattern != nil {
+			list = append(list, rt)
+			continue
+		}
+		index(rt, rt.bareHost())
+		for _, alias := range rt.aliasHosts() {
+			if ownHosts[alias] {
+				continue
+			}
+			index(rt, alias)
+			if rt.path == "" && rt.portLo == rt.portHi {
+				key := alias
+				if rt.port != "" {
+					key += ":" + rt.port
+				}
+				if cur, ok := aliases[key]; !ok || compareRoutes(rt, cur) < 0 {
+					aliases[key] = rt
+				}
+			}
+		}
+	}
+	for _, routes := range ranges {
+		slices.SortFunc(routes, func(a, b *route) int {
+			return cmp.Or(cmp.Compare(len(strings.TrimSuffix(b.path, "*")), len(strings.TrimSuffix(a.path, "*"))),
+				cmp.Compare(a.portHi-a.portLo, b.portHi-b.portLo), compareRoutes(a, b))
+		})
+	}
+	slices.SortFunc(list, compareRoutes)
+	for _, routes := range ports {
+		slices.SortFunc(routes, compareRoutes)
+	}
+	for _, routes := range paths {
+		// Longest path first; an exact path beats a prefix of the same length
+		slices.SortFunc(routes, func(a, b *route) int {
+			return cmp.Or(cmp.Compare(len(strings.TrimSuffix(b.path, "*")), len(strings.TrimSuffix(a.path, "*"))), cmp.Compare(a.path, b.path))
+		})
+	}
+	regexRoutes, pathRoutes, portRoutes, rangeRoutes, aliasRoutes = list, paths, ports, ranges, aliases
+}
+
+// compareRoutes orders routes of equal specificity: higher priority first,
+// then config order.
+func compareRoutes(a, b *route) int {
+	return cmp.Or(cmp.Compare(b.Priority, a.Priority), cmp.Compare(a.seq, b.seq))
+}
+
+// hostKeys lists the route keys that can serve host, most specific first:
+// the host itself, then its wildcard parents.
+func hostKeys(host string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if !yield(host) {
+			return
+		}
+		for rest := host; ; {
+			_, parent, found := strings.Cut(rest, ".")
+			if !found || !yield("*."+parent) {
+				return
+			}
+			rest = parent
+		}
+	}
+}
+
+// matchRoute finds the route for host as seen by client: an exact source
+// first, then the most specific wildcard, then the first regex that matches
+// by priority. A host served only by path or port routes matches one of
+// them, so DNS still points it at the relay. Routes of disabled groups,
+// routes whose clients do not include client and routes whose mode
+// excludes mode (modeDNS or modeHTTP) are skipped; a nil client matches
+// routes for any client. Callers hold mu.
+func matchRoute(host string, client net.IP, mode string) (*route, bool) {
+	serves := func(rt *route) bool { return rt.matchClient(client) && rt.serves(mode) }
+	if rt, ok := routeMap[host]; ok && rt.enabled() && serves(rt) {
+		return rt, true
+	}
+	for key := range hostKeys(host) {
+		if rt, ok := routeMap[key]; ok && rt.enabled() && serves(rt) {
+			return rt, true
+		}
+		if rt, ok := aliasRoutes[key]; ok && serves(rt) {
+			return rt, true
+		}
+		for _, rt := range slices.Backward(pathRoutes[key]) {
+			if serves(rt) {
+				return rt, true
+			}
+		}
+		for _, rt := range portRoutes[key] {
+			if serves(rt) {
+				return rt, true
+			}
+		}
+	}
+	for _, rt := range regexRoutes {
+		if rt.pattern.MatchString(host) && serves(rt) {
+			return rt, true
+		}
+	}
+	return nil, false
+}
+
+// matchRequestRoute finds the route for an HTTP request by its host and
+// ports (see requestPorts): for each key of the host, with each port, then
+// within port ranges, then without a port, the longest matching path, then
+// the key's own route; then regex routes, and last the default route, keyed
+// the same way. Routes whose method or query conditions the request does
+// not meet are skipped; filtered reports whether any was. Routes of
+// disabled groups, DNS-only routes and routes for other clients are skipped
+// too. Callers hold mu.
+func matchRequestRoute(host string, ports []string, r *http.Request) (rt *route, ok, filtered bool) {
+	client := remoteIP(r.RemoteAddr)
+	try := func(rt *route) bool {
+		if !rt.matchClient(client) || !rt.serves(modeHTTP) {
+			return false
+		}
+		if rt.matchConditions(r) {
+			return true
+		}
+		filtered = true
+		return false
+	}
+	byExactKey := func(k string) *route {
+		for _, rt := range pathRoutes[k] {
+			if rt.matchPath(r.URL.Path) && try(rt) {
+				return rt
+			}
+		}
+		if rt, ok := routeMap[k]; ok && rt.enabled() && try(rt) {
+			return rt
+		}
+		if rt, ok := aliasRoutes[k]; ok && try(rt) {
+			return rt
+		}
+		return nil
+	}
+	byKey := func(key string) *route {
+		for _, port := range ports {
+			if rt := byExactKey(key + ":" + port); rt != nil {
+				return rt
+			}
+			for _, rt := range rangeRoutes[key] {
+				if rt.inPortRange(port) && (rt.path == "" || rt.matchPath(r.URL.Path)) && try(rt) {
+					return rt
+				}
+			}
+		}
+		return byExactKey(key)
+	}
+	for key := range hostKeys(host) {
+		if rt := byKey(key); rt != nil {
+			return rt, true, filtered
+		}
+	}
+	for _, rt := range regexRoutes {
+		if rt.pattern.MatchString(host) && try(rt) {
+			return rt, true, filtered
+		}
+	}
+	if rt := byKey(defaultSource); rt != nil {
+		return rt, true, filtered
+	}
+	return nil, false, filtered
+}
+
+// requestRoute returns the route for an HTTP request, by host, the port of
+// the listener it arrived on, path and conditions. Nothing matches while the
+// kill switch is engaged.
+func requestRoute(r *http.Request) (*route, bool) {
+	rt, ok, _ := routeForRequest(r)
+	return rt, ok
+}
+
+// routeForRequest is requestRoute, also reporting whether routes were
+// skipped for unmet conditions.
+func routeForRequest(r *http.Request) (rt *route, ok, filtered bool) {
+	if forwardOnly() {
+		return nil, false, false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return matchRequest(r)
+}
+
+// matchRequest is routeForRequest regardless of forward-only mode. The
+// caller holds mu.
+func matchRequest(r *http.Request) (rt *route, ok, filtered bool) {
+	host := strings.ToLower(r.Host)
+	ports := requestPorts(r, host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return matchRequestRoute(host, ports, r)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// testRoutes loads routes as the live route table for the test.
+func testRoutes(t *testing.T, routes ...ConfigRoute) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	noDNSPrefetch = true
+	interfaceIP = net.IPv4(10, 0, 0, 1)
+	setRoutes(routes)
+	t.Cleanup(func() { setRoutes(nil) })
+}
+
+// dnsRoute is the route DNS answers host with for client ("" for any),
+// or "" when no route serves it.
+func dnsRoute(host, client string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	if rt, ok := matchRoute(host, net.ParseIP(client), modeDNS); ok {
+		return rt.name()
+	}
+	return ""
+}
+
+// testRequest builds a request for target as if it arrived on a listener
+// on port (0 for none) from client ("" for 192.0.2.1).
+func testRequest(method, target string, port int, client string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = net.JoinHostPort(valueOr(client, "192.0.2.1"), "40000")
+	if port != 0 {
+		addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: port}
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, addr))
+	}
+	return r
+}
+
+// httpRoute is the route a request is proxied by, or "" when none matches.
+func httpRoute(r *http.Request) string {
+	if rt, ok := requestRoute(r); ok {
+		return rt.name()
+	}
+	return ""
+}
+
+func TestMatchWildcardAndRegexSources(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: "App.Corp.Local", Target: "http://10.0.0.2"},
+		ConfigRoute{Source: "*.corp.local", Target: "http://10.0.0.3"},
+		ConfigRoute{Source: "*.dev.corp.local", Target: "http://10.0.0.4"},
+		ConfigRoute{Source: `^api-[0-9]+\.test$`, Target: "http://10.0.0.5"},
+		ConfigRoute{Source: `^api-1\.test$`, Target: "http://10.0.0.6"},
+		ConfigRoute{Source: `^.*\.corp\.local$`, Target: "http://10.0.0.7"},
+		ConfigRoute{Source: "*", Target: "http://10.0.0.8"},
+	)
+	tests := []struct {
+		name     string
+		host     string
+		wantDNS  string
+		wantHTTP string
+	}{
+		{"exact source, matched case-insensitively", "app.corp.local", "app.corp.local", "app.corp.local"},
+		{"wildcard", "db.corp.local", "*.corp.local", "*.corp.local"},
+		{"wildcard spans several labels", "a.b.corp.local", "*.corp.local", "*.corp.local"},
+		{"longest wildcard wins", "x.dev.corp.local", "*.dev.corp.local", "*.dev.corp.local"},
+		{"wildcard beats a matching regex", "web.corp.local", "*.corp.local", "*.corp.local"},
+		{"wildcard does not match its apex", "corp.local", "", "*"},
+		{"regex", "api-42.test", `^api-[0-9]+\.test$`, `^api-[0-9]+\.test$`},
+		{"first regex in config order", "api-1.test", `^api-[0-9]+\.test$`, `^api-[0-9]+\.test$`},
+		{"regex matches the whole host", "api-42.test.example", "", "*"},
+		{"default route serves HTTP only", "unknown.example", "", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dnsRoute(tt.host, ""); got != tt.wantDNS {
+				t.Errorf("DNS route = %q, want %q", got, tt.wantDNS)
+			}
+			if got := httpRoute(testRequest("GET", "http://"+tt.host+"/", 80, "")); got != tt.wantHTTP {
+				t.Errorf("HTTP route = %q, want %q", got, tt.wantHTTP)
+			}
+		})
+	}
+}
+
+func TestInvalidWildcardSources(t *testing.T) {
+	for _, source := range []string{"a.*.corp.local", "app*.corp.local", `^api-[0-9+\.test$`} {
+		if _, err := newRoute(ConfigRoute{Source: source, Target: "http://10.0.0.2"}); err == nil {
+			t.Errorf("newRoute(%q) succeeded, want an error", source)
+		}
+	}
+}
+
+func TestMatchPathRoutes(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: "api.local", Target: "http://10.0.0.2"},
+		ConfigRoute{Source: "api.local/v2/*", Target: "http://10.0.0.3"},
+		ConfigRoute{Source: "api.local/v2/admin/*", Target: "http://10.0.0.4"},
+		ConfigRoute{Source: "api.local/v2/status", Target: "http://10.0.0.5"},
+		ConfigRoute{Source: "api.local/v1", Target: "http://10.0.0.6"},
+		ConfigRoute{Source: "*.svc.local/metrics/*", Target: "http://10.0.0.7"},
+		ConfigRoute{Source: "docs.local/guide/*", Target: "http://10.0.0.8"},
+	)
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"no path route matches", "http://api.local/", "api.local"},
+		{"prefix", "http://api.local/v2/users", "api.local/v2/*"},
+		{"prefix matches its own path", "http://api.local/v2", "api.local/v2/*"},
+		{"longest prefix wins", "http://api.local/v2/admin/users", "api.local/v2/admin/*"},
+		{"prefixes match whole segments", "http://api.local/v2/administrator", "api.local/v2/*"},
+		{"exact path", "http://api.local/v2/status", "api.local/v2/status"},
+		{"exact path is not a prefix", "http://api.local/v1/users", "api.local"},
+		{"exact path matches itself", "http://api.local/v1", "api.local/v1"},
+		{"query is not part of the path", "http://api.local/v1?x=1", "api.local/v1"},
+		{"wildcard host with a path", "http://a.svc.local/metrics/cpu", "*.svc.local/metrics/*"},
+		{"wildcard host outside its path", "http://a.svc.local/", ""},
+		{"host served only by a path route", "http://docs.local/guide/intro", "docs.local/guide/*"},
+		{"outside the only path route", "http://docs.local/blog", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := httpRoute(testRequest("GET", tt.url, 80, "")); got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// DNS has no path, but points hosts with only path routes at the relay
+	for host, want := range map[string]string{"docs.local": "docs.local/guide/*", "a.svc.local": "*.svc.local/metrics/*"} {
+		if got := dnsRoute(host, ""); got != want {
+			t.Errorf("DNS route for %s = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestInvalidPathSources(t *testing.T) {
+	for _, source := range []string{"api.local/v*/users", "api.local/*/x", "/v2/*"} {
+		if _, err := newRoute(ConfigRoute{Source: source, Target: "http://10.0.0.2"}); err == nil {
+			t.Errorf("newRoute(%q) succeeded, want an error", source)
+		}
+	}
+}
+
+func TestRouteKinds(t *testing.T) {
+	tests := []struct {
+		source   string
+		kind     string
+		hostKind string
+	}{
+		{"api.local", "exact", "exact"},
+		{"api.local:8080", "exact", "exact"},
+		{"*.svc.local", "wildcard", "wildcard"},
+		{`^api-[0-9]+\.test$`, "regex", "regex"},
+		{"*", "default", "default"},
+		{"api.local/v2/*", "path", "exact"},
+		{"api.local:8080/v1", "path", "exact"},
+		{"*.svc.local/metrics/*", "path", "wildcard"},
+	}
+	for _, tt := range tests {
+		rt, err := newRoute(ConfigRoute{Source: tt.source, Target: "http://10.0.0.2"})
+		if err != nil {
+			t.Fatalf("newRoute(%q): %v", tt.source, err)
+		}
+		if kind, hostKind := rt.kind(), rt.hostKind(); kind != tt.kind || hostKind != tt.hostKind {
+			t.Errorf("%s: kind %q, host kind %q, want %q, %q", tt.source, kind, hostKind, tt.kind, tt.hostKind)
+		}
+	}
+}
+
+func TestMatchPortRoutes(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: "app.local", Target: "http://10.0.0.8:80"},
+		ConfigRoute{Source: "app.local:8443", Target: "https://10.0.0.8:8443"},
+		ConfigRoute{Source: "app.local:9000/metrics/*", Target: "http://10.0.0.8:9100"},
+		ConfigRoute{Source: "*.corp.local:8443", Target: "https://10.0.0.9:8443"},
+		ConfigRoute{Source: "only.local:8080", Target: "http://10.0.0.10"},
+	)
+	tests := []struct {
+		name string
+		url  string
+		port int // listener port
+		want string
+	}{
+		{"listener port", "http://app.local/", 8443, "app.local:8443"},
+		{"other ports fall back to the host route", "http://app.local/", 80, "app.local"},
+		{"Host header port when the listener's has no route", "http://app.local:8443/", 80, "app.local:8443"},
+		{"Host header port without a listener port", "http://app.local:8443/", 0, "app.local:8443"},
+		{"listener port before the Host header's", "http://app.local:9000/metrics/x", 8443, "app.local:8443"},
+		{"port route with a path", "http://app.local/metrics/cpu", 9000, "app.local:9000/metrics/*"},
+		{"outside the port route's path", "http://app.local/", 9000, "app.local"},
+		{"wildcard host with a port", "http://db.corp.local/", 8443, "*.corp.local:8443"},
+		{"wildcard port route on another port", "http://db.corp.local/", 80, ""},
+		{"host served only on another port", "http://only.local/", 80, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := httpRoute(testRequest("GET", tt.url, tt.port, "")); got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// DNS answers a host for any of its port routes
+	for host, want := range map[string]string{"app.local": "app.local", "only.local": "only.local:8080", "db.corp.local": "*.corp.local:8443"} {
+		if got := dnsRoute(host, ""); got != want {
+			t.Errorf("DNS route for %s = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestMatchRouteConditions(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: "app.local/api/*", Target: "http://10.0.0.8", Methods: []string{"post", "PUT"}},
+		ConfigRoute{Source: "app.local/export", Target: "http://10.0.0.8", Query: map[string]string{"format": "csv", "token": ""}},
+		ConfigRoute{Source: "app.local", Target: "http://10.0.0.9"},
+		ConfigRoute{Source: "strict.local", Target: "http://10.0.0.8", Methods: []string{"GET"}},
+	)
+	tests := []struct {
+		name         string
+		method       string
+		url          string
+		want         string
+		wantFiltered bool
+	}{
+		{"method allowed, case-insensitively", "POST", "http://app.local/api/users", "app.local/api/*", false},
+		{"other methods fall through", "GET", "http://app.local/api/users", "app.local", true},
+		{"query met", "GET", "http://app.local/export?format=csv&token=x", "app.local/export", false},
+		{"any value of a repeated parameter", "GET", "http://app.local/export?format=xml&format=csv&token", "app.local/export", false},
+		{"empty condition only needs the parameter", "GET", "http://app.local/export?format=csv", "app.local", true},
+		{"wrong value falls through", "GET", "http://app.local/export?format=xml&token=x", "app.local", true},
+		{"nothing left to fall through to", "POST", "http://strict.local/", "", true},
+		{"unconditioned route", "DELETE", "http://app.local/", "app.local", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRequest(tt.method, tt.url, 80, "")
+			rt, ok, filtered := routeForRequest(r)
+			got := ""
+			if ok {
+				got = rt.name()
+			}
+			if got != tt.want || filtered != tt.wantFiltered {
+				t.Errorf("route = %q (filtered %v), want %q (filtered %v)", got, filtered, tt.want, tt.wantFiltered)
+			}
+		})
+	}
+
+	// Conditions do not apply to DNS
+	if got := dnsRoute("strict.local", ""); got != "strict.local" {
+		t.Errorf("DNS route = %q, want strict.local", got)
+	}
+}
+
+func TestRoutePriority(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: `^.*\.corp\.local$`, Target: "http://10.0.0.9"},
+		ConfigRoute{Source: `^admin-[0-9]+\.corp\.local$`, Target: "http://10.0.0.8", Priority: 10},
+		ConfigRoute{Source: `^db-[0-9]+\.corp\.local$`, Target: "http://10.0.0.7"},
+		ConfigRoute{Source: "www.site.local", Target: "http://10.0.0.2"},
+		ConfigRoute{Source: "*.site.local", Target: "http://10.0.0.3", Priority: 100},
+		ConfigRoute{Source: "svc.local:8080", Target: "http://10.0.0.4"},
+		ConfigRoute{Source: "svc.local:9090", Target: "http://10.0.0.5", Priority: 5},
+		ConfigRoute{Source: "tie.local:8080", Target: "http://10.0.0.4"},
+		ConfigRoute{Source: "tie.local:9090", Target: "http://10.0.0.5"},
+		ConfigRoute{Source: "keep.local", Target: "http://10.0.0.10", Priority: 5},
+		ConfigRoute{Source: "Keep.Local", Target: "http://10.0.0.11"},
+		ConfigRoute{Source: "last.local", Target: "http://10.0.0.10"},
+		ConfigRoute{Source: "LAST.local", Target: "http://10.0.0.11"},
+	)
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"higher priority regex first", "admin-1.corp.local", `^admin-[0-9]+\.corp\.local$`},
+		{"equal priority regexes in config order", "db-1.corp.local", `^.*\.corp\.local$`},
+		{"priority does not beat specificity", "www.site.local", "www.site.local"},
+		{"DNS answers for the port route with the highest priority", "svc.local", "svc.local:9090"},
+		{"DNS answers for the first port route on a tie", "tie.local", "tie.local:8080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dnsRoute(tt.host, ""); got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Of a source defined twice, the higher priority wins, else the later
+	for host, want := range map[string]string{"keep.local": "http://10.0.0.10", "last.local": "http://10.0.0.11"} {
+		mu.RLock()
+		rt := routeMap[host]
+		mu.RUnlock()
+		if rt == nil || rt.target.String() != want {
+			t.Errorf("%s loaded %v, want target %s", host, rt, want)
+		}
+	}
+}
+
+func TestMatchPortRanges(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: "app.local:8000-8100", Target: "http://10.0.0.8:9000-9100"},
+		ConfigRoute{Source: "app.local:8000-8010", Target: "http://10.0.0.9"},
+		ConfigRoute{Source: "app.local:8005", Target: "http://10.0.0.10"},
+		ConfigRoute{Source: "app.local:8000-8100/admin/*", Target: "http://10.0.0.11"},
+		ConfigRoute{Source: "app.local", Target: "http://10.0.0.12"},
+	)
+	tests := []struct {
+		name       string
+		url        string
+		port       int
+		want       string
+		wantTarget string
+	}{
+		{"port in the range", "http://app.local/", 8042, "app.local:8000-8100", "http://10.0.0.8:9042/"},
+		{"range bounds are inclusive", "http://app.local/", 8100, "app.local:8000-8100", "http://10.0.0.8:9100/"},
+		{"narrower range wins", "http://app.local/", 8003, "app.local:8000-8010", "http://10.0.0.9/"},
+		{"single port beats a range", "http://app.local/", 8005, "app.local:8005", "http://10.0.0.10/"},
+		{"longer path beats a narrower range", "http://app.local/admin/x", 8003, "app.local:8000-8100/admin/*", "http://10.0.0.11/admin/x"},
+		{"outside every range", "http://app.local/", 8101, "app.local", "http://10.0.0.12/"},
+		{"Host header port", "http://app.local:8050/", 80, "app.local:8000-8100", "http://10.0.0.8:9050/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRequest("GET", tt.url, tt.port, "")
+			if got := httpRoute(r); got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+			rt, _, _ := routeForRequest(r)
+			out := r.Clone(r.Context())
+			be, _ := rt.pickBackend(out)
+			be.apply(out)
+			mapTargetPort(out, rt, be, r.Host)
+			if got := out.URL.String(); got != tt.wantTarget {
+				t.Errorf("target = %s, want %s", got, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestInvalidPortRanges(t *testing.T) {
+	for _, r := range []ConfigRoute{
+		{Source: "app.local:8100-8000", Target: "http://10.0.0.8"},
+		{Source: "app.local:0-10", Target: "http://10.0.0.8"},
+		{Source: "app.local:8000-70000", Target: "http://10.0.0.8"},
+		{Source: "app.local:8000-8100", Target: "http://10.0.0.8:9000-9050"},
+	} {
+		if _, err := newRoute(r); err == nil {
+			t.Errorf("newRoute(%s -> %s) succeeded, want an error", r.Source, r.Target)
+		}
+	}
+}
+
+func TestMatchClientRoutes(t *testing.T) {
+	testRoutes(t,
+		ConfigRoute{Source: "app.target.local", Target: "http://10.0.0.5", Clients: []string{"10.20.0.0/16", "192.0.2.7"}},
+		ConfigRoute{Source: "*.target.local", Target: "http://10.0.0.6"},
+		ConfigRoute{Source: "lab.local", Target: "http://10.0.0.7", Clients: []string{"2001:db8::/32"}},
+	)
+	tests := []struct {
+		name   string
+		host   string
+		client string
+		want   string
+	}{
+		{"client in a CIDR", "app.target.local", "10.20.3.4", "app.target.local"},
+		{"client listed by IP", "app.target.local", "192.0.2.7", "app.target.local"},
+		{"other clients see the next route", "app.target.local", "198.51.100.1", "*.target.local"},
+		{"IPv6 CIDR", "lab.local", "2001:db8::1", "lab.local"},
+		{"no route left for other clients", "lab.local", "10.20.3.4", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dnsRoute(tt.host, tt.client); got != tt.want {
+				t.Errorf("DNS route = %q, want %q", got, tt.want)
+			}
+			if got := httpRoute(testRequest("GET", "http://"+tt.host+"/", 80, tt.client)); got != tt.want {
+				t.Errorf("HTTP route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Without a client address, as in lookupRoute, every route applies
+	if got := dnsRoute("lab.local", ""); got != "lab.local" {
+		t.Errorf("route for any client = %q, want lab.local", got)
+	}
+}
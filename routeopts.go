@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// --- Per-Route Upstream Options Logic ---
+
+// parseUpstreamOptions validates the route's proxy, sni, host_header and
+// headers.
+func (rt *route) parseUpstreamOptions() error {
+	if rt.Proxy != "" && rt.Proxy != "-" {
+		if rt.egress != nil {
+			return fmt.Errorf("proxy cannot be combined with via_ssh or via_wireguard")
+		}
+		u, err := url.Parse(rt.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", rt.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("invalid proxy URL %q: scheme must be http, https or socks5", rt.Proxy)
+		}
+		rt.proxyURL = u
+	}
+	if rt.SNI != "" {
+		if strings.ContainsAny(rt.SNI, " \t\r\n/:[]") || net.ParseIP(rt.SNI) != nil {
+			return fmt.Errorf("invalid sni %q: want a hostname", rt.SNI)
+		}
+		if !slices.ContainsFunc(rt.upstreams(), func(u *url.URL) bool { return u.Scheme == "https" }) {
+			return fmt.Errorf("sni needs an https target or backend")
+		}
+	}
+	if strings.ContainsAny(rt.HostHeader, " \t\r\n/") {
+		return fmt.Errorf("invalid host_header %q", rt.HostHeader)
+	}
+	for name, value := range rt.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid value for header %s", name)
+		}
+	}
+	return nil
+}
+
+// applyUpstreamOptions rewrites the outgoing request's Host and headers.
+func applyUpstreamOptions(req *http.Request, rt *route) {
+	if rt.HostHeader != "" {
+		req.Host = rt.HostHeader
+	} else if rt.SNI != "" {
+		req.Host = rt.SNI
+	}
+	for name, value := range rt.Headers {
+		if value == "-" {
+			req.Header.Del(name)
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+}
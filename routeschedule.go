@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// --- Route Schedule Logic ---
+
+// parseSchedule validates active_from, active_until and active_windows,
+// and sets whether the route starts out active.
+func (rt *route) parseSchedule() error {
+	var err error
+	if rt.ActiveFrom != "" {
+		if rt.activeFrom, err = time.Parse(time.RFC3339, rt.ActiveFrom); err != nil {
+			return fmt.Errorf("invalid active_from %q: want an RFC 3339 time such as 2026-05-04T09:00:00+02:00", rt.ActiveFrom)
+		}
+	}
+	if rt.ActiveUntil != "" {
+		if rt.activeUntil, err = time.Parse(time.RFC3339, rt.ActiveUntil); err != nil {
+			return fmt.Errorf("invalid active_until %q: want an RFC 3339 time such as 2026-05-08T18:00:00+02:00", rt.ActiveUntil)
+		}
+		if !rt.activeFrom.IsZero() && !rt.activeUntil.After(rt.activeFrom) {
+			return fmt.Errorf("active_until must be after active_from")
+		}
+	}
+	for _, s := range rt.ActiveWindows {
+		w, err := parseActivityWindow(s)
+		if err != nil {
+			return err
+		}
+		rt.windows = append(rt.windows, w)
+	}
+	rt.scheduledOff.Store(!rt.scheduledActive(time.Now()))
+	return nil
+}
+
+// scheduled reports whether the route has a schedule.
+func (rt *route) scheduled() bool {
+	return !rt.activeFrom.IsZero() || !rt.activeUntil.IsZero() || len(rt.windows) > 0
+}
+
+// scheduledActive reports whether the route's schedule has it active at
+// now: between active_from and active_until, and inside one of its windows
+// if it has any. Windows are in the -active-tz time zone.
+func (rt *route) scheduledActive(now time.Time) bool {
+	if !rt.activeFrom.IsZero() && now.Before(rt.activeFrom) {
+		return false
+	}
+	if !rt.activeUntil.IsZero() && !now.Before(rt.activeUntil) {
+		return false
+	}
+	if len(rt.windows) == 0 {
+		return true
+	}
+	local := now.In(activityZone)
+	for _, w := range rt.windows {
+		if w.contains(local) {
+			return true
+		}
+	}
+	return false
+}
+
+// startRouteSchedules re-evaluates route schedules every second.
+func startRouteSchedules() {
+	go func() {
+		for now := range time.Tick(time.Second) {
+			updateRouteSchedules(now)
+		}
+	}()
+}
+
+// updateRouteSchedules activates and deactivates scheduled routes, logging
+// and auditing each change.
+func updateRouteSchedules(now time.Time) {
+	changed := false
+	mu.RLock()
+	for id, rt := range routeMap {
+		if !rt.scheduled() {
+			continue
+		}
+		active := rt.scheduledActive(now)
+		if rt.scheduledOff.Swap(!active) == !active {
+			continue
+		}
+		changed = true
+		if active {
+			log.Printf("[SCHEDULE] Route %s activated", id)
+			audit("system", "", "route_activated", map[string]string{"route": id})
+		} else {
+			log.Printf("[SCHEDULE] Route %s deactivated", id)
+			audit("system", "", "route_deactivated", map[string]string{"route": id})
+		}
+	}
+	mu.RUnlock()
+	if changed {
+		routesChanged()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Start forward-only and stay so until restarted: routes are loaded and
+// matched for the log, but never rebound or proxied
+var safeMode bool
+
+// --- Safe Mode Logic ---
+
+// setupSafeMode announces -safe-mode, which keeps forwardOnly true for the
+// life of the process.
+func setupSafeMode() {
+	if !safeMode {
+		return
+	}
+	log.Printf("[SAFE] Safe mode: DNS is resolved by the system and HTTP forwarded to the requested host; no route is rebound or proxied")
+	audit("system", "", "safe_mode", nil)
+}
+
+// logSafeModeDNS logs a DNS query in safe mode with the route that would
+// have answered it.
+func logSafeModeDNS(name, qtype, client string, serves func(string) bool) {
+	mu.RLock()
+	rt, ok := matchRoute(name, remoteIP(client), modeDNS)
+	mu.RUnlock()
+	if ok && serves(name) {
+		log.Printf("[SAFE] DNS %s %s from %s: route %s would answer, resolved by the system", qtype, name, client, rt.name())
+		return
+	}
+	log.Printf("[SAFE] DNS %s %s from %s: no route, resolved by the system", qtype, name, client)
+}
+
+// logSafeModeHTTP logs an HTTP request in safe mode with the route that
+// would have proxied it.
+func logSafeModeHTTP(r *http.Request) {
+	client, _, _ := net.SplitHostPort(r.RemoteAddr)
+	mu.RLock()
+	rt, ok, _ := matchRequest(r)
+	mu.RUnlock()
+	if ok {
+		log.Printf("[SAFE] HTTP %s %s%s from %s: route %s would proxy to %s, forwarded to the requested host", r.Method, r.Host, r.URL.Path, client, rt.name(), strings.Join(rt.manifestTargets(), ", "))
+		return
+	}
+	log.Printf("[SAFE] HTTP %s %s%s from %s: no route, forwarded to the requested host", r.Method, r.Host, r.URL.Path, client)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+var (
+	// Stitch each client's DNS lookups, TLS handshakes and HTTP requests
+	// of routed names into sessions
+	sessionsEnabled bool
+
+	// A session ends after this long without activity; a connection this
+	// soon after a lookup is taken to follow from it
+	sessionIdle time.Duration
+
+	sessionsMu     sync.Mutex
+	openSessions   = make(map[string][]*Session) // by host
+	closedSessions []*Session                    // oldest first
+)
+
+// Session is shared with the admin API client.
+type Session = adminclient.Session
+
+// SessionStep is shared with the admin API client.
+type SessionStep = adminclient.SessionStep
+
+const (
+	// Ended sessions kept for the admin API
+	maxSessions = 1000
+
+	// Steps recorded per session; later ones are only counted
+	maxSessionSteps = 100
+)
+
+// --- Session Stitching Logic ---
+
+// startSessions ends idle sessions in the background.
+func startSessions() {
+	if !sessionsEnabled {
+		return
+	}
+	if sessionIdle <= 0 {
+		log.Fatalf("Invalid -session-idle %s: must be positive", sessionIdle)
+	}
+	go func() {
+		ticker := time.NewTicker(max(min(sessionIdle/4, 10*time.Second), time.Second))
+		defer ticker.Stop()
+		for now := range ticker.C {
+			endSessions(func(s *Session) bool { return now.Sub(s.End) >= sessionIdle })
+		}
+	}()
+}
+
+// observeSessionDNS adds a routed lookup to the resolver's session for the
+// name, starting one if there is none. answer is the address returned.
+func observeSessionDNS(rt *route, name, resolver string, answer net.IP) {
+	if !sessionsEnabled {
+		return
+	}
+	detail := "A " + answer.String()
+	rebound := rt.rebindIP != nil && answer.Equal(rt.rebindIP)
+	if rebound {
+		detail += " (rebind)"
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s := findSession(name, func(s *Session) bool {
+		return s.Client == resolver || slices.Contains(s.Resolvers, resolver)
+	})
+	if s == nil {
+		s = newSession(name, rt.name())
+	}
+	if !slices.Contains(s.Resolvers, resolver) {
+		s.Resolvers = append(s.Resolvers, resolver)
+	}
+	s.Rebound = s.Rebound || rebound
+	s.DNSQueries++
+	addSessionStep(s, "dns", resolver, detail)
+}
+
+// observeSessionTLS adds a handshake naming a routed host to the client's
+// session.
+func observeSessionTLS(hello *tls.ClientHelloInfo) {
+	if !sessionsEnabled || hello.Conn == nil || hello.ServerName == "" {
+		return
+	}
+	rt, ok := lookupRoute(hello.ServerName)
+	if !ok {
+		return
+	}
+	client := remoteIP(hello.Conn.RemoteAddr().String())
+	if client == nil {
+		return
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s := clientSession(sessionHost(hello.ServerName), rt.name(), client.String())
+	s.TLSHandshakes++
+	addSessionStep(s, "tls", s.Client, "ClientHello SNI "+hello.ServerName)
+}
+
+// observeSessionHTTP adds a routed request to the client's session.
+func observeSessionHTTP(r *http.Request, route string, status int) {
+	if !sessionsEnabled || route == "" {
+		return
+	}
+	client := remoteIP(r.RemoteAddr)
+	if client == nil {
+		return
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s := clientSession(sessionHost(r.Host), route, client.String())
+	s.HTTPRequests++
+	addSessionStep(s, "http", s.Client, fmt.Sprintf("%s %s -> %d", r.Method, r.URL.Path, status))
+}
+
+// clientSession returns the session a connection from client belongs to:
+// its own, else the one its own address resolved in, else the latest
+// whose lookup no device has followed up yet, as when it resolves through
+// a recursive resolver. Callers hold sessionsMu.
+func clientSession(host, route, client string) *Session {
+	s := findSession(host, func(s *Session) bool { return s.Client == client })
+	if s == nil {
+		s = findSession(host, func(s *Session) bool { return s.Client == "" && slices.Contains(s.Resolvers, client) })
+	}
+	if s == nil {
+		s = findSession(host, func(s *Session) bool { return s.Client == "" })
+	}
+	if s == nil {
+		s = newSession(host, route)
+	}
+	s.Client = client
+	return s
+}
+
+// findSession returns the most recently active open session for host that
+// match accepts. Callers hold sessionsMu.
+func findSession(host string, match func(*Session) bool) *Session {
+	var found *Session
+	for _, s := range openSessions[host] {
+		if time.Since(s.End) < sessionIdle && match(s) && (found == nil || s.End.After(found.End)) {
+			found = s
+		}
+	}
+	return found
+}
+
+// newSession opens a session for host. Callers hold sessionsMu.
+func newSession(host, route string) *Session {
+	now := time.Now().UTC()
+	s := &Session{ID: newRequestID(), Host: host, Route: route, Open: true, Start: now, End: now}
+	openSessions[host] = append(openSessions[host], s)
+	return s
+}
+
+// addSessionStep records a step, or only counts it once the session has
+// maxSessionSteps. Callers hold sessionsMu.
+func addSessionStep(s *Session, protocol, client, detail string) {
+	s.End = time.Now().UTC()
+	if len(s.Steps) >= maxSessionSteps {
+		s.StepsDropped++
+		return
+	}
+	s.Steps = append(s.Steps, SessionStep{Time: s.End, Protocol: protocol, Client: client, Detail: detail})
+}
+
+// endSessions closes the open sessions done accepts, keeps them for the
+// admin API and publishes each as a session event.
+func endSessions(done func(*Session) bool) {
+	var ended []*Session
+	sessionsMu.Lock()
+	for host, list := range openSessions {
+		list = slices.DeleteFunc(list, func(s *Session) bool {
+			if !done(s) {
+				return false
+			}
+			s.Open = false
+			finishSession(s)
+			ended = append(ended, s)
+			return true
+		})
+		if len(list) == 0 {
+			delete(openSessions, host)
+		} else {
+			openSessions[host] = list
+		}
+	}
+	closedSessions = append(closedSessions, ended...)
+	if over := len(closedSessions) - maxSessions; over > 0 {
+		closedSessions = slices.Delete(closedSessions, 0, over)
+	}
+	sessionsMu.Unlock()
+
+	for _, s := range ended {
+		snapshot := *s
+		emit(Event{Type: adminclient.EventSession, Host: s.Host, Route: s.Route, Client: s.Client, Message: s.Chain, Session: &snapshot})
+	}
+}
+
+// closeSessions ends every open session, so none are lost on shutdown.
+func closeSessions() {
+	if sessionsEnabled {
+		endSessions(func(*Session) bool { return true })
+	}
+}
+
+// finishSession fills in the fields derived from the steps. Callers hold
+// sessionsMu.
+func finishSession(s *Session) {
+	s.Chain = sessionChain(s.Steps)
+	if s.StepsDropped > 0 {
+		s.Chain += fmt.Sprintf(" (+%d steps)", s.StepsDropped)
+	}
+	if s.Client != "" {
+		if system, browser := clientSystem(s.Client); browser != "" || system != "" {
+			s.Fingerprint = fingerprintLabel(&ClientFingerprint{OS: system, Browser: browser})
+		}
+	}
+}
+
+// sessionChain summarizes steps by protocol, e.g. "dns x2 -> tls -> http".
+func sessionChain(steps []SessionStep) string {
+	var parts []string
+	for i := 0; i < len(steps); {
+		n := 1
+		for i+n < len(steps) && steps[i+n].Protocol == steps[i].Protocol {
+			n++
+		}
+		part := steps[i].Protocol
+		if n > 1 {
+			part += fmt.Sprintf(" x%d", n)
+		}
+		parts = append(parts, part)
+		i += n
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// sessionHost normalizes a Host header or SNI to the names DNS sees.
+func sessionHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// handleListSessions lists open and ended sessions, newest first.
+// ?client= and ?host= filter by client or resolver address and host.
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	client := r.URL.Query().Get("client")
+	host := sessionHost(r.URL.Query().Get("host"))
+	sessionsMu.Lock()
+	all := slices.Clone(closedSessions)
+	for _, list := range openSessions {
+		for _, s := range list {
+			finishSession(s)
+			all = append(all, s)
+		}
+	}
+	list := make([]Session, 0, len(all))
+	for _, s := range all {
+		if (client == "" || s.Client == client || slices.Contains(s.Resolvers, client)) && (host == "" || s.Host == host) {
+			snapshot := *s
+			snapshot.Steps = slices.Clone(s.Steps)
+			list = append(list, snapshot)
+		}
+	}
+	sessionsMu.Unlock()
+	slices.SortStableFunc(list, func(a, b Session) int { return b.Start.Compare(a.Start) })
+	writeJSON(w, http.StatusOK, list)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"goRebind/adminclient"
+)
+
+// Simulation types are shared with the admin API client.
+type (
+	SimulateRequest = adminclient.SimulateRequest
+	Simulation      = adminclient.Simulation
+)
+
+// Reason given while routes are disabled relay-wide
+const forwardOnlyReason = "forward-only: safe mode, kill switch engaged or outside the activity windows"
+
+// --- Route Simulation Logic ---
+
+// simulate works out what the relay would answer a hypothetical query and
+// request with. It reads the route table and rebind states but changes
+// nothing, so it can be called on a live relay.
+func simulate(req SimulateRequest) (Simulation, error) {
+	var sim Simulation
+	if req.QName == "" && req.Host == "" {
+		return sim, errors.New("qname or host is required")
+	}
+	var client net.IP
+	if req.Client != "" {
+		if client = net.ParseIP(req.Client); client == nil {
+			return sim, fmt.Errorf("invalid client %q: an IP address is required", req.Client)
+		}
+	}
+	if req.QName != "" {
+		d, err := simulateDNS(req.QName, req.QType, client)
+		if err != nil {
+			return sim, err
+		}
+		sim.DNS = d
+	}
+	if req.Host != "" {
+		h, err := simulateHTTP(req, client)
+		if err != nil {
+			return sim, err
+		}
+		sim.HTTP = h
+	}
+	return sim, nil
+}
+
+// simulateDNS follows handleDNSRequest for a query of name from client
+// (nil for any client), peeking at the rebind strategy instead of
+// advancing it.
+func simulateDNS(name, qtype string, client net.IP) (*adminclient.DNSSimulation, error) {
+	qt := dns.TypeA
+	if qtype != "" {
+		t, ok := dns.StringToType[strings.ToUpper(qtype)]
+		if !ok {
+			return nil, fmt.Errorf("invalid qtype %q", qtype)
+		}
+		qt = t
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	sim := &adminclient.DNSSimulation{Result: "system"}
+	if client != nil && !dnsAllowed(client) {
+		sim.Result, sim.Reason = "refused", "client not in -dns-allow"
+		return sim, nil
+	}
+	if isKillSwitchHost(name) {
+		sim.Reason = "kill switch hostname: the query engages the kill switch"
+		return sim, nil
+	}
+	mu.RLock()
+	rt, ok := matchRoute(name, client, modeDNS)
+	mu.RUnlock()
+	if !ok {
+		sim.Reason = "no route"
+		return sim, nil
+	}
+	sim.Route = rt.name()
+	if forwardOnly() {
+		sim.Reason = forwardOnlyReason
+		return sim, nil
+	}
+	if cloakEnabled && client != nil && cloakedNetwork(client) {
+		sim.Reason = "client in a scanner network (-cloak)"
+		return sim, nil
+	}
+
+	var clientAddr string
+	if client != nil {
+		clientAddr = client.String()
+	}
+	if rt.rebindIP != nil {
+		sim.Profile = valueOr(rt.profileFor(clientAddr), "default")
+	}
+	switch {
+	case qt == dns.TypeA:
+		ip, state := rt.peekRebindAnswer(clientAddr, name)
+		if ip == nil {
+			sim.Result, sim.Reason = "not_served", "DNS is not served, so the relay has no address to answer with"
+			break
+		}
+		ttl := rt.answerTTL(clientAddr)
+		sim.Result, sim.Answer, sim.TTL, sim.State = "answer", ip.String(), &ttl, state
+		sim.Rebound = rt.rebindIP != nil && ip.Equal(rt.rebindIP)
+	case qt == dns.TypeAAAA && rt.rebindIP != nil && rt.clientStrategy(clientAddr).BlockAAAA:
+		sim.Result, sim.Reason = "empty", fmt.Sprintf("AAAA blocked by profile %s", sim.Profile)
+	default:
+		sim.Reason = "routes only answer A queries"
+	}
+	return sim, nil
+}
+
+// simulateHTTP follows the HTTP handler and Director for a request from
+// client (nil for any client), without picking a rotating backend.
+func simulateHTTP(req SimulateRequest, client net.IP) (*adminclient.HTTPSimulation, error) {
+	path := valueOr(req.Path, "/")
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid path %q: must start with /", path)
+	}
+	scheme := "http"
+	if req.TLS {
+		scheme = "https"
+	}
+	r, err := http.NewRequest(valueOr(strings.ToUpper(req.Method), http.MethodGet), scheme+"://"+req.Host+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host or path: %w", err)
+	}
+	for name, value := range req.Headers {
+		r.Header.Set(name, value)
+	}
+	if req.TLS {
+		r.TLS = &tls.ConnectionState{ServerName: r.URL.Hostname()}
+	}
+	if client != nil {
+		r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+	}
+	r.URL.Scheme, r.URL.Host = "", ""
+	origin := func() string {
+		u := *r.URL
+		forwardToOrigin(&http.Request{URL: &u, Host: r.Host, TLS: r.TLS})
+		return u.String()
+	}
+
+	sim := &adminclient.HTTPSimulation{}
+	if isKillSwitchHost(r.Host) {
+		sim.Result, sim.Reason = "not_found", "kill switch hostname: the request engages the kill switch"
+		return sim, nil
+	}
+	mu.RLock()
+	rt, ok, filtered := matchRequest(r)
+	mu.RUnlock()
+	if ok {
+		sim.Route = rt.name()
+	}
+	switch {
+	case forwardOnly():
+		sim.Result, sim.Target, sim.Reason = "forward", origin(), forwardOnlyReason
+		return sim, nil
+	case cloakEnabled && client != nil && cloakedNetwork(client):
+		sim.Result, sim.Target, sim.Reason = "forward", origin(), "client in a scanner network (-cloak)"
+		if cloakDecoy != nil {
+			sim.Target = cloakDecoy.Redacted()
+		}
+		return sim, nil
+	case !ok && filtered:
+		sim.Result, sim.Reason = "not_found", "route conditions not met"
+		return sim, nil
+	case !ok && forwardUnmatched:
+		sim.Result, sim.Target, sim.Reason = "forward", origin(), "no route (-forward-unmatched)"
+		return sim, nil
+	case !ok:
+		sim.Result, sim.Reason = "unmatched", "no route: answered with 502"
+		return sim, nil
+	case rt.target.Scheme == "file":
+		sim.Result, sim.Target = "static", rt.target.String()
+		return sim, nil
+	}
+
+	sim.Result = "proxy"
+	if !rt.fixedBackend(r) {
+		sim.Backends = rt.manifestTargets()
+		sim.Reason = "backend picked per request"
+		return sim, nil
+	}
+	be, _ := rt.pickBackend(r)
+	rewritePath(r, rt, be)
+	clientHost := r.Host
+	be.apply(r)
+	mapTargetPort(r, rt, be, clientHost)
+	sim.Target = r.URL.Redacted()
+	return sim, nil
+}
+
+// fixedBackend reports whether pickBackend settles on one backend for the
+// request without advancing the route's rotation.
+func (rt *route) fixedBackend(r *http.Request) bool {
+	switch {
+	case len(rt.pool) == 1 || rt.Sticky == "ip":
+		return true
+	case rt.Sticky == "cookie":
+		c, err := r.Cookie(rt.stickyCookie())
+		return err == nil && slices.ContainsFunc(rt.pool, func(b *backend) bool { return b.id == c.Value })
+	}
+	return false
+}
+
+// --- Route Simulation Admin Handlers ---
+
+func handleSimulate(w http.ResponseWriter, r *http.Request) {
+	var req SimulateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid simulation: %v", err))
+		return
+	}
+	sim, err := simulate(req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, sim)
+}
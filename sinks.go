@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	// NATS server URL(s) and subject prefix for the event stream
+	eventsNATS        string
+	eventsNATSSubject string
+
+	// Kafka brokers (comma-separated) and topic for the event stream
+	eventsKafka      string
+	eventsKafkaTopic string
+)
+
+// --- Event Sink Logic ---
+
+// startEventSinks publishes the event stream to the configured NATS and
+// Kafka endpoints so a central pipeline can ingest many relays.
+func startEventSinks() {
+	if eventsNATS != "" {
+		nc, err := nats.Connect(eventsNATS,
+			nats.Name("goRebind "+relayName),
+			nats.RetryOnFailedConnect(true),
+			nats.MaxReconnects(-1),
+		)
+		if err != nil {
+			log.Fatalf("Failed to connect to NATS: %v", err)
+		}
+		log.Printf("Publishing events to NATS %s (subject %s.<type>)", eventsNATS, eventsNATSSubject)
+		runEventSink("NATS", func(ev Event, data []byte) error {
+			return nc.Publish(eventsNATSSubject+"."+ev.Type, data)
+		}, func() {
+			_ = nc.FlushTimeout(5 * time.Second)
+			nc.Close()
+		})
+	}
+
+	if eventsKafka != "" {
+		writer := &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(eventsKafka, ",")...),
+			Topic:        eventsKafkaTopic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 100 * time.Millisecond,
+			Async:        true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					log.Printf("[EVENTS] Kafka publish of %d events failed: %v", len(messages), err)
+				}
+			},
+		}
+		log.Printf("Publishing events to Kafka %s (topic %s)", eventsKafka, eventsKafkaTopic)
+		runEventSink("Kafka", func(ev Event, data []byte) error {
+			// Keying by relay keeps each relay's events ordered within a partition
+			return writer.WriteMessages(context.Background(), kafka.Message{Key: []byte(relayName), Value: data})
+		}, func() {
+			_ = writer.Close()
+		})
+	}
+}
+
+// runEventSink forwards every event to publish until shutdown, then calls
+// flush so buffered events are not lost.
+func runEventSink(name string, publish func(ev Event, data []byte) error, flush func()) {
+	events, _ := subscribe(nil)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range events {
+			data, _ := json.Marshal(ev)
+			if err := publish(ev, data); err != nil {
+				log.Printf("[EVENTS] %s publish failed: %v", name, err)
+			}
+		}
+		flush()
+	}()
+	onShutdown(func(ctx context.Context) {
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	})
+}
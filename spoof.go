@@ -0,0 +1,35 @@
+package main
+
+import "net/http"
+
+// --- Response Header Spoofing Logic ---
+
+// spoofHeaders rewrites the fingerprinting headers of a response about to
+// be written for the request's route. Go's server writes header names in
+// canonical case and a fixed order, so that much is normalised already.
+func spoofHeaders(h http.Header, info *requestInfo) {
+	if info == nil || info.matched == nil || info.matched.SpoofHeaders == nil {
+		return
+	}
+	spoof := info.matched.SpoofHeaders
+	replaceHeader(h, "Server", spoof.Server)
+	replaceHeader(h, "X-Powered-By", spoof.PoweredBy)
+	for _, name := range spoof.Strip {
+		h.Del(name)
+	}
+	if !spoof.RequestID {
+		h.Del("X-Request-Id")
+	}
+}
+
+// replaceHeader sets a header to value, deletes it for "-", and leaves it
+// alone for "".
+func replaceHeader(h http.Header, name, value string) {
+	switch value {
+	case "":
+	case "-":
+		h.Del(name)
+	default:
+		h.Set(name, value)
+	}
+}
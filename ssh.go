@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"goRebind/adminclient"
+)
+
+// SSHTunnel is shared with the admin API client.
+type SSHTunnel = adminclient.SSHTunnel
+
+// sshConn is one relay-managed SSH connection, shared by every route
+// tunnelling through the same host as the same user.
+type sshConn struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+var (
+	sshMu       sync.Mutex
+	sshConns    = make(map[string]*sshConn)
+	sshShutdown sync.Once
+)
+
+// --- SSH Tunnel Logic ---
+
+// sshDialer returns a dialer that opens upstream connections as
+// direct-tcpip channels over a managed SSH connection.
+func sshDialer(cfg SSHTunnel) (dialFunc, error) {
+	if err := checkSSHTunnel(cfg); err != nil {
+		return nil, err
+	}
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	key := fmt.Sprintf("%s@%s|%s|%s", cfg.User, addr, cfg.Key, cfg.KnownHosts)
+
+	sshMu.Lock()
+	defer sshMu.Unlock()
+	if c, ok := sshConns[key]; ok {
+		return c.dial, nil
+	}
+
+	pem, err := os.ReadFile(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("via_ssh key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("via_ssh key %s: %w", cfg.Key, err)
+	}
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if cfg.InsecureHostKey {
+		log.Printf("Warning: via_ssh %s has insecure_host_key set; host key is not verified", addr)
+	} else if hostKey, err = knownhosts.New(cfg.KnownHosts); err != nil {
+		return nil, fmt.Errorf("via_ssh known_hosts: %w", err)
+	}
+
+	c := &sshConn{
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKey,
+			Timeout:         15 * time.Second,
+		},
+	}
+	sshConns[key] = c
+	sshShutdown.Do(func() {
+		onShutdown(func(context.Context) {
+			sshMu.Lock()
+			defer sshMu.Unlock()
+			for _, c := range sshConns {
+				c.close()
+			}
+		})
+	})
+	return c.dial, nil
+}
+
+// checkSSHTunnel rejects tunnels missing credentials or a way to verify
+// the server: an unverified host key has to be asked for explicitly.
+func checkSSHTunnel(cfg SSHTunnel) error {
+	switch {
+	case cfg.Host == "" || cfg.User == "" || cfg.Key == "":
+		return fmt.Errorf("via_ssh needs host, user and key")
+	case cfg.KnownHosts == "" && !cfg.InsecureHostKey:
+		return fmt.Errorf("via_ssh needs known_hosts to verify the host key (or insecure_host_key to skip it)")
+	case cfg.KnownHosts != "" && cfg.InsecureHostKey:
+		return fmt.Errorf("via_ssh known_hosts and insecure_host_key are mutually exclusive")
+	}
+	return nil
+}
+
+// dial opens a channel to addr through the tunnel, reconnecting once if
+// the SSH connection has died.
+func (c *sshConn) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := c.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.DialContext(ctx, network, addr)
+		if err == nil || attempt > 0 || ctx.Err() != nil {
+			return conn, err
+		}
+		// A dead connection fails every channel; a rejected one only this
+		if _, _, perr := client.SendRequest("keepalive@openssh.com", true, nil); perr == nil {
+			return nil, err
+		}
+		c.drop(client)
+	}
+}
+
+func (c *sshConn) get(ctx context.Context) (*ssh.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		return c.client, nil
+	}
+	conn, err := (&net.Dialer{Timeout: c.config.Timeout}).DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s: %w", c.addr, err)
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(conn, c.addr, c.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh %s: %w", c.addr, err)
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	c.client = client
+	log.Printf("[SSH] Connected to %s as %s", c.addr, c.config.User)
+
+	go c.keepalive(client)
+	go func() {
+		err := client.Wait()
+		log.Printf("[SSH] Connection to %s closed: %v", c.addr, err)
+		c.drop(client)
+	}()
+	return client, nil
+}
+
+// keepalive detects silently dropped tunnels between requests.
+func (c *sshConn) keepalive(client *ssh.Client) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			client.Close()
+			return
+		}
+	}
+}
+
+func (c *sshConn) drop(client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == client {
+		c.client = nil
+		client.Close()
+	}
+}
+
+func (c *sshConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Assets larger than this are streamed from disk instead of cached
+const maxCachedAsset = 512 << 10
+
+// Upper bound on cached assets across all static routes
+const maxCachedAssets = 1024
+
+type cachedAsset struct {
+	body        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+}
+
+var (
+	assetMu    sync.RWMutex
+	assetCache = make(map[string]*cachedAsset)
+)
+
+// --- Static Route Logic ---
+
+// staticTarget returns the file:// target serving this request, if any.
+func staticTarget(r *http.Request) *url.URL {
+	if isCloaked(r) {
+		if cloakDecoy != nil && cloakDecoy.Scheme == "file" {
+			return cloakDecoy
+		}
+		return nil
+	}
+	rt, exists := requestRoute(r)
+	if !exists || rt.target.Scheme != "file" {
+		return nil
+	}
+	return rt.target
+}
+
+// serveStatic serves a file from a file:// target directory, keeping small
+// assets (favicons, CSS, images) in memory so decoy pages render quickly.
+func serveStatic(w http.ResponseWriter, r *http.Request, target *url.URL) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	file := filepath.Join(filepath.FromSlash(target.Path), filepath.FromSlash(name))
+
+	info, err := os.Stat(file)
+	if err == nil && info.IsDir() {
+		file = filepath.Join(file, "index.html")
+		info, err = os.Stat(file)
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	asset, err := loadAsset(file, info)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if asset == nil {
+		http.ServeFile(w, r, file)
+		return
+	}
+
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("ETag", asset.etag)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeContent(w, r, file, asset.modTime, bytes.NewReader(asset.body))
+}
+
+// loadAsset returns the cached copy of a file, refreshing it when the file
+// changed on disk. Large files return nil and are served directly.
+func loadAsset(file string, info os.FileInfo) (*cachedAsset, error) {
+	if info.Size() > maxCachedAsset {
+		return nil, nil
+	}
+
+	assetMu.RLock()
+	asset, ok := assetCache[file]
+	assetMu.RUnlock()
+	if ok && asset.modTime.Equal(info.ModTime()) {
+		return asset, nil
+	}
+
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	asset = &cachedAsset{
+		body:        body,
+		contentType: assetContentType(file, body),
+		etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		modTime:     info.ModTime(),
+	}
+
+	assetMu.Lock()
+	if len(assetCache) >= maxCachedAssets {
+		assetCache = make(map[string]*cachedAsset)
+	}
+	assetCache[file] = asset
+	assetMu.Unlock()
+	return asset, nil
+}
+
+func assetContentType(file string, body []byte) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".ico":
+		return "image/x-icon"
+	case ".svg":
+		return "image/svg+xml"
+	case ".webmanifest":
+		return "application/manifest+json"
+	}
+	if ctype := mime.TypeByExtension(filepath.Ext(file)); ctype != "" {
+		return ctype
+	}
+	return http.DetectContentType(body)
+}
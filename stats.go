@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Stats and RouteStats are shared with the admin API client.
+type (
+	Stats      = adminclient.Stats
+	RouteStats = adminclient.RouteStats
+)
+
+var stats = &statsCollector{
+	started: time.Now(),
+	routes:  make(map[string]*RouteStats),
+
+	errorClasses: make(map[errorClass]uint64),
+}
+
+type statsCollector struct {
+	started time.Time
+
+	dnsQueries    atomic.Uint64
+	dnsHits       atomic.Uint64
+	httpRequests  atomic.Uint64
+	httpUnmatched atomic.Uint64
+	proxyErrors   atomic.Uint64
+	cloaked       atomic.Uint64
+	shed          atomic.Uint64
+	honeypot      atomic.Uint64
+
+	mu           sync.Mutex
+	routes       map[string]*RouteStats
+	errorClasses map[errorClass]uint64
+}
+
+// --- Stats Logic ---
+
+func (s *statsCollector) route(source string) *RouteStats {
+	rs, ok := s.routes[source]
+	if !ok {
+		rs = &RouteStats{}
+		s.routes[source] = rs
+	}
+	return rs
+}
+
+func (s *statsCollector) recordDNS(source string, hit bool) {
+	s.dnsQueries.Add(1)
+	if !hit {
+		return
+	}
+	s.dnsHits.Add(1)
+	s.mu.Lock()
+	s.route(source).DNSHits++
+	s.mu.Unlock()
+}
+
+func (s *statsCollector) recordHTTP(source string, hit bool) {
+	s.httpRequests.Add(1)
+	if !hit {
+		s.httpUnmatched.Add(1)
+		return
+	}
+	s.mu.Lock()
+	s.route(source).HTTPRequests++
+	s.mu.Unlock()
+}
+
+func (s *statsCollector) recordProxyError(class errorClass) {
+	s.proxyErrors.Add(1)
+	s.mu.Lock()
+	s.errorClasses[class]++
+	s.mu.Unlock()
+}
+
+func (s *statsCollector) snapshot() Stats {
+	snap := Stats{
+		UptimeSeconds: int64(time.Since(s.started).Seconds()),
+		DNSQueries:    s.dnsQueries.Load(),
+		DNSHits:       s.dnsHits.Load(),
+		HTTPRequests:  s.httpRequests.Load(),
+		HTTPUnmatched: s.httpUnmatched.Load(),
+		ProxyErrors:   s.proxyErrors.Load(),
+		Cloaked:       s.cloaked.Load(),
+		Shed:          s.shed.Load(),
+		Honeypot:      s.honeypot.Load(),
+		Routes:        make(map[string]*RouteStats),
+
+		ProxyErrorClasses: make(map[string]uint64),
+	}
+	s.mu.Lock()
+	for class, n := range s.errorClasses {
+		snap.ProxyErrorClasses[string(class)] = n
+	}
+	for source, rs := range s.routes {
+		copied := *rs
+		snap.Routes[source] = &copied
+	}
+	s.mu.Unlock()
+	snap.Clients = fingerprintCounts()
+	return snap
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// rebindStrategy decides when a client's lookups of a route switch from the
+// relay's address to the route's rebind_ip.
+type rebindStrategy struct {
+	// TTL of the answers; -1 uses -dns-ttl
+	TTL int
+	// Lookups answered with the relay's address before switching
+	AfterQueries int
+	// Minimum time since the client's first lookup before switching
+	After time.Duration
+	// Answer AAAA queries with no records, so dual-stack clients cannot
+	// reach the name over IPv6 and bypass the rebind
+	BlockAAAA bool
+}
+
+// strategyProfiles are tuned to each client's DNS pinning: browsers keep
+// answers for about a minute whatever the TTL, while many embedded HTTP
+// stacks resolve again for every request.
+var strategyProfiles = map[string]rebindStrategy{
+	// Chrome's host cache holds entries for up to a minute and pins
+	// established sockets, so the switch waits for the cache to lapse
+	"chrome": {TTL: 0, AfterQueries: 1, After: 60 * time.Second, BlockAAAA: true},
+	// Firefox caches for network.dnsCacheExpiration (60s) regardless of TTL
+	"firefox": {TTL: 0, AfterQueries: 1, After: 60 * time.Second, BlockAAAA: true},
+	// Safari goes through mDNSResponder, which honours short TTLs
+	"safari": {TTL: 1, AfterQueries: 1, After: 2 * time.Second, BlockAAAA: true},
+	// Embedded stacks rarely cache; the second lookup already rebinds
+	"iot": {TTL: 0, AfterQueries: 1},
+}
+
+// Used for routes with a rebind_ip but no strategy_profile
+var defaultStrategy = rebindStrategy{TTL: -1, AfterQueries: 1}
+
+const (
+	// Client/host pairs tracked before the oldest state is dropped
+	maxStrategyStates = 10000
+	// A client that stays quiet this long starts over with the relay's address
+	strategyIdle = 10 * time.Minute
+)
+
+// strategyState tracks one client's lookups of one host.
+type strategyState struct {
+	first, last time.Time
+	answered    int
+	rebound     bool
+	profile     string // in effect at the last lookup
+}
+
+// RebindState is returned by the admin API.
+type RebindState = adminclient.RebindState
+
+var (
+	strategyMu     sync.Mutex
+	strategyStates = make(map[string]*strategyState)
+)
+
+// --- Rebind Strategy Logic ---
+
+// parseStrategy validates rebind_ip, strategy_profile and auto_profiles.
+func (rt *route) parseStrategy() error {
+	if rt.StrategyProfile != "" && rt.StrategyProfile != autoProfile {
+		if _, ok := strategyProfiles[rt.StrategyProfile]; !ok {
+			return fmt.Errorf("unknown strategy_profile %q (one of: %s, %s)", rt.StrategyProfile, autoProfile, strings.Join(sortedKeys(strategyProfiles), ", "))
+		}
+	}
+	if err := rt.parseAutoProfiles(); err != nil {
+		return err
+	}
+	if rt.RebindIP == "" {
+		return nil
+	}
+	ip := net.ParseIP(rt.RebindIP)
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid rebind_ip %q: an IPv4 address is required", rt.RebindIP)
+	}
+	rt.rebindIP = ip.To4()
+	return nil
+}
+
+// strategy returns the route's rebind timing; for auto routes the default
+// one used until a client's fingerprint suits a profile.
+func (rt *route) strategy() rebindStrategy {
+	return profileStrategy(rt.StrategyProfile)
+}
+
+// clientStrategy returns the rebind timing of a client's lookups.
+func (rt *route) clientStrategy(client string) rebindStrategy {
+	return profileStrategy(rt.profileFor(client))
+}
+
+// profileStrategy returns a profile's rebind timing, the default one for
+// "" and auto.
+func profileStrategy(profile string) rebindStrategy {
+	s, ok := strategyProfiles[profile]
+	if !ok {
+		s = defaultStrategy
+	}
+	if deterministic {
+		// Fixed sequences: the switch depends on the lookup count alone
+		s.After = 0
+	}
+	return s
+}
+
+// answerTTL is the TTL for the route's answers to a client.
+func (rt *route) answerTTL(client string) uint32 {
+	if ttl := rt.clientStrategy(client).TTL; ttl >= 0 {
+		return uint32(ttl)
+	}
+	return uint32(dnsTTL)
+}
+
+// rebindAnswer returns the address a client's A lookup of host gets: the
+// relay's until the route's strategy fires, rebind_ip afterwards.
+func (rt *route) rebindAnswer(client, host string) net.IP {
+	if rt.rebindIP == nil {
+		return interfaceIP
+	}
+	profile := rt.profileFor(client)
+	s := profileStrategy(profile)
+	now := time.Now()
+	key := client + "|" + host
+
+	strategyMu.Lock()
+	st, ok := strategyStates[key]
+	if !ok || now.Sub(st.last) > strategyIdle {
+		if len(strategyStates) >= maxStrategyStates {
+			strategyStates = make(map[string]*strategyState)
+		}
+		st = &strategyState{first: now}
+		strategyStates[key] = st
+	}
+	st.last = now
+	picked := rt.picksProfile() && (!ok || st.profile != profile)
+	st.profile = profile
+	switched := false
+	if st.switches(s, now) {
+		st.rebound, switched = true, true
+	}
+	rebound := st.rebound
+	if !rebound {
+		st.answered++
+	}
+	answered := st.answered
+	strategyMu.Unlock()
+
+	if picked {
+		log.Printf("[STRATEGY] %s for %s%s: auto picked profile %s", host, client, fingerprintTag(client), valueOr(profile, "default"))
+	}
+	if switched {
+		log.Printf("[DNS] Rebind: %s for %s%s -> %s after %d lookup(s), %s (profile %s)", host, client, fingerprintTag(client), rt.rebindIP, answered, now.Sub(st.first).Round(time.Millisecond), valueOr(profile, "default"))
+	}
+	if rebound {
+		return rt.rebindIP
+	}
+	return interfaceIP
+}
+
+// switches reports whether a lookup at now moves the state to rebind_ip.
+func (st *strategyState) switches(s rebindStrategy, now time.Time) bool {
+	return !st.rebound && st.answered >= s.AfterQueries && now.Sub(st.first) >= s.After
+}
+
+// peekRebindAnswer is rebindAnswer without recording the lookup, along
+// with the client's live state for host, if any.
+func (rt *route) peekRebindAnswer(client, host string) (net.IP, *RebindState) {
+	if rt.rebindIP == nil {
+		return interfaceIP, nil
+	}
+	now := time.Now()
+	strategyMu.Lock()
+	st, ok := strategyStates[client+"|"+host]
+	if ok && now.Sub(st.last) > strategyIdle {
+		ok = false
+	}
+	var state *RebindState
+	if !ok {
+		// The lookup would start a new state
+		st = &strategyState{first: now}
+	} else {
+		state = &RebindState{
+			Client:      client,
+			Host:        host,
+			Profile:     valueOr(st.profile, "default"),
+			Lookups:     st.answered,
+			Rebound:     st.rebound,
+			FirstLookup: st.first.UTC(),
+			LastLookup:  st.last.UTC(),
+		}
+	}
+	rebound := st.rebound || st.switches(profileStrategy(rt.profileFor(client)), now)
+	strategyMu.Unlock()
+	if rebound {
+		return rt.rebindIP, state
+	}
+	return interfaceIP, state
+}
+
+// rebindStates lists the live strategy states matching client and host
+// ("" matches any), most recent lookup first. States idle past
+// strategyIdle are left out, as the next lookup starts them over.
+func rebindStates(client, host string) []RebindState {
+	now := time.Now()
+	var list []RebindState
+	strategyMu.Lock()
+	for key, st := range strategyStates {
+		c, h, _ := strings.Cut(key, "|")
+		if now.Sub(st.last) > strategyIdle || (client != "" && c != client) || (host != "" && h != host) {
+			continue
+		}
+		list = append(list, RebindState{
+			Client:      c,
+			Host:        h,
+			Profile:     valueOr(st.profile, "default"),
+			Lookups:     st.answered,
+			Rebound:     st.rebound,
+			FirstLookup: st.first.UTC(),
+			LastLookup:  st.last.UTC(),
+		})
+	}
+	strategyMu.Unlock()
+	slices.SortFunc(list, func(a, b RebindState) int { return b.LastLookup.Compare(a.LastLookup) })
+	return list
+}
+
+// resetRebindStates drops the strategy states matching client and host, so
+// their next lookup gets the relay's address again.
+func resetRebindStates(client, host string) int {
+	strategyMu.Lock()
+	defer strategyMu.Unlock()
+	n := 0
+	for key := range strategyStates {
+		c, h, _ := strings.Cut(key, "|")
+		if (client == "" || c == client) && (host == "" || h == host) {
+			delete(strategyStates, key)
+			n++
+		}
+	}
+	return n
+}
+
+// handleListRebindStates lists clients' progress through rebind
+// strategies. ?client= and ?host= filter by client address and host.
+func handleListRebindStates(w http.ResponseWriter, r *http.Request) {
+	list := rebindStates(r.URL.Query().Get("client"), sessionHost(r.URL.Query().Get("host")))
+	if list == nil {
+		list = []RebindState{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleResetRebindStates starts the matching clients' strategies over.
+func handleResetRebindStates(w http.ResponseWriter, r *http.Request) {
+	client, host := r.URL.Query().Get("client"), sessionHost(r.URL.Query().Get("host"))
+	n := resetRebindStates(client, host)
+	log.Printf("[STRATEGY] Reset %d rebind state(s) (client %s, host %s) by %s", n, valueOr(client, "any"), valueOr(host, "any"), requestActor(r))
+	auditRequest(r, "rebind_reset", map[string]any{"client": client, "host": host, "reset": n})
+	writeJSON(w, http.StatusOK, adminclient.RebindReset{Reset: n})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+var (
+	// Syslog destination (udp://host:514 or tcp://host:514)
+	syslogAddr string
+
+	// Security event format: cef or leef
+	syslogFormat string
+)
+
+// Syslog facility local0
+const syslogFacility = 16
+
+// securityEvent is an Event mapped onto SIEM fields.
+type securityEvent struct {
+	id       string
+	name     string
+	severity int // CEF scale, 0-10
+	fields   [][2]string
+}
+
+// --- CEF/LEEF Syslog Logic ---
+
+// startSyslogSink forwards the event stream to a syslog collector as CEF
+// or LEEF records, the formats most SIEM parsers understand out of the box.
+func startSyslogSink() {
+	if syslogAddr == "" {
+		return
+	}
+	u, err := url.Parse(syslogAddr)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		log.Fatalf("Invalid -syslog address %q: expected udp://host:port or tcp://host:port", syslogAddr)
+	}
+	var format func(securityEvent) string
+	switch syslogFormat {
+	case "cef":
+		format = formatCEF
+	case "leef":
+		format = formatLEEF
+	default:
+		log.Fatalf("Invalid -syslog-format %q: expected cef or leef", syslogFormat)
+	}
+
+	w := &syslogWriter{network: u.Scheme, addr: u.Host}
+	log.Printf("Sending %s security events to syslog %s", strings.ToUpper(syslogFormat), syslogAddr)
+	runEventSink("syslog", func(ev Event, _ []byte) error {
+		se := toSecurityEvent(ev)
+		return w.write(ev.Time, se.severity, format(se))
+	}, w.close)
+}
+
+// toSecurityEvent maps an event onto a signature ID, name, severity and
+// the standard CEF extension keys.
+func toSecurityEvent(ev Event) securityEvent {
+	se := securityEvent{fields: [][2]string{{"rt", strconv.FormatInt(ev.Time.UnixMilli(), 10)}}}
+	add := func(k, v string) {
+		if v != "" {
+			se.fields = append(se.fields, [2]string{k, v})
+		}
+	}
+	client, port, err := net.SplitHostPort(ev.Client)
+	if err != nil {
+		client, port = ev.Client, ""
+	}
+	add("src", client)
+	add("spt", port)
+	add("dhost", ev.Host)
+
+	switch ev.Type {
+	case adminclient.EventDNSQuery:
+		se.id, se.name, se.severity = "100", "DNS query", 3
+		add("cs1Label", "qtype")
+		add("cs1", ev.QType)
+		if ev.Hit {
+			se.severity = 5
+			add("act", "answered-with-relay")
+		} else {
+			add("act", "forwarded")
+		}
+	case adminclient.EventRebind:
+		se.id, se.name, se.severity = "200", "DNS rebind", 7
+		add("cs2Label", "route")
+		add("cs2", ev.Route)
+	case adminclient.EventHTTPRequest:
+		se.id, se.name, se.severity = "300", "HTTP request", 3
+		if ev.Route != "" {
+			se.severity = 5
+		}
+		add("requestMethod", ev.Method)
+		add("request", ev.Path)
+		add("cs2Label", "route")
+		add("cs2", ev.Route)
+		add("cs3Label", "requestId")
+		add("cs3", ev.RequestID)
+		if ev.Status != 0 {
+			add("cn1Label", "status")
+			add("cn1", strconv.Itoa(ev.Status))
+		}
+	case adminclient.EventHoneypot:
+		se.id, se.name, se.severity = "600", "Honeypot request", 6
+		add("requestMethod", ev.Method)
+		add("request", ev.Path)
+		add("requestClientApplication", ev.Message)
+		add("cs3Label", "requestId")
+		add("cs3", ev.RequestID)
+		add("cn1Label", "status")
+		add("cn1", strconv.Itoa(ev.Status))
+		ev.Message = ""
+	case adminclient.EventBaitHit:
+		se.id, se.name, se.severity = "700", "Bait triggered", 8
+	case adminclient.EventSession:
+		se.id, se.name, se.severity = "800", "Rebinding session", 4
+		if ev.Session != nil && ev.Session.Rebound {
+			se.severity = 7
+		}
+		add("cs2Label", "route")
+		add("cs2", ev.Route)
+	case adminclient.EventKillSwitch:
+		se.id, se.name, se.severity = "400", "Kill switch", 9
+	case adminclient.EventRoutesChanged:
+		se.id, se.name, se.severity = "500", "Routes changed", 3
+	default:
+		se.id, se.name, se.severity = "900", ev.Type, 3
+	}
+	add("msg", ev.Message)
+	add("dvchost", ev.Relay)
+	if ev.Engagement != "" {
+		add("cs4Label", "engagement")
+		add("cs4", ev.Engagement)
+	}
+	return se
+}
+
+// formatCEF renders an ArcSight Common Event Format record.
+func formatCEF(se securityEvent) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	value := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|goRebind|goRebind|%s|%s|%s|%d|", header.Replace(version), se.id, header.Replace(se.name), se.severity)
+	for i, f := range se.fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f[0] + "=" + value.Replace(f[1]))
+	}
+	return b.String()
+}
+
+// formatLEEF renders an IBM QRadar LEEF 2.0 record (tab-delimited).
+func formatLEEF(se securityEvent) string {
+	header := strings.NewReplacer("|", " ")
+	value := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	var b strings.Builder
+	fmt.Fprintf(&b, "LEEF:2.0|goRebind|goRebind|%s|%s|", header.Replace(version), se.id)
+	fmt.Fprintf(&b, "cat=%s\tsev=%d", value.Replace(se.name), se.severity)
+	for _, f := range se.fields {
+		key := f[0]
+		switch key {
+		case "rt":
+			key = "devTime"
+		case "spt":
+			key = "srcPort"
+		}
+		b.WriteString("\t" + key + "=" + value.Replace(f[1]))
+	}
+	return b.String()
+}
+
+// syslogWriter sends RFC 5424 messages, reconnecting TCP streams on error.
+type syslogWriter struct {
+	network string
+	addr    string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *syslogWriter) write(t time.Time, severity int, msg string) error {
+	// Map the 0-10 CEF scale onto syslog severities
+	level := 6 // informational
+	switch {
+	case severity >= 9:
+		level = 2 // critical
+	case severity >= 7:
+		level = 4 // warning
+	case severity >= 5:
+		level = 5 // notice
+	}
+	host, _ := os.Hostname()
+	line := fmt.Sprintf("<%d>1 %s %s goRebind %d - - %s", syslogFacility*8+level, t.Format(time.RFC3339Nano), valueOr(host, "-"), os.Getpid(), msg)
+	if w.network == "tcp" {
+		line += "\n"
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			conn, err := net.DialTimeout(w.network, w.addr, 5*time.Second)
+			if err != nil {
+				return err
+			}
+			w.conn = conn
+		}
+		if _, err := w.conn.Write([]byte(line)); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("write to %s failed", w.addr)
+}
+
+func (w *syslogWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
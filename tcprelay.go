@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JSON file of raw TCP relays (FTP, SMTP, IMAP, Redis, ...)
+var tcpRelaysFile string
+
+const (
+	// Longest line handed to a protocol module at once; longer lines
+	// arrive in pieces of this size
+	tcpMaxLine = 64 << 10
+	// Time a refusing session waits for the server to answer what was
+	// forwarded before the refusal and hang up
+	tcpDrainTimeout = 10 * time.Second
+)
+
+// tcpRelayConfig is one listener forwarding raw TCP to a fixed target.
+type tcpRelayConfig struct {
+	Listen   string `json:"listen,omitempty"`   // e.g. ":21"
+	Target   string `json:"target"`             // host:port
+	Protocol string `json:"protocol,omitempty"` // raw (default), ftp, smtp, imap, redis, memcached
+
+	// Replace the server greeting text
+	Banner string `json:"banner,omitempty"`
+	// Hide STARTTLS / AUTH TLS so clients stay in cleartext
+	StripStartTLS bool `json:"strip_starttls,omitempty"`
+	// Log credentials seen in USER/PASS, AUTH and LOGIN commands
+	LogCredentials bool `json:"log_credentials,omitempty"`
+	// Redis/Memcached commands to let through, or to refuse
+	Allow []string `json:"allow_commands,omitempty"`
+	Deny  []string `json:"deny_commands,omitempty"`
+	// Log every Redis/Memcached command, not just refused ones
+	LogCommands bool `json:"log_commands,omitempty"`
+
+	proto tcpProtocol
+}
+
+// tcpProtocol is a line-oriented protocol module. Either hook may return
+// nil to drop a line; reply, if set, is sent straight back to the client
+// instead of forwarding. Lines longer than tcpMaxLine are passed in pieces,
+// the last of which ends in a newline.
+type tcpProtocol interface {
+	fromClient(s *tcpSession, line []byte) (forward, reply []byte)
+	fromServer(s *tcpSession, line []byte) []byte
+}
+
+// tcpSession is one relayed connection.
+type tcpSession struct {
+	cfg    *tcpRelayConfig
+	client net.Conn
+	server net.Conn
+
+	// Guarded dialer the server connection came from, reused for FTP data
+	dial dialFunc
+
+	writeMu sync.Mutex
+
+	// Set once the client asks for TLS the relay lets through; the server
+	// side goes opaque after the line it matches
+	upgradeOn atomic.Pointer[func([]byte) bool]
+
+	// Set by refuse: the client is no longer read, and this reply follows
+	// the server's last one
+	final atomic.Pointer[[]byte]
+
+	// Protocol state, owned by the client or server loop respectively
+	clientState string
+	serverState string
+	user        string
+	reply       [][]byte
+	resp        respParser
+	dataLeft    int // bytes of a memcached data block still to come
+}
+
+// --- TCP Relay Logic ---
+
+func startTCPRelays() {
+	if tcpRelaysFile == "" {
+		return
+	}
+	data, err := os.ReadFile(tcpRelaysFile)
+	if err != nil {
+		log.Fatalf("Failed to read TCP relays: %v", err)
+	}
+	var relays []*tcpRelayConfig
+	if err := json.Unmarshal(data, &relays); err != nil {
+		log.Fatalf("Invalid TCP relays: %v", err)
+	}
+	for _, cfg := range relays {
+		if err := cfg.validate(); err != nil {
+			log.Fatalf("Invalid TCP relay %s: %v", cfg.Listen, err)
+		}
+		startTCPRelay(cfg)
+	}
+}
+
+// validate checks the relay's target and sets up its protocol module.
+func (cfg *tcpRelayConfig) validate() error {
+	var err error
+	if cfg.proto, err = newTCPProtocol(cfg.Protocol); err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(cfg.Target); err != nil {
+		return fmt.Errorf("target %q: %v", cfg.Target, err)
+	}
+	return nil
+}
+
+// startTCPRelay listens on cfg.Listen and relays in the background.
+func startTCPRelay(cfg *tcpRelayConfig) {
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Fatalf("Failed to start TCP relay %s: %v", cfg.Listen, err)
+	}
+	onShutdown(func(context.Context) { ln.Close() })
+	log.Printf("TCP relay (%s) listening on %s -> %s", valueOr(cfg.Protocol, "raw"), cfg.Listen, cfg.Target)
+	go serveTCPRelay(ln, cfg)
+}
+
+func newTCPProtocol(name string) (tcpProtocol, error) {
+	switch name {
+	case "", "raw":
+		return nil, nil
+	case "ftp":
+		return ftpProtocol{}, nil
+	case "smtp":
+		return smtpProtocol{}, nil
+	case "imap":
+		return imapProtocol{}, nil
+	case "redis":
+		return redisProtocol{}, nil
+	case "memcached":
+		return memcachedProtocol{}, nil
+	}
+	return nil, fmt.Errorf("unknown protocol %q", name)
+}
+
+func serveTCPRelay(ln net.Listener, cfg *tcpRelayConfig) {
+	dial := guardedDial((&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("[TCP] Accept on %s failed: %v", cfg.Listen, err)
+			}
+			return
+		}
+		touchActivity()
+		go handleTCPConn(conn, cfg, dial)
+	}
+}
+
+func handleTCPConn(client net.Conn, cfg *tcpRelayConfig, dial dialFunc) {
+	defer client.Close()
+	// The kill switch stops relaying just like it disables HTTP routes
+	if forwardOnly() {
+		return
+	}
+	server, err := dial(context.Background(), "tcp", cfg.Target)
+	if err != nil {
+		log.Printf("[TCP] %s %s -> %s: %v", valueOr(cfg.Protocol, "raw"), client.RemoteAddr(), cfg.Target, err)
+		return
+	}
+	defer server.Close()
+
+	start := time.Now()
+	s := &tcpSession{cfg: cfg, client: client, server: server, dial: dial}
+	log.Printf("[TCP] %s %s -> %s connected", valueOr(cfg.Protocol, "raw"), client.RemoteAddr(), cfg.Target)
+
+	var up, down int64
+	done := make(chan struct{})
+	go func() {
+		down = s.pumpServer()
+		if final := s.final.Load(); final != nil {
+			s.writeClient(*final)
+		}
+		// Unblock the client side once the server hangs up
+		client.Close()
+		close(done)
+	}()
+	up = s.pumpClient()
+	// Pass the client's EOF on; a server that cannot be half-closed is hung
+	// up on, or sessions with idle servers (Redis, IMAP) would never end
+	if tcp, ok := server.(interface{ CloseWrite() error }); !ok || tcp.CloseWrite() != nil {
+		server.Close()
+	}
+	if s.final.Load() != nil {
+		_ = server.SetReadDeadline(time.Now().Add(tcpDrainTimeout))
+	}
+	<-done
+	log.Printf("[TCP] %s %s -> %s closed after %s (%d bytes up, %d down)", valueOr(cfg.Protocol, "raw"), client.RemoteAddr(), cfg.Target, time.Since(start).Round(time.Millisecond), up, down)
+}
+
+// pumpClient relays client lines upstream until EOF, a refusal or a TLS
+// upgrade, after which bytes are copied untouched.
+func (s *tcpSession) pumpClient() int64 {
+	if s.cfg.proto == nil {
+		n, _ := io.Copy(s.server, s.client)
+		return n
+	}
+	var total int64
+	r := bufio.NewReaderSize(s.client, tcpMaxLine)
+	for {
+		line, err := readLine(r)
+		if len(line) > 0 {
+			total += int64(len(line))
+			forward, reply := s.cfg.proto.fromClient(s, line)
+			if reply != nil {
+				s.writeClient(reply)
+			}
+			if forward != nil {
+				if _, werr := s.server.Write(forward); werr != nil {
+					return total
+				}
+			}
+			if s.final.Load() != nil {
+				return total
+			}
+			if s.upgradeOn.Load() != nil {
+				n, _ := io.Copy(s.server, r)
+				return total + n
+			}
+		}
+		if err != nil {
+			return total
+		}
+	}
+}
+
+// pumpServer relays server lines to the client.
+func (s *tcpSession) pumpServer() int64 {
+	if s.cfg.proto == nil {
+		n, _ := io.Copy(s.client, s.server)
+		return n
+	}
+	var total int64
+	r := bufio.NewReaderSize(s.server, tcpMaxLine)
+	for {
+		line, err := readLine(r)
+		if len(line) > 0 {
+			total += int64(len(line))
+			if out := s.cfg.proto.fromServer(s, line); out != nil {
+				if s.writeClient(out) != nil {
+					return total
+				}
+			}
+			if upgrade := s.upgradeOn.Load(); upgrade != nil && (*upgrade)(line) {
+				n, _ := io.Copy(s.client, r)
+				return total + n
+			}
+		}
+		if err != nil {
+			return total
+		}
+	}
+}
+
+// readLine returns the next line, or the next tcpMaxLine bytes of a longer
+// one, so a peer cannot make the relay buffer an endless line.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		err = nil
+	}
+	return bytes.Clone(line), err
+}
+
+// refuse ends the session with a reply of the relay's own. The client is
+// no longer read, and the reply is sent after the server has answered the
+// commands already forwarded and hung up, so pipelined clients still match
+// each reply to its command.
+func (s *tcpSession) refuse(reply string) ([]byte, []byte) {
+	b := []byte(reply)
+	s.final.Store(&b)
+	return nil, nil
+}
+
+func (s *tcpSession) writeClient(b []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.client.Write(b)
+	return err
+}
+
+// passTLS lets a TLS upgrade through: the client side goes opaque now,
+// the server side after the reply matching done.
+func (s *tcpSession) passTLS(done func([]byte) bool) {
+	s.upgradeOn.Store(&done)
+}
+
+// logCredentials records a captured login when the relay asks for it.
+func (s *tcpSession) logCredentials(user, pass string) {
+	if !s.cfg.LogCredentials {
+		return
+	}
+	client := s.client.RemoteAddr().String()
+	log.Printf("[CREDS] %s %s -> %s user=%q pass=%q", s.cfg.Protocol, client, s.cfg.Target, user, pass)
+	audit("system", client, "credential_capture", map[string]string{
+		"protocol": s.cfg.Protocol, "target": s.cfg.Target, "user": user, "pass": pass,
+	})
+}
+
+// splitCommand returns the upper-cased verb and the rest of a command line.
+func splitCommand(line []byte) (string, string) {
+	verb, rest, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+	return strings.ToUpper(verb), rest
+}
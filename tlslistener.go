@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// HTTPS listener port (0 disables)
+	tlsPort int
+
+	// Copy subject, SANs and issuer from the routed target's certificate
+	tlsClone bool
+
+	// Leaf certificates minted per server name
+	leafMu    sync.Mutex
+	leafCerts = make(map[string]*leafEntry)
+)
+
+type leafEntry struct {
+	once   sync.Once
+	cert   *tls.Certificate
+	err    error
+	source string
+
+	// When the OCSP staple is next refreshed
+	stapleRefresh time.Time
+}
+
+// --- Certificate Minting Logic ---
+
+// leafCertificate returns the certificate for the ClientHello's server
+// name: the routing route's tls_cert, an imported or ACME certificate,
+// -tls-cert,
+// or else one minted on first use and cached. Clients without SNI get one
+// for the address they connected to.
+func leafCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		name, _, _ = net.SplitHostPort(hello.Conn.LocalAddr().String())
+	}
+	if cert := routeCertificate(name); cert != nil {
+		return cert, nil
+	}
+
+	leafMu.Lock()
+	entry, ok := leafCerts[name]
+	if !ok || entry.source == certMinted {
+		// An imported or ACME wildcard certificate covering one label wins
+		// over minting
+		if _, parent, found := strings.Cut(name, "."); found {
+			if wild, ok := leafCerts["*."+parent]; ok && wild.cert != nil && (wild.source == certImported || wild.source == certACME) {
+				leafMu.Unlock()
+				return wild.cert, nil
+			}
+		}
+	}
+	if (!ok || entry.source == certMinted) && defaultCert != nil {
+		leafMu.Unlock()
+		return defaultCert, nil
+	}
+	if !ok {
+		entry = &leafEntry{source: certMinted}
+		leafCerts[name] = entry
+	}
+	leafMu.Unlock()
+
+	entry.once.Do(func() {
+		cert, err := mintLeaf(name)
+		if err != nil {
+			log.Printf("[TLS] Failed to mint certificate for %s: %v", name, err)
+		} else {
+			saveLeaf(name, cert, certMinted)
+		}
+		leafMu.Lock()
+		entry.cert, entry.err = cert, err
+		leafMu.Unlock()
+	})
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return stapled(entry), nil
+}
+
+// mintLeaf creates a certificate for name, signed by the internal CA if
+// there is one and self-signed otherwise. With -tls-clone and an https
+// route for the name, the subject, SANs, validity and serial are copied
+// from the target's real certificate, and the key has the same type and
+// size. Self-signed clones copy the issuer name too, so only the
+// signature gives them away.
+func mintLeaf(name string) (*tls.Certificate, error) {
+	leafMu.Lock()
+	ca := internalCA
+	leafMu.Unlock()
+
+	var orig *x509.Certificate
+	if tlsClone {
+		if rt, ok := lookupRoute(name); ok {
+			var err error
+			if orig, err = fetchTargetCert(rt); err != nil {
+				log.Printf("[TLS] Not cloning certificate for %s: %v", name, err)
+			}
+		}
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	parent := template
+	var origPub crypto.PublicKey
+	if orig != nil {
+		template.SerialNumber = orig.SerialNumber
+		template.RawSubject = orig.RawSubject
+		template.DNSNames = orig.DNSNames
+		template.IPAddresses = orig.IPAddresses
+		template.EmailAddresses = orig.EmailAddresses
+		template.URIs = orig.URIs
+		template.NotBefore, template.NotAfter = orig.NotBefore, orig.NotAfter
+		template.KeyUsage, template.ExtKeyUsage = orig.KeyUsage, orig.ExtKeyUsage
+		template.SubjectKeyId = orig.SubjectKeyId
+		// The issuer name and key ID come from the parent
+		parent = &x509.Certificate{RawSubject: orig.RawIssuer, SubjectKeyId: orig.AuthorityKeyId}
+		origPub = orig.PublicKey
+	}
+
+	key, err := leafKey(origPub)
+	if err != nil {
+		return nil, err
+	}
+	var signer any = key
+	if ca != nil {
+		parent, signer = ca.Leaf, ca.PrivateKey
+		revocationURLs(template)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	issuer := "self-signed"
+	if ca != nil {
+		issuer = "signed by " + ca.Leaf.Subject.CommonName
+	}
+	if orig != nil {
+		log.Printf("[TLS] Minted certificate for %s cloned from %q (%s)", name, orig.Subject.String(), issuer)
+	} else {
+		log.Printf("[TLS] Minted certificate for %s (%s)", name, issuer)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// leafKey generates a key matching the type and size of like, or an
+// ECDSA P-256 key.
+func leafKey(like crypto.PublicKey) (crypto.Signer, error) {
+	switch pub := like.(type) {
+	case *rsa.PublicKey:
+		return rsa.GenerateKey(rand.Reader, pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	case ed25519.PublicKey:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// fetchTargetCert handshakes with an https route's target (through the
+// route's egress, if any) and returns the certificate it presents.
+func fetchTargetCert(rt *route) (*x509.Certificate, error) {
+	if rt.target == nil || rt.target.Scheme != "https" {
+		return nil, errors.New("target is not https")
+	}
+	addr := rt.target.Host
+	if rt.target.Port() == "" {
+		addr = net.JoinHostPort(rt.target.Hostname(), "443")
+	}
+	dial := warmDial
+	if rt.egress != nil {
+		dial = guardedDial(dialUnix(rt.egress))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: valueOr(rt.SNI, rt.target.Hostname()), InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	return certs[0], nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Top and TopEntry are shared with the admin API client.
+type (
+	Top      = adminclient.Top
+	TopEntry = adminclient.TopEntry
+)
+
+const (
+	// Seconds the live rates are averaged over
+	topWindow = 10
+
+	// Distinct clients or routes counted per second; the rest are lumped
+	// together so a scan from many addresses cannot grow the table
+	maxTopKeys = 10000
+	topOther   = "(other)"
+)
+
+// topSecond counts one second of DNS queries and HTTP requests, in total
+// and per client and route.
+type topSecond struct {
+	unix    int64
+	dns     uint64
+	http    uint64
+	clients map[string]*TopEntry
+	routes  map[string]*TopEntry
+}
+
+var (
+	topMu      sync.Mutex
+	topSeconds [topWindow]topSecond
+)
+
+// --- Live Top Logic ---
+
+// recordTop counts a DNS query (dns) or HTTP request from client for
+// route, which is empty when nothing matched.
+func recordTop(dns bool, client, route string) {
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	now := time.Now().Unix()
+	topMu.Lock()
+	defer topMu.Unlock()
+	sec := &topSeconds[now%topWindow]
+	if sec.unix != now {
+		*sec = topSecond{unix: now, clients: make(map[string]*TopEntry), routes: make(map[string]*TopEntry)}
+	}
+	count := func(e *TopEntry) {
+		if dns {
+			e.DNSQueries++
+		} else {
+			e.HTTPRequests++
+		}
+	}
+	if dns {
+		sec.dns++
+	} else {
+		sec.http++
+	}
+	count(topEntry(sec.clients, client))
+	if route != "" {
+		count(topEntry(sec.routes, route))
+	}
+}
+
+// topEntry returns the entry for key, or the shared one once the second
+// has maxTopKeys. Callers hold topMu.
+func topEntry(entries map[string]*TopEntry, key string) *TopEntry {
+	e, ok := entries[key]
+	if !ok {
+		if len(entries) >= maxTopKeys {
+			key = topOther
+			if e, ok = entries[key]; ok {
+				return e
+			}
+		}
+		e = &TopEntry{Name: key}
+		entries[key] = e
+	}
+	return e
+}
+
+// topSnapshot sums the last topWindow seconds, with the n busiest clients
+// and routes.
+func topSnapshot(n int) Top {
+	now := time.Now().Unix()
+	clients := make(map[string]*TopEntry)
+	routes := make(map[string]*TopEntry)
+	var queries, requests uint64
+	topMu.Lock()
+	for i := range topSeconds {
+		sec := &topSeconds[i]
+		if sec.unix <= now-topWindow || sec.unix > now {
+			continue
+		}
+		queries += sec.dns
+		requests += sec.http
+		for _, pair := range []struct{ from, to map[string]*TopEntry }{{sec.clients, clients}, {sec.routes, routes}} {
+			for key, e := range pair.from {
+				sum, ok := pair.to[key]
+				if !ok {
+					sum = &TopEntry{Name: key}
+					pair.to[key] = sum
+				}
+				sum.DNSQueries += e.DNSQueries
+				sum.HTTPRequests += e.HTTPRequests
+			}
+		}
+	}
+	topMu.Unlock()
+	return Top{
+		WindowSeconds: topWindow,
+		DNSPerSecond:  float64(queries) / topWindow,
+		HTTPPerSecond: float64(requests) / topWindow,
+		Clients:       busiest(clients, n),
+		Routes:        busiest(routes, n),
+	}
+}
+
+// busiest returns the n entries with the most queries and requests.
+func busiest(entries map[string]*TopEntry, n int) []TopEntry {
+	list := make([]TopEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	slices.SortFunc(list, func(a, b TopEntry) int {
+		return cmp.Or(cmp.Compare(b.DNSQueries+b.HTTPRequests, a.DNSQueries+a.HTTPRequests), strings.Compare(a.Name, b.Name))
+	})
+	return list[:min(n, len(list))]
+}
+
+// handleTop serves the live rates; ?n= sets how many clients and routes
+// are listed (default 10).
+func handleTop(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "invalid n")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, topSnapshot(n))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"goRebind/adminclient"
+)
+
+type RequestTransform = adminclient.RequestTransform
+
+// Largest request body a transform template reads
+const maxTransformBody = 10 << 20
+
+// requestTemplate is a parsed transform.
+type requestTemplate struct {
+	query map[string]*template.Template // nil removes the parameter
+	body  *template.Template
+}
+
+// transformData is what transform templates see.
+type transformData struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+	Client string
+}
+
+// Functions available to transform templates besides the text/template ones
+var transformFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+}
+
+// --- Request Transform Logic ---
+
+// parseTransform validates the route's transform and compiles its templates.
+func (rt *route) parseTransform() error {
+	t := rt.Transform
+	if t == nil {
+		return nil
+	}
+	if t.Method != "" {
+		t.Method = strings.ToUpper(t.Method)
+		if strings.ContainsAny(t.Method, " \t\r\n/") {
+			return fmt.Errorf("invalid transform method %q", t.Method)
+		}
+	}
+	if strings.ContainsAny(t.ContentType, "\r\n") {
+		return fmt.Errorf("invalid transform content_type %q", t.ContentType)
+	}
+	tmpl := &requestTemplate{query: make(map[string]*template.Template, len(t.Query))}
+	for name, value := range t.Query {
+		if name == "" {
+			return fmt.Errorf("transform query parameters need a name")
+		}
+		if value == "-" {
+			tmpl.query[name] = nil
+			continue
+		}
+		parsed, err := template.New(name).Funcs(transformFuncs).Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid transform query %s: %w", name, err)
+		}
+		tmpl.query[name] = parsed
+	}
+	if t.Body != "" {
+		parsed, err := template.New("body").Funcs(transformFuncs).Parse(t.Body)
+		if err != nil {
+			return fmt.Errorf("invalid transform body: %w", err)
+		}
+		tmpl.body = parsed
+	}
+	rt.transform = tmpl
+	return nil
+}
+
+// transformRequest rewrites the outgoing request's method, query and body
+// as the route's transform says. A template that fails leaves the request
+// as the client sent it.
+func transformRequest(req *http.Request, rt *route) {
+	t := rt.Transform
+	if t == nil || rt.transform == nil {
+		return
+	}
+	client, _, _ := net.SplitHostPort(req.RemoteAddr)
+	data := transformData{
+		Method: req.Method,
+		Host:   req.Host,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query(),
+		Header: req.Header,
+		Client: client,
+	}
+	if rt.transform.body != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxTransformBody+1))
+		if err == nil && len(body) > maxTransformBody {
+			err = fmt.Errorf("body larger than %d MiB", maxTransformBody>>20)
+		}
+		if err != nil {
+			logRequest(req, "[TRANSFORM] %s: failed to read request body: %v", rt.name(), err)
+			req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+			return
+		}
+		req.Body.Close()
+		data.Body = string(body)
+		setRequestBody(req, body)
+	}
+
+	query := req.URL.Query()
+	for name, tmpl := range rt.transform.query {
+		if tmpl == nil {
+			query.Del(name)
+			continue
+		}
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			logRequest(req, "[TRANSFORM] %s: query %s: %v", rt.name(), name, err)
+			return
+		}
+		query.Set(name, value.String())
+	}
+	var body bytes.Buffer
+	if rt.transform.body != nil {
+		if err := rt.transform.body.Execute(&body, data); err != nil {
+			logRequest(req, "[TRANSFORM] %s: body: %v", rt.name(), err)
+			return
+		}
+	}
+
+	if len(rt.transform.query) > 0 {
+		req.URL.RawQuery = query.Encode()
+	}
+	if t.Method != "" {
+		req.Method = t.Method
+	}
+	if rt.transform.body != nil {
+		setRequestBody(req, body.Bytes())
+		req.Header.Del("Content-Encoding")
+		if t.ContentType != "" {
+			req.Header.Set("Content-Type", t.ContentType)
+		}
+	}
+}
+
+// setRequestBody replaces the request body, keeping it replayable.
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+}
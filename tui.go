@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"goRebind/adminclient"
+	"golang.org/x/term"
+)
+
+const (
+	// Log lines the console keeps for scrolling out of view
+	consoleLogLines = 500
+
+	// How often routes, rebind states and rates are fetched again
+	consoleRefresh = time.Second
+)
+
+// Console views, switched with Tab or their number
+const (
+	viewRoutes = iota
+	viewRebind
+)
+
+// consoleSnapshot is one poll of the relay.
+type consoleSnapshot struct {
+	manifest Manifest
+	states   []RebindState
+	top      Top
+	err      error
+}
+
+// console is the operator console's state; only runConsole's loop touches
+// it.
+type console struct {
+	client *adminclient.Client
+	addr   string
+
+	view   int
+	cursor [2]int
+	snap   consoleSnapshot
+	log    []string
+	status string
+
+	// Text typed for a new route while adding one; nil otherwise
+	prompt []rune
+}
+
+// --- Operator Console Logic ---
+
+// runConsole runs the interactive console on the terminal until q or
+// Ctrl-C: a live log of the relay's events under a route table to toggle
+// and add to, or the clients' rebind states to inspect and reset.
+func runConsole(client *adminclient.Client, args []string) {
+	if len(args) > 0 {
+		log.Fatal("Usage: goRebind ctl tui")
+	}
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		log.Fatal("ctl tui needs a terminal")
+	}
+	saved, err := term.MakeRaw(in)
+	if err != nil {
+		log.Fatalf("Failed to set up the terminal: %v", err)
+	}
+	// Alternate screen without a cursor, restored on the way out
+	fmt.Print("\033[?1049h\033[?25l")
+	defer func() {
+		fmt.Print("\033[?25h\033[?1049l")
+		_ = term.Restore(in, saved)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keys := make(chan string)
+	lines := make(chan string, eventBuffer)
+	snaps := make(chan consoleSnapshot)
+	refresh := make(chan struct{}, 1)
+	go readConsoleKeys(ctx, keys)
+	go streamConsoleLog(ctx, client, lines)
+	go pollConsole(ctx, client, snaps, refresh)
+
+	c := &console{client: client, addr: client.BaseURL, status: "Connecting..."}
+	for {
+		c.draw(out)
+		select {
+		case key, ok := <-keys:
+			if !ok || !c.handleKey(key) {
+				return
+			}
+			select {
+			case refresh <- struct{}{}:
+			default:
+			}
+		case line := <-lines:
+			c.log = append(c.log, screenSafe(line))
+			if over := len(c.log) - consoleLogLines; over > 0 {
+				c.log = c.log[over:]
+			}
+		case snap := <-snaps:
+			if c.status == "Connecting..." {
+				c.status = ""
+			}
+			c.snap = snap
+			c.clampCursor()
+		}
+	}
+}
+
+// readConsoleKeys sends each key pressed, by name for the keys the console
+// binds ("up", "enter", ...) and as itself otherwise, until stdin closes.
+func readConsoleKeys(ctx context.Context, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		// Terminals deliver an escape sequence in one read
+		var pressed []string
+		switch seq := string(buf[:n]); seq {
+		case "\033[A", "\033OA":
+			pressed = []string{"up"}
+		case "\033[B", "\033OB":
+			pressed = []string{"down"}
+		case "\033":
+			pressed = []string{"esc"}
+		default:
+			if strings.HasPrefix(seq, "\033") {
+				continue
+			}
+			for _, r := range seq {
+				switch r {
+				case '\r', '\n':
+					pressed = append(pressed, "enter")
+				case '\t':
+					pressed = append(pressed, "tab")
+				case 0x7f, '\b':
+					pressed = append(pressed, "backspace")
+				case 0x03:
+					pressed = append(pressed, "ctrl-c")
+				default:
+					pressed = append(pressed, string(r))
+				}
+			}
+		}
+		for _, key := range pressed {
+			select {
+			case keys <- key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// streamConsoleLog formats the relay's events as log lines, reconnecting
+// when the stream drops.
+func streamConsoleLog(ctx context.Context, client *adminclient.Client, lines chan<- string) {
+	send := func(line string) error {
+		select {
+		case lines <- line:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for {
+		err := client.Events(ctx, nil, func(ev Event) error { return send(formatConsoleEvent(ev)) })
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("stream ended")
+		}
+		if send(fmt.Sprintf("%s events: %v; reconnecting", time.Now().Format(time.TimeOnly), err)) != nil {
+			return
+		}
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// pollConsole fetches the route manifest, rebind states and rates every
+// consoleRefresh, and straight away after an action.
+func pollConsole(ctx context.Context, client *adminclient.Client, snaps chan<- consoleSnapshot, refresh <-chan struct{}) {
+	ticker := time.NewTicker(consoleRefresh)
+	defer ticker.Stop()
+	for {
+		var snap consoleSnapshot
+		reqCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		snap.manifest, snap.err = client.Manifest(reqCtx)
+		if snap.err == nil {
+			snap.states, snap.err = client.RebindStates(reqCtx, "", "")
+		}
+		if snap.err == nil {
+			snap.top, snap.err = client.Top(reqCtx, 1)
+		}
+		cancel()
+		select {
+		case snaps <- snap:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-refresh:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// formatConsoleEvent renders an event as one log line.
+func formatConsoleEvent(ev Event) string {
+	at := ev.Time.Local().Format(time.TimeOnly)
+	switch ev.Type {
+	case adminclient.EventDNSQuery:
+		result := "miss"
+		if ev.Hit {
+			result = "hit"
+		}
+		return fmt.Sprintf("%s DNS    %s %s %s (%s)", at, ev.Client, ev.QType, ev.Host, result)
+	case adminclient.EventHTTPRequest, adminclient.EventHoneypot:
+		label := "HTTP  "
+		if ev.Type == adminclient.EventHoneypot {
+			label = "HONEY "
+		}
+		return fmt.Sprintf("%s %s %s %s %s%s -> %d (%.0fms)", at, label, ev.Client, ev.Method, ev.Host, ev.Path, ev.Status, ev.DurationMS)
+	}
+	parts := []string{at, strings.ToUpper(ev.Type)}
+	for _, s := range []string{ev.Host, ev.Client, ev.Message} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// handleKey acts on a key, reporting false to quit.
+func (c *console) handleKey(key string) bool {
+	if key == "ctrl-c" {
+		return false
+	}
+	if c.prompt != nil {
+		switch key {
+		case "esc":
+			c.prompt = nil
+		case "enter":
+			c.addRoute(string(c.prompt))
+			c.prompt = nil
+		case "backspace":
+			if len(c.prompt) > 0 {
+				c.prompt = c.prompt[:len(c.prompt)-1]
+			}
+		case "tab", "up", "down":
+		default:
+			c.prompt = append(c.prompt, []rune(key)...)
+		}
+		return true
+	}
+
+	switch key {
+	case "q":
+		return false
+	case "tab":
+		c.view = (c.view + 1) % 2
+	case "1":
+		c.view = viewRoutes
+	case "2":
+		c.view = viewRebind
+	case "up", "k":
+		c.cursor[c.view]--
+	case "down", "j":
+		c.cursor[c.view]++
+	case "a":
+		c.prompt = []rune{}
+	case " ", "t":
+		if c.view == viewRoutes {
+			c.toggleRoute()
+		}
+	case "g":
+		if c.view == viewRoutes {
+			c.toggleGroup()
+		}
+	case "x":
+		if c.view == viewRebind {
+			c.resetState()
+		}
+	}
+	c.clampCursor()
+	return true
+}
+
+func (c *console) clampCursor() {
+	n := []int{len(c.snap.manifest.Routes), len(c.snap.states)}[c.view]
+	c.cursor[c.view] = max(min(c.cursor[c.view], n-1), 0)
+}
+
+// selectedRoute returns the route under the cursor, if any.
+func (c *console) selectedRoute() (adminclient.ManifestRoute, bool) {
+	routes := c.snap.manifest.Routes
+	if i := c.cursor[viewRoutes]; i < len(routes) {
+		return routes[i], true
+	}
+	return adminclient.ManifestRoute{}, false
+}
+
+func (c *console) toggleRoute() {
+	rt, ok := c.selectedRoute()
+	if !ok {
+		return
+	}
+	c.act(func(ctx context.Context) (string, error) {
+		if rt.Disabled {
+			_, err := c.client.EnableRoute(ctx, rt.ID)
+			return "Enabled route " + rt.ID, err
+		}
+		_, err := c.client.DisableRoute(ctx, rt.ID)
+		return "Disabled route " + rt.ID, err
+	})
+}
+
+func (c *console) toggleGroup() {
+	rt, ok := c.selectedRoute()
+	if !ok {
+		return
+	}
+	if rt.Group == "" {
+		c.status = rt.ID + " is in no group"
+		return
+	}
+	c.act(func(ctx context.Context) (string, error) {
+		groups, err := c.client.Groups(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, g := range groups {
+			if g.Name == rt.Group && !g.Enabled {
+				_, err := c.client.EnableGroup(ctx, g.Name)
+				return "Enabled group " + g.Name, err
+			}
+		}
+		_, err = c.client.DisableGroup(ctx, rt.Group)
+		return "Disabled group " + rt.Group, err
+	})
+}
+
+func (c *console) resetState() {
+	states := c.snap.states
+	i := c.cursor[viewRebind]
+	if i >= len(states) {
+		return
+	}
+	st := states[i]
+	c.act(func(ctx context.Context) (string, error) {
+		_, err := c.client.ResetRebindStates(ctx, st.Client, st.Host)
+		return fmt.Sprintf("Reset %s for %s", st.Host, st.Client), err
+	})
+}
+
+// addRoute adds the route typed at the prompt as "<source> <target>".
+func (c *console) addRoute(text string) {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		c.status = "Add a route as: <source> <target>"
+		return
+	}
+	c.act(func(ctx context.Context) (string, error) {
+		stored, err := c.client.SetRoute(ctx, adminclient.Route{Source: fields[0], Target: fields[1]})
+		return fmt.Sprintf("Added route %s -> %s", valueOr(stored.ID, fields[0]), fields[1]), err
+	})
+}
+
+// act runs an admin API call and reports its outcome on the status line.
+func (c *console) act(call func(context.Context) (string, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	msg, err := call(ctx)
+	if err != nil {
+		msg = err.Error()
+	}
+	c.status = msg
+}
+
+// draw repaints the whole screen: the header, the current view, the log
+// and the status and key help at the bottom.
+func (c *console) draw(fd int) {
+	width, height, err := term.GetSize(fd)
+	if err != nil || width < 20 || height < 10 {
+		width, height = 80, 24
+	}
+	var screen []string
+	add := func(format string, args ...any) {
+		screen = append(screen, fmt.Sprintf(format, args...))
+	}
+
+	header := fmt.Sprintf("goRebind console - %s - %s", c.addr, time.Now().Format(time.TimeOnly))
+	if c.snap.err == nil {
+		header += fmt.Sprintf("   DNS %.1f q/s   HTTP %.1f req/s", c.snap.top.DNSPerSecond, c.snap.top.HTTPPerSecond)
+		if c.snap.manifest.SafeMode {
+			header += "   SAFE MODE"
+		} else if c.snap.manifest.ForwardOnly {
+			header += "   FORWARD-ONLY"
+		}
+	}
+	add("%s", header)
+	tabs := []string{
+		fmt.Sprintf(" 1 Routes (%d) ", len(c.snap.manifest.Routes)),
+		fmt.Sprintf(" 2 Rebind states (%d) ", len(c.snap.states)),
+	}
+	tabs[c.view] = "\033[7m" + tabs[c.view] + "\033[0m"
+	add("%s", strings.Join(tabs, " "))
+
+	// The view takes half of what is left, the log the rest
+	body := height - len(screen) - 2
+	rows := body/2 - 1
+	var head string
+	var items []string
+	switch c.view {
+	case viewRoutes:
+		head = fmt.Sprintf("  %-4s %-32s %-8s %-5s %-28s %-12s %7s %7s  %s", "ON", "ROUTE", "KIND", "MODE", "TARGET", "GROUP", "DNS", "HTTP", "STRATEGY")
+		for _, rt := range c.snap.manifest.Routes {
+			state := "on"
+			switch {
+			case rt.Disabled:
+				state = "off"
+			case !rt.Enabled:
+				state = "idle"
+			}
+			strategy := "-"
+			if s := rt.Strategy; s != nil {
+				strategy = fmt.Sprintf("%s -> %s after %d", s.Profile, s.RebindIP, s.AfterQueries)
+				if s.After != "" {
+					strategy += " + " + s.After
+				}
+			}
+			items = append(items, fmt.Sprintf("%-4s %-32s %-8s %-5s %-28s %-12s %7d %7d  %s",
+				state, clip(rt.ID, 32), rt.Kind, rt.Mode, clip(strings.Join(rt.Targets, ","), 28), clip(valueOr(rt.Group, "-"), 12), rt.DNSHits, rt.HTTPRequests, strategy))
+		}
+	case viewRebind:
+		head = fmt.Sprintf("  %-39s %-32s %-8s %7s %-7s  %s", "CLIENT", "HOST", "PROFILE", "LOOKUPS", "REBOUND", "LAST LOOKUP")
+		for _, st := range c.snap.states {
+			rebound := "no"
+			if st.Rebound {
+				rebound = "yes"
+			}
+			items = append(items, fmt.Sprintf("%-39s %-32s %-8s %7d %-7s  %s ago",
+				clip(st.Client, 39), clip(st.Host, 32), st.Profile, st.Lookups, rebound, time.Since(st.LastLookup).Round(time.Second)))
+		}
+	}
+	for i := range items {
+		items[i] = screenSafe(items[i])
+	}
+	add("\033[1m%s\033[0m", head)
+	cursor := c.cursor[c.view]
+	offset := max(cursor-rows+1, 0)
+	for i := offset; i < offset+rows; i++ {
+		switch {
+		case i >= len(items):
+			add("")
+		case i == cursor:
+			add("\033[7m> %s\033[0m", items[i])
+		default:
+			add("  %s", items[i])
+		}
+	}
+
+	logRows := height - len(screen) - 3
+	add("\033[1m-- Log %s\033[0m", strings.Repeat("-", max(width-8, 0)))
+	shown := c.log[max(len(c.log)-logRows, 0):]
+	for i := range logRows {
+		if i < len(shown) {
+			add("%s", shown[i])
+		} else {
+			add("")
+		}
+	}
+
+	status := c.status
+	if c.snap.err != nil {
+		status = "admin API: " + c.snap.err.Error()
+	}
+	add("%s", status)
+	switch {
+	case c.prompt != nil:
+		add("Add route (<source> <target>, Enter to add, Esc to cancel): %s_", string(c.prompt))
+	case c.view == viewRoutes:
+		add("\033[2mTab/1/2 view  j/k move  space toggle route  g toggle group  a add route  q quit\033[0m")
+	default:
+		add("\033[2mTab/1/2 view  j/k move  x reset state  a add route  q quit\033[0m")
+	}
+
+	var b strings.Builder
+	b.WriteString("\033[H")
+	for i, line := range screen[:min(len(screen), height)] {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(fitLine(line, width))
+		b.WriteString("\033[K")
+	}
+	b.WriteString("\033[J")
+	fmt.Print(b.String())
+}
+
+// screenSafe replaces control characters, so names and user agents from the
+// network cannot move the cursor or recolor the screen.
+func screenSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r >= 0x80 && r < 0xa0 {
+			return '?'
+		}
+		return r
+	}, s)
+}
+
+// clip shortens s to n runes, marking the cut with "~".
+func clip(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "~"
+}
+
+// fitLine cuts a line to the terminal width, counting only printed runes
+// so the escape sequences for highlighting survive.
+func fitLine(line string, width int) string {
+	var b strings.Builder
+	printed := 0
+	escape := false
+	for _, r := range line {
+		switch {
+		case r == '\033':
+			escape = true
+		case escape:
+			if r >= '@' && r <= '~' && r != '[' {
+				escape = false
+			}
+		case printed >= width:
+			continue
+		default:
+			printed++
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Pseudo domain standing in for Unix socket upstreams in request URLs, so
+// the transport pools connections per socket
+const unixHostSuffix = ".unix.invalid"
+
+// Pseudo host -> socket path
+var unixSockets sync.Map
+
+// --- Unix Socket Upstream Logic ---
+
+// parseUpstream parses a target URL: http(s)://host[:port] or
+// unix:///path/to/app.sock.
+func parseUpstream(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "unix" {
+		if u.Path == "" {
+			return nil, fmt.Errorf("unix target %q has no socket path", raw)
+		}
+		return u, nil
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("target %q has no host", raw)
+	}
+	return u, nil
+}
+
+// unixHost registers a socket path and returns the pseudo host for it.
+func unixHost(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	host := hex.EncodeToString(sum[:8]) + unixHostSuffix
+	unixSockets.Store(host, path)
+	return host
+}
+
+// unixSocketFor returns the socket behind a pseudo host address.
+func unixSocketFor(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	path, ok := unixSockets.Load(host)
+	if !ok {
+		return "", false
+	}
+	return path.(string), true
+}
+
+// dialUnix connects pseudo host addresses to their socket.
+func dialUnix(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if path, ok := unixSocketFor(addr); ok {
+			return dial(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// bypassUnix keeps Unix socket upstreams away from the outbound proxy.
+func bypassUnix(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if strings.HasSuffix(req.URL.Hostname(), unixHostSuffix) {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
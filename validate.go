@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const validateUsage = `Usage: goRebind validate [flags]
+
+Loads config files as the relay would, without serving them: parses every
+file and include, checks each route and target URL, and reports duplicate and
+shadowed sources. Exits 1 if anything is wrong, for use in CI.
+
+Flags:
+`
+
+// validationProblem is one finding, located by file and route number.
+type validationProblem struct {
+	file   string
+	index  int // 1-based position in the file
+	source string
+	msg    string
+}
+
+func (p validationProblem) String() string {
+	return fmt.Sprintf("%s: route %d (%s): %s", p.file, p.index, p.source, p.msg)
+}
+
+// --- Config Validation Logic ---
+
+func newValidateFlags() (*flag.FlagSet, *stringList) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	var paths stringList
+	fs.Var(&paths, "config", "Config file to validate; repeat to merge several as the relay would")
+	fs.StringVar(&configFormat, "config-format", "", "Config file syntax: json, yaml or toml (default: from the file extension)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), validateUsage)
+		fs.PrintDefaults()
+	}
+	return fs, &paths
+}
+
+func runValidate(args []string) {
+	fs, paths := newValidateFlags()
+	_ = fs.Parse(args)
+	// Files may also be given as arguments
+	*paths = append(*paths, fs.Args()...)
+	if len(*paths) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	l, err := loadConfigFiles(*paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	problems := validateRoutes(l.routes, l.files)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	listenerProblems := 0
+	if l.listeners != nil {
+		if err := validateListeners(l.listeners); err != nil {
+			fmt.Printf("%s: listeners: %v\n", l.listenersFile, err)
+			listenerProblems++
+		}
+	}
+	if e := l.engagement; e != nil {
+		fmt.Printf("engagement %s, operator %s, authorization %s\n", e.ID, valueOr(e.Operator, "-"), valueOr(e.Authorization, "-"))
+	}
+	fmt.Printf("%d routes in %s: %d problems\n", len(l.routes), strings.Join(uniqueFiles(l.files, *paths), ", "), len(problems)+listenerProblems)
+	if len(problems)+listenerProblems > 0 {
+		os.Exit(1)
+	}
+}
+
+// validateRoutes checks each route as setRoutes would load it, then the
+// route set as a whole.
+func validateRoutes(routes []ConfigRoute, files []string) []validationProblem {
+	var problems []validationProblem
+	built := make(map[string]*route)
+	located := make(map[string]validationProblem) // canonical source -> where it was first defined
+	counts := make(map[string]int)
+	for i, cfg := range routes {
+		counts[files[i]]++
+		at := validationProblem{file: files[i], index: counts[files[i]], source: cfg.Source}
+		report := func(format string, args ...any) {
+			p := at
+			p.msg = fmt.Sprintf(format, args...)
+			problems = append(problems, p)
+		}
+
+		id := canonicalSource(cfg.Source)
+		first, duplicate := located[id]
+		if !duplicate {
+			located[id] = at
+		}
+		rt, err := validateRoute(cfg)
+		if err != nil {
+			report("%v", err)
+			continue
+		}
+		if duplicate {
+			if prev := built[id]; prev != nil && prev.Priority > rt.Priority {
+				report("duplicate source, also route %d, whose higher priority wins; this definition is ignored", first.index)
+				continue
+			}
+			report("duplicate source, also route %d; only this definition is used", first.index)
+		}
+		for _, u := range rt.upstreams() {
+			if err := checkTarget(u); err != nil {
+				report("%v", err)
+			}
+		}
+		built[id] = rt
+	}
+
+	// A path route for every path with no conditions leaves the host's own
+	// route unreachable
+	for _, id := range sortedKeys(built) {
+		rt := built[id]
+		host, path := splitSource(id)
+		if path != "/*" || rt.conditional() {
+			continue
+		}
+		if _, ok := built[host]; ok {
+			p := located[host]
+			p.msg = fmt.Sprintf("shadowed by %s, which matches every path", id)
+			problems = append(problems, p)
+		}
+	}
+	// Regex routes are tried by priority and config order, so none after a
+	// catch-all pattern is ever reached
+	var regexes []*route
+	for _, rt := range built {
+		if rt.pattern != nil {
+			regexes = append(regexes, rt)
+		}
+	}
+	slices.SortFunc(regexes, compareRoutes)
+	catchAll := ""
+	for _, rt := range regexes {
+		id := rt.name()
+		if catchAll != "" {
+			p := located[id]
+			p.msg = fmt.Sprintf("shadowed by %s, a regex tried first that matches every host", catchAll)
+			problems = append(problems, p)
+			continue
+		}
+		if !rt.conditional() && matchesAnyHost(rt.pattern.MatchString) {
+			catchAll = id
+		}
+	}
+	if _, ok := built[defaultSource]; ok && catchAll != "" {
+		p := located[defaultSource]
+		p.msg = fmt.Sprintf("default route shadowed by %s, a regex matching every host", catchAll)
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+// matchesAnyHost reports whether a pattern matches hosts with nothing in
+// common, which only a catch-all such as ^.*$ does.
+func matchesAnyHost(match func(string) bool) bool {
+	for _, host := range []string{"a", "x-1.example.com", "192.0.2.1"} {
+		if !match(host) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateRoute builds a route like newRoute, without opening SSH or
+// WireGuard tunnels.
+func validateRoute(cfg ConfigRoute) (*route, error) {
+	if cfg.ViaSSH != nil && cfg.ViaWireGuard != "" {
+		return nil, fmt.Errorf("via_ssh and via_wireguard are mutually exclusive")
+	}
+	tunnelled := cfg.ViaSSH != nil || cfg.ViaWireGuard != ""
+	if cfg.ViaSSH != nil {
+		if err := checkSSHTunnel(*cfg.ViaSSH); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(cfg.ViaSSH.Key); err != nil {
+			return nil, fmt.Errorf("via_ssh key: %w", err)
+		}
+	}
+	if cfg.ViaWireGuard != "" {
+		if _, err := parseWireGuardConfig(cfg.ViaWireGuard); err != nil {
+			return nil, fmt.Errorf("via_wireguard %s: %w", cfg.ViaWireGuard, err)
+		}
+	}
+	if tunnelled && cfg.Proxy != "" && cfg.Proxy != "-" {
+		return nil, fmt.Errorf("proxy cannot be combined with via_ssh or via_wireguard")
+	}
+	cfg.ViaSSH, cfg.ViaWireGuard = nil, ""
+	return newRoute(cfg)
+}
+
+// checkTarget rejects upstream URLs the relay cannot dial.
+func checkTarget(u *url.URL) error {
+	switch u.Scheme {
+	case "http", "https":
+		if u.Hostname() == "" {
+			return fmt.Errorf("target %s has no host", u)
+		}
+		if port := u.Port(); port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("target %s: bad port %q", u, port)
+			}
+		}
+	case "file":
+		if u.Path == "" {
+			return fmt.Errorf("target %s has no directory", u)
+		}
+		if info, err := os.Stat(u.Path); err != nil || !info.IsDir() {
+			return fmt.Errorf("target %s: directory not found", u)
+		}
+	case "unix":
+		if u.Path == "" {
+			return fmt.Errorf("target %s has no socket path", u)
+		}
+	default:
+		return fmt.Errorf("target %s: unsupported scheme %q (want http, https, file or unix)", u, u.Scheme)
+	}
+	return nil
+}
+
+// uniqueFiles lists the files routes came from, or the given paths if none
+// had routes.
+func uniqueFiles(files, paths []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	if len(out) == 0 {
+		return paths
+	}
+	return out
+}
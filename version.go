@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"goRebind/adminclient"
+)
+
+// Set with -ldflags "-X main.commit=... -X main.buildDate=..."; filled from
+// the Go build info when empty
+var (
+	commit    string
+	buildDate string
+)
+
+// Release feed consulted by `goRebind version -check`
+const releasesURL = "https://api.github.com/repos/captain-noob/goRebind/releases/latest"
+
+// BuildInfo is returned by the admin API.
+type BuildInfo = adminclient.BuildInfo
+
+// --- Version Logic ---
+
+func currentBuild() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+func describeBuild(b BuildInfo) string {
+	details := []string{}
+	if b.Commit != "" {
+		c := b.Commit
+		if len(c) > 12 {
+			c = c[:12]
+		}
+		if b.Modified {
+			c += "-dirty"
+		}
+		details = append(details, "commit "+c)
+	}
+	if b.BuildDate != "" {
+		details = append(details, "built "+b.BuildDate)
+	}
+	details = append(details, b.GoVersion, b.Platform)
+	return fmt.Sprintf("goRebind %s (%s)", b.Version, strings.Join(details, ", "))
+}
+
+func newVersionFlags() (*flag.FlagSet, *bool, *bool) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print build information as JSON")
+	check := fs.Bool("check", false, "Compare against the latest GitHub release (contacts api.github.com)")
+	return fs, asJSON, check
+}
+
+func runVersion(args []string) {
+	fs, asJSON, check := newVersionFlags()
+	_ = fs.Parse(args)
+
+	info := currentBuild()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(info)
+	} else {
+		fmt.Println(describeBuild(info))
+	}
+	if !*check {
+		return
+	}
+
+	latest, url, err := latestRelease()
+	if err != nil {
+		log.Fatalf("Update check failed: %v", err)
+	}
+	switch cmp, ok := compareVersions(info.Version, latest); {
+	case !ok:
+		fmt.Printf("Latest release is %s (%s); this build (%s) cannot be compared\n", latest, url, info.Version)
+	case cmp < 0:
+		fmt.Printf("Update available: %s (%s)\n", latest, url)
+		os.Exit(1)
+	default:
+		fmt.Printf("Up to date (latest release %s)\n", latest)
+	}
+}
+
+// latestRelease returns the tag and page of the newest GitHub release.
+func latestRelease() (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "goRebind/"+version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("%s: %s", releasesURL, resp.Status)
+	}
+	var release struct {
+		Tag string `json:"tag_name"`
+		URL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", "", err
+	}
+	return release.Tag, release.URL, nil
+}
+
+// compareVersions compares two dotted release versions ("v1.4.2"), ignoring
+// pre-release suffixes. ok is false if either is not a release version.
+func compareVersions(a, b string) (cmp int, ok bool) {
+	parse := func(v string) ([]int, bool) {
+		v = strings.TrimPrefix(v, "v")
+		v, _, _ = strings.Cut(v, "-")
+		var nums []int
+		for _, part := range strings.Split(v, ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, false
+			}
+			nums = append(nums, n)
+		}
+		return nums, true
+	}
+	x, okA := parse(a)
+	y, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := 0; i < max(len(x), len(y)); i++ {
+		var p, q int
+		if i < len(x) {
+			p = x[i]
+		}
+		if i < len(y) {
+			q = y[i]
+		}
+		if p != q {
+			if p < q {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, currentBuild())
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pre-dialed connections older than this are recycled, since upstreams
+// commonly close idle keep-alive connections after a minute or so
+const warmMaxAge = 30 * time.Second
+
+type warmConn struct {
+	net.Conn
+	at time.Time
+}
+
+// warmPool keeps a fixed number of ready connections (TCP, plus the TLS
+// handshake for https targets) to one upstream address.
+type warmPool struct {
+	key    string
+	addr   string
+	useTLS bool
+
+	mu     sync.Mutex
+	size   int
+	conns  []warmConn
+	refill chan struct{}
+	stop   chan struct{}
+}
+
+var (
+	warmMu    sync.Mutex
+	warmPools = make(map[string]*warmPool)
+
+	// Upstream dialers, set once the HTTP transport is configured
+	warmDial      func(ctx context.Context, network, addr string) (net.Conn, error)
+	warmTLSConfig func() *tls.Config
+)
+
+// --- Connection Warm-up Logic ---
+
+// warmKey identifies an upstream by scheme and host:port as dialed by the transport.
+func warmKey(scheme, addr string) string {
+	return scheme + "://" + addr
+}
+
+// syncWarmPools starts, resizes or stops pools to match the warm_conns
+// settings of the live route table.
+func syncWarmPools() {
+	if warmDial == nil {
+		return
+	}
+
+	desired := make(map[string]int)
+	mu.RLock()
+	for _, rt := range routeMap {
+		if rt.WarmConns <= 0 || rt.ownTransport() || !rt.enabled() || !rt.serves(modeHTTP) {
+			continue
+		}
+		for _, u := range rt.upstreams() {
+			if u.Scheme != "http" && u.Scheme != "https" {
+				continue
+			}
+			port := u.Port()
+			if port == "" {
+				port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+			}
+			key := warmKey(u.Scheme, net.JoinHostPort(u.Hostname(), port))
+			desired[key] = max(desired[key], rt.WarmConns)
+		}
+	}
+	mu.RUnlock()
+
+	warmMu.Lock()
+	defer warmMu.Unlock()
+	for key, pool := range warmPools {
+		if _, ok := desired[key]; !ok {
+			close(pool.stop)
+			delete(warmPools, key)
+			log.Printf("[WARM] Stopped pool for %s", key)
+		}
+	}
+	for key, size := range desired {
+		if pool, ok := warmPools[key]; ok {
+			pool.mu.Lock()
+			pool.size = size
+			pool.mu.Unlock()
+			pool.signal()
+			continue
+		}
+		scheme, addr, _ := strings.Cut(key, "://")
+		pool := &warmPool{
+			key:    key,
+			addr:   addr,
+			useTLS: scheme == "https",
+			size:   size,
+			refill: make(chan struct{}, 1),
+			stop:   make(chan struct{}),
+		}
+		warmPools[key] = pool
+		log.Printf("[WARM] Keeping %d connections warm to %s", size, key)
+		go pool.run()
+	}
+}
+
+func (p *warmPool) signal() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run tops the pool up and recycles aged connections until stopped.
+func (p *warmPool) run() {
+	ticker := time.NewTicker(warmMaxAge / 3)
+	defer ticker.Stop()
+	backoff := time.Second
+	for {
+		p.prune()
+		if err := p.fill(); err != nil {
+			log.Printf("[WARM] Failed to warm %s: %v", p.key, err)
+			select {
+			case <-time.After(backoff):
+			case <-p.stop:
+				p.drain()
+				return
+			}
+			backoff = min(backoff*2, time.Minute)
+			continue
+		}
+		backoff = time.Second
+
+		select {
+		case <-p.refill:
+		case <-ticker.C:
+		case <-p.stop:
+			p.drain()
+			return
+		}
+	}
+}
+
+func (p *warmPool) fill() error {
+	for {
+		p.mu.Lock()
+		missing := p.size - len(p.conns)
+		p.mu.Unlock()
+		if missing <= 0 {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		conn, err := p.dial(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, warmConn{Conn: conn, at: time.Now()})
+		p.mu.Unlock()
+	}
+}
+
+func (p *warmPool) dial(ctx context.Context) (net.Conn, error) {
+	if p.useTLS {
+		return dialUpstreamTLS(ctx, "tcp", p.addr)
+	}
+	return warmDial(ctx, "tcp", p.addr)
+}
+
+// prune closes connections that are too old or beyond the pool size.
+func (p *warmPool) prune() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	kept := p.conns[:0]
+	for _, c := range p.conns {
+		if time.Since(c.at) > warmMaxAge || len(kept) >= p.size {
+			c.Close()
+			continue
+		}
+		kept = append(kept, c)
+	}
+	p.conns = kept
+}
+
+func (p *warmPool) drain() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+// take hands out the freshest ready connection, if any.
+func (p *warmPool) take() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.conns) > 0 {
+		c := p.conns[len(p.conns)-1]
+		p.conns = p.conns[:len(p.conns)-1]
+		if time.Since(c.at) <= warmMaxAge {
+			p.signal()
+			return c.Conn
+		}
+		c.Close()
+	}
+	p.signal()
+	return nil
+}
+
+func takeWarmConn(scheme, addr string) net.Conn {
+	warmMu.Lock()
+	pool := warmPools[warmKey(scheme, addr)]
+	warmMu.Unlock()
+	if pool == nil {
+		return nil
+	}
+	return pool.take()
+}
+
+// dialUpstream is the transport's DialContext for plain HTTP upstreams.
+func dialUpstream(ctx context.Context, network, addr string) (net.Conn, error) {
+	if conn := takeWarmConn("http", addr); conn != nil {
+		return conn, nil
+	}
+	return warmDial(ctx, network, addr)
+}
+
+// dialUpstreamTLSWarm is the transport's DialTLSContext for HTTPS upstreams.
+func dialUpstreamTLSWarm(ctx context.Context, network, addr string) (net.Conn, error) {
+	if conn := takeWarmConn("https", addr); conn != nil {
+		return conn, nil
+	}
+	return dialUpstreamTLS(ctx, network, addr)
+}
+
+// dialUpstreamTLS dials and completes a TLS handshake using the transport's
+// TLS settings. Its session cache lets later handshakes resume.
+func dialUpstreamTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	return dialTLSVia(ctx, warmDial, warmTLSConfig(), network, addr)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	// Local responses for commonly probed files on attack hosts
+	robotsTxtFile   string
+	securityTxtFile string
+	robotsTxt       []byte
+	securityTxt     []byte
+)
+
+// Flag value that disables the local response and proxies the file upstream
+const wellKnownProxy = "proxy"
+
+const defaultRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// --- Well-Known Files Logic ---
+
+func loadWellKnownFiles() {
+	robotsTxt = readWellKnownFile(robotsTxtFile, []byte(defaultRobotsTxt))
+	securityTxt = readWellKnownFile(securityTxtFile, nil)
+}
+
+func readWellKnownFile(path string, fallback []byte) []byte {
+	if path == "" || path == wellKnownProxy {
+		return fallback
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return data
+}
+
+// serveWellKnown answers /robots.txt and security.txt locally for routed
+// hosts. It returns false when the request should be proxied as usual.
+func serveWellKnown(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	var body []byte
+	switch r.URL.Path {
+	case "/robots.txt":
+		if robotsTxtFile == wellKnownProxy {
+			return false
+		}
+		body = robotsTxt
+	case "/.well-known/security.txt", "/security.txt":
+		if securityTxtFile == wellKnownProxy {
+			return false
+		}
+		body = securityTxt
+	default:
+		return false
+	}
+
+	if _, exists := lookupRoute(strings.ToLower(r.Host)); !exists {
+		return false
+	}
+	if body == nil {
+		http.NotFound(w, r)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(body)
+	return true
+}
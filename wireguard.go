@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+// wgTunnel is a userspace WireGuard interface; routes naming the same
+// config file share it.
+type wgTunnel struct {
+	dev  *device.Device
+	tnet *netstack.Net
+}
+
+var (
+	wgMu       sync.Mutex
+	wgTunnels  = make(map[string]*wgTunnel)
+	wgShutdown sync.Once
+)
+
+// --- WireGuard Egress Logic ---
+
+// wireguardDialer brings up (or reuses) the tunnel described by a wg-quick
+// style config file and returns a dialer that egresses through it. No
+// host interfaces or routing tables are touched.
+func wireguardDialer(path string) (dialFunc, error) {
+	wgMu.Lock()
+	defer wgMu.Unlock()
+	if t, ok := wgTunnels[path]; ok {
+		return t.tnet.DialContext, nil
+	}
+
+	cfg, err := parseWireGuardConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("via_wireguard %s: %w", path, err)
+	}
+	tun, tnet, err := netstack.CreateNetTUN(cfg.addresses, cfg.dns, cfg.mtu)
+	if err != nil {
+		return nil, fmt.Errorf("via_wireguard %s: %w", path, err)
+	}
+	logger := &device.Logger{
+		Verbosef: device.DiscardLogf,
+		Errorf:   func(format string, args ...any) { log.Printf("[WG] "+format, args...) },
+	}
+	if verboseMode {
+		logger.Verbosef = logger.Errorf
+	}
+	dev := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	if err := dev.IpcSet(cfg.uapi); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("via_wireguard %s: %w", path, err)
+	}
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("via_wireguard %s: %w", path, err)
+	}
+	log.Printf("[WG] Tunnel %s up as %v", path, cfg.addresses)
+
+	wgTunnels[path] = &wgTunnel{dev: dev, tnet: tnet}
+	wgShutdown.Do(func() {
+		onShutdown(func(context.Context) {
+			wgMu.Lock()
+			defer wgMu.Unlock()
+			for _, t := range wgTunnels {
+				t.dev.Close()
+			}
+		})
+	})
+	return tnet.DialContext, nil
+}
+
+type wireGuardConfig struct {
+	addresses []netip.Addr
+	dns       []netip.Addr
+	mtu       int
+	uapi      string
+}
+
+// parseWireGuardConfig reads a wg-quick config ([Interface] and [Peer]
+// sections) into netstack settings and a UAPI device configuration.
+func parseWireGuardConfig(path string) (*wireGuardConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &wireGuardConfig{mtu: 1420}
+	var uapi strings.Builder
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToLower(strings.Trim(line, "[]"))
+			if section == "peer" {
+				// Peer keys must come first in the UAPI stream
+				section = "peer-start"
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch section + "." + key {
+		case "interface.privatekey":
+			k, err := wgKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			fmt.Fprintf(&uapi, "private_key=%s\n", k)
+		case "interface.address":
+			for _, a := range strings.Split(value, ",") {
+				prefix, err := netip.ParsePrefix(strings.TrimSpace(a))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", n, err)
+				}
+				cfg.addresses = append(cfg.addresses, prefix.Addr())
+			}
+		case "interface.dns":
+			for _, a := range strings.Split(value, ",") {
+				// Search domains are allowed here by wg-quick; skip them
+				if addr, err := netip.ParseAddr(strings.TrimSpace(a)); err == nil {
+					cfg.dns = append(cfg.dns, addr)
+				}
+			}
+		case "interface.mtu":
+			if cfg.mtu, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("line %d: invalid MTU", n)
+			}
+		case "interface.listenport":
+			fmt.Fprintf(&uapi, "listen_port=%s\n", value)
+		case "peer-start.publickey", "peer.publickey":
+			k, err := wgKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			fmt.Fprintf(&uapi, "public_key=%s\n", k)
+			section = "peer"
+		case "peer.presharedkey":
+			k, err := wgKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			fmt.Fprintf(&uapi, "preshared_key=%s\n", k)
+		case "peer.endpoint":
+			addr, err := net.ResolveUDPAddr("udp", value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			fmt.Fprintf(&uapi, "endpoint=%s\n", addr)
+		case "peer.allowedips":
+			for _, a := range strings.Split(value, ",") {
+				fmt.Fprintf(&uapi, "allowed_ip=%s\n", strings.TrimSpace(a))
+			}
+		case "peer.persistentkeepalive":
+			fmt.Fprintf(&uapi, "persistent_keepalive_interval=%s\n", value)
+		case "interface.table", "interface.preup", "interface.postup", "interface.predown", "interface.postdown", "interface.saveconfig", "interface.fwmark":
+			// Host-side wg-quick settings have no meaning in userspace
+		default:
+			if section == "peer-start" {
+				return nil, fmt.Errorf("line %d: [Peer] must start with PublicKey", n)
+			}
+			return nil, fmt.Errorf("line %d: unknown setting %q", n, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cfg.addresses) == 0 {
+		return nil, fmt.Errorf("[Interface] has no Address")
+	}
+	cfg.uapi = uapi.String()
+	return cfg, nil
+}
+
+// wgKey converts a base64 WireGuard key into the hex form UAPI expects.
+func wgKey(b64 string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("invalid key")
+	}
+	return hex.EncodeToString(raw), nil
+}